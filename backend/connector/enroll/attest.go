@@ -0,0 +1,93 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// attestX509PoP is the attestation type for enrolling with a machine
+// certificate instead of a token.
+const attestX509PoP = "x509pop"
+
+// x509PoPContext must match the controller's; it prefixes the CSR in the
+// digest the machine key signs.
+const x509PoPContext = "ztna x509pop v1\x00"
+
+// loadMachineIdentity reads the machine certificate chain and key named by
+// MACHINE_CERT and MACHINE_KEY. It returns nil when neither is set.
+func loadMachineIdentity() ([]byte, crypto.Signer, error) {
+	certPath := strings.TrimSpace(os.Getenv("MACHINE_CERT"))
+	keyPath := strings.TrimSpace(os.Getenv("MACHINE_KEY"))
+	if certPath == "" && keyPath == "" {
+		return nil, nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, nil, errors.New("MACHINE_CERT and MACHINE_KEY must be set together")
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read MACHINE_CERT: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read MACHINE_KEY: %w", err)
+	}
+	key, err := parseMachineKey(keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("MACHINE_KEY: %w", err)
+	}
+	return certPEM, key, nil
+}
+
+func parseMachineKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// signX509PoP signs the x509pop digest of the CSR with the machine key.
+func signX509PoP(key crypto.Signer, csrPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, errors.New("invalid CSR PEM")
+	}
+	h := sha256.New()
+	h.Write([]byte(x509PoPContext))
+	h.Write(block.Bytes)
+	digest := h.Sum(nil)
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
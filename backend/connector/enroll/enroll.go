@@ -2,21 +2,31 @@ package enroll
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"connector/internal/tlsutil"
 	controllerpb "controller/gen/controllerpb"
+	"controller/identity"
+	"controller/trustbundle"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // Config controls enrollment behavior.
@@ -27,6 +37,10 @@ type Config struct {
 	Token          string
 	PrivateIP      string
 	Version        string
+	// MachineCertPEM and MachineKey, if set, enroll with x509pop attestation
+	// instead of Token.
+	MachineCertPEM []byte
+	MachineKey     crypto.Signer
 }
 
 // Run performs one-time connector enrollment with the controller.
@@ -36,16 +50,29 @@ func Run() error {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// No overall deadline: an enrollment held for approval is polled until
+	// an admin decides or the command is interrupted.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	cert, _, caPEM, spiffeID, err := Enroll(ctx, cfg)
+	cert, certPEM, caPEM, spiffeID, policyKeys, err := Enroll(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	_ = cert
-	_ = caPEM
+	ids, err := IdentityStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	if ids != nil {
+		if err := ids.Save(cert, certPEM, caPEM); err != nil {
+			return fmt.Errorf("persist identity: %w", err)
+		}
+		if err := ids.SavePolicyKeys(policyKeys); err != nil {
+			return fmt.Errorf("persist policy keys: %w", err)
+		}
+		fmt.Printf("Saved identity to %s\n", ids.Path())
+	}
 	fmt.Printf("Enrolled connector with SPIFFE ID: %s\n", spiffeID)
 	return nil
 }
@@ -74,7 +101,11 @@ func ConfigFromEnvEnroll() (Config, error) {
 		}
 		token = cred
 	}
-	if token == "" {
+	machineCert, machineKey, err := loadMachineIdentity()
+	if err != nil {
+		return Config{}, err
+	}
+	if token == "" && machineKey == nil {
 		return Config{}, fmt.Errorf("ENROLLMENT_TOKEN is not set")
 	}
 
@@ -92,6 +123,8 @@ func ConfigFromEnvEnroll() (Config, error) {
 		Token:          token,
 		PrivateIP:      privateIP,
 		Version:        version,
+		MachineCertPEM: machineCert,
+		MachineKey:     machineKey,
 	}, nil
 }
 
@@ -119,40 +152,52 @@ func ConfigFromEnvRun() (Config, error) {
 
 	version := ResolveVersion()
 
+	machineCert, machineKey, err := loadMachineIdentity()
+	if err != nil {
+		return Config{}, err
+	}
+
 	return Config{
 		ControllerAddr: controllerAddr,
 		ConnectorID:    connectorID,
 		TrustDomain:    trustDomain,
 		PrivateIP:      privateIP,
 		Version:        version,
+		MachineCertPEM: machineCert,
+		MachineKey:     machineKey,
 	}, nil
 }
 
-// Enroll performs enrollment and returns the issued workload certificate.
-func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, string, error) {
+// Enroll performs enrollment and returns the issued workload certificate and
+// the controller's policy verification keys.
+func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, string, identity.PolicyKeys, error) {
 	// ---- generate key pair (in-memory only) ----
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to generate key pair: %w", err)
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("failed to generate key pair: %w", err)
 	}
 
 	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to marshal public key: %w", err)
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("failed to marshal public key: %w", err)
 	}
 
 	pubPEM := pem.EncodeToMemory(&pem.Block{
 		Type:  "PUBLIC KEY",
 		Bytes: pubDER,
 	})
+	csrPEM, err := tlsutil.CreateCSR(privKey, fmt.Sprintf("spiffe://%s/connector/%s", cfg.TrustDomain, cfg.ConnectorID))
+	if err != nil {
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
 
 	localCAPEM, err := loadExplicitCA()
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", err
+		return tls.Certificate{}, nil, nil, "", nil, err
 	}
 	rootPool, err := tlsutil.RootPoolFromPEM(localCAPEM)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", err
+		return tls.Certificate{}, nil, nil, "", nil, err
 	}
 
 	// ---- TLS config for enrollment ----
@@ -171,53 +216,114 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 	)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to connect to controller: %w", err)
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("failed to connect to controller: %w", err)
 	}
 	defer conn.Close()
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
 
-	resp, err := client.EnrollConnector(ctx, &controllerpb.EnrollRequest{
+	req := &controllerpb.EnrollRequest{
 		Id:        cfg.ConnectorID,
 		PublicKey: pubPEM,
+		Csr:       csrPEM,
 		Token:     cfg.Token,
 		PrivateIp: cfg.PrivateIP,
 		Version:   cfg.Version,
-	})
+	}
+	if cfg.MachineKey != nil {
+		sig, err := signX509PoP(cfg.MachineKey, csrPEM)
+		if err != nil {
+			return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("failed to sign attestation: %w", err)
+		}
+		req.Token = ""
+		req.AttestationType = attestX509PoP
+		req.MachineCertChain = cfg.MachineCertPEM
+		req.MachineSignature = sig
+	}
+	var resp *controllerpb.EnrollResponse
+	for {
+		rpcCtx, rpcCancel := context.WithTimeout(ctx, enrollRPCTimeout)
+		resp, err = client.EnrollConnector(rpcCtx, req)
+		rpcCancel()
+		delay, pending := approvalRetryDelay(err)
+		if !pending {
+			break
+		}
+		// Keep the same key while waiting: the approval is bound to it.
+		log.Printf("%s; retrying in %s", status.Convert(err).Message(), delay)
+		select {
+		case <-ctx.Done():
+			return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("enrollment not approved: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("enrollment RPC failed: %w", err)
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("enrollment RPC failed: %w", err)
 	}
 
 	if len(resp.Certificate) == 0 {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("controller returned empty certificate")
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("controller returned empty certificate")
 	}
 	if len(resp.CaCertificate) == 0 {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("controller returned empty CA certificate")
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("controller returned empty CA certificate")
 	}
 
-	if _, err := tlsutil.ParseAndValidateCA(resp.CaCertificate); err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("invalid internal CA: %w", err)
+	if _, err := trustbundle.Parse(resp.CaCertificate); err != nil {
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("invalid internal CA: %w", err)
 	}
 
 	// ---- basic validation of returned cert ----
-	block, _ := pem.Decode(resp.Certificate)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("invalid certificate PEM from controller")
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
+	chain, cert, err := tlsutil.ParseCertChain(resp.Certificate)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to parse issued certificate: %w", err)
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("invalid certificate from controller: %w", err)
 	}
 
 	if len(cert.URIs) != 1 {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("issued certificate must contain exactly one SPIFFE ID")
+		return tls.Certificate{}, nil, nil, "", nil, fmt.Errorf("issued certificate must contain exactly one SPIFFE ID")
+	}
+
+	policyKeys, err := policyKeysFromResponse(resp.GetPolicySigningKeys())
+	if err != nil {
+		return tls.Certificate{}, nil, nil, "", nil, err
 	}
 
 	workloadCert := tls.Certificate{
-		Certificate: [][]byte{block.Bytes},
+		Certificate: chain,
 		PrivateKey:  privKey,
 	}
 
-	return workloadCert, resp.Certificate, resp.CaCertificate, cert.URIs[0].String(), nil
+	return workloadCert, resp.Certificate, resp.CaCertificate, cert.URIs[0].String(), policyKeys, nil
+}
+
+func policyKeysFromResponse(keys []*controllerpb.PolicySigningKey) (identity.PolicyKeys, error) {
+	out := make(identity.PolicyKeys, len(keys))
+	for _, k := range keys {
+		if k.GetKeyId() == "" || len(k.GetPublicKey()) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("controller returned invalid policy signing key %q", k.GetKeyId())
+		}
+		out[k.GetKeyId()] = ed25519.PublicKey(k.GetPublicKey())
+	}
+	return out, nil
+}
+
+// enrollRPCTimeout bounds each enrollment attempt.
+const enrollRPCTimeout = 15 * time.Second
+
+// approvalRetryDelay reports whether err says the enrollment is waiting for
+// admin approval and how long to wait before asking again.
+func approvalRetryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			delay := info.GetRetryDelay().AsDuration()
+			if delay <= 0 {
+				delay = enrollRPCTimeout
+			}
+			return delay, true
+		}
+	}
+	return 0, false
 }
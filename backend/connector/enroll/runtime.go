@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"connector/internal/buildinfo"
+	"controller/identity"
 )
 
 const (
@@ -71,6 +72,31 @@ func normalizeTrustDomain(v string) string {
 	return v
 }
 
+// IdentityStoreFromEnv returns the on-disk identity store configured by
+// IDENTITY_FILE, defaulting to identity.pem in systemd's STATE_DIRECTORY.
+// It returns nil when neither is set. IDENTITY_PASSPHRASE (env or systemd
+// credential) enables encryption of the private key.
+func IdentityStoreFromEnv() (*identity.Store, error) {
+	path := strings.TrimSpace(os.Getenv("IDENTITY_FILE"))
+	if path == "" {
+		if dir := strings.TrimSpace(os.Getenv("STATE_DIRECTORY")); dir != "" {
+			path = filepath.Join(dir, "identity.pem")
+		}
+	}
+	if path == "" {
+		return nil, nil
+	}
+	passphrase := os.Getenv("IDENTITY_PASSPHRASE")
+	if passphrase == "" {
+		cred, err := ReadCredential("IDENTITY_PASSPHRASE")
+		if err != nil {
+			return nil, err
+		}
+		passphrase = cred
+	}
+	return identity.NewStore(path, []byte(passphrase)), nil
+}
+
 func loadExplicitCA() ([]byte, error) {
 	if cred, err := ReadCredential("CONTROLLER_CA"); err != nil {
 		return nil, err
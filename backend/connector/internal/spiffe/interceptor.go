@@ -2,6 +2,7 @@ package spiffe
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"strings"
 
@@ -21,6 +22,19 @@ type Allowlist interface {
 	Allowed(spiffeID string) bool
 }
 
+// RevocationChecker is optionally implemented by an Allowlist to reject
+// revoked tunneler certificates.
+type RevocationChecker interface {
+	Revoked(cert *x509.Certificate) bool
+}
+
+// StreamTracker is optionally implemented by an Allowlist to end a
+// tunneler's open streams when it is removed. Track registers cancel for a
+// stream of spiffeID and returns a func that unregisters it.
+type StreamTracker interface {
+	Track(spiffeID string, cancel context.CancelFunc) func()
+}
+
 // UnaryInterceptor enforces SPIFFE identity on unary RPCs.
 func UnaryInterceptor(trustDomain string, allowedRoles ...string) grpc.UnaryServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
@@ -56,8 +70,10 @@ func UnaryInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, allo
 		if err != nil {
 			return nil, err
 		}
-		if role == "tunneler" && allowlist != nil && !allowlist.Allowed(spiffeID) {
-			return nil, errors.New("tunneler not allowed")
+		if role == "tunneler" {
+			if err := checkAllowlist(ctx, allowlist, spiffeID); err != nil {
+				return nil, err
+			}
 		}
 		ctx = context.WithValue(ctx, spiffeIDContextKey, spiffeID)
 		ctx = context.WithValue(ctx, roleContextKey, role)
@@ -106,13 +122,22 @@ func StreamInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, all
 		if err != nil {
 			return err
 		}
-		if role == "tunneler" && allowlist != nil && !allowlist.Allowed(spiffeID) {
-			return errors.New("tunneler not allowed")
+		ctx := ss.Context()
+		if role == "tunneler" {
+			if err := checkAllowlist(ctx, allowlist, spiffeID); err != nil {
+				return err
+			}
+			if tracker, ok := allowlist.(StreamTracker); ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				defer cancel()
+				defer tracker.Track(spiffeID, cancel)()
+			}
 		}
 		wrapped := &wrappedStream{
 			ServerStream: ss,
 			ctx: context.WithValue(
-				context.WithValue(ss.Context(), spiffeIDContextKey, spiffeID),
+				context.WithValue(ctx, spiffeIDContextKey, spiffeID),
 				roleContextKey,
 				role,
 			),
@@ -151,22 +176,49 @@ func RoleFromContext(ctx context.Context) (string, bool) {
 	return role, ok
 }
 
-func extractAndVerifySPIFFE(ctx context.Context, trustDomain string, allowedRoles map[string]struct{}) (string, string, error) {
-	p, ok := peer.FromContext(ctx)
+// checkAllowlist rejects tunnelers missing from allowlist or whose
+// certificate the allowlist reports as revoked.
+func checkAllowlist(ctx context.Context, allowlist Allowlist, spiffeID string) error {
+	if allowlist == nil {
+		return nil
+	}
+	if !allowlist.Allowed(spiffeID) {
+		return errors.New("tunneler not allowed")
+	}
+	checker, ok := allowlist.(RevocationChecker)
 	if !ok {
-		return "", "", errors.New("missing peer information")
+		return nil
 	}
+	cert, err := peerCertificate(ctx)
+	if err != nil {
+		return err
+	}
+	if checker.Revoked(cert) {
+		return errors.New("tunneler certificate revoked")
+	}
+	return nil
+}
 
+func peerCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("missing peer information")
+	}
 	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return "", "", errors.New("connection is not using TLS")
+		return nil, errors.New("connection is not using TLS")
 	}
-
 	if len(tlsInfo.State.PeerCertificates) == 0 {
-		return "", "", errors.New("no peer certificates presented")
+		return nil, errors.New("no peer certificates presented")
 	}
+	return tlsInfo.State.PeerCertificates[0], nil
+}
 
-	cert := tlsInfo.State.PeerCertificates[0]
+func extractAndVerifySPIFFE(ctx context.Context, trustDomain string, allowedRoles map[string]struct{}) (string, string, error) {
+	cert, err := peerCertificate(ctx)
+	if err != nil {
+		return "", "", err
+	}
 
 	if len(cert.URIs) != 1 {
 		return "", "", errors.New("exactly one SPIFFE ID is required")
@@ -1,6 +1,8 @@
 package tlsutil
 
 import (
+	"crypto"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -33,6 +35,13 @@ func (s *CertStore) Update(cert tls.Certificate, certPEM []byte, notAfter time.T
 	s.notAfter = notAfter
 }
 
+// Certificate returns the current certificate and its PEM encoding.
+func (s *CertStore) Certificate() (tls.Certificate, []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, s.certPEM
+}
+
 // NotAfter returns the current certificate expiry.
 func (s *CertStore) NotAfter() time.Time {
 	s.mu.RLock()
@@ -63,33 +72,44 @@ func RootPoolFromPEM(pemBytes []byte) (*x509.CertPool, error) {
 	return pool, nil
 }
 
-// ParseAndValidateCA ensures the PEM contains a valid CA certificate.
-func ParseAndValidateCA(pemBytes []byte) (*x509.Certificate, error) {
-	block, _ := pem.Decode(pemBytes)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return nil, errors.New("invalid CA PEM")
-	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+// CreateCSR returns a PEM PKCS#10 request for spiffeID signed by key, proving
+// possession of the key to the controller.
+func CreateCSR(key crypto.Signer, spiffeID string) ([]byte, error) {
+	uri, err := url.Parse(spiffeID)
 	if err != nil {
 		return nil, err
 	}
-	if !cert.IsCA || !cert.BasicConstraintsValid {
-		return nil, errors.New("certificate is not a valid CA")
-	}
-	if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
-		return nil, errors.New("CA certificate missing key usage")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{uri}}, key)
+	if err != nil {
+		return nil, err
 	}
-	return cert, nil
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
 }
 
-// EqualCAPEM compares two CA PEM blocks by their DER bytes.
-func EqualCAPEM(a, b []byte) bool {
-	ab, _ := pem.Decode(a)
-	bb, _ := pem.Decode(b)
-	if ab == nil || bb == nil {
-		return false
+// ParseCertChain decodes an issued certificate PEM: the leaf followed by any
+// intermediate CAs. It returns the DER chain for a tls.Certificate and the
+// parsed leaf.
+func ParseCertChain(certPEM []byte) ([][]byte, *x509.Certificate, error) {
+	var chain [][]byte
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, nil, errors.New("invalid certificate PEM")
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(chain) == 0 {
+		return nil, nil, errors.New("invalid certificate PEM")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, nil, err
 	}
-	return string(ab.Bytes) == string(bb.Bytes)
+	return chain, leaf, nil
 }
 
 // VerifyPeerSPIFFE validates SPIFFE identity using verified chains.
@@ -0,0 +1,63 @@
+package run
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"testing"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+func TestTunnelerAllowlistRevocations(t *testing.T) {
+	allowlist := newTunnelerAllowlist()
+	id := "spiffe://example.internal/tunneler/t1"
+	uri, _ := url.Parse(id)
+	cert := &x509.Certificate{SerialNumber: big.NewInt(42), URIs: []*url.URL{uri}}
+
+	payload, _ := json.Marshal(revocationList{Serials: []string{"42"}})
+	handleControlMessage(&controllerpb.ControlMessage{Type: "revocation_list", Payload: payload}, allowlist, nil, nil)
+	if !allowlist.Revoked(cert) {
+		t.Fatal("expected revoked serial to be rejected")
+	}
+
+	payload, _ = json.Marshal(revocationList{SPIFFEIDs: []string{id}})
+	handleControlMessage(&controllerpb.ControlMessage{Type: "revocation_list", Payload: payload}, allowlist, nil, nil)
+	other := &x509.Certificate{SerialNumber: big.NewInt(43), URIs: []*url.URL{uri}}
+	if !allowlist.Revoked(other) {
+		t.Fatal("expected revoked identity to be rejected")
+	}
+
+	allowlist.Add(id)
+	if allowlist.Revoked(other) {
+		t.Fatal("expected re-allowed identity to be accepted")
+	}
+}
+
+func TestTunnelerRevokeClosesStreams(t *testing.T) {
+	allowlist := newTunnelerAllowlist()
+	id := "spiffe://example.internal/tunneler/t1"
+	other := "spiffe://example.internal/tunneler/t2"
+	allowlist.Add(id)
+	allowlist.Add(other)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer allowlist.Track(id, cancel)()
+	otherCtx, otherCancel := context.WithCancel(context.Background())
+	defer otherCancel()
+	defer allowlist.Track(other, otherCancel)()
+
+	payload, _ := json.Marshal(tunnelerInfo{TunnelerID: "t1", SPIFFEID: id})
+	handleControlMessage(&controllerpb.ControlMessage{Type: "tunneler_revoke", Payload: payload}, allowlist, nil, nil)
+	if allowlist.Allowed(id) {
+		t.Fatal("expected revoked tunneler to be removed from the allowlist")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expected revoked tunneler's stream to be cancelled")
+	}
+	if !allowlist.Allowed(other) || otherCtx.Err() != nil {
+		t.Fatal("expected other tunnelers to be unaffected")
+	}
+}
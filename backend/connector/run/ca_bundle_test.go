@@ -0,0 +1,74 @@
+package run
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/trustbundle"
+)
+
+func testCAPEM(t *testing.T, cn string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func caBundleControlMessage(bundle []byte) *controllerpb.ControlMessage {
+	payload, _ := json.Marshal(caBundleMessage{BundlePEM: string(bundle)})
+	return &controllerpb.ControlMessage{Type: "ca_bundle", Payload: payload}
+}
+
+func TestControlMessageCABundle(t *testing.T) {
+	oldCA, newCA, rogueCA := testCAPEM(t, "old"), testCAPEM(t, "new"), testCAPEM(t, "rogue")
+	trust, err := trustbundle.New(oldCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := trust.Changed()
+
+	rotation := append(append([]byte(nil), oldCA...), newCA...)
+	handleControlMessage(caBundleControlMessage(rotation), newTunnelerAllowlist(), nil, trust)
+	if string(trust.PEM()) != string(rotation) {
+		t.Fatal("expected overlapping bundle to be accepted")
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected bundle change to be signalled")
+	}
+
+	handleControlMessage(caBundleControlMessage(rogueCA), newTunnelerAllowlist(), nil, trust)
+	if string(trust.PEM()) != string(rotation) {
+		t.Fatal("expected bundle without a trusted root to be rejected")
+	}
+
+	handleControlMessage(caBundleControlMessage(newCA), newTunnelerAllowlist(), nil, trust)
+	if string(trust.PEM()) != string(newCA) {
+		t.Fatal("expected retirement of the old root to be accepted")
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"connector/internal/spiffe"
 	controllerpb "controller/gen/controllerpb"
+	"controller/trustbundle"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,8 +20,22 @@ import (
 type controlPlaneServer struct {
 	controllerpb.UnimplementedControlPlaneServer
 	connectorID string
-	sendCh      chan<- *controllerpb.ControlMessage
+	sendCh      *controllerQueue
 	acls        *policyCache
+	tunnel      tunnelConfig
+	trust       *trustbundle.Bundle
+}
+
+// syncStream serializes Send so the CA bundle relay can share the stream.
+type syncStream struct {
+	controllerpb.ControlPlane_ConnectServer
+	mu sync.Mutex
+}
+
+func (s *syncStream) Send(msg *controllerpb.ControlMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ControlPlane_ConnectServer.Send(msg)
 }
 
 func (s *controlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectServer) error {
@@ -32,14 +48,43 @@ func (s *controlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 	log.Printf("tunneler connected: %s", spiffeID)
 	tunnelerID := parseTunnelerID(spiffeID)
 	connectionID := fmt.Sprintf("conn-%d", time.Now().UnixNano())
+	stream = &syncStream{ControlPlane_ConnectServer: stream}
+	if s.trust != nil {
+		go relayCABundle(stream, s.trust)
+	}
 
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+	// Receive on a separate goroutine so the stream ends as soon as its
+	// context is cancelled, e.g. when the tunneler is revoked.
+	ctx := stream.Context()
+	msgs := make(chan *controllerpb.ControlMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if err != nil {
+	}()
+
+	for {
+		var msg *controllerpb.ControlMessage
+		select {
+		case <-ctx.Done():
+			log.Printf("tunneler stream closed: %s", spiffeID)
+			return status.FromContextError(ctx.Err()).Err()
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
 			return err
+		case msg = <-msgs:
 		}
 
 		if msg.GetType() == "ping" {
@@ -60,28 +105,79 @@ func (s *controlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 				ConnectorID: s.connectorID,
 			}
 			if data, err := json.Marshal(payload); err == nil {
-				s.sendCh <- &controllerpb.ControlMessage{
+				s.notifyController(&controllerpb.ControlMessage{
 					Type:    "tunneler_heartbeat",
 					Payload: data,
-				}
+				})
 			}
 		}
-		if msg.GetType() == "tunneler_request" && s.acls != nil {
+		if msg.GetType() == "tunneler_request" {
 			var req struct {
+				RequestID   string `json:"request_id"`
 				Destination string `json:"destination"`
 				Protocol    string `json:"protocol"`
 				Port        uint16 `json:"port"`
 			}
-			if err := json.Unmarshal(msg.GetPayload(), &req); err != nil {
-				s.sendDecision(spiffeID, tunnelerID, req.Destination, req.Protocol, req.Port, false, "", "invalid_request", connectionID)
-				continue
+			allowed, resourceID, reason := false, "", "invalid_request"
+			if err := json.Unmarshal(msg.GetPayload(), &req); err == nil {
+				if s.acls == nil {
+					reason = "no_snapshot"
+				} else {
+					allowed, resourceID, reason = s.acls.Allowed(spiffeID, req.Destination, req.Protocol, req.Port)
+				}
 			}
-			allowed, resourceID, reason := s.acls.Allowed(spiffeID, req.Destination, req.Protocol, req.Port)
 			s.sendDecision(spiffeID, tunnelerID, req.Destination, req.Protocol, req.Port, allowed, resourceID, reason, connectionID)
+			if err := replyDecision(stream, req.RequestID, allowed, resourceID, reason); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// relayCABundle sends the trusted CA bundle to a tunneler on connect and
+// again whenever the controller updates it.
+func relayCABundle(stream controllerpb.ControlPlane_ConnectServer, trust *trustbundle.Bundle) {
+	for {
+		changed := trust.Changed()
+		payload, err := json.Marshal(caBundleMessage{BundlePEM: string(trust.PEM())})
+		if err != nil {
+			return
+		}
+		if err := stream.Send(&controllerpb.ControlMessage{Type: "ca_bundle", Payload: payload}); err != nil {
+			return
+		}
+		select {
+		case <-stream.Context().Done():
+			return
+		case <-changed:
 		}
 	}
 }
 
+// replyDecision answers a tunneler_request on the tunneler's stream so the
+// tunneler can fail fast before opening a Tunnel.
+func replyDecision(stream controllerpb.ControlPlane_ConnectServer, requestID string, allowed bool, resourceID, reason string) error {
+	decision := "deny"
+	if allowed {
+		decision = "allow"
+	}
+	payload, err := json.Marshal(struct {
+		RequestID  string `json:"request_id"`
+		Decision   string `json:"decision"`
+		ResourceID string `json:"resource_id"`
+		Reason     string `json:"reason"`
+	}{
+		RequestID:  requestID,
+		Decision:   decision,
+		ResourceID: resourceID,
+		Reason:     reason,
+	})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&controllerpb.ControlMessage{Type: "tunneler_decision", Payload: payload})
+}
+
 func (s *controlPlaneServer) sendDecision(spiffeID, tunnelerID, dest, protocol string, port uint16, allowed bool, resourceID, reason, connectionID string) {
 	decision := "deny"
 	if allowed {
@@ -94,33 +190,43 @@ func (s *controlPlaneServer) sendDecision(spiffeID, tunnelerID, dest, protocol s
 		return
 	}
 	payload := struct {
-		TunnelerID  string `json:"tunneler_id"`
-		SPIFFEID    string `json:"spiffe_id"`
-		ResourceID  string `json:"resource_id"`
-		Destination string `json:"destination"`
-		Protocol    string `json:"protocol"`
-		Port        uint16 `json:"port"`
-		Decision    string `json:"decision"`
-		Reason      string `json:"reason"`
-		ConnectorID string `json:"connector_id"`
+		TunnelerID   string `json:"tunneler_id"`
+		SPIFFEID     string `json:"spiffe_id"`
+		ResourceID   string `json:"resource_id"`
+		Destination  string `json:"destination"`
+		Protocol     string `json:"protocol"`
+		Port         uint16 `json:"port"`
+		Decision     string `json:"decision"`
+		Reason       string `json:"reason"`
+		ConnectorID  string `json:"connector_id"`
+		ConnectionID string `json:"connection_id"`
 	}{
-		TunnelerID:  tunnelerID,
-		SPIFFEID:    spiffeID,
-		ResourceID:  resourceID,
-		Destination: dest,
-		Protocol:    protocol,
-		Port:        port,
-		Decision:    decision,
-		Reason:      reason,
-		ConnectorID: s.connectorID,
-		// ConnectionID: connectionID,
+		TunnelerID:   tunnelerID,
+		SPIFFEID:     spiffeID,
+		ResourceID:   resourceID,
+		Destination:  dest,
+		Protocol:     protocol,
+		Port:         port,
+		Decision:     decision,
+		Reason:       reason,
+		ConnectorID:  s.connectorID,
+		ConnectionID: connectionID,
 	}
 	if data, err := json.Marshal(payload); err == nil {
-		s.sendCh <- &controllerpb.ControlMessage{
+		s.notifyController(&controllerpb.ControlMessage{
 			Type:    "acl_decision",
 			Payload: data,
-		}
+		})
+	}
+}
+
+// notifyController queues msg for the controller stream without blocking, so
+// a controller outage never stalls tunneler streams.
+func (s *controlPlaneServer) notifyController(msg *controllerpb.ControlMessage) {
+	if s.sendCh == nil {
+		return
 	}
+	s.sendCh.push(msg)
 }
 
 func parseTunnelerID(spiffeID string) string {
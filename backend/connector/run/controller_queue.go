@@ -0,0 +1,72 @@
+package run
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+const (
+	controllerQueueSize = 16
+	// auditQueueSize holds acl_decision records while the controller stream
+	// is slow or down.
+	auditQueueSize = 1024
+)
+
+// controllerQueue carries messages from tunneler streams to the controller
+// stream without blocking them. Audit records (acl_decision) get their own,
+// larger buffer so other traffic cannot crowd them out; records that still do
+// not fit are counted and reported to the controller in an audit_dropped
+// message once the stream is up.
+type controllerQueue struct {
+	msgs    chan *controllerpb.ControlMessage
+	audit   chan *controllerpb.ControlMessage
+	dropped atomic.Uint64
+}
+
+func newControllerQueue() *controllerQueue {
+	return &controllerQueue{
+		msgs:  make(chan *controllerpb.ControlMessage, controllerQueueSize),
+		audit: make(chan *controllerpb.ControlMessage, auditQueueSize),
+	}
+}
+
+// push queues msg and reports whether it fit.
+func (q *controllerQueue) push(msg *controllerpb.ControlMessage) bool {
+	ch := q.msgs
+	if msg.GetType() == "acl_decision" {
+		ch = q.audit
+	}
+	select {
+	case ch <- msg:
+		return true
+	default:
+	}
+	if ch == q.audit {
+		if n := q.dropped.Add(1); n == 1 || n%100 == 0 {
+			log.Printf("audit queue full; %d acl_decision records dropped since last report", n)
+		}
+	} else {
+		log.Printf("controller send queue full; dropping %s message", msg.GetType())
+	}
+	return false
+}
+
+// takeDropped returns a message reporting audit records dropped since the
+// last call, or nil if none were. Call restoreDropped if it cannot be sent.
+func (q *controllerQueue) takeDropped() (*controllerpb.ControlMessage, uint64) {
+	n := q.dropped.Swap(0)
+	if n == 0 {
+		return nil, 0
+	}
+	payload, _ := json.Marshal(struct {
+		Count uint64 `json:"count"`
+	}{Count: n})
+	return &controllerpb.ControlMessage{Type: "audit_dropped", Payload: payload}, n
+}
+
+func (q *controllerQueue) restoreDropped(n uint64) {
+	q.dropped.Add(n)
+}
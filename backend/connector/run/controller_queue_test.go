@@ -0,0 +1,45 @@
+package run
+
+import (
+	"encoding/json"
+	"testing"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+func TestControllerQueueKeepsAuditSeparate(t *testing.T) {
+	q := newControllerQueue()
+	for i := 0; i < controllerQueueSize+5; i++ {
+		q.push(&controllerpb.ControlMessage{Type: "tunneler_heartbeat"})
+	}
+	if !q.push(&controllerpb.ControlMessage{Type: "acl_decision"}) {
+		t.Fatal("expected audit record to be queued while the general queue is full")
+	}
+	if msg, _ := q.takeDropped(); msg != nil {
+		t.Fatal("expected no audit drops")
+	}
+}
+
+func TestControllerQueueReportsAuditDrops(t *testing.T) {
+	q := newControllerQueue()
+	for i := 0; i < auditQueueSize+3; i++ {
+		q.push(&controllerpb.ControlMessage{Type: "acl_decision"})
+	}
+	msg, n := q.takeDropped()
+	if msg == nil || n != 3 || msg.GetType() != "audit_dropped" {
+		t.Fatalf("expected 3 drops reported, got %d (%v)", n, msg)
+	}
+	var payload struct {
+		Count uint64 `json:"count"`
+	}
+	if err := json.Unmarshal(msg.GetPayload(), &payload); err != nil || payload.Count != 3 {
+		t.Fatalf("unexpected payload %s", msg.GetPayload())
+	}
+	if again, _ := q.takeDropped(); again != nil {
+		t.Fatal("expected drop count to reset after reporting")
+	}
+	q.restoreDropped(n)
+	if _, m := q.takeDropped(); m != 3 {
+		t.Fatalf("expected restored count 3, got %d", m)
+	}
+}
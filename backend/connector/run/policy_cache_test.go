@@ -1,11 +1,44 @@
 package run
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"controller/identity"
 )
 
-const testKey = "test-signing-key"
+const testKeyID = "pk_test"
+
+var testKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+
+func testPolicyKeys() identity.PolicyKeys {
+	return identity.PolicyKeys{testKeyID: testKey.Public().(ed25519.PublicKey)}
+}
+
+func signSnapshot(key ed25519.PrivateKey, snap policySnapshot) (string, error) {
+	snap.SnapshotMeta.Signature = ""
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ed25519.Sign(key, data)), nil
+}
+
+func signDelta(key ed25519.PrivateKey, delta policyDelta) (string, error) {
+	delta.DeltaMeta.Signature = ""
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ed25519.Sign(key, data)), nil
+}
 
 func newSignedSnapshot(t *testing.T, resources []policyResource) policySnapshot {
 	t.Helper()
@@ -15,11 +48,12 @@ func newSignedSnapshot(t *testing.T, resources []policyResource) policySnapshot
 			PolicyVersion: 1,
 			CompiledAt:    time.Now().UTC().Format(time.RFC3339),
 			ValidUntil:    time.Now().UTC().Add(10 * time.Minute).Format(time.RFC3339),
+			KeyID:         testKeyID,
 			Signature:     "",
 		},
 		Resources: resources,
 	}
-	sig, err := signSnapshot([]byte(testKey), snap)
+	sig, err := signSnapshot(testKey, snap)
 	if err != nil {
 		t.Fatalf("signSnapshot failed: %v", err)
 	}
@@ -29,7 +63,7 @@ func newSignedSnapshot(t *testing.T, resources []policyResource) policySnapshot
 
 func newCache(t *testing.T, resources []policyResource) *policyCache {
 	t.Helper()
-	cache := newPolicyCache([]byte(testKey), 5*time.Minute)
+	cache := newPolicyCache(testPolicyKeys(), 5*time.Minute)
 	if ok := cache.ReplaceSnapshot(newSignedSnapshot(t, resources)); !ok {
 		t.Fatalf("ReplaceSnapshot failed")
 	}
@@ -144,3 +178,150 @@ func TestPolicyCacheMultiResourceNoEarlyDeny(t *testing.T) {
 		t.Fatalf("expected allow on res_allowed, got allowed=%v resourceID=%s reason=%s", allowed, resourceID, reason)
 	}
 }
+
+func TestPolicyCacheRefreshDue(t *testing.T) {
+	empty := newPolicyCache(testPolicyKeys(), 5*time.Minute)
+	if !empty.refreshDue(time.Now()) {
+		t.Fatalf("expected refresh to be due without a snapshot")
+	}
+
+	cache := newCache(t, nil)
+	if cache.refreshDue(time.Now()) {
+		t.Fatalf("expected fresh snapshot not to need refresh")
+	}
+	if !cache.refreshDue(time.Now().Add(8 * time.Minute)) {
+		t.Fatalf("expected refresh to be due near valid_until")
+	}
+}
+
+func TestPolicyCacheApplyDelta(t *testing.T) {
+	cache := newCache(t, []policyResource{
+		{ResourceID: "res_a", Type: "dns", Address: "a.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-1"}},
+		{ResourceID: "res_b", Type: "dns", Address: "b.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-1"}},
+	})
+
+	want := []policyResource{
+		{ResourceID: "res_a", Type: "dns", Address: "a.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-2"}},
+		{ResourceID: "res_c", Type: "dns", Address: "c.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-1"}},
+	}
+	delta := policyDelta{
+		DeltaMeta: deltaMeta{
+			ConnectorID:   "con_test",
+			BaseVersion:   1,
+			PolicyVersion: 2,
+			PolicyHash:    policyHash(want),
+			CompiledAt:    time.Now().UTC().Format(time.RFC3339),
+			ValidUntil:    time.Now().UTC().Add(10 * time.Minute).Format(time.RFC3339),
+			KeyID:         testKeyID,
+		},
+		Added:           []policyResource{want[1]},
+		Changed:         []policyResource{},
+		Removed:         []string{"res_b"},
+		IdentityChanges: []identityChange{{ResourceID: "res_a", Added: []string{"identity-2"}, Removed: []string{"identity-1"}}},
+	}
+	sig, err := signDelta(testKey, delta)
+	if err != nil {
+		t.Fatalf("signDelta failed: %v", err)
+	}
+	delta.DeltaMeta.Signature = sig
+
+	if ok, reason := cache.ApplyDelta(delta); !ok {
+		t.Fatalf("expected delta to apply, got %s", reason)
+	}
+	if allowed, _, _ := cache.Allowed("identity-1", "a.internal", "TCP", 443); allowed {
+		t.Fatalf("expected identity-1 to lose access to res_a")
+	}
+	if allowed, _, _ := cache.Allowed("identity-2", "a.internal", "TCP", 443); !allowed {
+		t.Fatalf("expected identity-2 to gain access to res_a")
+	}
+	if allowed, _, _ := cache.Allowed("identity-1", "c.internal", "TCP", 443); !allowed {
+		t.Fatalf("expected res_c to be added")
+	}
+
+	// Replaying the same delta no longer matches the installed version.
+	if ok, reason := cache.ApplyDelta(delta); ok || reason != "base_version_mismatch" {
+		t.Fatalf("expected base_version_mismatch, got ok=%v reason=%s", ok, reason)
+	}
+	if allowed, _, _ := cache.Allowed("identity-2", "a.internal", "TCP", 443); !allowed {
+		t.Fatalf("expected rejected delta to keep the current policy")
+	}
+}
+
+func TestPolicyCacheRejectsUnknownKey(t *testing.T) {
+	snap := newSignedSnapshot(t, nil)
+	cache := newPolicyCache(identity.PolicyKeys{}, 5*time.Minute)
+	if ok, reason := cache.ApplySnapshot(snap); ok || reason != "invalid_signature" {
+		t.Fatalf("expected invalid_signature without trusted key, got ok=%v reason=%s", ok, reason)
+	}
+
+	cache.SetPolicyKeys(testPolicyKeys())
+	if ok, reason := cache.ApplySnapshot(snap); !ok {
+		t.Fatalf("expected snapshot to verify after key update, got %s", reason)
+	}
+
+	snap.SnapshotMeta.PolicyVersion++
+	if ok, _ := cache.ApplySnapshot(snap); ok {
+		t.Fatalf("expected tampered snapshot to be rejected")
+	}
+}
+
+func TestPolicyCachePersistsLastKnownGood(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	resources := []policyResource{
+		{ResourceID: "res_a", Type: "dns", Address: "a.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-1"}},
+	}
+	cache := newPolicyCache(testPolicyKeys(), 5*time.Minute)
+	if err := cache.LoadPersisted(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing policy file, got %v", err)
+	}
+	if ok, reason := cache.ApplySnapshot(newSignedSnapshot(t, resources)); !ok {
+		t.Fatalf("ApplySnapshot failed: %s", reason)
+	}
+
+	restored := newPolicyCache(testPolicyKeys(), 5*time.Minute)
+	if err := restored.LoadPersisted(path); err != nil {
+		t.Fatalf("LoadPersisted failed: %v", err)
+	}
+	if allowed, _, reason := restored.Allowed("identity-1", "a.internal", "TCP", 443); !allowed {
+		t.Fatalf("expected restored policy to allow, got %s", reason)
+	}
+
+	untrusted := newPolicyCache(identity.PolicyKeys{}, 5*time.Minute)
+	if err := untrusted.LoadPersisted(path); err == nil {
+		t.Fatalf("expected stored snapshot to fail verification without keys")
+	}
+}
+
+func TestPolicyCacheSurvivabilityGrace(t *testing.T) {
+	snap := policySnapshot{
+		SnapshotMeta: snapshotMeta{
+			ConnectorID:               "con_test",
+			PolicyVersion:             1,
+			CompiledAt:                time.Now().UTC().Add(-30 * time.Minute).Format(time.RFC3339),
+			ValidUntil:                time.Now().UTC().Add(-20 * time.Minute).Format(time.RFC3339),
+			KeyID:                     testKeyID,
+			SurvivabilityGraceSeconds: 3600,
+		},
+		Resources: []policyResource{
+			{ResourceID: "res_a", Type: "dns", Address: "a.internal", Protocol: "TCP", AllowedIdentities: []string{"identity-1"}},
+		},
+	}
+	sig, err := signSnapshot(testKey, snap)
+	if err != nil {
+		t.Fatalf("signSnapshot failed: %v", err)
+	}
+	snap.SnapshotMeta.Signature = sig
+
+	cache := newPolicyCache(testPolicyKeys(), 5*time.Minute)
+	if ok, reason := cache.ApplySnapshot(snap); !ok {
+		t.Fatalf("expected survivability grace to accept expired snapshot, got %s", reason)
+	}
+	if allowed, _, reason := cache.Allowed("identity-1", "a.internal", "TCP", 443); !allowed {
+		t.Fatalf("expected allow while controller unreachable, got %s", reason)
+	}
+
+	cache.SetControllerConnected(true)
+	if allowed, _, reason := cache.Allowed("identity-1", "a.internal", "TCP", 443); allowed || reason != "snapshot_expired" {
+		t.Fatalf("expected snapshot_expired with controller connected, got allowed=%v reason=%s", allowed, reason)
+	}
+}
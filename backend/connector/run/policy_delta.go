@@ -0,0 +1,129 @@
+package run
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// policyDelta mirrors the controller's incremental policy update.
+type policyDelta struct {
+	DeltaMeta       deltaMeta        `json:"delta_meta"`
+	Added           []policyResource `json:"added"`
+	Changed         []policyResource `json:"changed"`
+	Removed         []string         `json:"removed"`
+	IdentityChanges []identityChange `json:"identity_changes"`
+}
+
+type deltaMeta struct {
+	ConnectorID   string `json:"connector_id"`
+	BaseVersion   int    `json:"base_version"`
+	PolicyVersion int    `json:"policy_version"`
+	PolicyHash    string `json:"policy_hash"`
+	CompiledAt    string `json:"compiled_at"`
+	ValidUntil    string `json:"valid_until"`
+	KeyID         string `json:"key_id"`
+	Signature     string `json:"signature"`
+
+	SurvivabilityGraceSeconds int `json:"survivability_grace_seconds,omitempty"`
+}
+
+type identityChange struct {
+	ResourceID string   `json:"resource_id"`
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+}
+
+// ApplyDelta patches the current snapshot with delta. Unlike a rejected full
+// snapshot, a rejected delta leaves the current policy in place; the caller
+// should request a full snapshot. Rejection reasons: invalid_signature,
+// invalid_valid_until, base_version_mismatch, unknown_resource, hash_mismatch.
+func (p *policyCache) ApplyDelta(delta policyDelta) (bool, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !verifyDelta(p.keys, delta) {
+		log.Printf("policy delta rejected: invalid signature")
+		return false, "invalid_signature"
+	}
+	validUntil, err := time.Parse(time.RFC3339, delta.DeltaMeta.ValidUntil)
+	if err != nil {
+		log.Printf("policy delta rejected: invalid valid_until")
+		return false, "invalid_valid_until"
+	}
+	if !p.hasSnapshot || p.meta.PolicyVersion != delta.DeltaMeta.BaseVersion {
+		log.Printf("policy delta rejected: base_version=%d current=%d", delta.DeltaMeta.BaseVersion, p.meta.PolicyVersion)
+		return false, "base_version_mismatch"
+	}
+
+	next := make(map[string]policyResource, len(p.byID)+len(delta.Added))
+	for id, res := range p.byID {
+		next[id] = res
+	}
+	for _, id := range delta.Removed {
+		delete(next, id)
+	}
+	for _, res := range delta.Added {
+		next[res.ResourceID] = res
+	}
+	for _, res := range delta.Changed {
+		next[res.ResourceID] = res
+	}
+	for _, change := range delta.IdentityChanges {
+		res, ok := next[change.ResourceID]
+		if !ok {
+			log.Printf("policy delta rejected: identity change for unknown resource %s", change.ResourceID)
+			return false, "unknown_resource"
+		}
+		res.AllowedIdentities = patchIdentities(res.AllowedIdentities, change.Added, change.Removed)
+		next[change.ResourceID] = res
+	}
+
+	resources := make([]policyResource, 0, len(next))
+	for _, res := range next {
+		resources = append(resources, res)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].ResourceID < resources[j].ResourceID
+	})
+	if policyHash(resources) != delta.DeltaMeta.PolicyHash {
+		log.Printf("policy delta rejected: resulting policy hash does not match version %d", delta.DeltaMeta.PolicyVersion)
+		return false, "hash_mismatch"
+	}
+
+	meta := snapshotMeta{
+		ConnectorID:   delta.DeltaMeta.ConnectorID,
+		PolicyVersion: delta.DeltaMeta.PolicyVersion,
+		CompiledAt:    delta.DeltaMeta.CompiledAt,
+		ValidUntil:    delta.DeltaMeta.ValidUntil,
+		KeyID:         delta.DeltaMeta.KeyID,
+		Signature:     delta.DeltaMeta.Signature,
+
+		SurvivabilityGraceSeconds: delta.DeltaMeta.SurvivabilityGraceSeconds,
+	}
+	p.installLocked(meta, resources, validUntil)
+	p.persisted.Deltas = append(p.persisted.Deltas, delta)
+	p.persistLocked()
+	return true, ""
+}
+
+func patchIdentities(current, added, removed []string) []string {
+	drop := make(map[string]struct{}, len(removed))
+	for _, id := range removed {
+		drop[id] = struct{}{}
+	}
+	set := make(map[string]struct{}, len(current)+len(added))
+	out := make([]string, 0, len(current)+len(added))
+	for _, id := range append(append([]string(nil), current...), added...) {
+		if _, ok := drop[id]; ok {
+			continue
+		}
+		if _, ok := set[id]; ok {
+			continue
+		}
+		set[id] = struct{}{}
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
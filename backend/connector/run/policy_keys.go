@@ -0,0 +1,102 @@
+package run
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"controller/identity"
+)
+
+// policyKeysMessage is the payload of a policy_keys control message.
+type policyKeysMessage struct {
+	Keys []struct {
+		KeyID     string `json:"key_id"`
+		PublicKey []byte `json:"public_key"`
+	} `json:"keys"`
+}
+
+func (m policyKeysMessage) policyKeys() identity.PolicyKeys {
+	keys := make(identity.PolicyKeys, len(m.Keys))
+	for _, k := range m.Keys {
+		if k.KeyID == "" || len(k.PublicKey) != ed25519.PublicKeySize {
+			log.Printf("ignoring invalid policy signing key %q", k.KeyID)
+			continue
+		}
+		keys[k.KeyID] = ed25519.PublicKey(k.PublicKey)
+	}
+	return keys
+}
+
+// SetPolicyKeys replaces the trusted policy verification keys and persists
+// them when they changed, so snapshots can be verified after a restart.
+func (p *policyCache) SetPolicyKeys(keys identity.PolicyKeys) {
+	p.mu.Lock()
+	changed := !samePolicyKeys(p.keys, keys)
+	p.keys = keys
+	store := p.keyStore
+	p.mu.Unlock()
+	if !changed {
+		return
+	}
+	log.Printf("policy signing keys updated: %d trusted", len(keys))
+	if store != nil {
+		if err := store.SavePolicyKeys(keys); err != nil {
+			log.Printf("failed to persist policy keys to %s: %v", store.PolicyKeysPath(), err)
+		}
+	}
+}
+
+func (p *policyCache) policyKeys() identity.PolicyKeys {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys
+}
+
+func samePolicyKeys(a, b identity.PolicyKeys) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, pub := range a {
+		if other, ok := b[id]; !ok || !pub.Equal(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifySignature checks a hex Ed25519 signature over payload with the key
+// named by keyID.
+func verifySignature(keys identity.PolicyKeys, keyID, signature string, payload []byte) bool {
+	pub, ok := keys[keyID]
+	if !ok {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, payload, sig)
+}
+
+func verifySnapshot(keys identity.PolicyKeys, snap policySnapshot) bool {
+	sig := snap.SnapshotMeta.Signature
+	snap.SnapshotMeta.Signature = ""
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return false
+	}
+	return verifySignature(keys, snap.SnapshotMeta.KeyID, sig, data)
+}
+
+func verifyDelta(keys identity.PolicyKeys, delta policyDelta) bool {
+	sig := delta.DeltaMeta.Signature
+	delta.DeltaMeta.Signature = ""
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return false
+	}
+	return verifySignature(keys, delta.DeltaMeta.KeyID, sig, data)
+}
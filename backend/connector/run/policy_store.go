@@ -0,0 +1,147 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxPersistedDeltas bounds the delta chain kept on disk after the last full
+// snapshot. Once reached, the connector asks the controller for a full
+// snapshot so the file stays small.
+const maxPersistedDeltas = 64
+
+// persistedPolicy is the last-known-good policy written to disk: the last
+// verified full snapshot and the deltas applied on top of it. Both keep their
+// controller signatures so a restarted connector can verify them offline.
+type persistedPolicy struct {
+	Snapshot policySnapshot `json:"snapshot"`
+	Deltas   []policyDelta  `json:"deltas,omitempty"`
+}
+
+// SetControllerConnected records whether the control stream is up. While it
+// is down, the network's survivability grace extends how long the installed
+// policy is enforced past valid_until.
+func (p *policyCache) SetControllerConnected(connected bool) {
+	p.mu.Lock()
+	p.controllerConnected = connected
+	p.mu.Unlock()
+}
+
+// graceLocked is how long past valid_until meta stays enforceable. p.mu must
+// be held.
+func (p *policyCache) graceLocked(meta snapshotMeta) time.Duration {
+	grace := p.staleGrace
+	if !p.controllerConnected && meta.SurvivabilityGraceSeconds > 0 {
+		grace += time.Duration(meta.SurvivabilityGraceSeconds) * time.Second
+	}
+	return grace
+}
+
+// persistLocked writes the last-known-good policy to p.statePath. p.mu must
+// be held.
+func (p *policyCache) persistLocked() {
+	if p.statePath == "" {
+		return
+	}
+	data, err := json.Marshal(p.persisted)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(p.statePath, data); err != nil {
+		log.Printf("failed to persist policy to %s: %v", p.statePath, err)
+	}
+}
+
+// LoadPersisted installs the policy stored at path, re-verifying the snapshot
+// and every delta. Later writes go to the same path.
+func (p *policyCache) LoadPersisted(path string) error {
+	defer func() {
+		p.mu.Lock()
+		p.statePath = path
+		p.mu.Unlock()
+	}()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var stored persistedPolicy
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("policy file %s: %w", path, err)
+	}
+	if ok, reason := p.ApplySnapshot(stored.Snapshot); !ok {
+		return fmt.Errorf("policy file %s: snapshot %s", path, reason)
+	}
+	for _, delta := range stored.Deltas {
+		if ok, reason := p.ApplyDelta(delta); !ok {
+			log.Printf("stored policy delta %d rejected (%s); using version %d", delta.DeltaMeta.PolicyVersion, reason, p.version())
+			break
+		}
+	}
+	return nil
+}
+
+// Discard drops the installed policy and its persisted copy, so nothing is
+// allowed until the controller sends a new snapshot.
+func (p *policyCache) Discard() {
+	p.clear()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.persisted = persistedPolicy{}
+	if p.statePath == "" {
+		return
+	}
+	if err := os.Remove(p.statePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove policy file %s: %v", p.statePath, err)
+	}
+}
+
+func (p *policyCache) version() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.meta.PolicyVersion
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".policy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// policyFileFromEnv returns POLICY_CACHE_FILE, defaulting to policy.json in
+// systemd's STATE_DIRECTORY. Empty disables persistence.
+func policyFileFromEnv() string {
+	if path := strings.TrimSpace(os.Getenv("POLICY_CACHE_FILE")); path != "" {
+		return path
+	}
+	if dir := strings.TrimSpace(os.Getenv("STATE_DIRECTORY")); dir != "" {
+		return filepath.Join(dir, "policy.json")
+	}
+	return ""
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
@@ -26,13 +25,19 @@ import (
 	"connector/internal/spiffe"
 	"connector/internal/tlsutil"
 	controllerpb "controller/gen/controllerpb"
+	"controller/identity"
+	"controller/trustbundle"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
-const policyKeyLabel = "ztna-policy-signing-v1"
+// snapshotRequestInterval rate-limits snapshot_request messages.
+const snapshotRequestInterval = 30 * time.Second
 
 // Run starts the long-running connector service.
 func Run() error {
@@ -61,39 +66,98 @@ func Run() error {
 		go systemdWatchdogLoop(ctx)
 	}
 
-	if enrollCfg.Token == "" {
-		return fmt.Errorf("ENROLLMENT_TOKEN is required for enrollment")
-	}
-	cert, certPEM, caPEM, spiffeID, err := enroll.Enroll(ctx, enrollCfg)
+	ids, err := enroll.IdentityStoreFromEnv()
 	if err != nil {
 		return err
 	}
+	var (
+		workloadCert tls.Certificate
+		certPEM      []byte
+		caPEM        []byte
+		spiffeID     string
+		policyKeys   identity.PolicyKeys
+		restored     bool
+	)
+	if ids != nil {
+		id, err := ids.Load()
+		switch {
+		case err == nil && id.SPIFFEID != fmt.Sprintf("spiffe://%s/connector/%s", cfg.trustDomain, cfg.connectorID):
+			log.Printf("stored identity %s does not match TRUST_DOMAIN and CONNECTOR_ID; re-enrolling", id.SPIFFEID)
+		case err == nil && time.Now().Before(id.NotAfter):
+			workloadCert, certPEM, caPEM, spiffeID = id.Certificate, id.CertPEM, id.CAPEM, id.SPIFFEID
+			restored = true
+		case err == nil:
+			log.Printf("stored identity expired at %s; re-enrolling", id.NotAfter.Format(time.RFC3339))
+		case !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("load identity: %w", err)
+		}
+	}
+	if !restored {
+		if enrollCfg.Token == "" && enrollCfg.MachineKey == nil {
+			return fmt.Errorf("ENROLLMENT_TOKEN or MACHINE_CERT is required for enrollment")
+		}
+		workloadCert, certPEM, caPEM, spiffeID, policyKeys, err = enroll.Enroll(ctx, enrollCfg)
+		if err != nil {
+			return err
+		}
+	}
 
 	certInfo, err := parseLeafCert(certPEM)
 	if err != nil {
 		return err
 	}
-	workloadCert := cert
-	notAfter := certInfo.NotAfter
+	store := tlsutil.NewCertStore(workloadCert, certPEM, certInfo.NotAfter)
 	totalTTL := certInfo.NotAfter.Sub(certInfo.NotBefore)
-
-	log.Printf("connector enrolled as %s", spiffeID)
-
-	store := tlsutil.NewCertStore(workloadCert, nil, notAfter)
-	rootPool, err := tlsutil.RootPoolFromPEM(caPEM)
+	trust, err := trustbundle.New(caPEM)
 	if err != nil {
 		return err
 	}
+
+	if restored {
+		log.Printf("connector restored identity %s from %s", spiffeID, ids.Path())
+		cert, renewedPEM, notAfter, notBefore, err := renewOnce(ctx, cfg.controllerAddr, cfg.connectorID, cfg.trustDomain, store, trust)
+		if err != nil {
+			log.Printf("startup renewal failed, using stored certificate until %s: %v", certInfo.NotAfter.Format(time.RFC3339), err)
+		} else {
+			store.Update(cert, renewedPEM, notAfter)
+			workloadCert, certPEM = cert, renewedPEM
+			totalTTL = notAfter.Sub(notBefore)
+		}
+	} else {
+		log.Printf("connector enrolled as %s", spiffeID)
+	}
+	if ids != nil {
+		if err := ids.Save(workloadCert, certPEM, trust.PEM()); err != nil {
+			log.Printf("failed to persist identity to %s: %v", ids.Path(), err)
+		}
+		if restored {
+			policyKeys, err = ids.LoadPolicyKeys()
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("failed to load policy keys, waiting for controller: %v", err)
+			}
+		} else if err := ids.SavePolicyKeys(policyKeys); err != nil {
+			log.Printf("failed to persist policy keys to %s: %v", ids.PolicyKeysPath(), err)
+		}
+	}
+
 	allowlist := newTunnelerAllowlist()
-	policyCache := newPolicyCache(cfg.policyKey, cfg.staleGrace)
-	controllerSendCh := make(chan *controllerpb.ControlMessage, 16)
+	policyCache := newPolicyCache(policyKeys, cfg.staleGrace)
+	policyCache.keyStore = ids
+	if cfg.policyFile != "" {
+		if err := policyCache.LoadPersisted(cfg.policyFile); err == nil {
+			log.Printf("restored last-known-good policy version %d from %s", policyCache.version(), cfg.policyFile)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("ignoring stored policy: %v", err)
+		}
+	}
+	controllerSendCh := newControllerQueue()
 
 	reloadCh := make(chan struct{}, 1)
-	go controlPlaneLoop(ctx, cfg.controllerAddr, cfg.trustDomain, cfg.connectorID, cfg.privateIP, store, rootPool, allowlist, policyCache, controllerSendCh, reloadCh)
-	go renewalLoop(ctx, cfg.controllerAddr, cfg.connectorID, cfg.trustDomain, store, rootPool, caPEM, totalTTL)
+	go controlPlaneLoop(ctx, cfg.controllerAddr, cfg.trustDomain, cfg.connectorID, cfg.privateIP, store, trust, allowlist, policyCache, controllerSendCh, reloadCh)
+	go renewalLoop(ctx, cfg.controllerAddr, cfg.connectorID, cfg.trustDomain, store, trust, totalTTL, ids)
 
 	if cfg.listenAddr != "" {
-		go serverLoop(ctx, cfg.listenAddr, cfg.trustDomain, store, rootPool, allowlist, policyCache, controllerSendCh, cfg.connectorID)
+		go serverLoop(ctx, cfg.listenAddr, cfg.trustDomain, store, trust, allowlist, policyCache, controllerSendCh, cfg.connectorID, cfg.tunnel)
 	}
 
 	<-ctx.Done()
@@ -169,8 +233,9 @@ type runtimeConfig struct {
 	trustDomain    string
 	listenAddr     string
 	privateIP      string
-	policyKey      []byte
 	staleGrace     time.Duration
+	policyFile     string
+	tunnel         tunnelConfig
 }
 
 func configFromEnv() (runtimeConfig, error) {
@@ -178,13 +243,26 @@ func configFromEnv() (runtimeConfig, error) {
 	connectorID := os.Getenv("CONNECTOR_ID")
 	trustDomain := os.Getenv("TRUST_DOMAIN")
 	listenAddr := os.Getenv("CONNECTOR_LISTEN_ADDR")
-	policyKey := ""
 	staleGrace := 10 * time.Minute
 	if v := strings.TrimSpace(os.Getenv("POLICY_STALE_GRACE_SECONDS")); v != "" {
 		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
 			staleGrace = time.Duration(secs) * time.Second
 		}
 	}
+	tunnel := tunnelConfig{
+		idleTimeout:        5 * time.Minute,
+		udpFlowIdleTimeout: time.Minute,
+	}
+	if v := strings.TrimSpace(os.Getenv("TUNNEL_IDLE_TIMEOUT_SECONDS")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			tunnel.idleTimeout = time.Duration(secs) * time.Second
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("UDP_FLOW_IDLE_TIMEOUT_SECONDS")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			tunnel.udpFlowIdleTimeout = time.Duration(secs) * time.Second
+		}
+	}
 
 	if trustDomain == "" {
 		trustDomain = "mycorp.internal"
@@ -209,12 +287,13 @@ func configFromEnv() (runtimeConfig, error) {
 		trustDomain:    trustDomain,
 		listenAddr:     listenAddr,
 		privateIP:      privateIP,
-		policyKey:      []byte(policyKey),
 		staleGrace:     staleGrace,
+		policyFile:     policyFileFromEnv(),
+		tunnel:         tunnel,
 	}, nil
 }
 
-func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh chan<- *controllerpb.ControlMessage, connectorID string) error {
+func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh *controllerQueue, connectorID string, tunnel tunnelConfig) error {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
@@ -223,9 +302,16 @@ func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, root
 	tlsConfig := &tls.Config{
 		MinVersion:     tls.VersionTLS13,
 		ClientAuth:     tls.RequireAndVerifyClientCert,
-		ClientCAs:      roots,
 		GetCertificate: store.GetCertificate,
 	}
+	// Resolve client CAs per handshake so CA bundle updates apply without
+	// restarting the listener.
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := tlsConfig.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = trust.Pool()
+		return cfg, nil
+	}
 
 	grpcServer := grpc.NewServer(
 		grpc.Creds(credentials.NewTLS(tlsConfig)),
@@ -237,13 +323,15 @@ func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, root
 		connectorID: connectorID,
 		sendCh:      controllerSendCh,
 		acls:        acl,
+		tunnel:      tunnel,
+		trust:       trust,
 	})
 
 	log.Printf("connector server listening on %s", addr)
 	return grpcServer.Serve(lis)
 }
 
-func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh chan<- *controllerpb.ControlMessage, connectorID string) {
+func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh *controllerQueue, connectorID string, tunnel tunnelConfig) {
 	backoff := 2 * time.Second
 	for {
 		select {
@@ -252,7 +340,7 @@ func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.Ce
 		default:
 		}
 
-		if err := runConnectorServer(addr, trustDomain, store, roots, allowlist, acl, controllerSendCh, connectorID); err != nil && !errors.Is(err, context.Canceled) {
+		if err := runConnectorServer(addr, trustDomain, store, trust, allowlist, acl, controllerSendCh, connectorID, tunnel); err != nil && !errors.Is(err, context.Canceled) {
 			log.Printf("connector server stopped: %v", err)
 		}
 
@@ -269,7 +357,7 @@ func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.Ce
 	}
 }
 
-func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh <-chan *controllerpb.ControlMessage, reloadCh <-chan struct{}) {
+func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, trust *trustbundle.Bundle, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh *controllerQueue, reloadCh <-chan struct{}) {
 	backoff := 2 * time.Second
 	for {
 		select {
@@ -281,7 +369,7 @@ func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connecto
 		sessionCtx, cancel := context.WithCancel(ctx)
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- connectControlPlane(sessionCtx, controllerAddr, trustDomain, connectorID, privateIP, store, roots, allowlist, acl, controllerSendCh)
+			errCh <- connectControlPlane(sessionCtx, controllerAddr, trustDomain, connectorID, privateIP, store, trust, allowlist, acl, controllerSendCh)
 		}()
 
 		select {
@@ -297,6 +385,15 @@ func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connecto
 			if err != nil && !errors.Is(err, context.Canceled) {
 				log.Printf("control-plane connection ended: %v", err)
 			}
+			if reason := controllerDisconnectReason(err); reason != "" {
+				// The controller deleted or disabled this connector: stop
+				// serving tunnelers until it lets us back in.
+				n := allowlist.RemoveAll()
+				if acl != nil {
+					acl.Discard()
+				}
+				log.Printf("connector %s by controller; closed %d tunneler streams and dropped policy", strings.ToLower(strings.TrimPrefix(reason, "CONNECTOR_")), n)
+			}
 		}
 
 		timer := time.NewTimer(backoff)
@@ -312,32 +409,45 @@ func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connecto
 	}
 }
 
-func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh <-chan *controllerpb.ControlMessage) error {
+// Reasons the controller gives, as an ErrorInfo reason, for ending or
+// refusing this connector's control stream.
+const (
+	disconnectDeleted  = "CONNECTOR_DELETED"
+	disconnectDisabled = "CONNECTOR_DISABLED"
+)
+
+// controllerDisconnectReason returns the reason in err if the controller
+// closed the control stream because the connector was deleted or disabled.
+func controllerDisconnectReason(err error) string {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.PermissionDenied {
+		return ""
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			switch info.GetReason() {
+			case disconnectDeleted, disconnectDisabled:
+				return info.GetReason()
+			}
+		}
+	}
+	return ""
+}
+
+func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, trust *trustbundle.Bundle, allowlist *tunnelerAllowlist, acl *policyCache, controllerSendCh *controllerQueue) error {
 	tlsConfig := &tls.Config{
 		MinVersion:           tls.VersionTLS13,
 		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
+		RootCAs:              trust.Pool(),
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
 		},
 	}
 
-	creds := newExportingCreds(
-		credentials.NewTLS(tlsConfig),
-		policyKeyLabel,
-		[]byte(connectorID),
-		func(key []byte) {
-			if acl != nil {
-				acl.SetSigningKey(key)
-			}
-			log.Printf("derived policy signing key from mTLS")
-		},
-	)
-
 	conn, err := grpc.DialContext(
 		ctx,
 		controllerAddr,
-		grpc.WithTransportCredentials(creds),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:                30 * time.Second,
 			Timeout:             10 * time.Second,
@@ -358,6 +468,10 @@ func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, conne
 	if err := stream.Send(&controllerpb.ControlMessage{Type: "connector_hello"}); err != nil {
 		return err
 	}
+	if acl != nil {
+		acl.SetControllerConnected(true)
+		defer acl.SetControllerConnected(false)
+	}
 
 	recvCh := make(chan *controllerpb.ControlMessage, 1)
 	recvErr := make(chan error, 1)
@@ -374,6 +488,7 @@ func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, conne
 
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
+	var lastSnapshotRequest time.Time
 
 	for {
 		select {
@@ -382,14 +497,26 @@ func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, conne
 		case err := <-recvErr:
 			return err
 		case msg := <-recvCh:
-			handleControlMessage(msg, allowlist, acl)
-		case msg := <-controllerSendCh:
-			if msg != nil {
-				if err := stream.Send(msg); err != nil {
+			if reply := handleControlMessage(msg, allowlist, acl, trust); reply != nil {
+				if err := stream.Send(reply); err != nil {
 					return err
 				}
 			}
+		case msg := <-controllerSendCh.msgs:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case msg := <-controllerSendCh.audit:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
 		case <-ticker.C:
+			if report, n := controllerSendCh.takeDropped(); report != nil {
+				if err := stream.Send(report); err != nil {
+					controllerSendCh.restoreDropped(n)
+					return err
+				}
+			}
 			if err := stream.Send(&controllerpb.ControlMessage{
 				Type:        "heartbeat",
 				ConnectorId: connectorID,
@@ -398,64 +525,18 @@ func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, conne
 			}); err != nil {
 				return err
 			}
+			if acl != nil && acl.refreshDue(time.Now()) && time.Since(lastSnapshotRequest) >= snapshotRequestInterval {
+				lastSnapshotRequest = time.Now()
+				log.Printf("policy snapshot missing or near expiry; requesting refresh")
+				if err := stream.Send(snapshotRequest("near_expiry")); err != nil {
+					return err
+				}
+			}
 		}
 	}
 }
 
-type exportingCreds struct {
-	base    credentials.TransportCredentials
-	label   string
-	context []byte
-	onKey   func([]byte)
-}
-
-func newExportingCreds(base credentials.TransportCredentials, label string, context []byte, onKey func([]byte)) *exportingCreds {
-	return &exportingCreds{
-		base:    base,
-		label:   label,
-		context: append([]byte(nil), context...),
-		onKey:   onKey,
-	}
-}
-
-func (e *exportingCreds) ClientHandshake(ctx context.Context, addr string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
-	c, info, err := e.base.ClientHandshake(ctx, addr, conn)
-	if err != nil {
-		return c, info, err
-	}
-	tlsInfo, ok := info.(credentials.TLSInfo)
-	if !ok {
-		return c, info, nil
-	}
-	key, err := tlsInfo.State.ExportKeyingMaterial(e.label, e.context, 32)
-	if err == nil && e.onKey != nil {
-		e.onKey(key)
-	}
-	return c, info, nil
-}
-
-func (e *exportingCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
-	return e.base.ServerHandshake(conn)
-}
-
-func (e *exportingCreds) Info() credentials.ProtocolInfo {
-	return e.base.Info()
-}
-
-func (e *exportingCreds) Clone() credentials.TransportCredentials {
-	return &exportingCreds{
-		base:    e.base.Clone(),
-		label:   e.label,
-		context: append([]byte(nil), e.context...),
-		onKey:   e.onKey,
-	}
-}
-
-func (e *exportingCreds) OverrideServerName(name string) error {
-	return e.base.OverrideServerName(name)
-}
-
-func renewalLoop(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte, totalTTL time.Duration) {
+func renewalLoop(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, totalTTL time.Duration, ids *identity.Store) {
 	for {
 		next := nextRenewal(store.NotAfter(), totalTTL)
 		timer := time.NewTimer(time.Until(next))
@@ -463,10 +544,21 @@ func renewalLoop(ctx context.Context, controllerAddr, connectorID, trustDomain s
 		case <-ctx.Done():
 			timer.Stop()
 			return
+		case <-trust.Changed():
+			// A CA bundle pushed over the control stream; persist it with
+			// the current certificate.
+			timer.Stop()
+			if ids != nil {
+				cert, certPEM := store.Certificate()
+				if err := ids.Save(cert, certPEM, trust.PEM()); err != nil {
+					log.Printf("failed to persist CA bundle to %s: %v", ids.Path(), err)
+				}
+			}
+			continue
 		case <-timer.C:
 		}
 
-		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, connectorID, trustDomain, store, roots, caPEM)
+		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, connectorID, trustDomain, store, trust)
 		if err != nil {
 			log.Printf("certificate renewal failed: %v", err)
 			continue
@@ -474,10 +566,15 @@ func renewalLoop(ctx context.Context, controllerAddr, connectorID, trustDomain s
 
 		store.Update(cert, certPEM, notAfter)
 		totalTTL = notAfter.Sub(notBefore)
+		if ids != nil {
+			if err := ids.Save(cert, certPEM, trust.PEM()); err != nil {
+				log.Printf("failed to persist renewed identity to %s: %v", ids.Path(), err)
+			}
+		}
 	}
 }
 
-func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte) (tls.Certificate, []byte, time.Time, time.Time, error) {
+func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle) (tls.Certificate, []byte, time.Time, time.Time, error) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
@@ -489,11 +586,15 @@ func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain str
 	}
 
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	csrPEM, err := tlsutil.CreateCSR(privKey, fmt.Sprintf("spiffe://%s/connector/%s", trustDomain, connectorID))
+	if err != nil {
+		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
+	}
 
 	tlsConfig := &tls.Config{
 		MinVersion:           tls.VersionTLS13,
 		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
+		RootCAs:              trust.Pool(),
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
 		},
@@ -510,31 +611,47 @@ func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain str
 	defer conn.Close()
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
-	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: connectorID, PublicKey: pubPEM})
+	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: connectorID, PublicKey: pubPEM, Csr: csrPEM})
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
 	if len(resp.CaCertificate) == 0 {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("empty CA certificate in renewal response")
 	}
-	if !tlsutil.EqualCAPEM(caPEM, resp.CaCertificate) {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("internal CA mismatch during renewal")
-	}
-
-	block, _ := pem.Decode(resp.Certificate)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("invalid certificate PEM")
-	}
 
-	leaf, err := x509.ParseCertificate(block.Bytes)
+	chain, leaf, err := tlsutil.ParseCertChain(resp.Certificate)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
+	if err := acceptCABundle(trust, resp.CaCertificate, leaf); err != nil {
+		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
+	}
 
-	workloadCert := tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: privKey}
+	workloadCert := tls.Certificate{Certificate: chain, PrivateKey: privKey}
 	return workloadCert, resp.Certificate, leaf.NotAfter, leaf.NotBefore, nil
 }
 
+// acceptCABundle installs the CA bundle returned with a renewed certificate.
+// During a CA rotation it differs from the trusted bundle; it must still
+// share a root with it and must verify the new certificate.
+func acceptCABundle(trust *trustbundle.Bundle, bundlePEM []byte, leaf *x509.Certificate) error {
+	roots, err := tlsutil.RootPoolFromPEM(bundlePEM)
+	if err != nil {
+		return err
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return fmt.Errorf("renewed certificate does not chain to CA bundle: %w", err)
+	}
+	changed, err := trust.Update(bundlePEM)
+	if err != nil {
+		return fmt.Errorf("CA bundle rejected during renewal: %w", err)
+	}
+	if changed {
+		log.Printf("CA bundle updated during renewal")
+	}
+	return nil
+}
+
 func nextRenewal(notAfter time.Time, totalTTL time.Duration) time.Time {
 	remaining := time.Until(notAfter)
 	if remaining <= 0 {
@@ -562,10 +679,68 @@ func parseLeafCert(certPEM []byte) (*x509.Certificate, error) {
 type tunnelerAllowlist struct {
 	mu       sync.RWMutex
 	bySPIFFE map[string]struct{}
+	// Cancel funcs of open tunneler streams by SPIFFE ID, so removing a
+	// tunneler can end them.
+	streams    map[string]map[uint64]context.CancelFunc
+	nextStream uint64
+	// Revoked certificate serials (decimal) and SPIFFE IDs pushed by the
+	// controller.
+	revokedSerials map[string]struct{}
+	revokedIDs     map[string]struct{}
 }
 
 func newTunnelerAllowlist() *tunnelerAllowlist {
-	return &tunnelerAllowlist{bySPIFFE: make(map[string]struct{})}
+	return &tunnelerAllowlist{
+		bySPIFFE:       make(map[string]struct{}),
+		streams:        make(map[string]map[uint64]context.CancelFunc),
+		revokedSerials: make(map[string]struct{}),
+		revokedIDs:     make(map[string]struct{}),
+	}
+}
+
+// caBundleMessage is the ca_bundle payload: the PEM roots workloads should
+// trust.
+type caBundleMessage struct {
+	BundlePEM string `json:"bundle_pem"`
+}
+
+// revocationList mirrors the controller's revocation_list payload.
+type revocationList struct {
+	Serials   []string `json:"serials"`
+	SPIFFEIDs []string `json:"spiffe_ids"`
+}
+
+// Revoked implements spiffe.RevocationChecker.
+func (a *tunnelerAllowlist) Revoked(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if cert.SerialNumber != nil {
+		if _, ok := a.revokedSerials[cert.SerialNumber.String()]; ok {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if _, ok := a.revokedIDs[uri.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *tunnelerAllowlist) ReplaceRevocations(list revocationList) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.revokedSerials = make(map[string]struct{}, len(list.Serials))
+	for _, serial := range list.Serials {
+		a.revokedSerials[serial] = struct{}{}
+	}
+	a.revokedIDs = make(map[string]struct{}, len(list.SPIFFEIDs))
+	for _, id := range list.SPIFFEIDs {
+		a.revokedIDs[id] = struct{}{}
+	}
 }
 
 func (a *tunnelerAllowlist) Allowed(spiffeID string) bool {
@@ -593,7 +768,60 @@ func (a *tunnelerAllowlist) Add(spiffeID string) {
 	}
 	a.mu.Lock()
 	a.bySPIFFE[spiffeID] = struct{}{}
+	// A newly allowed tunneler has re-enrolled, which lifts an identity
+	// revocation on the controller.
+	delete(a.revokedIDs, spiffeID)
+	a.mu.Unlock()
+}
+
+// Remove drops spiffeID from the allowlist and cancels its open streams.
+func (a *tunnelerAllowlist) Remove(spiffeID string) int {
+	a.mu.Lock()
+	delete(a.bySPIFFE, spiffeID)
+	streams := a.streams[spiffeID]
+	delete(a.streams, spiffeID)
 	a.mu.Unlock()
+	for _, cancel := range streams {
+		cancel()
+	}
+	return len(streams)
+}
+
+// RemoveAll empties the allowlist and cancels every open tunneler stream.
+func (a *tunnelerAllowlist) RemoveAll() int {
+	a.mu.Lock()
+	a.bySPIFFE = make(map[string]struct{})
+	streams := a.streams
+	a.streams = make(map[string]map[uint64]context.CancelFunc)
+	a.mu.Unlock()
+	n := 0
+	for _, byID := range streams {
+		for _, cancel := range byID {
+			cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// Track implements spiffe.StreamTracker.
+func (a *tunnelerAllowlist) Track(spiffeID string, cancel context.CancelFunc) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextStream++
+	id := a.nextStream
+	if a.streams[spiffeID] == nil {
+		a.streams[spiffeID] = make(map[uint64]context.CancelFunc)
+	}
+	a.streams[spiffeID][id] = cancel
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.streams[spiffeID], id)
+		if len(a.streams[spiffeID]) == 0 {
+			delete(a.streams, spiffeID)
+		}
+	}
 }
 
 type tunnelerInfo struct {
@@ -601,9 +829,11 @@ type tunnelerInfo struct {
 	SPIFFEID   string `json:"spiffe_id"`
 }
 
-func handleControlMessage(msg *controllerpb.ControlMessage, allowlist *tunnelerAllowlist, acl *policyCache) {
+// handleControlMessage applies a message from the controller and returns an
+// optional reply to send back on the stream.
+func handleControlMessage(msg *controllerpb.ControlMessage, allowlist *tunnelerAllowlist, acl *policyCache, trust *trustbundle.Bundle) *controllerpb.ControlMessage {
 	if msg == nil || allowlist == nil {
-		return
+		return nil
 	}
 	switch msg.GetType() {
 	case "tunneler_allowlist":
@@ -616,20 +846,120 @@ func handleControlMessage(msg *controllerpb.ControlMessage, allowlist *tunnelerA
 		if err := json.Unmarshal(msg.GetPayload(), &item); err == nil {
 			allowlist.Add(item.SPIFFEID)
 		}
+	case "tunneler_revoke":
+		var item tunnelerInfo
+		if err := json.Unmarshal(msg.GetPayload(), &item); err != nil || item.SPIFFEID == "" {
+			log.Printf("invalid tunneler_revoke payload")
+			return nil
+		}
+		n := allowlist.Remove(item.SPIFFEID)
+		log.Printf("tunneler revoked: %s (closed %d streams)", item.SPIFFEID, n)
+	case "ca_bundle":
+		if trust == nil {
+			return nil
+		}
+		var bundle caBundleMessage
+		if err := json.Unmarshal(msg.GetPayload(), &bundle); err != nil {
+			log.Printf("invalid ca_bundle payload: %v", err)
+			return nil
+		}
+		changed, err := trust.Update([]byte(bundle.BundlePEM))
+		if err != nil {
+			log.Printf("CA bundle rejected: %v", err)
+		} else if changed {
+			log.Printf("CA bundle updated by controller")
+		}
+	case "revocation_list":
+		var list revocationList
+		if err := json.Unmarshal(msg.GetPayload(), &list); err != nil {
+			log.Printf("invalid revocation_list payload: %v", err)
+			return nil
+		}
+		allowlist.ReplaceRevocations(list)
+		log.Printf("revocation list updated: serials=%d spiffe_ids=%d", len(list.Serials), len(list.SPIFFEIDs))
 	case "policy_snapshot":
 		if acl == nil {
-			return
+			return nil
 		}
 		var snap policySnapshot
-		if err := json.Unmarshal(msg.GetPayload(), &snap); err == nil {
-			if acl.ReplaceSnapshot(snap) {
-				log.Printf("policy snapshot applied: version=%d resources=%d", snap.SnapshotMeta.PolicyVersion, len(snap.Resources))
-				if payload, err := json.MarshalIndent(snap, "", "  "); err == nil {
-					log.Printf("policy snapshot payload:\n%s", string(payload))
-				}
+		if err := json.Unmarshal(msg.GetPayload(), &snap); err != nil {
+			return snapshotAck(0, "", false, "invalid_payload")
+		}
+		applied, reason := acl.ApplySnapshot(snap)
+		if applied {
+			log.Printf("policy snapshot applied: version=%d resources=%d", snap.SnapshotMeta.PolicyVersion, len(snap.Resources))
+			if payload, err := json.MarshalIndent(snap, "", "  "); err == nil {
+				log.Printf("policy snapshot payload:\n%s", string(payload))
 			}
 		}
+		return snapshotAck(snap.SnapshotMeta.PolicyVersion, policyHash(snap.Resources), applied, reason)
+	case "policy_keys":
+		if acl == nil {
+			return nil
+		}
+		var keys policyKeysMessage
+		if err := json.Unmarshal(msg.GetPayload(), &keys); err != nil {
+			log.Printf("invalid policy_keys payload: %v", err)
+			return nil
+		}
+		acl.SetPolicyKeys(keys.policyKeys())
+	case "policy_delta":
+		if acl == nil {
+			return nil
+		}
+		var delta policyDelta
+		if err := json.Unmarshal(msg.GetPayload(), &delta); err != nil {
+			return snapshotRequest("invalid_delta")
+		}
+		applied, reason := acl.ApplyDelta(delta)
+		if !applied {
+			return snapshotRequest(reason)
+		}
+		if n := len(delta.Added) + len(delta.Changed) + len(delta.Removed) + len(delta.IdentityChanges); n > 0 {
+			log.Printf("policy delta applied: base=%d version=%d changes=%d", delta.DeltaMeta.BaseVersion, delta.DeltaMeta.PolicyVersion, n)
+		}
+		return snapshotAck(delta.DeltaMeta.PolicyVersion, delta.DeltaMeta.PolicyHash, true, "")
+	}
+	return nil
+}
+
+// snapshotRequest asks the controller for a full policy snapshot.
+func snapshotRequest(reason string) *controllerpb.ControlMessage {
+	payload, _ := json.Marshal(map[string]string{"reason": reason})
+	return &controllerpb.ControlMessage{Type: "snapshot_request", Payload: payload}
+}
+
+// snapshotAck builds the snapshot_ack reply for a received snapshot or
+// delta. The policy hash matches the controller's hash of the same resource
+// list.
+func snapshotAck(version int, hash string, applied bool, reason string) *controllerpb.ControlMessage {
+	status := "applied"
+	if !applied {
+		status = "rejected"
+	}
+	payload, err := json.Marshal(struct {
+		PolicyVersion int    `json:"policy_version"`
+		PolicyHash    string `json:"policy_hash"`
+		Status        string `json:"status"`
+		Reason        string `json:"reason,omitempty"`
+	}{
+		PolicyVersion: version,
+		PolicyHash:    hash,
+		Status:        status,
+		Reason:        reason,
+	})
+	if err != nil {
+		return nil
 	}
+	return &controllerpb.ControlMessage{Type: "snapshot_ack", Payload: payload}
+}
+
+func policyHash(resources []policyResource) string {
+	data, _ := json.Marshal(struct {
+		Resources []policyResource `json:"resources"`
+	}{Resources: resources})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // Policy snapshot enforcement (O(1) ACL lookup)
@@ -643,7 +973,11 @@ type snapshotMeta struct {
 	PolicyVersion int    `json:"policy_version"`
 	CompiledAt    string `json:"compiled_at"`
 	ValidUntil    string `json:"valid_until"`
+	KeyID         string `json:"key_id"`
 	Signature     string `json:"signature"`
+	// SurvivabilityGraceSeconds extends enforcement past valid_until while
+	// the controller is unreachable; set per remote network.
+	SurvivabilityGraceSeconds int `json:"survivability_grace_seconds,omitempty"`
 }
 
 type policyResource struct {
@@ -667,9 +1001,15 @@ type policyCache struct {
 	aclTable    map[string]struct{}
 	meta        snapshotMeta
 	validUntil  time.Time
-	signingKey  []byte
+	keys        identity.PolicyKeys
+	keyStore    *identity.Store
 	staleGrace  time.Duration
 	hasSnapshot bool
+
+	controllerConnected bool
+	// Last-known-good policy and where it is persisted; guarded by mu.
+	persisted persistedPolicy
+	statePath string
 }
 
 type cidrEntry struct {
@@ -677,54 +1017,63 @@ type cidrEntry struct {
 	ResourceID string
 }
 
-func newPolicyCache(signingKey []byte, staleGrace time.Duration) *policyCache {
+func newPolicyCache(keys identity.PolicyKeys, staleGrace time.Duration) *policyCache {
 	return &policyCache{
 		byID:       make(map[string]policyResource),
 		byDNS:      make(map[string][]string),
 		byIP:       make(map[string][]string),
 		aclTable:   make(map[string]struct{}),
-		signingKey: signingKey,
+		keys:       keys,
 		staleGrace: staleGrace,
 	}
 }
 
-func (p *policyCache) SetSigningKey(key []byte) {
-	p.mu.Lock()
-	if len(key) == 0 {
-		p.signingKey = nil
-	} else {
-		p.signingKey = append([]byte(nil), key...)
-	}
-	p.mu.Unlock()
+// ReplaceSnapshot verifies and installs snap, reporting whether it was applied.
+func (p *policyCache) ReplaceSnapshot(snap policySnapshot) bool {
+	ok, _ := p.ApplySnapshot(snap)
+	return ok
 }
 
-func (p *policyCache) ReplaceSnapshot(snap policySnapshot) bool {
-	if !verifySnapshot(p.signingKey, snap) {
+// ApplySnapshot is ReplaceSnapshot with the rejection reason, which is
+// reported back to the controller in a snapshot_ack.
+func (p *policyCache) ApplySnapshot(snap policySnapshot) (bool, string) {
+	if !verifySnapshot(p.policyKeys(), snap) {
 		p.clear()
 		log.Printf("policy snapshot rejected: invalid signature")
-		return false
+		return false, "invalid_signature"
 	}
 	validUntil, err := time.Parse(time.RFC3339, snap.SnapshotMeta.ValidUntil)
 	if err != nil {
 		p.clear()
 		log.Printf("policy snapshot rejected: invalid valid_until")
-		return false
+		return false, "invalid_valid_until"
 	}
-	if time.Now().UTC().After(validUntil.Add(p.staleGrace)) {
+	p.mu.RLock()
+	grace := p.graceLocked(snap.SnapshotMeta)
+	p.mu.RUnlock()
+	if time.Now().UTC().After(validUntil.Add(grace)) {
 		p.clear()
 		log.Printf("policy snapshot rejected: expired beyond grace")
-		return false
+		return false, "expired"
 	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.installLocked(snap.SnapshotMeta, snap.Resources, validUntil)
+	p.persisted = persistedPolicy{Snapshot: snap}
+	p.persistLocked()
+	return true, ""
+}
+
+// installLocked rebuilds the lookup indexes from resources. p.mu must be held.
+func (p *policyCache) installLocked(meta snapshotMeta, resources []policyResource, validUntil time.Time) {
 	p.byID = make(map[string]policyResource)
 	p.byDNS = make(map[string][]string)
 	p.byIP = make(map[string][]string)
 	p.byCIDR = nil
 	p.internetIDs = nil
 	p.aclTable = make(map[string]struct{})
-	for _, res := range snap.Resources {
+	for _, res := range resources {
 		p.byID[res.ResourceID] = res
 		addr := strings.ToLower(strings.TrimSpace(res.Address))
 		switch strings.ToLower(strings.TrimSpace(res.Type)) {
@@ -749,10 +1098,25 @@ func (p *policyCache) ReplaceSnapshot(snap policySnapshot) bool {
 			p.aclTable[identity+"::"+res.ResourceID] = struct{}{}
 		}
 	}
-	p.meta = snap.SnapshotMeta
+	p.meta = meta
 	p.validUntil = validUntil
 	p.hasSnapshot = true
-	return true
+}
+
+// refreshDue reports whether a new snapshot should be requested: none is
+// loaded, or less than a quarter of the current snapshot's lifetime remains.
+func (p *policyCache) refreshDue(now time.Time) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.hasSnapshot || len(p.persisted.Deltas) >= maxPersistedDeltas {
+		return true
+	}
+	compiledAt, err := time.Parse(time.RFC3339, p.meta.CompiledAt)
+	lifetime := p.validUntil.Sub(compiledAt)
+	if err != nil || lifetime <= 0 {
+		return !now.Before(p.validUntil)
+	}
+	return p.validUntil.Sub(now) <= lifetime/4
 }
 
 func (p *policyCache) Allowed(identityID, dest, protocol string, port uint16) (bool, string, string) {
@@ -761,7 +1125,7 @@ func (p *policyCache) Allowed(identityID, dest, protocol string, port uint16) (b
 	if !p.hasSnapshot {
 		return false, "", "no_snapshot"
 	}
-	if time.Now().UTC().After(p.validUntil.Add(p.staleGrace)) {
+	if time.Now().UTC().After(p.validUntil.Add(p.graceLocked(p.meta))) {
 		log.Printf("policy snapshot expired beyond grace; denying all")
 		return false, "", "snapshot_expired"
 	}
@@ -826,38 +1190,6 @@ func (p *policyCache) clear() {
 	p.validUntil = time.Time{}
 }
 
-func verifySnapshot(key []byte, snap policySnapshot) bool {
-	if len(key) == 0 {
-		return false
-	}
-	expected, err := signSnapshot(key, snap)
-	if err != nil {
-		return false
-	}
-	sig := strings.TrimSpace(snap.SnapshotMeta.Signature)
-	sig = strings.TrimPrefix(sig, "sha256:")
-	provided, err := hex.DecodeString(sig)
-	if err != nil {
-		return false
-	}
-	want, _ := hex.DecodeString(expected)
-	return hmac.Equal(want, provided)
-}
-
-func signSnapshot(key []byte, snap policySnapshot) (string, error) {
-	if len(key) == 0 {
-		return "", errors.New("signing key not configured")
-	}
-	snap.SnapshotMeta.Signature = ""
-	data, err := json.Marshal(snap)
-	if err != nil {
-		return "", err
-	}
-	mac := hmac.New(sha256.New, key)
-	_, _ = mac.Write(data)
-	return hex.EncodeToString(mac.Sum(nil)), nil
-}
-
 func portMatches(res policyResource, port uint16) bool {
 	if res.PortFrom == nil && res.PortTo == nil {
 		if res.Port == 0 {
@@ -0,0 +1,42 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func statusWithReason(t *testing.T, code codes.Code, reason string) error {
+	t.Helper()
+	st, err := status.New(code, "disconnected by controller").WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: "controller.ztna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestControllerDisconnectReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"deleted", statusWithReason(t, codes.PermissionDenied, "CONNECTOR_DELETED"), disconnectDeleted},
+		{"disabled", statusWithReason(t, codes.PermissionDenied, "CONNECTOR_DISABLED"), disconnectDisabled},
+		{"wrapped", fmt.Errorf("recv: %w", statusWithReason(t, codes.PermissionDenied, "CONNECTOR_DISABLED")), disconnectDisabled},
+		{"other reason", statusWithReason(t, codes.PermissionDenied, "SOMETHING_ELSE"), ""},
+		{"other code", statusWithReason(t, codes.Unavailable, "CONNECTOR_DELETED"), ""},
+		{"no details", status.Error(codes.PermissionDenied, "certificate revoked"), ""},
+		{"not a status", errors.New("connection reset"), ""},
+		{"nil", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := controllerDisconnectReason(tt.err); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package run
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"connector/internal/spiffe"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tunnel frame types exchanged on the ControlPlane.Tunnel stream.
+const (
+	tunnelFrameOpen       = "open"
+	tunnelFrameOpened     = "opened"
+	tunnelFrameData       = "data"
+	tunnelFrameCloseWrite = "close_write"
+	tunnelFrameError      = "error"
+)
+
+const (
+	tunnelBufferSize  = 32 * 1024
+	tunnelDialTimeout = 10 * time.Second
+)
+
+var errTunnelIdle = errors.New("tunnel idle timeout")
+
+// tunnelConfig holds data-plane limits for the connector's Tunnel RPC.
+type tunnelConfig struct {
+	idleTimeout        time.Duration
+	udpFlowIdleTimeout time.Duration
+}
+
+// tunnelStream is the subset of the Tunnel server stream used by the relay.
+type tunnelStream interface {
+	Send(*controllerpb.TunnelFrame) error
+	Recv() (*controllerpb.TunnelFrame, error)
+}
+
+// tunnelSession tracks one tunneled connection and its byte counters.
+type tunnelSession struct {
+	id         string
+	spiffeID   string
+	resourceID string
+	target     string
+	started    time.Time
+	bytesUp    atomic.Uint64
+	bytesDown  atomic.Uint64
+	lastActive atomic.Int64
+}
+
+func newTunnelSession(id, spiffeID, resourceID, target string) *tunnelSession {
+	sess := &tunnelSession{
+		id:         id,
+		spiffeID:   spiffeID,
+		resourceID: resourceID,
+		target:     target,
+		started:    time.Now(),
+	}
+	sess.touch()
+	return sess
+}
+
+func (t *tunnelSession) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+func (t *tunnelSession) idleFor() time.Duration {
+	return time.Since(time.Unix(0, t.lastActive.Load()))
+}
+
+// Tunnel authorizes a tunneler request against the policy cache, dials the
+// destination and relays bytes in both directions until both sides close.
+func (s *controlPlaneServer) Tunnel(stream controllerpb.ControlPlane_TunnelServer) error {
+	role, ok := spiffe.RoleFromContext(stream.Context())
+	if !ok || role != "tunneler" {
+		return status.Error(codes.PermissionDenied, "tunneler role required")
+	}
+	spiffeID, _ := spiffe.SPIFFEIDFromContext(stream.Context())
+	tunnelerID := parseTunnelerID(spiffeID)
+
+	open, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if open.GetType() != tunnelFrameOpen {
+		return status.Error(codes.InvalidArgument, "first tunnel frame must be open")
+	}
+	if s.acls == nil {
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "no_snapshot"})
+	}
+
+	sessionID := newSessionID()
+	dest := strings.TrimSpace(open.GetDestination())
+	protocol := strings.ToUpper(strings.TrimSpace(open.GetProtocol()))
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	if protocol == "UDP" {
+		return s.tunnelUDP(stream, spiffeID, tunnelerID, sessionID, open)
+	}
+	if dest == "" || open.GetPort() == 0 || open.GetPort() > 65535 {
+		s.sendDecision(spiffeID, tunnelerID, dest, protocol, 0, false, "", "invalid_request", sessionID)
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "invalid_request", SessionId: sessionID})
+	}
+	port := uint16(open.GetPort())
+
+	allowed, resourceID, reason := s.acls.Allowed(spiffeID, dest, protocol, port)
+	s.sendDecision(spiffeID, tunnelerID, dest, protocol, port, allowed, resourceID, reason, sessionID)
+	if !allowed {
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: reason, SessionId: sessionID})
+	}
+	if protocol != "TCP" {
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "unsupported_protocol", SessionId: sessionID})
+	}
+
+	target := net.JoinHostPort(dest, strconv.Itoa(int(port)))
+	conn, err := net.DialTimeout("tcp", target, tunnelDialTimeout)
+	if err != nil {
+		log.Printf("tunnel dial failed: session=%s target=%s err=%v", sessionID, target, err)
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "dial_failed", SessionId: sessionID})
+	}
+	defer conn.Close()
+
+	if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameOpened, SessionId: sessionID}); err != nil {
+		return err
+	}
+
+	session := newTunnelSession(sessionID, spiffeID, resourceID, target)
+	log.Printf("tunnel opened: session=%s principal=%s resource_id=%s target=%s", session.id, spiffeID, resourceID, target)
+	err = relayTunnel(stream.Context(), stream, conn, session, s.tunnel.idleTimeout)
+	log.Printf("tunnel closed: session=%s target=%s bytes_up=%d bytes_down=%d duration=%s err=%v",
+		session.id, target, session.bytesUp.Load(), session.bytesDown.Load(), time.Since(session.started).Round(time.Millisecond), err)
+	if errors.Is(err, errTunnelIdle) {
+		return status.Error(codes.DeadlineExceeded, "tunnel idle timeout")
+	}
+	return err
+}
+
+// relayTunnel copies bytes between the stream and conn. A close_write frame
+// (or the client closing its send side) half-closes conn; EOF from conn is
+// reported with a close_write frame. It returns once both directions are
+// finished, on the first error, or when the session has been idle too long.
+func relayTunnel(ctx context.Context, stream tunnelStream, conn net.Conn, session *tunnelSession, idleTimeout time.Duration) error {
+	upErr := make(chan error, 1)
+	downErr := make(chan error, 1)
+	go func() { upErr <- pumpStreamToConn(stream, conn, session) }()
+	go func() { downErr <- pumpConnToStream(conn, stream, session) }()
+	// Only the downstream goroutine sends on the stream, so wait for it
+	// before returning; closing conn is what unblocks it.
+	defer func() {
+		_ = conn.Close()
+		if downErr != nil {
+			<-downErr
+		}
+	}()
+
+	var tick <-chan time.Time
+	if idleTimeout > 0 {
+		ticker := time.NewTicker(idleCheckInterval(idleTimeout))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for upErr != nil || downErr != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-upErr:
+			upErr = nil
+			if err != nil {
+				return err
+			}
+		case err := <-downErr:
+			downErr = nil
+			if err != nil {
+				return err
+			}
+		case <-tick:
+			if session.idleFor() >= idleTimeout {
+				return errTunnelIdle
+			}
+		}
+	}
+	return nil
+}
+
+func pumpStreamToConn(stream tunnelStream, conn net.Conn, session *tunnelSession) error {
+	for {
+		frame, err := stream.Recv()
+		if err == io.EOF {
+			return closeWrite(conn)
+		}
+		if err != nil {
+			return err
+		}
+		switch frame.GetType() {
+		case tunnelFrameData:
+			if len(frame.GetData()) == 0 {
+				continue
+			}
+			n, err := conn.Write(frame.GetData())
+			session.bytesUp.Add(uint64(n))
+			session.touch()
+			if err != nil {
+				return err
+			}
+		case tunnelFrameCloseWrite:
+			return closeWrite(conn)
+		case tunnelFrameError:
+			return fmt.Errorf("peer aborted tunnel: %s", frame.GetReason())
+		}
+	}
+}
+
+func pumpConnToStream(conn net.Conn, stream tunnelStream, session *tunnelSession) error {
+	buf := make([]byte, tunnelBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			session.bytesDown.Add(uint64(n))
+			session.touch()
+			data := append([]byte(nil), buf[:n]...)
+			if sendErr := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameData, Data: data}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameCloseWrite})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// newSessionID returns a random tunnel session ID. It is part of the
+// connection_id in decision and audit records, so it must not repeat across
+// concurrent tunnels.
+func newSessionID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:]) // never fails since Go 1.24
+	return "tun-" + hex.EncodeToString(b[:])
+}
+
+func idleCheckInterval(idleTimeout time.Duration) time.Duration {
+	interval := idleTimeout / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	if interval > 15*time.Second {
+		interval = 15 * time.Second
+	}
+	return interval
+}
@@ -0,0 +1,214 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+type fakeTunnelStream struct {
+	in  chan *controllerpb.TunnelFrame
+	out chan *controllerpb.TunnelFrame
+}
+
+func newFakeTunnelStream() *fakeTunnelStream {
+	return &fakeTunnelStream{
+		in:  make(chan *controllerpb.TunnelFrame, 16),
+		out: make(chan *controllerpb.TunnelFrame, 16),
+	}
+}
+
+func (f *fakeTunnelStream) Send(frame *controllerpb.TunnelFrame) error {
+	f.out <- frame
+	return nil
+}
+
+func (f *fakeTunnelStream) Recv() (*controllerpb.TunnelFrame, error) {
+	frame, ok := <-f.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return frame, nil
+}
+
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+				_ = conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestRelayTunnelHalfCloseAndCounters(t *testing.T) {
+	addr := startEchoServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	stream := newFakeTunnelStream()
+	session := newTunnelSession("tun-test", "identity-1", "res_echo", addr)
+
+	done := make(chan error, 1)
+	go func() { done <- relayTunnel(context.Background(), stream, conn, session, time.Minute) }()
+
+	stream.in <- &controllerpb.TunnelFrame{Type: tunnelFrameData, Data: []byte("hello")}
+	stream.in <- &controllerpb.TunnelFrame{Type: tunnelFrameCloseWrite}
+
+	var echoed []byte
+	for frame := range stream.out {
+		if frame.GetType() == tunnelFrameCloseWrite {
+			break
+		}
+		echoed = append(echoed, frame.GetData()...)
+	}
+	if string(echoed) != "hello" {
+		t.Fatalf("expected echo of hello, got %q", echoed)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("relay returned error: %v", err)
+	}
+	if session.bytesUp.Load() != 5 || session.bytesDown.Load() != 5 {
+		t.Fatalf("unexpected counters: up=%d down=%d", session.bytesUp.Load(), session.bytesDown.Load())
+	}
+}
+
+func TestRelayTunnelIdleTimeout(t *testing.T) {
+	addr := startEchoServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	stream := newFakeTunnelStream()
+	session := newTunnelSession("tun-idle", "identity-1", "res_echo", addr)
+
+	err = relayTunnel(context.Background(), stream, conn, session, 200*time.Millisecond)
+	if !errors.Is(err, errTunnelIdle) {
+		t.Fatalf("expected idle timeout, got %v", err)
+	}
+}
+
+// startUDPEchoServer returns the port of a UDP echo server on 127.0.0.1.
+func startUDPEchoServer(t *testing.T) uint32 {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return uint32(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestUDPRelayForwardsAllowedFlowAndRejectsDenied(t *testing.T) {
+	port := startUDPEchoServer(t)
+
+	server := &controlPlaneServer{acls: newCache(t, []policyResource{
+		{
+			ResourceID:        "res_dns_udp",
+			Type:              "ip",
+			Address:           "127.0.0.1",
+			Protocol:          "UDP",
+			AllowedIdentities: []string{"identity-1"},
+		},
+	})}
+	stream := newFakeTunnelStream()
+	relay := &udpRelay{
+		server:      server,
+		stream:      stream,
+		spiffeID:    "identity-1",
+		sessionID:   "tun-udp",
+		defaultDest: "127.0.0.1",
+		defaultPort: uint16(port),
+		idleTimeout: time.Minute,
+		flows:       make(map[string]*udpFlow),
+	}
+	done := make(chan error, 1)
+	go func() { done <- relay.run(context.Background()) }()
+
+	stream.in <- &controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: "client-a", Data: []byte("ping")}
+	reply := <-stream.out
+	if reply.GetType() != tunnelFrameDatagram || reply.GetFlowId() != "client-a" || string(reply.GetData()) != "ping" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+
+	stream.in <- &controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: "client-b", Destination: "10.0.0.1", Port: 53, Data: []byte("q")}
+	denied := <-stream.out
+	if denied.GetType() != tunnelFrameFlowClosed || denied.GetFlowId() != "client-b" || denied.GetReason() != "resource_not_found" {
+		t.Fatalf("expected flow_closed for denied flow, got %+v", denied)
+	}
+
+	close(stream.in)
+	if err := <-done; err != nil {
+		t.Fatalf("relay returned error: %v", err)
+	}
+}
+
+func TestUDPRelayOpensNoFlowAfterClose(t *testing.T) {
+	server := &controlPlaneServer{acls: newCache(t, []policyResource{
+		{
+			ResourceID:        "res_dns_udp",
+			Type:              "ip",
+			Address:           "127.0.0.1",
+			Protocol:          "UDP",
+			AllowedIdentities: []string{"identity-1"},
+		},
+	})}
+	relay := &udpRelay{
+		server:    server,
+		stream:    newFakeTunnelStream(),
+		spiffeID:  "identity-1",
+		sessionID: "tun-udp-closed",
+		flows:     make(map[string]*udpFlow),
+	}
+	relay.closeAll()
+	if flow := relay.lookupOrOpen(context.Background(), "client-a", "127.0.0.1", 53, []byte("q")); flow != nil {
+		t.Fatalf("expected no flow after close, got %+v", flow)
+	}
+	relay.mu.Lock()
+	n := len(relay.flows)
+	relay.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no flows after close, got %d", n)
+	}
+}
+
+func TestNewSessionIDIsUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := newSessionID()
+		if !strings.HasPrefix(id, "tun-") || seen[id] {
+			t.Fatalf("unexpected or repeated session id %q", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,402 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// UDP tunnels carry one datagram per frame. Each frame names a flow chosen by
+// the tunneler (typically its local client address) and optionally its own
+// destination; frames without a destination use the one from the open frame.
+const (
+	tunnelFrameDatagram   = "datagram"
+	tunnelFrameFlowClosed = "flow_closed"
+)
+
+const (
+	udpMaxDatagramSize   = 64 * 1024
+	udpMaxFlowsPerTunnel = 1024
+)
+
+// udpFlowPendingLimit bounds the datagrams queued for a flow while its
+// destination is being authorized and dialed.
+const udpFlowPendingLimit = 16
+
+// udpFlow is one NAT table entry: a tunneler flow bound to a local UDP socket
+// connected to the destination. Denied flows are kept with a nil conn so
+// repeated datagrams are dropped without re-auditing until the entry expires.
+type udpFlow struct {
+	flowID       string
+	connectionID string
+	dest         string
+	port         uint16
+	resourceID   string
+	conn         *net.UDPConn
+	// opening is set until the flow's ACL check and dial have finished and
+	// pending, the datagrams that arrived meanwhile, has been written.
+	opening    bool
+	pending    [][]byte
+	started    time.Time
+	bytesUp    atomic.Uint64
+	bytesDown  atomic.Uint64
+	lastActive atomic.Int64
+}
+
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+func (f *udpFlow) idleFor() time.Duration {
+	return time.Since(time.Unix(0, f.lastActive.Load()))
+}
+
+type udpRelay struct {
+	server      *controlPlaneServer
+	stream      tunnelStream
+	sendMu      sync.Mutex
+	spiffeID    string
+	tunnelerID  string
+	sessionID   string
+	defaultDest string
+	defaultPort uint16
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	flows map[string]*udpFlow
+	seq   int
+	// closed is set by closeAll; no flows are opened after it.
+	closed bool
+	// workers tracks flow opens and readers so closeAll can wait for them.
+	workers sync.WaitGroup
+}
+
+func (s *controlPlaneServer) tunnelUDP(stream controllerpb.ControlPlane_TunnelServer, spiffeID, tunnelerID, sessionID string, open *controllerpb.TunnelFrame) error {
+	if open.GetPort() > 65535 {
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "invalid_request", SessionId: sessionID})
+	}
+	relay := &udpRelay{
+		server:      s,
+		stream:      stream,
+		spiffeID:    spiffeID,
+		tunnelerID:  tunnelerID,
+		sessionID:   sessionID,
+		defaultDest: strings.TrimSpace(open.GetDestination()),
+		defaultPort: uint16(open.GetPort()),
+		idleTimeout: s.tunnel.udpFlowIdleTimeout,
+		flows:       make(map[string]*udpFlow),
+	}
+	if err := relay.send(&controllerpb.TunnelFrame{Type: tunnelFrameOpened, SessionId: sessionID}); err != nil {
+		return err
+	}
+	log.Printf("udp tunnel opened: session=%s principal=%s", sessionID, spiffeID)
+	err := relay.run(stream.Context())
+	log.Printf("udp tunnel closed: session=%s err=%v", sessionID, err)
+	return err
+}
+
+func (r *udpRelay) send(frame *controllerpb.TunnelFrame) error {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	return r.stream.Send(frame)
+}
+
+func (r *udpRelay) run(ctx context.Context) error {
+	defer r.closeAll()
+	// Cancelled before closeAll waits, to abort flows still dialing.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := r.stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			switch frame.GetType() {
+			case tunnelFrameDatagram:
+				r.handleDatagram(ctx, frame)
+			case tunnelFrameFlowClosed:
+				r.closeFlowsByID(frame.GetFlowId())
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if r.idleTimeout > 0 {
+		ticker := time.NewTicker(idleCheckInterval(r.idleTimeout))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		case <-tick:
+			r.expireIdle()
+		}
+	}
+}
+
+func (r *udpRelay) handleDatagram(ctx context.Context, frame *controllerpb.TunnelFrame) {
+	dest := strings.TrimSpace(frame.GetDestination())
+	port := frame.GetPort()
+	if dest == "" {
+		dest = r.defaultDest
+	}
+	if port == 0 {
+		port = uint32(r.defaultPort)
+	}
+	if dest == "" || port == 0 || port > 65535 || len(frame.GetData()) == 0 {
+		return
+	}
+	data := append([]byte(nil), frame.GetData()...)
+	if flow := r.lookupOrOpen(ctx, frame.GetFlowId(), dest, uint16(port), data); flow != nil {
+		r.write(flow, data)
+	}
+}
+
+func (r *udpRelay) write(flow *udpFlow, data []byte) {
+	n, err := flow.conn.Write(data)
+	flow.bytesUp.Add(uint64(n))
+	flow.touch()
+	if err != nil {
+		log.Printf("udp flow write failed: session=%s flow=%s err=%v", r.sessionID, flow.flowID, err)
+	}
+}
+
+// lookupOrOpen returns the open flow data should be written to. A datagram
+// for a new flow, or one still being opened, is queued instead and written
+// by openFlow, so the receive loop never waits on an ACL check or dial; nil
+// is returned in that case and for denied or closed flows.
+func (r *udpRelay) lookupOrOpen(ctx context.Context, flowID, dest string, port uint16, data []byte) *udpFlow {
+	key := flowID + "|" + net.JoinHostPort(dest, strconv.Itoa(int(port)))
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	if flow, ok := r.flows[key]; ok {
+		if flow.opening {
+			if len(flow.pending) < udpFlowPendingLimit {
+				flow.pending = append(flow.pending, data)
+			}
+			flow.touch()
+			r.mu.Unlock()
+			return nil
+		}
+		r.mu.Unlock()
+		if flow.conn == nil {
+			return nil
+		}
+		return flow
+	}
+	if len(r.flows) >= udpMaxFlowsPerTunnel {
+		r.mu.Unlock()
+		_ = r.send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: flowID, Destination: dest, Port: uint32(port), Reason: "flow_limit"})
+		return nil
+	}
+	r.seq++
+	flow := &udpFlow{
+		flowID:       flowID,
+		connectionID: fmt.Sprintf("%s/%d", r.sessionID, r.seq),
+		dest:         dest,
+		port:         port,
+		opening:      true,
+		pending:      [][]byte{data},
+		started:      time.Now(),
+	}
+	flow.touch()
+	r.flows[key] = flow
+	r.workers.Add(1)
+	r.mu.Unlock()
+	go r.openFlow(ctx, key, flow)
+	return nil
+}
+
+// openFlow authorizes and dials a new flow, then writes the datagrams queued
+// for it and starts relaying replies.
+func (r *udpRelay) openFlow(ctx context.Context, key string, flow *udpFlow) {
+	defer r.workers.Done()
+	flowID, dest, port := flow.flowID, flow.dest, flow.port
+
+	allowed, resourceID, reason := r.server.acls.Allowed(r.spiffeID, dest, "UDP", port)
+	r.server.sendDecision(r.spiffeID, r.tunnelerID, dest, "UDP", port, allowed, resourceID, reason, flow.connectionID)
+	if !allowed {
+		r.finishOpen(flow)
+		_ = r.send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: flowID, Destination: dest, Port: uint32(port), Reason: reason})
+		return
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(dest, strconv.Itoa(int(port))))
+	if err != nil {
+		r.finishOpen(flow)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("udp flow dial failed: session=%s flow=%s dest=%s:%d err=%v", r.sessionID, flowID, dest, port, err)
+		_ = r.send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: flowID, Destination: dest, Port: uint32(port), Reason: "dial_failed"})
+		return
+	}
+	r.mu.Lock()
+	if r.closed || r.flows[key] != flow {
+		// Relay shut down, or flow expired or closed by the tunneler, while
+		// we were dialing.
+		r.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	flow.resourceID = resourceID
+	flow.conn = conn.(*net.UDPConn)
+	r.workers.Add(1)
+	r.mu.Unlock()
+	go r.readFlow(key, flow)
+
+	// Flush in order; datagrams keep queueing until pending is empty.
+	for {
+		r.mu.Lock()
+		pending := flow.pending
+		flow.pending = nil
+		if len(pending) == 0 {
+			flow.opening = false
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+		for _, data := range pending {
+			r.write(flow, data)
+		}
+	}
+}
+
+// finishOpen marks a flow that will not forward as opened, dropping its
+// queued datagrams.
+func (r *udpRelay) finishOpen(flow *udpFlow) {
+	r.mu.Lock()
+	flow.opening = false
+	flow.pending = nil
+	r.mu.Unlock()
+}
+
+func (r *udpRelay) readFlow(key string, flow *udpFlow) {
+	defer r.workers.Done()
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// ICMP errors surface on connected UDP sockets; report and drop the flow.
+			r.removeFlow(key, flow, "unreachable")
+			return
+		}
+		flow.bytesDown.Add(uint64(n))
+		flow.touch()
+		frame := &controllerpb.TunnelFrame{
+			Type:        tunnelFrameDatagram,
+			FlowId:      flow.flowID,
+			Destination: flow.dest,
+			Port:        uint32(flow.port),
+			Data:        append([]byte(nil), buf[:n]...),
+		}
+		if err := r.send(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (r *udpRelay) expireIdle() {
+	r.mu.Lock()
+	var expired []string
+	for key, flow := range r.flows {
+		if flow.idleFor() >= r.idleTimeout {
+			expired = append(expired, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, key := range expired {
+		r.mu.Lock()
+		flow := r.flows[key]
+		r.mu.Unlock()
+		if flow != nil {
+			r.removeFlow(key, flow, "idle")
+		}
+	}
+}
+
+func (r *udpRelay) closeFlowsByID(flowID string) {
+	r.mu.Lock()
+	var keys []string
+	for key, flow := range r.flows {
+		if flow.flowID == flowID {
+			keys = append(keys, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, key := range keys {
+		r.mu.Lock()
+		flow := r.flows[key]
+		r.mu.Unlock()
+		if flow != nil {
+			r.removeFlow(key, flow, "")
+		}
+	}
+}
+
+// removeFlow drops a NAT entry, closing its socket. A non-empty reason is
+// reported to the tunneler for flows that were actually forwarding.
+func (r *udpRelay) removeFlow(key string, flow *udpFlow, reason string) {
+	r.mu.Lock()
+	if r.flows[key] != flow {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.flows, key)
+	conn := flow.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	_ = conn.Close()
+	log.Printf("udp flow closed: session=%s flow=%s dest=%s:%d resource_id=%s bytes_up=%d bytes_down=%d duration=%s reason=%s",
+		r.sessionID, flow.flowID, flow.dest, flow.port, flow.resourceID, flow.bytesUp.Load(), flow.bytesDown.Load(),
+		time.Since(flow.started).Round(time.Millisecond), reason)
+	if reason != "" {
+		_ = r.send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: flow.flowID, Destination: flow.dest, Port: uint32(flow.port), Reason: reason})
+	}
+}
+
+func (r *udpRelay) closeAll() {
+	r.mu.Lock()
+	r.closed = true
+	flows := r.flows
+	r.flows = make(map[string]*udpFlow)
+	for _, flow := range flows {
+		if flow.conn != nil {
+			_ = flow.conn.Close()
+		}
+	}
+	r.mu.Unlock()
+	r.workers.Wait()
+}
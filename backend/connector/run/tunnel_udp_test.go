@@ -0,0 +1,142 @@
+package run
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"connector/internal/spiffe"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialTestConnector serves s over an in-memory listener, presenting every
+// client as the tunneler spiffeID, and returns a client for it.
+func dialTestConnector(t *testing.T, s *controlPlaneServer, spiffeID string) controllerpb.ControlPlaneClient {
+	t.Helper()
+	uri, err := url.Parse(spiffeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asPeer := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := peer.NewContext(ss.Context(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{{URIs: []*url.URL{uri}}}},
+		}})
+		return handler(srv, &peerStream{ServerStream: ss, ctx: ctx})
+	}
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer(grpc.ChainStreamInterceptor(asPeer, spiffe.StreamInterceptor("example.internal", "tunneler")))
+	controllerpb.RegisterControlPlaneServer(gs, s)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///connector",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return controllerpb.NewControlPlaneClient(conn)
+}
+
+type peerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *peerStream) Context() context.Context { return s.ctx }
+
+// TestUDPTunnelOverGRPC drives the Tunnel RPC the way a tunneler's UDP
+// forward does: one protocol=UDP tunnel carrying datagrams for several flows.
+func TestUDPTunnelOverGRPC(t *testing.T) {
+	port := startUDPEchoServer(t)
+	const tunnelerID = "spiffe://example.internal/tunneler/t1"
+	server := &controlPlaneServer{
+		acls: newCache(t, []policyResource{{
+			ResourceID:        "res_dns_udp",
+			Type:              "ip",
+			Address:           "127.0.0.1",
+			Protocol:          "UDP",
+			AllowedIdentities: []string{tunnelerID},
+		}}),
+		tunnel: tunnelConfig{udpFlowIdleTimeout: time.Minute},
+	}
+	client := dialTestConnector(t, server, tunnelerID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.Tunnel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameOpen, Protocol: "UDP", Destination: "127.0.0.1", Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := stream.Recv(); err != nil || opened.GetType() != tunnelFrameOpened {
+		t.Fatalf("expected opened, got %+v %v", opened, err)
+	}
+
+	// Datagrams sent before a flow's socket is dialed are queued, not lost.
+	for _, msg := range []struct{ flow, data string }{
+		{"127.0.0.1:5001", "a1"}, {"127.0.0.1:5002", "b1"}, {"127.0.0.1:5001", "a2"},
+	} {
+		if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: msg.flow, Data: []byte(msg.data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: "127.0.0.1:5003", Destination: "10.0.0.1", Port: 53, Data: []byte("q")}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	var closed *controllerpb.TunnelFrame
+	for i := 0; i < 4; i++ {
+		frame, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch frame.GetType() {
+		case tunnelFrameDatagram:
+			got[frame.GetFlowId()] = append(got[frame.GetFlowId()], string(frame.GetData()))
+		case tunnelFrameFlowClosed:
+			closed = frame
+		default:
+			t.Fatalf("unexpected frame %+v", frame)
+		}
+	}
+	if len(got["127.0.0.1:5001"]) != 2 || got["127.0.0.1:5001"][0] != "a1" || got["127.0.0.1:5001"][1] != "a2" {
+		t.Fatalf("unexpected replies for flow a: %v", got)
+	}
+	if len(got["127.0.0.1:5002"]) != 1 || got["127.0.0.1:5002"][0] != "b1" {
+		t.Fatalf("unexpected replies for flow b: %v", got)
+	}
+	if closed == nil || closed.GetFlowId() != "127.0.0.1:5003" || closed.GetReason() != "resource_not_found" {
+		t.Fatalf("expected flow_closed for the denied flow, got %+v", closed)
+	}
+
+	// A flow closed by the tunneler is reopened by its next datagram.
+	if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: "127.0.0.1:5001"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: "127.0.0.1:5001", Data: []byte("a3")}); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := stream.Recv()
+	if err != nil || frame.GetFlowId() != "127.0.0.1:5001" || string(frame.GetData()) != "a3" {
+		t.Fatalf("expected a reply after reopening the flow, got %+v %v", frame, err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+}
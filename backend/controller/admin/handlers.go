@@ -1,12 +1,15 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"controller/api"
 	"controller/state"
 )
 
@@ -16,6 +19,17 @@ type ConnectorStreamChecker interface {
 	IsStreamActive(id string) bool
 }
 
+// ConnectorDisconnector closes a connector's control-plane streams.
+type ConnectorDisconnector interface {
+	DisconnectConnector(id, reason string) int
+}
+
+// PolicyKeyManager lists and rotates the controller's policy signing keys.
+type PolicyKeyManager interface {
+	PolicyKeys() []api.PolicyPublicKey
+	RotatePolicyKey() (api.PolicyPublicKey, error)
+}
+
 type Server struct {
 	Tokens        *state.TokenStore
 	Reg           *state.Registry
@@ -25,24 +39,52 @@ type Server struct {
 	Users         *state.UserStore
 	RemoteNet     *state.RemoteNetworkStore
 	StreamChecker ConnectorStreamChecker
+	Disconnector  ConnectorDisconnector
+	PolicyKeys    PolicyKeyManager
 
-	AdminAuthToken    string
+	Revocations      *state.RevocationStore
+	RevocationNotify RevocationNotifier
+	TunnelerNotify   TunnelerNotifier
+
+	AdminAuthToken string
+	// AdminTokens maps further admin bearer tokens to the admin's name,
+	// recorded as the creator of enrollment tokens. AdminAuthToken
+	// authenticates as "admin".
+	AdminTokens       map[string]string
 	InternalAuthToken string
-	CACertPEM         []byte
+	CAs               *api.CAManager
+	ControllerCert    ControllerCertSource
+	CertTTLs          *api.TTLPolicy
+	TrustDomain       string
 }
 
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	// CA cert is public — no auth required. Connectors and tunnelers fetch it
 	// during bootstrap before any trust is established (same pattern as Vault
 	// /v1/pki/ca/pem, Consul /v1/connect/ca/roots, Teleport, etc.)
+	// During a CA rotation /ca.crt is the bundle of every trusted root.
 	mux.HandleFunc("/ca.crt", s.handleCACert)
-	mux.Handle("/api/admin/tokens", s.adminAuth(http.HandlerFunc(s.handleCreateToken)))
+	mux.HandleFunc("/ca.crl", s.handleCRL)
+	mux.Handle("/api/admin/tokens", s.adminAuth(http.HandlerFunc(s.handleTokens)))
+	mux.Handle("/api/admin/tokens/", s.adminAuth(http.HandlerFunc(s.handleTokenSubroutes)))
+	mux.Handle("/api/admin/enrollments", s.adminAuth(http.HandlerFunc(s.handlePendingEnrollments)))
+	mux.Handle("/api/admin/enrollments/", s.adminAuth(http.HandlerFunc(s.handlePendingEnrollmentSubroutes)))
 	mux.Handle("/api/admin/connectors", s.adminAuth(http.HandlerFunc(s.handleListConnectors)))
 	mux.Handle("/api/admin/connectors/", s.adminAuth(http.HandlerFunc(s.handleConnectorSubroutes)))
 	mux.Handle("/api/admin/tunnelers", s.adminAuth(http.HandlerFunc(s.handleListTunnelers)))
+	mux.Handle("/api/admin/tunnelers/", s.adminAuth(http.HandlerFunc(s.handleTunnelerSubroutes)))
 	mux.Handle("/api/admin/resources", s.adminAuth(http.HandlerFunc(s.handleResources)))
 	mux.Handle("/api/admin/resources/", s.adminAuth(http.HandlerFunc(s.handleResourceSubroutes)))
 	mux.Handle("/api/admin/audit", s.adminAuth(http.HandlerFunc(s.handleAuditLog)))
+	mux.Handle("/api/admin/policy/convergence", s.adminAuth(http.HandlerFunc(s.handlePolicyConvergence)))
+	mux.Handle("/api/admin/policy/signing-keys", s.adminAuth(http.HandlerFunc(s.handlePolicySigningKeys)))
+	mux.Handle("/api/admin/policy/signing-keys/rotate", s.adminAuth(http.HandlerFunc(s.handleRotatePolicySigningKey)))
+	mux.Handle("/api/admin/ca", s.adminAuth(http.HandlerFunc(s.handleListCAs)))
+	mux.Handle("/api/admin/ca/", s.adminAuth(http.HandlerFunc(s.handleCARotation)))
+	mux.Handle("/api/admin/controller/certificate", s.adminAuth(http.HandlerFunc(s.handleControllerCert)))
+	mux.Handle("/api/admin/certificates/ttl", s.adminAuth(http.HandlerFunc(s.handleCertTTLs)))
+	mux.Handle("/api/admin/certificates", s.adminAuth(http.HandlerFunc(s.handleCertificates)))
+	mux.Handle("/api/admin/revocations", s.adminAuth(http.HandlerFunc(s.handleRevocations)))
 	mux.Handle("/api/admin/users", s.adminAuth(http.HandlerFunc(s.handleUsers)))
 	mux.Handle("/api/admin/users/", s.adminAuth(http.HandlerFunc(s.handleUserSubroutes)))
 	mux.Handle("/api/admin/user-groups", s.adminAuth(http.HandlerFunc(s.handleUserGroups)))
@@ -68,15 +110,36 @@ func (s *Server) adminAuth(next http.Handler) http.Handler {
 			http.Error(w, "admin auth not configured", http.StatusServiceUnavailable)
 			return
 		}
-		auth := r.Header.Get("Authorization")
-		if auth != "Bearer "+s.AdminAuthToken {
+		name, ok := s.adminName(r.Header.Get("Authorization"))
+		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminNameKey{}, name)))
 	})
 }
 
+type adminNameKey struct{}
+
+// adminName returns the admin authenticated by an Authorization header.
+func (s *Server) adminName(auth string) (string, bool) {
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	if token == s.AdminAuthToken {
+		return "admin", true
+	}
+	name, ok := s.AdminTokens[token]
+	return name, ok
+}
+
+// requestAdmin returns the admin adminAuth authenticated for r.
+func requestAdmin(r *http.Request) string {
+	name, _ := r.Context().Value(adminNameKey{}).(string)
+	return name
+}
+
 func (s *Server) internalAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if s.InternalAuthToken == "" {
@@ -96,15 +159,98 @@ func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	token, expires, err := s.Tokens.CreateToken()
+	// All fields are optional; an empty body mints a single-use token for
+	// either role.
+	var req struct {
+		Role            string `json:"role"`
+		WorkloadID      string `json:"workload_id"`
+		RemoteNetworkID string `json:"remote_network_id"`
+		MaxUses         int    `json:"max_uses"`
+		TTLSeconds      int64  `json:"ttl_seconds"`
+		RequireApproval bool   `json:"require_approval"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Role = strings.TrimSpace(req.Role)
+	req.WorkloadID = strings.TrimSpace(req.WorkloadID)
+	req.RemoteNetworkID = strings.TrimSpace(req.RemoteNetworkID)
+	switch req.Role {
+	case "", "connector", "tunneler":
+	default:
+		http.Error(w, "role must be connector or tunneler", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "max_uses must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.WorkloadID != "" && req.MaxUses > 1 {
+		http.Error(w, "a token for a fixed workload_id can only be used once", http.StatusBadRequest)
+		return
+	}
+	if req.TTLSeconds < 0 {
+		http.Error(w, "ttl_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.RemoteNetworkID != "" {
+		if req.Role != "connector" {
+			http.Error(w, "remote_network_id requires role connector", http.StatusBadRequest)
+			return
+		}
+		if s.RemoteNet == nil {
+			http.Error(w, "remote networks not configured", http.StatusServiceUnavailable)
+			return
+		}
+		ok, err := s.RemoteNet.NetworkExists(req.RemoteNetworkID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to look up remote network: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "remote network not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts := state.TokenOptions{
+		Role:            req.Role,
+		WorkloadID:      req.WorkloadID,
+		RemoteNetworkID: req.RemoteNetworkID,
+		MaxUses:         req.MaxUses,
+		TTL:             time.Duration(req.TTLSeconds) * time.Second,
+		CreatedBy:       requestAdmin(r),
+		RequireApproval: req.RequireApproval,
+	}
+	token, expires, err := s.Tokens.CreateToken(opts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to create token: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if opts.MaxUses == 0 {
+		opts.MaxUses = 1
+	}
 
-	resp := map[string]string{
+	resp := map[string]interface{}{
 		"token":      token,
+		"id":         state.TokenID(token),
 		"expires_at": expires.UTC().Format(time.RFC3339),
+		"max_uses":   opts.MaxUses,
+	}
+	if opts.Role != "" {
+		resp["role"] = opts.Role
+	}
+	if opts.WorkloadID != "" {
+		resp["workload_id"] = opts.WorkloadID
+	}
+	if opts.RemoteNetworkID != "" {
+		resp["remote_network_id"] = opts.RemoteNetworkID
+	}
+	if opts.RequireApproval {
+		resp["require_approval"] = true
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
@@ -117,6 +263,7 @@ func (s *Server) handleConsumeToken(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Token       string `json:"token"`
 		ConnectorID string `json:"connector_id"`
+		Role        string `json:"role"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
@@ -126,10 +273,20 @@ func (s *Server) handleConsumeToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing connector_id", http.StatusBadRequest)
 		return
 	}
-	if err := s.Tokens.ConsumeToken(req.Token, req.ConnectorID); err != nil {
+	if req.Role == "" {
+		req.Role = "connector"
+	}
+	rec, err := s.Tokens.ConsumeToken(req.Token, req.Role, req.ConnectorID)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("token invalid: %v", err), http.StatusUnauthorized)
 		return
 	}
+	if rec.RemoteNetworkID != "" && req.Role == "connector" && s.RemoteNet != nil {
+		if err := s.RemoteNet.AssignConnector(rec.RemoteNetworkID, req.ConnectorID); err != nil {
+			http.Error(w, fmt.Sprintf("failed to assign remote network: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -146,6 +303,7 @@ func (s *Server) handleListConnectors(w http.ResponseWriter, r *http.Request) {
 		PrivateIP string `json:"private_ip"`
 		LastSeen  string `json:"last_seen"`
 		Version   string `json:"version"`
+		Disabled  bool   `json:"disabled"`
 	}
 	resp := make([]respConnector, 0, len(records))
 	for _, rec := range records {
@@ -159,29 +317,154 @@ func (s *Server) handleListConnectors(w http.ResponseWriter, r *http.Request) {
 			PrivateIP: rec.PrivateIP,
 			LastSeen:  humanizeDuration(now.Sub(rec.LastSeen)),
 			Version:   rec.Version,
+			Disabled:  rec.Disabled,
 		})
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// handleConnectorSubroutes serves DELETE /api/admin/connectors/{id} and
+// POST /api/admin/connectors/{id}/disable and /enable.
 func (s *Server) handleConnectorSubroutes(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	id := strings.TrimPrefix(r.URL.Path, "/api/admin/connectors/")
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/connectors/"), "/"), "/")
+	id := parts[0]
 	if id == "" {
 		http.Error(w, "connector id required", http.StatusBadRequest)
 		return
 	}
-	s.Reg.Delete(id)
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.deleteConnector(id)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	case len(parts) == 2 && (parts[1] == "disable" || parts[1] == "enable"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		disabled := parts[1] == "disable"
+		if err := s.setConnectorDisabled(id, disabled); err != nil {
+			http.Error(w, fmt.Sprintf("failed to update connector: %v", err), http.StatusInternalServerError)
+			return
+		}
+		status := "enabled"
+		if disabled {
+			status = "disabled"
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": status})
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// deleteConnector forgets a connector, revokes its identity and closes its
+// control-plane streams.
+func (s *Server) deleteConnector(id string) {
+	if s.Reg != nil {
+		s.Reg.Delete(id)
+	}
 	if s.ACLs != nil && s.ACLs.DB() != nil {
 		_ = state.DeleteConnectorFromDB(s.ACLs.DB(), id)
 	}
 	if s.Tokens != nil {
-		_ = s.Tokens.DeleteByConnectorID(id)
+		_ = s.Tokens.ForgetConnector(id)
+	}
+	s.revokeConnector(id)
+	if s.Disconnector != nil {
+		s.Disconnector.DisconnectConnector(id, api.DisconnectDeleted)
+	}
+}
+
+// setConnectorDisabled disables or re-enables a connector. Disabling closes
+// its control-plane streams; the controller refuses it until it is enabled.
+func (s *Server) setConnectorDisabled(id string, disabled bool) error {
+	if s.ACLs != nil && s.ACLs.DB() != nil {
+		if err := state.SetConnectorDisabledInDB(s.ACLs.DB(), id, disabled); err != nil {
+			return err
+		}
+	}
+	if s.Reg != nil {
+		s.Reg.SetDisabled(id, disabled)
+	}
+	if disabled && s.Disconnector != nil {
+		s.Disconnector.DisconnectConnector(id, api.DisconnectDisabled)
+	}
+	return nil
+}
+
+// handlePolicyConvergence reports which policy version each connector runs
+// and which connectors lag behind the version compiled for them.
+func (s *Server) handlePolicyConvergence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type connectorState struct {
+		state.PolicyConvergence
+		StreamActive bool `json:"stream_active"`
+	}
+	resp := struct {
+		Connectors []connectorState `json:"connectors"`
+		Versions   map[string]int   `json:"versions"`
+		Lagging    []string         `json:"lagging"`
+	}{
+		Connectors: []connectorState{},
+		Versions:   map[string]int{},
+		Lagging:    []string{},
+	}
+	if s.ACLs == nil || s.ACLs.DB() == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	rows, err := state.ListPolicyConvergence(s.ACLs.DB())
+	if err != nil {
+		http.Error(w, "failed to query policy versions", http.StatusInternalServerError)
+		return
+	}
+	for _, row := range rows {
+		active := s.StreamChecker != nil && s.StreamChecker.IsStreamActive(row.ConnectorID)
+		resp.Connectors = append(resp.Connectors, connectorState{PolicyConvergence: row, StreamActive: active})
+		resp.Versions[fmt.Sprintf("%d", row.AppliedVersion)]++
+		if !row.Converged {
+			resp.Lagging = append(resp.Lagging, row.ConnectorID)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePolicySigningKeys lists the published policy verification keys.
+func (s *Server) handlePolicySigningKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys := []api.PolicyPublicKey{}
+	if s.PolicyKeys != nil {
+		keys = append(keys, s.PolicyKeys.PolicyKeys()...)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// handleRotatePolicySigningKey activates a new policy signing key. The
+// previous key stays published until the next rotation.
+func (s *Server) handleRotatePolicySigningKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.PolicyKeys == nil {
+		http.Error(w, "policy signing not configured", http.StatusServiceUnavailable)
+		return
+	}
+	key, err := s.PolicyKeys.RotatePolicyKey()
+	if err != nil {
+		http.Error(w, "failed to rotate policy signing key", http.StatusInternalServerError)
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	writeJSON(w, http.StatusOK, key)
 }
 
 func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
@@ -394,14 +677,14 @@ func (s *Server) handleCACert(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if len(s.CACertPEM) == 0 {
+	if s.CAs == nil {
 		http.Error(w, "CA cert not available", http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "application/x-pem-file")
 	w.Header().Set("Content-Disposition", `attachment; filename="ca.crt"`)
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(s.CACertPEM)
+	_, _ = w.Write(s.CAs.BundlePEM())
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// handleListCAs lists the internal CAs and their rotation state.
+func (s *Server) handleListCAs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.CAs == nil {
+		http.Error(w, "CA not configured", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.CAs.List())
+}
+
+// handleCARotation drives an internal CA rotation:
+//
+//	POST /api/admin/ca/stage     {"cert_pem": "...", "key_pem": "..."} (optional; generated when empty)
+//	POST /api/admin/ca/activate
+//	POST /api/admin/ca/retire    {"force": false}
+func (s *Server) handleCARotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.CAs == nil {
+		http.Error(w, "CA not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		CertPEM string `json:"cert_pem"`
+		KeyPEM  string `json:"key_pem"`
+		Force   bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/api/admin/ca/")
+	var err error
+	var info interface{}
+	switch action {
+	case "stage":
+		if (req.CertPEM == "") != (req.KeyPEM == "") {
+			http.Error(w, "cert_pem and key_pem must be given together", http.StatusBadRequest)
+			return
+		}
+		info, err = s.CAs.Stage([]byte(req.CertPEM), []byte(req.KeyPEM))
+	case "activate":
+		info, err = s.CAs.Activate()
+	case "retire":
+		info, err = s.CAs.RetirePrevious(req.Force)
+	default:
+		http.Error(w, "unknown action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to %s CA: %v", action, err), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ControllerCertStatus describes the controller's own TLS certificate.
+type ControllerCertStatus struct {
+	SPIFFEID         string   `json:"spiffe_id,omitempty"`
+	Serial           string   `json:"serial,omitempty"`
+	Issuer           string   `json:"issuer,omitempty"`
+	NotBefore        string   `json:"not_before,omitempty"`
+	NotAfter         string   `json:"not_after,omitempty"`
+	RemainingSeconds int64    `json:"remaining_seconds"`
+	DNSNames         []string `json:"dns_names,omitempty"`
+	// Supplied is set for a certificate from CONTROLLER_CERT, which is not
+	// renewed automatically.
+	Supplied bool `json:"supplied"`
+}
+
+// ControllerCertSource reports the controller's current TLS certificate.
+type ControllerCertSource interface {
+	ControllerCertStatus() ControllerCertStatus
+}
+
+// handleControllerCert reports the controller TLS certificate and its
+// remaining lifetime.
+func (s *Server) handleControllerCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ControllerCert == nil {
+		http.Error(w, "controller certificate not configured", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.ControllerCert.ControllerCertStatus())
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type certTTLOverride struct {
+	SPIFFEID   string `json:"spiffe_id"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// handleCertTTLs manages workload certificate lifetimes:
+//
+//	GET    /api/admin/certificates/ttl                  role TTLs, cap and overrides
+//	PUT    /api/admin/certificates/ttl                  {"spiffe_id": "...", "ttl_seconds": 3600}
+//	DELETE /api/admin/certificates/ttl?spiffe_id=...    back to the role TTL
+//
+// Changes apply from the workload's next enrollment or renewal.
+func (s *Server) handleCertTTLs(w http.ResponseWriter, r *http.Request) {
+	if s.CertTTLs == nil {
+		http.Error(w, "certificate TTL policy not configured", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		overrides, err := s.CertTTLs.Overrides()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to list TTL overrides: %v", err), http.StatusInternalServerError)
+			return
+		}
+		roles := make(map[string]int64)
+		for role, ttl := range s.CertTTLs.Roles() {
+			roles[role] = int64(ttl / time.Second)
+		}
+		out := make([]certTTLOverride, 0, len(overrides))
+		for _, o := range overrides {
+			out = append(out, certTTLOverride{
+				SPIFFEID:   o.SPIFFEID,
+				TTLSeconds: int64(o.TTL / time.Second),
+				UpdatedAt:  o.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"max_ttl_seconds": int64(s.CertTTLs.Max() / time.Second),
+			"roles":           roles,
+			"overrides":       out,
+		})
+	case http.MethodPut:
+		var req struct {
+			SPIFFEID   string `json:"spiffe_id"`
+			TTLSeconds int64  `json:"ttl_seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		spiffeID := strings.TrimSpace(req.SPIFFEID)
+		if !s.workloadSPIFFEID(spiffeID) {
+			http.Error(w, "spiffe_id must be a connector or tunneler in the trust domain", http.StatusBadRequest)
+			return
+		}
+		if err := s.CertTTLs.SetOverride(spiffeID, time.Duration(req.TTLSeconds)*time.Second); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"spiffe_id": spiffeID, "ttl_seconds": req.TTLSeconds})
+	case http.MethodDelete:
+		spiffeID := strings.TrimSpace(r.URL.Query().Get("spiffe_id"))
+		if spiffeID == "" {
+			http.Error(w, "spiffe_id is required", http.StatusBadRequest)
+			return
+		}
+		found, err := s.CertTTLs.DeleteOverride(spiffeID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to delete TTL override: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "no TTL override for spiffe_id", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// workloadSPIFFEID reports whether id names a connector or tunneler in the
+// controller's trust domain.
+func (s *Server) workloadSPIFFEID(id string) bool {
+	for _, role := range []string{"connector", "tunneler"} {
+		prefix := "spiffe://" + s.TrustDomain + "/" + role + "/"
+		if rest := strings.TrimPrefix(id, prefix); rest != id && rest != "" && !strings.Contains(rest, "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller/state"
+)
+
+// handleCertificates lists issued certificates. Query parameters: identity
+// (SPIFFE ID or workload ID), role, key_proof, expires_after and
+// expires_before (RFC3339), live=true (not yet expired) and limit.
+func (s *Server) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ACLs == nil || s.ACLs.DB() == nil {
+		writeJSON(w, http.StatusOK, []interface{}{})
+		return
+	}
+	q := r.URL.Query()
+	filter := state.CertificateFilter{
+		Identity: strings.TrimSpace(q.Get("identity")),
+		Role:     strings.TrimSpace(q.Get("role")),
+		KeyProof: strings.TrimSpace(q.Get("key_proof")),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"expires_after", &filter.ExpiresAfter}, {"expires_before", &filter.ExpiresBefore}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, p.name+" must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		*p.dst = t
+	}
+	if q.Get("live") == "true" && filter.ExpiresAfter.IsZero() {
+		filter.ExpiresAfter = time.Now()
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	certs, err := state.ListIssuedCertificates(s.ACLs.DB(), filter)
+	if err != nil {
+		http.Error(w, "failed to query certificates", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, certs)
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"controller/state"
+)
+
+// handlePendingEnrollments lists enrollment requests held for approval,
+// optionally filtered by ?status=.
+func (s *Server) handlePendingEnrollments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ACLs == nil || s.ACLs.DB() == nil {
+		writeJSON(w, http.StatusOK, []interface{}{})
+		return
+	}
+	list, err := state.ListPendingEnrollments(s.ACLs.DB(), strings.TrimSpace(r.URL.Query().Get("status")))
+	if err != nil {
+		http.Error(w, "failed to list enrollments", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handlePendingEnrollmentSubroutes serves GET /api/admin/enrollments/{id} and
+// POST /api/admin/enrollments/{id}/approve|deny. The workload receives its
+// certificate on its next poll after approval.
+func (s *Server) handlePendingEnrollmentSubroutes(w http.ResponseWriter, r *http.Request) {
+	if s.ACLs == nil || s.ACLs.DB() == nil {
+		http.Error(w, "enrollments not configured", http.StatusServiceUnavailable)
+		return
+	}
+	db := s.ACLs.DB()
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/enrollments/"), "/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p, err := state.GetPendingEnrollment(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "enrollment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load enrollment", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+		return
+	}
+
+	var approve bool
+	switch parts[1] {
+	case "approve":
+		approve = true
+	case "deny":
+	default:
+		http.Error(w, "unknown subresource", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	p, err := state.DecidePendingEnrollment(db, id, approve, strings.TrimSpace(req.Reason))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "enrollment not found", http.StatusNotFound)
+		return
+	case errors.Is(err, state.ErrEnrollmentDecided):
+		http.Error(w, fmt.Sprintf("enrollment is already %s", p.Status), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("failed to update enrollment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
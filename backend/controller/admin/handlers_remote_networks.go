@@ -1,7 +1,9 @@
 package admin
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -25,9 +27,11 @@ func (s *Server) handleRemoteNetworks(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, nets)
 	case http.MethodPost:
 		var req struct {
-			Name     string            `json:"name"`
-			Location string            `json:"location"`
-			Tags     map[string]string `json:"tags"`
+			Name                      string            `json:"name"`
+			Location                  string            `json:"location"`
+			Tags                      map[string]string `json:"tags"`
+			SurvivabilityGraceSeconds int               `json:"survivability_grace_seconds"`
+			RequireEnrollmentApproval bool              `json:"require_enrollment_approval"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
@@ -37,12 +41,19 @@ func (s *Server) handleRemoteNetworks(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "name is required", http.StatusBadRequest)
 			return
 		}
+		if req.SurvivabilityGraceSeconds < 0 {
+			http.Error(w, "survivability_grace_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
 		n := state.RemoteNetwork{
 			Name:      req.Name,
 			Location:  req.Location,
 			Tags:      req.Tags,
 			CreatedAt: time.Now().UTC(),
 			UpdatedAt: time.Now().UTC(),
+
+			SurvivabilityGraceSeconds: req.SurvivabilityGraceSeconds,
+			RequireEnrollmentApproval: req.RequireEnrollmentApproval,
 		}
 		if err := s.RemoteNet.CreateNetwork(&n); err != nil {
 			http.Error(w, fmt.Sprintf("failed to create network: %v", err), http.StatusBadRequest)
@@ -66,6 +77,10 @@ func (s *Server) handleRemoteNetworkConnectors(w http.ResponseWriter, r *http.Re
 		return
 	}
 	networkID := parts[0]
+	if len(parts) == 1 && r.Method == http.MethodPatch {
+		s.handleUpdateRemoteNetwork(w, r, networkID)
+		return
+	}
 	if len(parts) < 2 || parts[1] != "connectors" {
 		http.Error(w, "unknown subresource", http.StatusNotFound)
 		return
@@ -116,3 +131,52 @@ func (s *Server) handleRemoteNetworkConnectors(w http.ResponseWriter, r *http.Re
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// handleUpdateRemoteNetwork changes a network's survivability grace, pushing
+// the new value to its connectors with their next policy update, and whether
+// connectors enrolling into it need approval.
+func (s *Server) handleUpdateRemoteNetwork(w http.ResponseWriter, r *http.Request, networkID string) {
+	var req struct {
+		SurvivabilityGraceSeconds *int  `json:"survivability_grace_seconds"`
+		RequireEnrollmentApproval *bool `json:"require_enrollment_approval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.SurvivabilityGraceSeconds == nil && req.RequireEnrollmentApproval == nil {
+		http.Error(w, "survivability_grace_seconds or require_enrollment_approval required", http.StatusBadRequest)
+		return
+	}
+	if req.SurvivabilityGraceSeconds != nil && *req.SurvivabilityGraceSeconds < 0 {
+		http.Error(w, "survivability_grace_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{"id": networkID}
+	if req.SurvivabilityGraceSeconds != nil {
+		if err := s.RemoteNet.SetSurvivabilityGrace(networkID, *req.SurvivabilityGraceSeconds); err != nil {
+			writeNetworkUpdateError(w, err)
+			return
+		}
+		if s.ACLNotify != nil {
+			s.ACLNotify.NotifyPolicyChange()
+		}
+		resp["survivability_grace_seconds"] = *req.SurvivabilityGraceSeconds
+	}
+	if req.RequireEnrollmentApproval != nil {
+		if err := s.RemoteNet.SetRequireEnrollmentApproval(networkID, *req.RequireEnrollmentApproval); err != nil {
+			writeNetworkUpdateError(w, err)
+			return
+		}
+		resp["require_enrollment_approval"] = *req.RequireEnrollmentApproval
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeNetworkUpdateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("failed to update network: %v", err), http.StatusBadRequest)
+}
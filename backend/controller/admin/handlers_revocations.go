@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"controller/ca"
+	"controller/state"
+)
+
+// crlValidity is how long a served CRL stays valid. It is re-signed on every
+// request, so this only bounds how long a cached copy may be used.
+const crlValidity = 24 * time.Hour
+
+// RevocationNotifier pushes revocation changes to connected connectors.
+type RevocationNotifier interface {
+	NotifyRevocations()
+}
+
+func (s *Server) handleRevocations(w http.ResponseWriter, r *http.Request) {
+	if s.Revocations == nil {
+		http.Error(w, "revocations not configured", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Revocations.List())
+	case http.MethodPost:
+		var req struct {
+			Serial   string `json:"serial"`
+			SPIFFEID string `json:"spiffe_id"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		req.Serial = strings.TrimSpace(req.Serial)
+		req.SPIFFEID = strings.TrimSpace(req.SPIFFEID)
+		if (req.Serial == "") == (req.SPIFFEID == "") {
+			http.Error(w, "exactly one of serial or spiffe_id is required", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if req.Serial != "" {
+			if _, ok := new(big.Int).SetString(req.Serial, 10); !ok {
+				http.Error(w, "serial must be a decimal number", http.StatusBadRequest)
+				return
+			}
+			err = s.Revocations.RevokeSerial(req.Serial, req.Reason, time.Time{})
+		} else {
+			if !strings.HasPrefix(req.SPIFFEID, "spiffe://") {
+				http.Error(w, "invalid spiffe_id", http.StatusBadRequest)
+				return
+			}
+			err = s.Revocations.RevokeSPIFFEID(req.SPIFFEID, req.Reason)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to revoke: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.notifyRevocations()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCRL serves a DER CRL of revoked serials signed by the internal CA.
+// Like /ca.crt it is public.
+func (s *Server) handleCRL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.CAs == nil {
+		http.Error(w, "CRL not available", http.StatusNotFound)
+		return
+	}
+	var entries []x509.RevocationListEntry
+	for _, rev := range s.Revocations.List() {
+		if rev.Kind != state.RevokeSerial {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(rev.Value, 10)
+		if !ok {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: rev.RevokedAt,
+		})
+	}
+	now := time.Now().UTC()
+	crl, err := ca.CreateCRL(s.CAs.Issuer(), entries, big.NewInt(now.UnixNano()), now, now.Add(crlValidity))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to sign CRL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Disposition", `attachment; filename="ca.crl"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(crl)
+}
+
+// revokeConnector revokes a deleted connector's identity and live certs.
+func (s *Server) revokeConnector(connectorID string) {
+	if s.Revocations == nil || s.TrustDomain == "" {
+		return
+	}
+	spiffeID := fmt.Sprintf("spiffe://%s/connector/%s", s.TrustDomain, connectorID)
+	if err := s.Revocations.RevokeSPIFFEID(spiffeID, "connector deleted"); err != nil {
+		return
+	}
+	s.notifyRevocations()
+}
+
+func (s *Server) notifyRevocations() {
+	if s.RevocationNotify != nil {
+		s.RevocationNotify.NotifyRevocations()
+	}
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/api"
+	"controller/ca"
+	"controller/state"
+)
+
+type countingRevocationNotifier struct {
+	calls int
+}
+
+func (n *countingRevocationNotifier) NotifyRevocations() { n.calls++ }
+
+func TestCRLListsRevokedSerials(t *testing.T) {
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cas, err := api.NewCAManager(db, issuer, certPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := &countingRevocationNotifier{}
+	s := &Server{
+		AdminAuthToken:   "root-token",
+		CAs:              cas,
+		Revocations:      state.NewRevocationStore(db),
+		RevocationNotify: notify,
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	// c1 holds a live certificate, which revoking its identity also revokes.
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	uri, _ := url.Parse("spiffe://example.internal/connector/c1")
+	cert := &x509.Certificate{SerialNumber: big.NewInt(77), URIs: []*url.URL{uri}, PublicKey: &key.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+	if err := state.RecordIssuedCertificate(db, cert, state.IssuedViaEnroll, state.KeyProofCSR); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"serial": "1234"}`, `{"spiffe_id": "spiffe://example.internal/connector/c1"}`} {
+		if code := doAdmin(t, mux, http.MethodPost, "/api/admin/revocations", "root-token", body, nil); code != http.StatusOK {
+			t.Fatalf("revoke %s: expected 200, got %d", body, code)
+		}
+	}
+	if notify.calls != 2 {
+		t.Fatalf("expected connectors to be notified twice, got %d", notify.calls)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ca.crl", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/pkix-crl" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	crl, err := x509.ParseRevocationList(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(issuer.Cert); err != nil {
+		t.Fatalf("CRL signature: %v", err)
+	}
+	if !crl.NextUpdate.After(time.Now()) {
+		t.Fatalf("expected a future next update, got %v", crl.NextUpdate)
+	}
+	got := map[string]bool{}
+	for _, entry := range crl.RevokedCertificateEntries {
+		got[entry.SerialNumber.String()] = true
+	}
+	if len(got) != 2 || !got["1234"] || !got["77"] {
+		t.Fatalf("expected serials 1234 and 77 in the CRL, got %v", got)
+	}
+}
+
+func TestRevocationsRejectInvalidInput(t *testing.T) {
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	s := &Server{AdminAuthToken: "root-token", Revocations: state.NewRevocationStore(db)}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	for _, body := range []string{
+		`{}`,
+		`{"serial": "12", "spiffe_id": "spiffe://example.internal/connector/c1"}`,
+		`{"serial": "0x12"}`,
+		`{"spiffe_id": "connector/c1"}`,
+		`not json`,
+	} {
+		if code := doAdmin(t, mux, http.MethodPost, "/api/admin/revocations", "root-token", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+	if len(s.Revocations.List()) != 0 {
+		t.Fatalf("expected nothing revoked, got %+v", s.Revocations.List())
+	}
+}
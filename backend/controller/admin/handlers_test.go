@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"controller/state"
+)
+
+type fakeStreamChecker map[string]bool
+
+func (f fakeStreamChecker) IsStreamActive(connectorID string) bool { return f[connectorID] }
+
+func TestPolicyConvergence(t *testing.T) {
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	s := &Server{
+		AdminAuthToken: "root-token",
+		ACLs:           state.NewACLStoreWithDB(db),
+		StreamChecker:  fakeStreamChecker{"c1": true},
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	for _, row := range []struct {
+		id      string
+		version int
+		hash    string
+	}{{"c1", 2, "h2"}, {"c2", 3, "h3"}} {
+		if _, err := db.Exec(`INSERT INTO connector_policy_versions (connector_id, version, compiled_at, policy_hash) VALUES (?, ?, '', ?)`, row.id, row.version, row.hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := time.Unix(1700000000, 0)
+	if err := state.RecordSnapshotAck(db, "c1", 2, "h2", state.SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RecordSnapshotAck(db, "c2", 2, "h2", state.SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/policy/convergence", "", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", code)
+	}
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/policy/convergence", "root-token", "", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", code)
+	}
+
+	var resp struct {
+		Connectors []struct {
+			ConnectorID    string `json:"connector_id"`
+			DesiredVersion int    `json:"desired_version"`
+			AppliedVersion int    `json:"applied_version"`
+			AckStatus      string `json:"ack_status"`
+			AckedAt        string `json:"acked_at"`
+			Converged      bool   `json:"converged"`
+			StreamActive   bool   `json:"stream_active"`
+		} `json:"connectors"`
+		Versions map[string]int `json:"versions"`
+		Lagging  []string       `json:"lagging"`
+	}
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/policy/convergence", "root-token", "", &resp); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(resp.Connectors) != 2 {
+		t.Fatalf("expected 2 connectors, got %+v", resp.Connectors)
+	}
+	c1, c2 := resp.Connectors[0], resp.Connectors[1]
+	if c1.ConnectorID != "c1" || !c1.Converged || !c1.StreamActive || c1.AppliedVersion != 2 || c1.AckStatus != "applied" || c1.AckedAt != "2023-11-14T22:13:20Z" {
+		t.Fatalf("unexpected c1 %+v", c1)
+	}
+	if c2.ConnectorID != "c2" || c2.Converged || c2.StreamActive || c2.DesiredVersion != 3 || c2.AppliedVersion != 2 {
+		t.Fatalf("unexpected c2 %+v", c2)
+	}
+	if !reflect.DeepEqual(resp.Versions, map[string]int{"2": 2}) {
+		t.Fatalf("unexpected versions %v", resp.Versions)
+	}
+	if !reflect.DeepEqual(resp.Lagging, []string{"c2"}) {
+		t.Fatalf("unexpected lagging %v", resp.Lagging)
+	}
+}
+
+func TestPolicyConvergenceWithoutDB(t *testing.T) {
+	s := &Server{AdminAuthToken: "root-token"}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	var resp struct {
+		Connectors []interface{}  `json:"connectors"`
+		Versions   map[string]int `json:"versions"`
+		Lagging    []string       `json:"lagging"`
+	}
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/policy/convergence", "root-token", "", &resp); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if resp.Connectors == nil || resp.Versions == nil || resp.Lagging == nil {
+		t.Fatalf("expected empty lists rather than null, got %+v", resp)
+	}
+}
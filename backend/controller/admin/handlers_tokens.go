@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"controller/state"
+)
+
+// tokenView is an enrollment token as shown by the admin API. The token
+// itself is never stored; ID is a prefix of its SHA-256.
+type tokenView struct {
+	ID              string   `json:"id"`
+	State           string   `json:"state"`
+	Role            string   `json:"role,omitempty"`
+	WorkloadID      string   `json:"workload_id,omitempty"`
+	RemoteNetworkID string   `json:"remote_network_id,omitempty"`
+	MaxUses         int      `json:"max_uses"`
+	UsedBy          []string `json:"used_by"`
+	CreatedBy       string   `json:"created_by,omitempty"`
+	CreatedAt       string   `json:"created_at,omitempty"`
+	ExpiresAt       string   `json:"expires_at,omitempty"`
+	RevokedAt       string   `json:"revoked_at,omitempty"`
+	RequireApproval bool     `json:"require_approval,omitempty"`
+}
+
+func newTokenView(rec state.TokenRecord, now time.Time) tokenView {
+	v := tokenView{
+		ID:              rec.ID(),
+		State:           rec.State(now),
+		Role:            rec.Role,
+		WorkloadID:      rec.WorkloadID,
+		RemoteNetworkID: rec.RemoteNetworkID,
+		MaxUses:         rec.MaxUses,
+		UsedBy:          rec.UsedBy,
+		CreatedBy:       rec.CreatedBy,
+		CreatedAt:       formatTime(rec.CreatedAt),
+		ExpiresAt:       formatTime(rec.ExpiresAt),
+		RevokedAt:       formatTime(rec.RevokedAt),
+		RequireApproval: rec.RequireApproval,
+	}
+	if v.MaxUses <= 0 {
+		v.MaxUses = 1
+	}
+	if v.UsedBy == nil {
+		v.UsedBy = []string{}
+	}
+	return v
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// handleTokens lists enrollment tokens (GET, optionally filtered by state)
+// and creates them (POST).
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		filter := strings.TrimSpace(r.URL.Query().Get("state"))
+		now := time.Now()
+		out := []tokenView{}
+		for _, rec := range s.Tokens.ListTokens() {
+			v := newTokenView(rec, now)
+			if filter != "" && v.State != filter {
+				continue
+			}
+			out = append(out, v)
+		}
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		s.handleCreateToken(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTokenSubroutes serves /api/admin/tokens/{id} (GET to inspect, DELETE
+// to revoke) and POST /api/admin/tokens/purge.
+func (s *Server) handleTokenSubroutes(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/tokens/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if id == "purge" {
+		s.handlePurgeTokens(w, r)
+		return
+	}
+
+	var (
+		rec state.TokenRecord
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		rec, err = s.Tokens.GetToken(id)
+	case http.MethodDelete:
+		rec, err = s.Tokens.RevokeToken(id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, state.ErrTokenNotFound):
+		http.Error(w, "token not found", http.StatusNotFound)
+		return
+	case errors.Is(err, state.ErrTokenAmbiguous):
+		http.Error(w, "token id is ambiguous; use a longer prefix", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("failed to update token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenView(rec, time.Now()))
+}
+
+// handlePurgeTokens deletes tokens that can no longer enroll anything. The
+// optional body {"states": [...]} limits which of expired, used and revoked
+// are purged; by default all three are.
+func (s *Server) handlePurgeTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		States []string `json:"states"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.States) == 0 {
+		req.States = []string{state.TokenExpired, state.TokenUsed, state.TokenRevoked}
+	}
+	for _, st := range req.States {
+		switch st {
+		case state.TokenExpired, state.TokenUsed, state.TokenRevoked:
+		default:
+			http.Error(w, fmt.Sprintf("cannot purge tokens in state %q", st), http.StatusBadRequest)
+			return
+		}
+	}
+	n, err := s.Tokens.PurgeTokens(req.States...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to purge tokens: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"controller/state"
+)
+
+func newTokenTestServer(t *testing.T) (*Server, *http.ServeMux) {
+	t.Helper()
+	s := &Server{
+		Tokens:         state.NewTokenStore(time.Hour, ""),
+		AdminAuthToken: "root-token",
+		AdminTokens:    map[string]string{"alice-token": "alice"},
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	return s, mux
+}
+
+func doAdmin(t *testing.T, mux *http.ServeMux, method, path, bearer, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestCreateTokenRecordsAuthenticatedAdmin(t *testing.T) {
+	_, mux := newTokenTestServer(t)
+
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens", "wrong", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown admin token, got %d", code)
+	}
+
+	var created struct {
+		Token string `json:"token"`
+	}
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens", "alice-token", `{"created_by": "mallory"}`, &created); code != http.StatusOK {
+		t.Fatalf("create: %d", code)
+	}
+	var view tokenView
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/tokens/"+state.TokenID(created.Token), "alice-token", "", &view); code != http.StatusOK {
+		t.Fatalf("get: %d", code)
+	}
+	if view.CreatedBy != "alice" {
+		t.Fatalf("expected created_by from the admin credential, got %q", view.CreatedBy)
+	}
+
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens", "root-token", "", &created); code != http.StatusOK {
+		t.Fatalf("create: %d", code)
+	}
+	doAdmin(t, mux, http.MethodGet, "/api/admin/tokens/"+state.TokenID(created.Token), "root-token", "", &view)
+	if view.CreatedBy != "admin" {
+		t.Fatalf("expected ADMIN_AUTH_TOKEN to record admin, got %q", view.CreatedBy)
+	}
+}
+
+func TestTokenListGetRevokePurge(t *testing.T) {
+	s, mux := newTokenTestServer(t)
+	active, _, _ := s.Tokens.CreateToken(state.TokenOptions{})
+	used, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector"})
+	if _, err := s.Tokens.ConsumeToken(used, "connector", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	expired, _, _ := s.Tokens.CreateToken(state.TokenOptions{TTL: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+
+	var list []tokenView
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/tokens", "root-token", "", &list); code != http.StatusOK || len(list) != 3 {
+		t.Fatalf("list: expected 3 tokens, got %d (%d)", len(list), code)
+	}
+	doAdmin(t, mux, http.MethodGet, "/api/admin/tokens?state=used", "root-token", "", &list)
+	if len(list) != 1 || list[0].ID != state.TokenID(used) || len(list[0].UsedBy) != 1 || list[0].UsedBy[0] != "c1" {
+		t.Fatalf("list used: unexpected %+v", list)
+	}
+
+	var view tokenView
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/tokens/"+state.TokenID(expired), "root-token", "", &view); code != http.StatusOK || view.State != state.TokenExpired {
+		t.Fatalf("get expired: %d %+v", code, view)
+	}
+	if code := doAdmin(t, mux, http.MethodGet, "/api/admin/tokens/ffffffffffff", "root-token", "", nil); code != http.StatusNotFound {
+		t.Fatalf("get unknown: expected 404, got %d", code)
+	}
+
+	if code := doAdmin(t, mux, http.MethodDelete, "/api/admin/tokens/"+state.TokenID(active), "root-token", "", &view); code != http.StatusOK || view.State != state.TokenRevoked {
+		t.Fatalf("revoke: %d %+v", code, view)
+	}
+	if _, err := s.Tokens.ConsumeToken(active, "connector", "c2"); err == nil {
+		t.Fatal("expected revoked token to be refused")
+	}
+
+	var purged map[string]int
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens/purge", "root-token", `{"states": ["active"]}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("purge active: expected 400, got %d", code)
+	}
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens/purge", "root-token", `{"states": ["expired"]}`, &purged); code != http.StatusOK || purged["purged"] != 1 {
+		t.Fatalf("purge expired: %d %v", code, purged)
+	}
+	if code := doAdmin(t, mux, http.MethodPost, "/api/admin/tokens/purge", "root-token", "", &purged); code != http.StatusOK || purged["purged"] != 2 {
+		t.Fatalf("purge all: %d %v", code, purged)
+	}
+	if left := s.Tokens.ListTokens(); len(left) != 0 {
+		t.Fatalf("expected no tokens left, got %d", len(left))
+	}
+}
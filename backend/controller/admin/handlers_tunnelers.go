@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"controller/state"
+)
+
+// TunnelerNotifier tells connected connectors to stop accepting a tunneler.
+type TunnelerNotifier interface {
+	NotifyTunnelerRevoked(tunnelerID, spiffeID string)
+}
+
+// handleTunnelerSubroutes serves DELETE /api/admin/tunnelers/{id}.
+func (s *Server) handleTunnelerSubroutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/tunnelers/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "tunneler id required", http.StatusBadRequest)
+		return
+	}
+	if !s.deleteTunneler(id) {
+		http.Error(w, "tunneler not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// deleteTunneler forgets a tunneler, revokes its identity so it cannot
+// reconnect or renew, and has connectors drop it from their allowlists and
+// end its open streams. A tunneler counts as known if it is in the registry,
+// the tunnelers table or the certificate inventory; the last covers one that
+// enrolled but has not been seen since the controller restarted. Unknown IDs
+// are left alone and false is returned, so a typo does not revoke anything.
+func (s *Server) deleteTunneler(tunnelerID string) bool {
+	spiffeID := ""
+	if s.TrustDomain != "" {
+		spiffeID = fmt.Sprintf("spiffe://%s/tunneler/%s", s.TrustDomain, tunnelerID)
+	}
+	found := false
+	if s.Tunnelers != nil && s.Tunnelers.Delete(tunnelerID) {
+		found = true
+	}
+	if s.ACLs != nil && s.ACLs.DB() != nil {
+		db := s.ACLs.DB()
+		err := state.DeleteTunnelerFromDB(db, tunnelerID)
+		switch {
+		case err == nil:
+			found = true
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("failed to delete tunneler %s: %v", tunnelerID, err)
+		}
+		if !found && spiffeID != "" {
+			certs, err := state.ListIssuedCertificates(db, state.CertificateFilter{Identity: spiffeID, Limit: 1})
+			if err != nil {
+				log.Printf("failed to look up certificates for tunneler %s: %v", tunnelerID, err)
+			}
+			found = len(certs) > 0
+		}
+	}
+	if !found {
+		return false
+	}
+	if s.Revocations != nil && spiffeID != "" {
+		if err := s.Revocations.RevokeSPIFFEID(spiffeID, "tunneler deleted"); err != nil {
+			log.Printf("failed to revoke tunneler %s: %v", tunnelerID, err)
+		} else {
+			s.notifyRevocations()
+		}
+	}
+	if s.TunnelerNotify != nil {
+		s.TunnelerNotify.NotifyTunnelerRevoked(tunnelerID, spiffeID)
+	}
+	log.Printf("tunneler %s deleted", tunnelerID)
+	return true
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/state"
+)
+
+type recordingTunnelerNotifier struct {
+	revoked []string
+}
+
+func (n *recordingTunnelerNotifier) NotifyTunnelerRevoked(tunnelerID, spiffeID string) {
+	n.revoked = append(n.revoked, tunnelerID)
+}
+
+func TestDeleteTunneler(t *testing.T) {
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	notify := &recordingTunnelerNotifier{}
+	s := &Server{
+		AdminAuthToken: "root-token",
+		TrustDomain:    "example.internal",
+		Tunnelers:      state.NewTunnelerStatusRegistry(),
+		ACLs:           state.NewACLStoreWithDB(db),
+		Revocations:    state.NewRevocationStore(db),
+		TunnelerNotify: notify,
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	s.Tunnelers.Record("t1", "spiffe://example.internal/tunneler/t1", "c1")
+	if err := state.SaveTunnelerToDB(db, state.TunnelerRecord{ID: "t2", SPIFFEID: "spiffe://example.internal/tunneler/t2", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// t3 enrolled but has not been seen since the controller restarted.
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	uri, _ := url.Parse("spiffe://example.internal/tunneler/t3")
+	cert := &x509.Certificate{SerialNumber: big.NewInt(3), URIs: []*url.URL{uri}, PublicKey: &key.PublicKey, NotAfter: time.Now().Add(time.Hour)}
+	if err := state.RecordIssuedCertificate(db, cert, state.IssuedViaEnroll, state.KeyProofCSR); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"t1", "t2", "t3"} {
+		if code := doAdmin(t, mux, http.MethodDelete, "/api/admin/tunnelers/"+id, "root-token", "", nil); code != http.StatusOK {
+			t.Fatalf("delete %s: expected 200, got %d", id, code)
+		}
+		if !s.Revocations.IsSPIFFEIDRevoked("spiffe://example.internal/tunneler/" + id) {
+			t.Fatalf("expected %s to be revoked", id)
+		}
+	}
+
+	if code := doAdmin(t, mux, http.MethodDelete, "/api/admin/tunnelers/t1x", "root-token", "", nil); code != http.StatusNotFound {
+		t.Fatalf("delete unknown: expected 404, got %d", code)
+	}
+	if s.Revocations.IsSPIFFEIDRevoked("spiffe://example.internal/tunneler/t1x") {
+		t.Fatal("expected unknown tunneler not to be revoked")
+	}
+	if len(notify.revoked) != 3 {
+		t.Fatalf("expected connectors to be told about 3 tunnelers, got %v", notify.revoked)
+	}
+	if code := doAdmin(t, mux, http.MethodDelete, "/api/admin/tunnelers/t1", "root-token", "", nil); code != http.StatusNotFound {
+		t.Fatalf("delete again: expected 404, got %d", code)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"controller/api"
-	"controller/state"
 	"github.com/google/uuid"
 )
 
@@ -1033,15 +1032,7 @@ func (s *Server) handleUIConnectorsSubroutes(w http.ResponseWriter, r *http.Requ
 	connectorID := parts[0]
 	if len(parts) == 1 {
 		if r.Method == http.MethodDelete {
-			if s.Reg != nil {
-				s.Reg.Delete(connectorID)
-			}
-			if s.ACLs != nil && s.ACLs.DB() != nil {
-				_ = state.DeleteConnectorFromDB(s.ACLs.DB(), connectorID)
-			}
-			if s.Tokens != nil {
-				_ = s.Tokens.DeleteByConnectorID(connectorID)
-			}
+			s.deleteConnector(connectorID)
 			writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
 			return
 		}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"log"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"controller/state"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// enrollmentPollInterval is how often workloads are told to retry an
+// enrollment that is waiting for approval.
+const enrollmentPollInterval = 15 * time.Second
+
+// awaitApproval holds enrollments that need an admin's approval, either
+// because the attestation asks for it or because its remote network does.
+// The first request is recorded as pending and every request is answered with
+// Unavailable and a RetryInfo until an admin decides. Once approved, the
+// request made with the same key gets the approved record back, to be
+// completed after the certificate is issued. It returns nil when no approval
+// is needed.
+func (s *EnrollmentServer) awaitApproval(ctx context.Context, role, id string, req *controllerpb.EnrollRequest, pub crypto.PublicKey, keyProof string, att *Attestation) (*state.PendingEnrollment, error) {
+	required := att.RequireApproval
+	if !required && att.RemoteNetworkID != "" {
+		var err error
+		required, err = state.RequiresEnrollmentApproval(s.DB, att.RemoteNetworkID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to load remote network: %v", err)
+		}
+	}
+	if !required {
+		return nil, nil
+	}
+	if s.DB == nil {
+		return nil, status.Error(codes.FailedPrecondition, "enrollment approval unavailable")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+	}
+	fingerprint := keySHA256(der)
+	source := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		source = p.Addr.String()
+	}
+
+	pending, err := state.FindOpenEnrollment(s.DB, role, id, fingerprint)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load pending enrollment: %v", err)
+	}
+	if pending == nil {
+		pending = &state.PendingEnrollment{
+			Role:            role,
+			WorkloadID:      id,
+			PublicKeyPEM:    string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			PublicKeySHA256: fingerprint,
+			KeyProof:        keyProof,
+			PrivateIP:       req.GetPrivateIp(),
+			Version:         req.GetVersion(),
+			SourceAddr:      source,
+			AttestationType: att.Type,
+			AttestedBy:      att.Subject,
+			RemoteNetworkID: att.RemoteNetworkID,
+		}
+		if err := state.CreatePendingEnrollment(s.DB, pending); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to record pending enrollment: %v", err)
+		}
+		log.Printf("enroll-%s: %s from %s (%s) is pending approval as %s", role, id, source, att.Subject, pending.ID)
+		return nil, pendingApprovalError(pending.ID)
+	}
+
+	switch pending.Status {
+	case state.EnrollmentApproved:
+		// The certificate carries the private IP as a SAN, so it must be
+		// the one the admin approved.
+		if role == "connector" && req.GetPrivateIp() != pending.PrivateIP {
+			return nil, status.Errorf(codes.FailedPrecondition, "enrollment %s was approved for private ip %s", pending.ID, pending.PrivateIP)
+		}
+		return pending, nil
+	case state.EnrollmentDenied:
+		return nil, status.Errorf(codes.PermissionDenied, "enrollment %s was denied", pending.ID)
+	default:
+		if err := state.TouchPendingEnrollment(s.DB, pending.ID, source); err != nil {
+			log.Printf("enroll-%s: failed to update pending enrollment %s: %v", role, pending.ID, err)
+		}
+		return nil, pendingApprovalError(pending.ID)
+	}
+}
+
+// resumeApproval attests a join token enrollment already held for approval
+// with the same token and key, even if the token has expired since: the
+// recorded request now decides the outcome, and an expired token must not
+// strand an approval. It returns nil if there is no such request, in which
+// case the token is attested as usual.
+func (s *EnrollmentServer) resumeApproval(role string, req *controllerpb.EnrollRequest) *Attestation {
+	typ := req.GetAttestationType()
+	if (typ != "" && typ != AttestJoinToken) || s.DB == nil || s.Tokens == nil || req.GetToken() == "" || !validID(req.GetId()) {
+		return nil
+	}
+	fingerprint, ok := requestKeySHA256(req)
+	if !ok {
+		return nil
+	}
+	pending, err := state.FindOpenEnrollment(s.DB, role, req.GetId(), fingerprint)
+	if err != nil || pending == nil || pending.AttestationType != AttestJoinToken || pending.AttestedBy != "token "+state.TokenID(req.GetToken()) {
+		return nil
+	}
+	rec, err := s.Tokens.ResumeToken(req.GetToken(), role, req.GetId())
+	if err != nil {
+		return nil
+	}
+	return &Attestation{
+		Type:            AttestJoinToken,
+		WorkloadID:      req.GetId(),
+		RemoteNetworkID: pending.RemoteNetworkID,
+		RequireApproval: true,
+		Subject:         "token " + rec.ID(),
+	}
+}
+
+// requestKeySHA256 returns the fingerprint of the key req asks to certify,
+// taken from its CSR or public key. The key is not verified here; the
+// request is checked in full before a certificate is issued.
+func requestKeySHA256(req *controllerpb.EnrollRequest) (string, bool) {
+	var pub crypto.PublicKey
+	if block, _ := pem.Decode(req.GetCsr()); block != nil {
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return "", false
+		}
+		pub = csr.PublicKey
+	} else {
+		var err error
+		if pub, err = parsePublicKey(req.GetPublicKey()); err != nil {
+			return "", false
+		}
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", false
+	}
+	return keySHA256(der), true
+}
+
+func keySHA256(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// completeApproval marks an approved enrollment as used once its certificate
+// has been issued.
+func (s *EnrollmentServer) completeApproval(approved *state.PendingEnrollment) {
+	if approved == nil {
+		return
+	}
+	if err := state.CompletePendingEnrollment(s.DB, approved.ID); err != nil {
+		log.Printf("failed to complete pending enrollment %s: %v", approved.ID, err)
+	}
+}
+
+func pendingApprovalError(id string) error {
+	st := status.Newf(codes.Unavailable, "enrollment %s is pending approval", id)
+	if withRetry, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(enrollmentPollInterval)}); err == nil {
+		st = withRetry
+	}
+	return st.Err()
+}
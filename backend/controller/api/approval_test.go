@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestEnrollmentServer(t *testing.T) *EnrollmentServer {
+	t.Helper()
+	db := openTestDB(t)
+	envCA, envCertPEM := newTestCA(t)
+	cas, err := NewCAManager(db, envCA, envCertPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewPolicySigner(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEnrollmentServer(cas, "example.internal", state.NewTokenStoreWithDB(time.Hour, db), state.NewRegistry(),
+		nil, signer, db, state.NewRevocationStore(db), NewTTLPolicy(db, nil, 0))
+}
+
+// connectorRequest returns an enrollment request for c1 with a fresh key.
+func connectorRequest(t *testing.T, token, privateIP, version string) *controllerpb.EnrollRequest {
+	t.Helper()
+	return &controllerpb.EnrollRequest{
+		Id:        "c1",
+		Token:     token,
+		Csr:       createTestCSR(t, newECKey(t), spiffeCSRTemplate(t, "spiffe://example.internal/connector/c1")),
+		PrivateIp: privateIP,
+		Version:   version,
+	}
+}
+
+func pendingFor(t *testing.T, s *EnrollmentServer) state.PendingEnrollment {
+	t.Helper()
+	list, err := state.ListPendingEnrollments(s.DB, "")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected one pending enrollment, got %d (%v)", len(list), err)
+	}
+	return list[0]
+}
+
+func TestEnrollmentWaitsForApproval(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector", RequireApproval: true})
+	req := connectorRequest(t, token, "10.0.0.5", "1.0.0")
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.Unavailable {
+			t.Fatalf("poll %d: expected Unavailable while pending, got %v", i, err)
+		}
+	}
+	p := pendingFor(t, s)
+	if p.Status != state.EnrollmentPending || p.PrivateIP != "10.0.0.5" {
+		t.Fatalf("unexpected pending enrollment %+v", p)
+	}
+
+	// Another key for the same ID is a separate request.
+	if _, err := s.EnrollConnector(context.Background(), connectorRequest(t, token, "10.0.0.5", "1.0.0")); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable for another key, got %v", err)
+	}
+	if list, _ := state.ListPendingEnrollments(s.DB, state.EnrollmentPending); len(list) != 2 {
+		t.Fatalf("expected two pending requests, got %d", len(list))
+	}
+}
+
+func TestApprovedEnrollmentCompletesAfterTokenExpiry(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector", RequireApproval: true, TTL: 50 * time.Millisecond})
+	req := connectorRequest(t, token, "10.0.0.5", "1.0.0")
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := state.DecidePendingEnrollment(s.DB, pendingFor(t, s).ID, true, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The workload upgraded while waiting and now reports another IP.
+	moved := &controllerpb.EnrollRequest{Id: req.Id, Token: req.Token, Csr: req.Csr, PrivateIp: "10.0.0.9", Version: "1.0.1"}
+	if _, err := s.EnrollConnector(context.Background(), moved); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a changed private ip, got %v", err)
+	}
+
+	moved.PrivateIp = "10.0.0.5"
+	resp, err := s.EnrollConnector(context.Background(), moved)
+	if err != nil {
+		t.Fatalf("expected approved enrollment to complete after token expiry: %v", err)
+	}
+	block, _ := pem.Decode(resp.GetCertificate())
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.5")) {
+		t.Fatalf("expected approved private ip in certificate, got %v", leaf.IPAddresses)
+	}
+	if rec, ok := s.Registry.Get("c1"); !ok || rec.Version != "1.0.0" {
+		t.Fatalf("expected approved version to be registered, got %+v", rec)
+	}
+	if p := pendingFor(t, s); p.Status != state.EnrollmentCompleted {
+		t.Fatalf("expected request to be completed, got %s", p.Status)
+	}
+
+	// The approval is used up and the token has expired.
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied after completion, got %v", err)
+	}
+}
+
+func TestDeniedEnrollmentStaysDenied(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector", RequireApproval: true, TTL: 50 * time.Millisecond})
+	req := connectorRequest(t, token, "10.0.0.5", "1.0.0")
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	if _, err := state.DecidePendingEnrollment(s.DB, pendingFor(t, s).ID, false, "unknown host"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied after expiry, got %v", err)
+	}
+}
+
+func TestExpiredTokenWithoutPendingRequestIsRefused(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector", RequireApproval: true, TTL: time.Millisecond})
+	time.Sleep(10 * time.Millisecond)
+	if _, err := s.EnrollConnector(context.Background(), connectorRequest(t, token, "10.0.0.5", "1.0.0")); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	if list, _ := state.ListPendingEnrollments(s.DB, ""); len(list) != 0 {
+		t.Fatalf("expected no pending request, got %d", len(list))
+	}
+}
+
+func TestRevokedTokenStopsPendingEnrollment(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector", RequireApproval: true})
+	req := connectorRequest(t, token, "10.0.0.5", "1.0.0")
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	if _, err := state.DecidePendingEnrollment(s.DB, pendingFor(t, s).ID, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Tokens.RevokeToken(state.TokenID(token)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EnrollConnector(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a revoked token, got %v", err)
+	}
+}
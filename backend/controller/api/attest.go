@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Attestation types accepted in EnrollRequest.attestation_type.
+const (
+	AttestJoinToken = "join_token"
+	AttestX509PoP   = "x509pop"
+)
+
+// x509PoPContext prefixes the CSR in the digest signed by the machine key, so
+// the signature cannot be mistaken for one made for another purpose.
+const x509PoPContext = "ztna x509pop v1\x00"
+
+// Attestor verifies the evidence a workload presents to enroll.
+type Attestor interface {
+	// Attest checks req's evidence for a workload of role and returns what
+	// it vouches for. It may consume single-use evidence such as a join
+	// token.
+	Attest(ctx context.Context, role string, req *controllerpb.EnrollRequest) (*Attestation, error)
+}
+
+// Attestation holds the claims an Attestor vouches for.
+type Attestation struct {
+	Type string
+	// WorkloadID is the ID the workload is enrolled under.
+	WorkloadID string
+	// RemoteNetworkID, if set, receives an enrolled connector.
+	RemoteNetworkID string
+	// RequireApproval holds the enrollment until an admin approves it.
+	RequireApproval bool
+	// Subject names the evidence in logs and pending enrollments: the token
+	// ID or the machine certificate.
+	Subject string
+}
+
+// attest runs the attestor req asks for.
+func (s *EnrollmentServer) attest(ctx context.Context, role string, req *controllerpb.EnrollRequest) (*Attestation, error) {
+	typ := req.GetAttestationType()
+	if typ == "" {
+		typ = AttestJoinToken
+	}
+	a, ok := s.Attestors[typ]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported attestation type %q", typ)
+	}
+	att := s.resumeApproval(role, req)
+	if att == nil {
+		var err error
+		if att, err = a.Attest(ctx, role, req); err != nil {
+			return nil, err
+		}
+	}
+	if !validID(att.WorkloadID) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s id", role)
+	}
+	return att, nil
+}
+
+// checkRevokedIdentity refuses to re-enroll a revoked identity unless it
+// presents a join token: an admin-issued token is what lifts the revocation,
+// whereas machine evidence stays valid after the identity is revoked.
+func (s *EnrollmentServer) checkRevokedIdentity(att *Attestation, spiffeID string) error {
+	if att.Type == AttestJoinToken || !s.Revocations.IsSPIFFEIDRevoked(spiffeID) {
+		return nil
+	}
+	log.Printf("enroll: refused %s attestation for revoked identity %s", att.Type, spiffeID)
+	return status.Errorf(codes.PermissionDenied, "%s has been revoked; enroll with a join token", spiffeID)
+}
+
+// JoinTokenAttestor accepts a workload holding an enrollment token. The
+// workload chooses its ID unless the token fixes one.
+type JoinTokenAttestor struct {
+	Tokens *state.TokenStore
+}
+
+func (a *JoinTokenAttestor) Attest(ctx context.Context, role string, req *controllerpb.EnrollRequest) (*Attestation, error) {
+	if !validID(req.GetId()) {
+		return nil, status.Errorf(codes.InvalidArgument, "missing %s id", role)
+	}
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing enrollment token")
+	}
+	if a.Tokens == nil {
+		return nil, status.Error(codes.FailedPrecondition, "token service unavailable")
+	}
+	rec, err := a.Tokens.ConsumeToken(req.GetToken(), role, req.GetId())
+	if err != nil {
+		log.Printf("enroll-%s: rejected token for %s: %v", role, req.GetId(), err)
+		return nil, status.Error(codes.PermissionDenied, "invalid enrollment token")
+	}
+	return &Attestation{
+		Type:            AttestJoinToken,
+		WorkloadID:      req.GetId(),
+		RemoteNetworkID: rec.RemoteNetworkID,
+		RequireApproval: rec.RequireApproval,
+		Subject:         "token " + rec.ID(),
+	}, nil
+}
+
+// X509PoPAttestor accepts a connector that presents a machine certificate
+// issued from Roots and signs its CSR with the machine key. The connector is
+// enrolled under the certificate's common name and, if an organizational
+// unit names a remote network, assigned to that network.
+type X509PoPAttestor struct {
+	Roots    *x509.CertPool
+	Networks *state.RemoteNetworkStore
+}
+
+// NewX509PoPAttestor trusts the machine certificate issuers in bundlePEM.
+func NewX509PoPAttestor(bundlePEM []byte, networks *state.RemoteNetworkStore) (*X509PoPAttestor, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundlePEM) {
+		return nil, errors.New("no certificates in machine CA bundle")
+	}
+	return &X509PoPAttestor{Roots: roots, Networks: networks}, nil
+}
+
+func (a *X509PoPAttestor) Attest(ctx context.Context, role string, req *controllerpb.EnrollRequest) (*Attestation, error) {
+	if role != "connector" {
+		return nil, status.Errorf(codes.PermissionDenied, "%s attestation is only available to connectors", AttestX509PoP)
+	}
+	leaf, intermediates, err := parseMachineChain(req.GetMachineCertChain())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid machine certificate: %v", err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         a.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		CurrentTime:   time.Now(),
+	}); err != nil {
+		log.Printf("enroll-%s: untrusted machine certificate %q: %v", role, leaf.Subject.CommonName, err)
+		return nil, status.Error(codes.PermissionDenied, "untrusted machine certificate")
+	}
+
+	block, _ := pem.Decode(req.GetCsr())
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s attestation requires a csr", AttestX509PoP)
+	}
+	if err := verifyMachineSignature(leaf.PublicKey, X509PoPDigest(block.Bytes), req.GetMachineSignature()); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "invalid machine signature: %v", err)
+	}
+
+	id := leaf.Subject.CommonName
+	if req.GetId() != "" && req.GetId() != id {
+		return nil, status.Errorf(codes.PermissionDenied, "id %q does not match machine certificate %q", req.GetId(), id)
+	}
+	sum := sha256.Sum256(leaf.Raw)
+	att := &Attestation{
+		Type:       AttestX509PoP,
+		WorkloadID: id,
+		Subject:    fmt.Sprintf("machine cert %q sha256=%s", id, hex.EncodeToString(sum[:8])),
+	}
+	for _, ou := range leaf.Subject.OrganizationalUnit {
+		if a.Networks == nil {
+			break
+		}
+		ok, err := a.Networks.NetworkExists(ou)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to look up remote network: %v", err)
+		}
+		if ok {
+			att.RemoteNetworkID = ou
+			break
+		}
+	}
+	return att, nil
+}
+
+// X509PoPDigest is the digest of csrDER the machine key signs for x509pop
+// attestation.
+func X509PoPDigest(csrDER []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(x509PoPContext))
+	h.Write(csrDER)
+	return h.Sum(nil)
+}
+
+func parseMachineChain(chainPEM []byte) (*x509.Certificate, *x509.CertPool, error) {
+	var certs []*x509.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, nil, errors.New("no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	return certs[0], intermediates, nil
+}
+
+// verifyMachineSignature checks sig over digest: ASN.1 ECDSA, RSA PKCS#1
+// v1.5 with SHA-256, or Ed25519 over the digest itself.
+func verifyMachineSignature(pub crypto.PublicKey, digest, sig []byte) error {
+	if len(sig) == 0 {
+		return errors.New("missing signature")
+	}
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, digest, sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported machine key type %T", pub)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type machineIdentity struct {
+	chainPEM []byte
+	key      *ecdsa.PrivateKey
+}
+
+func newMachineCA(t *testing.T) (*ca.CA, []byte) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("machine-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer, certPEM
+}
+
+func issueMachineCert(t *testing.T, issuer *ca.CA, cn string, ous []string, eku x509.ExtKeyUsage) machineIdentity {
+	t.Helper()
+	key := newECKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{eku},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer.Cert, &key.PublicKey, issuer.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machineIdentity{chainPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key: key}
+}
+
+// x509PoPRequest builds an x509pop enrollment request for id, signing the CSR
+// digest with signer (normally the machine key).
+func x509PoPRequest(t *testing.T, m machineIdentity, id string, signer *ecdsa.PrivateKey) *controllerpb.EnrollRequest {
+	t.Helper()
+	csrPEM := createTestCSR(t, newECKey(t), spiffeCSRTemplate(t, "spiffe://example.internal/connector/"+id))
+	block, _ := pem.Decode(csrPEM)
+	sig, err := ecdsa.SignASN1(rand.Reader, signer, X509PoPDigest(block.Bytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &controllerpb.EnrollRequest{
+		Id:               id,
+		AttestationType:  AttestX509PoP,
+		Csr:              csrPEM,
+		MachineCertChain: m.chainPEM,
+		MachineSignature: sig,
+		PrivateIp:        "10.0.0.5",
+		Version:          "1.0.0",
+	}
+}
+
+func TestX509PoPAttestor(t *testing.T) {
+	db := openTestDB(t)
+	networks := state.NewRemoteNetworkStore(db)
+	network := &state.RemoteNetwork{Name: "branch"}
+	if err := networks.CreateNetwork(network); err != nil {
+		t.Fatal(err)
+	}
+	machineCA, bundlePEM := newMachineCA(t)
+	a, err := NewX509PoPAttestor(bundlePEM, networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := issueMachineCert(t, machineCA, "c1", []string{"Servers", network.ID}, x509.ExtKeyUsageClientAuth)
+
+	att, err := a.Attest(context.Background(), "connector", x509PoPRequest(t, good, "c1", good.key))
+	if err != nil {
+		t.Fatalf("good machine cert: %v", err)
+	}
+	if att.Type != AttestX509PoP || att.WorkloadID != "c1" || att.RemoteNetworkID != network.ID {
+		t.Fatalf("unexpected attestation %+v", att)
+	}
+
+	untrustedCA, _ := newMachineCA(t)
+	untrusted := issueMachineCert(t, untrustedCA, "c1", nil, x509.ExtKeyUsageClientAuth)
+	serverOnly := issueMachineCert(t, machineCA, "c1", nil, x509.ExtKeyUsageServerAuth)
+	noOU := issueMachineCert(t, machineCA, "c1", []string{"Servers"}, x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name string
+		role string
+		req  *controllerpb.EnrollRequest
+		code codes.Code
+	}{
+		{"wrong eku", "connector", x509PoPRequest(t, serverOnly, "c1", serverOnly.key), codes.PermissionDenied},
+		{"untrusted root", "connector", x509PoPRequest(t, untrusted, "c1", untrusted.key), codes.PermissionDenied},
+		{"signed by another key", "connector", x509PoPRequest(t, good, "c1", newECKey(t)), codes.PermissionDenied},
+		{"id mismatch", "connector", x509PoPRequest(t, good, "c2", good.key), codes.PermissionDenied},
+		{"tunneler", "tunneler", x509PoPRequest(t, good, "c1", good.key), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if _, err := a.Attest(context.Background(), tt.role, tt.req); status.Code(err) != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+		}
+	}
+
+	// A signature over a plain hash of the CSR, without the x509pop context, is
+	// refused.
+	req := x509PoPRequest(t, good, "c1", good.key)
+	block, _ := pem.Decode(req.Csr)
+	plain := sha256.Sum256(block.Bytes)
+	req.MachineSignature, _ = ecdsa.SignASN1(rand.Reader, good.key, plain[:])
+	if _, err := a.Attest(context.Background(), "connector", req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("signature over the wrong digest: expected PermissionDenied, got %v", err)
+	}
+
+	att, err = a.Attest(context.Background(), "connector", x509PoPRequest(t, noOU, "c1", noOU.key))
+	if err != nil || att.RemoteNetworkID != "" {
+		t.Fatalf("expected no remote network for unknown OUs, got %+v %v", att, err)
+	}
+}
+
+func TestX509PoPRevokedIdentityNeedsJoinToken(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	machineCA, bundlePEM := newMachineCA(t)
+	a, err := NewX509PoPAttestor(bundlePEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Attestors[AttestX509PoP] = a
+	m := issueMachineCert(t, machineCA, "c1", nil, x509.ExtKeyUsageClientAuth)
+
+	if _, err := s.EnrollConnector(context.Background(), x509PoPRequest(t, m, "c1", m.key)); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	const spiffeID = "spiffe://example.internal/connector/c1"
+	if err := s.Revocations.RevokeSPIFFEID(spiffeID, "lost laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EnrollConnector(context.Background(), x509PoPRequest(t, m, "c1", m.key)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected revoked identity to be refused with machine evidence, got %v", err)
+	}
+	if !s.Revocations.IsSPIFFEIDRevoked(spiffeID) {
+		t.Fatal("expected revocation to survive the refused re-enrollment")
+	}
+
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector"})
+	if _, err := s.EnrollConnector(context.Background(), connectorRequest(t, token, "10.0.0.5", "1.0.0")); err != nil {
+		t.Fatalf("join token enrollment: %v", err)
+	}
+	if s.Revocations.IsSPIFFEIDRevoked(spiffeID) {
+		t.Fatal("expected join token enrollment to lift the revocation")
+	}
+	if _, err := s.EnrollConnector(context.Background(), x509PoPRequest(t, m, "c1", m.key)); err != nil {
+		t.Fatalf("expected machine evidence to work again: %v", err)
+	}
+}
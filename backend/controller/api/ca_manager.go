@@ -0,0 +1,418 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"controller/ca"
+	"controller/state"
+)
+
+// caValidity is the lifetime of a CA generated by Stage.
+const caValidity = 10 * 365 * 24 * time.Hour
+
+var errExternalSigner = errors.New("the CA key is held by INTERNAL_CA_SIGNER_SOCKET; rotate by changing INTERNAL_CA_CERT and the signer's key")
+
+// caRetireDelay is how long the previous CA stays trusted after a new one is
+// activated, so every workload certificate it signed has been renewed. It is
+// used when RetireDelay is unset and matches the default tunneler TTL.
+const caRetireDelay = 30 * time.Minute
+
+// CAInfo describes an internal CA for the admin API.
+type CAInfo struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Subject     string `json:"subject"`
+	Root        string `json:"root,omitempty"`
+	NotAfter    string `json:"not_after"`
+	CreatedAt   string `json:"created_at"`
+	ActivatedAt string `json:"activated_at,omitempty"`
+	RetiredAt   string `json:"retired_at,omitempty"`
+}
+
+// CAManager holds the internal CAs through a rotation: stage a new CA so
+// workloads trust it, activate it so it issues, then retire the old one.
+// Workloads trust the bundle of every CA that is not retired.
+type CAManager struct {
+	db        *sql.DB
+	mu        sync.RWMutex
+	records   []state.CACertificate // oldest first
+	cas       map[string]*ca.CA     // loaded signers by ID
+	sealer    *ca.KeySealer         // encrypts CA keys stored in db; nil if unset
+	listeners []func()
+
+	// ExternalSigner is set when the active CA key is held by an external
+	// signer (INTERNAL_CA_SIGNER_SOCKET). Staging and activating through the
+	// admin API are refused then, since they would put a CA key back in the
+	// controller's database.
+	ExternalSigner bool
+
+	// RetireDelay returns the longest workload certificate TTL, which is
+	// how long RetirePrevious waits after activation.
+	RetireDelay func() time.Duration
+}
+
+// NewCAManager loads the rotation state from db. envCA is the CA configured
+// through INTERNAL_CA_CERT/INTERNAL_CA_KEY; it is the active CA until a
+// rotation activates another one. sealer encrypts the keys of staged CAs in
+// db; without it staging is refused.
+func NewCAManager(db *sql.DB, envCA *ca.CA, envCertPEM []byte, sealer *ca.KeySealer) (*CAManager, error) {
+	records, err := state.LoadCACertificates(db)
+	if err != nil {
+		return nil, err
+	}
+	m := &CAManager{db: db, records: records, cas: make(map[string]*ca.CA), sealer: sealer}
+	envID := caID(envCA.Cert)
+	m.cas[envID] = envCA
+
+	active := m.find(func(r state.CACertificate) bool { return r.Status == state.CAActive })
+	switch {
+	case active == nil:
+		now := time.Now().UTC()
+		rec := state.CACertificate{ID: envID, CertPEM: envCertPEM, Status: state.CAActive, CreatedAt: now, ActivatedAt: now}
+		if err := m.save(rec); err != nil {
+			return nil, err
+		}
+	case active.ID == envID:
+	case len(active.KeyPEM) == 0:
+		// The configured CA was replaced outside a rotation; keep trusting
+		// the old one until it is retired.
+		log.Printf("INTERNAL_CA_CERT changed from %s to %s; keeping %s trusted as previous CA", active.ID, envID, active.ID)
+		prev := *active
+		prev.Status = state.CAPrevious
+		if err := m.save(prev); err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		rec := state.CACertificate{ID: envID, CertPEM: envCertPEM, Status: state.CAActive, CreatedAt: now, ActivatedAt: now}
+		if existing := m.find(func(r state.CACertificate) bool { return r.ID == envID }); existing != nil {
+			rec.CreatedAt = existing.CreatedAt
+		}
+		if err := m.save(rec); err != nil {
+			return nil, err
+		}
+	default:
+		log.Printf("internal CA %s was activated by rotation; INTERNAL_CA_CERT (%s) is not used for issuance", active.ID, envID)
+	}
+
+	for _, rec := range m.records {
+		if rec.Status == state.CARetired || len(rec.KeyPEM) == 0 {
+			continue
+		}
+		keyPEM, err := m.openKey(rec)
+		if err != nil {
+			return nil, fmt.Errorf("internal CA %s: %w", rec.ID, err)
+		}
+		loaded, err := ca.LoadCA(rec.CertPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("internal CA %s: %w", rec.ID, err)
+		}
+		m.cas[rec.ID] = loaded
+	}
+	if m.Issuer() == nil {
+		return nil, errors.New("active internal CA has no private key")
+	}
+	return m, nil
+}
+
+// Issuer returns the CA that signs new certificates.
+func (m *CAManager) Issuer() *ca.CA {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if active := m.find(func(r state.CACertificate) bool { return r.Status == state.CAActive }); active != nil {
+		return m.cas[active.ID]
+	}
+	return nil
+}
+
+// BundlePEM returns the roots workloads should trust: the root of every
+// staged, active and previous CA. Intermediates are not included; workloads
+// present them with their certificates.
+func (m *CAManager) BundlePEM() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []byte
+	for _, rec := range m.records {
+		if rec.Status == state.CARetired {
+			continue
+		}
+		chain, err := ca.ParseChain(rec.CertPEM)
+		if err != nil {
+			continue
+		}
+		root := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[len(chain)-1].Raw})
+		if !bytes.Contains(out, root) {
+			out = append(out, root...)
+		}
+	}
+	return out
+}
+
+// Pool returns the bundle as a cert pool.
+func (m *CAManager) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(m.BundlePEM())
+	return pool
+}
+
+// List returns all CAs, oldest first.
+func (m *CAManager) List() []CAInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]CAInfo, 0, len(m.records))
+	for _, rec := range m.records {
+		info := CAInfo{ID: rec.ID, Status: rec.Status, CreatedAt: rec.CreatedAt.Format(time.RFC3339)}
+		if chain, err := ca.ParseChain(rec.CertPEM); err == nil {
+			info.Subject = chain[0].Subject.String()
+			info.NotAfter = chain[0].NotAfter.UTC().Format(time.RFC3339)
+			if len(chain) > 1 {
+				info.Root = chain[len(chain)-1].Subject.String()
+			}
+		}
+		if !rec.ActivatedAt.IsZero() {
+			info.ActivatedAt = rec.ActivatedAt.Format(time.RFC3339)
+		}
+		if !rec.RetiredAt.IsZero() {
+			info.RetiredAt = rec.RetiredAt.Format(time.RFC3339)
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// OnChange registers fn to run after the bundle or issuer changes.
+func (m *CAManager) OnChange(fn func()) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, fn)
+	m.mu.Unlock()
+}
+
+// Stage adds a new CA to the trust bundle without issuing from it. certPEM
+// may be an intermediate followed by its chain. With empty certPEM/keyPEM a
+// self-signed CA is generated, unless the active CA is an intermediate whose
+// root is kept offline. The key is stored in the database, encrypted by the
+// sealer, so the CA survives restarts.
+func (m *CAManager) Stage(certPEM, keyPEM []byte) (CAInfo, error) {
+	if m.ExternalSigner {
+		return CAInfo{}, errExternalSigner
+	}
+	if m.sealer == nil {
+		return CAInfo{}, errors.New("INTERNAL_CA_KEY_ENCRYPTION_KEY is not set; staged CA keys are only stored encrypted")
+	}
+	if len(certPEM) == 0 && len(keyPEM) == 0 {
+		issuer := m.Issuer()
+		if issuer != nil && len(issuer.Chain) > 0 {
+			return CAInfo{}, errors.New("the active CA is an intermediate; stage a new intermediate signed by the offline root")
+		}
+		cn := "internal-ca"
+		if issuer != nil && issuer.Cert.Subject.CommonName != "" {
+			cn = issuer.Cert.Subject.CommonName
+		}
+		var err error
+		certPEM, keyPEM, err = ca.GenerateSelfSignedCA(cn, caValidity)
+		if err != nil {
+			return CAInfo{}, err
+		}
+	}
+	loaded, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		return CAInfo{}, err
+	}
+	if !loaded.Cert.IsCA || loaded.Cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return CAInfo{}, errors.New("certificate is not a signing CA")
+	}
+	if time.Now().After(loaded.Cert.NotAfter) {
+		return CAInfo{}, errors.New("CA certificate has expired")
+	}
+
+	id := caID(loaded.Cert)
+	sealed, err := m.sealer.Seal(id, keyPEM)
+	if err != nil {
+		return CAInfo{}, err
+	}
+	m.mu.Lock()
+	if m.find(func(r state.CACertificate) bool { return r.Status == state.CAStaged }) != nil {
+		m.mu.Unlock()
+		return CAInfo{}, errors.New("a CA is already staged")
+	}
+	if m.find(func(r state.CACertificate) bool { return r.ID == id }) != nil {
+		m.mu.Unlock()
+		return CAInfo{}, errors.New("CA is already known")
+	}
+	rec := state.CACertificate{ID: id, CertPEM: certPEM, KeyPEM: sealed, Status: state.CAStaged, CreatedAt: time.Now().UTC()}
+	if err := m.saveLocked(rec); err != nil {
+		m.mu.Unlock()
+		return CAInfo{}, err
+	}
+	m.cas[id] = loaded
+	m.mu.Unlock()
+
+	log.Printf("internal CA %s staged", id)
+	m.notify()
+	return m.info(id), nil
+}
+
+// Activate switches issuance to the staged CA. The previously active CA
+// stays in the bundle until RetirePrevious.
+func (m *CAManager) Activate() (CAInfo, error) {
+	if m.ExternalSigner {
+		return CAInfo{}, errExternalSigner
+	}
+	m.mu.Lock()
+	staged := m.find(func(r state.CACertificate) bool { return r.Status == state.CAStaged })
+	if staged == nil {
+		m.mu.Unlock()
+		return CAInfo{}, errors.New("no staged CA")
+	}
+	if m.find(func(r state.CACertificate) bool { return r.Status == state.CAPrevious }) != nil {
+		m.mu.Unlock()
+		return CAInfo{}, errors.New("retire the previous CA before activating another")
+	}
+	now := time.Now().UTC()
+	next := *staged
+	if active := m.find(func(r state.CACertificate) bool { return r.Status == state.CAActive }); active != nil {
+		prev := *active
+		prev.Status = state.CAPrevious
+		if err := m.saveLocked(prev); err != nil {
+			m.mu.Unlock()
+			return CAInfo{}, err
+		}
+	}
+	next.Status = state.CAActive
+	next.ActivatedAt = now
+	if err := m.saveLocked(next); err != nil {
+		m.mu.Unlock()
+		return CAInfo{}, err
+	}
+	m.mu.Unlock()
+
+	log.Printf("internal CA %s activated", next.ID)
+	m.notify()
+	return m.info(next.ID), nil
+}
+
+// RetirePrevious removes the previous CA from the bundle. Unless force is
+// set it refuses while certificates the previous CA signed may still be live.
+func (m *CAManager) RetirePrevious(force bool) (CAInfo, error) {
+	m.mu.Lock()
+	prev := m.find(func(r state.CACertificate) bool { return r.Status == state.CAPrevious })
+	if prev == nil {
+		m.mu.Unlock()
+		return CAInfo{}, errors.New("no previous CA to retire")
+	}
+	if active := m.find(func(r state.CACertificate) bool { return r.Status == state.CAActive }); active != nil && !force {
+		if wait := time.Until(active.ActivatedAt.Add(m.retireDelay())); wait > 0 {
+			m.mu.Unlock()
+			return CAInfo{}, fmt.Errorf("certificates signed by the previous CA may still be live; retry in %s", wait.Round(time.Second))
+		}
+	}
+	rec := *prev
+	rec.Status = state.CARetired
+	rec.RetiredAt = time.Now().UTC()
+	if err := m.saveLocked(rec); err != nil {
+		m.mu.Unlock()
+		return CAInfo{}, err
+	}
+	delete(m.cas, rec.ID)
+	m.mu.Unlock()
+
+	log.Printf("internal CA %s retired", rec.ID)
+	m.notify()
+	return m.info(rec.ID), nil
+}
+
+// openKey returns the plaintext key of rec. Keys stored before an encryption
+// key was configured are sealed in place once one is.
+func (m *CAManager) openKey(rec state.CACertificate) ([]byte, error) {
+	if ca.IsSealedKey(rec.KeyPEM) {
+		if m.sealer == nil {
+			return nil, errors.New("key is encrypted and INTERNAL_CA_KEY_ENCRYPTION_KEY is not set")
+		}
+		return m.sealer.Open(rec.ID, rec.KeyPEM)
+	}
+	if m.sealer == nil {
+		log.Printf("internal CA %s key is stored unencrypted; set INTERNAL_CA_KEY_ENCRYPTION_KEY to encrypt it", rec.ID)
+		return rec.KeyPEM, nil
+	}
+	sealed, err := m.sealer.Seal(rec.ID, rec.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := rec.KeyPEM
+	rec.KeyPEM = sealed
+	if err := m.save(rec); err != nil {
+		return nil, err
+	}
+	log.Printf("internal CA %s key encrypted in the database", rec.ID)
+	return keyPEM, nil
+}
+
+func (m *CAManager) retireDelay() time.Duration {
+	if m.RetireDelay != nil {
+		return m.RetireDelay()
+	}
+	return caRetireDelay
+}
+
+func (m *CAManager) notify() {
+	m.mu.RLock()
+	listeners := append([]func(){}, m.listeners...)
+	m.mu.RUnlock()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+func (m *CAManager) info(id string) CAInfo {
+	for _, info := range m.List() {
+		if info.ID == id {
+			return info
+		}
+	}
+	return CAInfo{ID: id}
+}
+
+// find returns the first matching record. m.mu must be held, except during
+// construction.
+func (m *CAManager) find(match func(state.CACertificate) bool) *state.CACertificate {
+	for i := range m.records {
+		if match(m.records[i]) {
+			return &m.records[i]
+		}
+	}
+	return nil
+}
+
+func (m *CAManager) save(rec state.CACertificate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked(rec)
+}
+
+func (m *CAManager) saveLocked(rec state.CACertificate) error {
+	if err := state.SaveCACertificate(m.db, rec); err != nil {
+		return err
+	}
+	for i := range m.records {
+		if m.records[i].ID == rec.ID {
+			m.records[i] = rec
+			return nil
+		}
+	}
+	m.records = append(m.records, rec)
+	return nil
+}
+
+func caID(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "ca_" + hex.EncodeToString(sum[:8])
+}
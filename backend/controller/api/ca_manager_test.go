@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/ca"
+	"controller/state"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestCA(t *testing.T) (*ca.CA, []byte) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("generate CA: %v", err)
+	}
+	loaded, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load CA: %v", err)
+	}
+	return loaded, certPEM
+}
+
+func storedCAKey(t *testing.T, db *sql.DB, id string) []byte {
+	t.Helper()
+	records, err := state.LoadCACertificates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if rec.ID == id {
+			return rec.KeyPEM
+		}
+	}
+	t.Fatalf("CA %s not stored", id)
+	return nil
+}
+
+func TestCAManagerStagesEncryptedKeys(t *testing.T) {
+	db := openTestDB(t)
+	envCA, envCertPEM := newTestCA(t)
+
+	m, err := NewCAManager(db, envCA, envCertPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stage(nil, nil); err == nil {
+		t.Fatal("expected staging to be refused without an encryption key")
+	}
+
+	sealer, _ := ca.NewKeySealer(bytes.Repeat([]byte{1}, 32))
+	m, err = NewCAManager(db, envCA, envCertPEM, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := m.Stage(nil, nil)
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	if key := storedCAKey(t, db, info.ID); !ca.IsSealedKey(key) || bytes.Contains(key, []byte("PRIVATE KEY")) {
+		t.Fatalf("expected staged key to be stored encrypted, got %q", key)
+	}
+
+	if _, err := NewCAManager(db, envCA, envCertPEM, sealer); err != nil {
+		t.Fatalf("reload with encryption key: %v", err)
+	}
+	if _, err := NewCAManager(db, envCA, envCertPEM, nil); err == nil {
+		t.Fatal("expected reload without the encryption key to fail")
+	}
+}
+
+func TestCAManagerEncryptsLegacyKeys(t *testing.T) {
+	db := openTestDB(t)
+	envCA, envCertPEM := newTestCA(t)
+	if _, err := NewCAManager(db, envCA, envCertPEM, nil); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("legacy", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := ca.LoadCA(certPEM, keyPEM)
+	id := caID(legacy.Cert)
+	if err := state.SaveCACertificate(db, state.CACertificate{ID: id, CertPEM: certPEM, KeyPEM: keyPEM, Status: state.CAStaged, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	sealer, _ := ca.NewKeySealer(bytes.Repeat([]byte{2}, 32))
+	if _, err := NewCAManager(db, envCA, envCertPEM, sealer); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if key := storedCAKey(t, db, id); !ca.IsSealedKey(key) {
+		t.Fatalf("expected plaintext key to be encrypted on load, got %q", key)
+	}
+}
+
+func TestCAManagerRefusesRotationWithExternalSigner(t *testing.T) {
+	db := openTestDB(t)
+	envCA, envCertPEM := newTestCA(t)
+	sealer, _ := ca.NewKeySealer(bytes.Repeat([]byte{3}, 32))
+	m, err := NewCAManager(db, envCA, envCertPEM, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ExternalSigner = true
+	if _, err := m.Stage(nil, nil); err == nil {
+		t.Fatal("expected staging to be refused with an external signer")
+	}
+	if _, err := m.Activate(); err == nil {
+		t.Fatal("expected activation to be refused with an external signer")
+	}
+	if records, _ := state.LoadCACertificates(db); len(records) != 1 {
+		t.Fatalf("expected only the configured CA to be stored, got %d", len(records))
+	}
+}
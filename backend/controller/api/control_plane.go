@@ -15,8 +15,6 @@ import (
 	"controller/state"
 
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -28,14 +26,16 @@ type ControlPlaneServer struct {
 	tunnelerStatus *state.TunnelerStatusRegistry
 	acls           *state.ACLStore
 	db             *sql.DB
-	signingKey     []byte
+	signer         *PolicySigner
+	revocations    *state.RevocationStore
+	cas            *CAManager
 	snapshotTTL    time.Duration
 	mu             sync.Mutex
 	clients        map[string]*connectorClient
 }
 
 // NewControlPlaneServer creates a new control plane server.
-func NewControlPlaneServer(trustDomain string, registry *state.Registry, tunnelers *state.TunnelerRegistry, tunnelerStatus *state.TunnelerStatusRegistry, acls *state.ACLStore, db *sql.DB, signingKey []byte, snapshotTTL time.Duration) *ControlPlaneServer {
+func NewControlPlaneServer(trustDomain string, registry *state.Registry, tunnelers *state.TunnelerRegistry, tunnelerStatus *state.TunnelerStatusRegistry, acls *state.ACLStore, db *sql.DB, signer *PolicySigner, revocations *state.RevocationStore, cas *CAManager, snapshotTTL time.Duration) *ControlPlaneServer {
 	_ = trustDomain
 	return &ControlPlaneServer{
 		registry:       registry,
@@ -43,7 +43,9 @@ func NewControlPlaneServer(trustDomain string, registry *state.Registry, tunnele
 		tunnelerStatus: tunnelerStatus,
 		acls:           acls,
 		db:             db,
-		signingKey:     signingKey,
+		signer:         signer,
+		revocations:    revocations,
+		cas:            cas,
 		snapshotTTL:    snapshotTTL,
 		clients:        make(map[string]*connectorClient),
 	}
@@ -59,27 +61,51 @@ func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 	spiffeID, _ := SPIFFEIDFromContext(stream.Context())
 	log.Printf("control-plane stream connected: %s", spiffeID)
 	connectorID := parseConnectorID(spiffeID)
+	// DisconnectConnector cancels ctx with the status the stream ends with.
+	ctx, cancel := context.WithCancelCause(stream.Context())
+	defer cancel(nil)
 	client := &connectorClient{
 		stream:      stream,
 		connectorID: connectorID,
-		signingKey:  derivePolicyKey(stream.Context(), connectorID),
+		cancel:      cancel,
 	}
 	s.logConnectorEvent(connectorID, "control-plane stream connected")
-	if len(client.signingKey) == 0 {
-		log.Printf("policy key derivation failed for connector %s", connectorID)
-	}
 	s.addClient(spiffeID, client)
-	defer s.removeClient(spiffeID)
+	defer s.removeClient(spiffeID, client)
+	s.sendCABundle(client)
 	s.sendAllowlist(client)
+	s.sendRevocations(client)
+	s.sendPolicyKeys(client)
 	s.sendPolicySnapshot(client)
 
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+	msgs := make(chan *controllerpb.ControlMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if err != nil {
+	}()
+
+	for {
+		var msg *controllerpb.ControlMessage
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
 			return err
+		case msg = <-msgs:
 		}
 
 		if msg.GetType() == "ping" {
@@ -87,6 +113,31 @@ func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 				return err
 			}
 		}
+		if msg.GetType() == "snapshot_request" {
+			var req struct {
+				Reason string `json:"reason"`
+			}
+			_ = json.Unmarshal(msg.GetPayload(), &req)
+			log.Printf("policy snapshot requested by connector %s: reason=%s", connectorID, req.Reason)
+			s.sendPolicySnapshot(client)
+		}
+		if msg.GetType() == "snapshot_ack" {
+			var ack struct {
+				PolicyVersion int    `json:"policy_version"`
+				PolicyHash    string `json:"policy_hash"`
+				Status        string `json:"status"`
+				Reason        string `json:"reason"`
+			}
+			if err := json.Unmarshal(msg.GetPayload(), &ack); err == nil {
+				if err := state.RecordSnapshotAck(s.db, connectorID, ack.PolicyVersion, ack.PolicyHash, ack.Status, ack.Reason, time.Now()); err != nil {
+					log.Printf("failed to record snapshot ack for %s: %v", connectorID, err)
+				}
+				if ack.Status != state.SnapshotAckApplied {
+					client.resetPolicyBase()
+					s.logConnectorEvent(connectorID, fmt.Sprintf("policy snapshot rejected: version=%d reason=%s", ack.PolicyVersion, ack.Reason))
+				}
+			}
+		}
 		if msg.GetType() == "heartbeat" {
 			if s.registry != nil {
 				s.registry.RecordHeartbeat(msg.GetConnectorId(), msg.GetPrivateIp())
@@ -114,6 +165,15 @@ func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 				}
 			}
 		}
+		if msg.GetType() == "audit_dropped" {
+			var payload struct {
+				Count uint64 `json:"count"`
+			}
+			if err := json.Unmarshal(msg.GetPayload(), &payload); err == nil && payload.Count > 0 {
+				log.Printf("connector %s dropped %d acl decision records", connectorID, payload.Count)
+				s.logConnectorEvent(connectorID, fmt.Sprintf("audit queue overflow: %d acl decision records dropped", payload.Count))
+			}
+		}
 		if msg.GetType() == "acl_decision" {
 			log.Printf("acl decision: %s", string(msg.GetPayload()))
 			if s.acls != nil && s.acls.DB() != nil {
@@ -165,11 +225,51 @@ func (s *ControlPlaneServer) NotifyTunnelerAllowed(tunnelerID, spiffeID string)
 	})
 }
 
+// NotifyTunnelerRevoked removes a tunneler from the allowlist and tells every
+// connector to drop it and end its open streams.
+func (s *ControlPlaneServer) NotifyTunnelerRevoked(tunnelerID, spiffeID string) {
+	if s.tunnelers != nil {
+		if info, ok := s.tunnelers.Remove(tunnelerID); ok && spiffeID == "" {
+			spiffeID = info.SPIFFEID
+		}
+	}
+	info := state.TunnelerInfo{ID: tunnelerID, SPIFFEID: spiffeID}
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	s.broadcast(&controllerpb.ControlMessage{
+		Type:    "tunneler_revoke",
+		Payload: payload,
+	})
+}
+
 type connectorClient struct {
 	stream      controllerpb.ControlPlane_ConnectServer
 	sendMu      sync.Mutex
 	connectorID string
-	signingKey  []byte
+	cancel      context.CancelCauseFunc
+	// policyMu serializes policy sends so reading the base, sending the
+	// snapshot or delta and recording the new base happen as one step; a
+	// refresh tick and a policy change cannot both build on the same base.
+	// It is taken before sendMu.
+	policyMu sync.Mutex
+	// Policy last pushed on this stream, used as the base for deltas and
+	// refresh scheduling; guarded by sendMu. policyResources is nil until a
+	// full snapshot has been sent.
+	validUntil      time.Time
+	policyVersion   int
+	policyResources []PolicyResource
+	policyGrace     int
+}
+
+func (c *connectorClient) resetPolicyBase() {
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	c.sendMu.Lock()
+	c.policyVersion = 0
+	c.policyResources = nil
+	c.sendMu.Unlock()
 }
 
 func (s *ControlPlaneServer) addClient(id string, c *connectorClient) {
@@ -178,21 +278,28 @@ func (s *ControlPlaneServer) addClient(id string, c *connectorClient) {
 	s.clients[id] = c
 }
 
-func (s *ControlPlaneServer) removeClient(id string) {
+// removeClient forgets c unless a newer stream from the same connector has
+// already replaced it.
+func (s *ControlPlaneServer) removeClient(id string, c *connectorClient) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.clients, id)
+	if s.clients[id] == c {
+		delete(s.clients, id)
+	}
 }
 
-func (s *ControlPlaneServer) broadcast(msg *controllerpb.ControlMessage) {
+func (s *ControlPlaneServer) listClients() []*connectorClient {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	clients := make([]*connectorClient, 0, len(s.clients))
 	for _, c := range s.clients {
 		clients = append(clients, c)
 	}
-	s.mu.Unlock()
+	return clients
+}
 
-	for _, c := range clients {
+func (s *ControlPlaneServer) broadcast(msg *controllerpb.ControlMessage) {
+	for _, c := range s.listClients() {
 		c.sendMu.Lock()
 		_ = c.stream.Send(msg)
 		c.sendMu.Unlock()
@@ -216,6 +323,68 @@ func (s *ControlPlaneServer) sendAllowlist(c *connectorClient) {
 	c.sendMu.Unlock()
 }
 
+// NotifyCABundle pushes the internal CA trust bundle to every connector,
+// which relays it to its tunnelers.
+func (s *ControlPlaneServer) NotifyCABundle() {
+	for _, c := range s.listClients() {
+		s.sendCABundle(c)
+	}
+}
+
+func (s *ControlPlaneServer) sendCABundle(c *connectorClient) {
+	if c == nil || s.cas == nil {
+		return
+	}
+	payload, err := json.Marshal(struct {
+		BundlePEM string `json:"bundle_pem"`
+	}{BundlePEM: string(s.cas.BundlePEM())})
+	if err != nil {
+		return
+	}
+	c.sendMu.Lock()
+	_ = c.stream.Send(&controllerpb.ControlMessage{
+		Type:    "ca_bundle",
+		Payload: payload,
+	})
+	c.sendMu.Unlock()
+}
+
+// NotifyRevocations pushes the current revocation list to every connector so
+// they stop accepting revoked tunnelers.
+func (s *ControlPlaneServer) NotifyRevocations() {
+	for _, c := range s.listClients() {
+		s.sendRevocations(c)
+	}
+}
+
+func (s *ControlPlaneServer) sendRevocations(c *connectorClient) {
+	if c == nil || s.revocations == nil {
+		return
+	}
+	list := struct {
+		Serials   []string `json:"serials"`
+		SPIFFEIDs []string `json:"spiffe_ids"`
+	}{Serials: []string{}, SPIFFEIDs: []string{}}
+	for _, rev := range s.revocations.List() {
+		switch rev.Kind {
+		case state.RevokeSerial:
+			list.Serials = append(list.Serials, rev.Value)
+		case state.RevokeSPIFFEID:
+			list.SPIFFEIDs = append(list.SPIFFEIDs, rev.Value)
+		}
+	}
+	payload, err := json.Marshal(list)
+	if err != nil {
+		return
+	}
+	c.sendMu.Lock()
+	_ = c.stream.Send(&controllerpb.ControlMessage{
+		Type:    "revocation_list",
+		Payload: payload,
+	})
+	c.sendMu.Unlock()
+}
+
 // ACL notifications
 func (s *ControlPlaneServer) NotifyACLInit() {
 	s.broadcastPolicySnapshots()
@@ -245,41 +414,142 @@ func (s *ControlPlaneServer) broadcastPolicySnapshots() {
 	if s.db == nil {
 		return
 	}
-	s.mu.Lock()
-	clients := make([]*connectorClient, 0, len(s.clients))
-	for _, c := range s.clients {
-		clients = append(clients, c)
+	for _, c := range s.listClients() {
+		s.sendPolicyUpdate(c, false)
 	}
-	s.mu.Unlock()
+}
 
-	for _, c := range clients {
-		s.sendPolicySnapshot(c)
+// RunSnapshotRefresh re-signs and pushes a fresh snapshot to every connected
+// connector once half of the snapshot TTL has elapsed, so connectors never
+// reach valid_until while the policy is unchanged.
+func (s *ControlPlaneServer) RunSnapshotRefresh(ctx context.Context) {
+	if s.db == nil || s.snapshotTTL <= 0 {
+		return
+	}
+	interval := s.snapshotTTL / 10
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.refreshSnapshots(now)
+		}
+	}
+}
+
+func (s *ControlPlaneServer) refreshSnapshots(now time.Time) {
+	for _, c := range s.listClients() {
+		c.sendMu.Lock()
+		due := c.validUntil.Sub(now) <= s.snapshotTTL/2
+		c.sendMu.Unlock()
+		if due {
+			s.sendPolicyUpdate(c, true)
+		}
 	}
 }
 
 func (s *ControlPlaneServer) sendPolicySnapshot(c *connectorClient) {
-	if s.db == nil || c == nil || c.connectorID == "" {
+	if c == nil {
 		return
 	}
-	if len(c.signingKey) == 0 {
-		log.Printf("skipping policy snapshot for %s: no derived policy key", c.connectorID)
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	snap, ok := s.compileSnapshot(c)
+	if !ok {
+		return
+	}
+	s.pushSnapshot(c, snap)
+}
+
+// sendPolicyUpdate pushes the connector's current policy as a delta against
+// the policy last sent on its stream. It falls back to a full snapshot when
+// there is no base or the delta would not be smaller. Unchanged policy is
+// skipped unless refresh is set, in which case an empty delta extends
+// valid_until.
+func (s *ControlPlaneServer) sendPolicyUpdate(c *connectorClient, refresh bool) {
+	if c == nil {
 		return
 	}
-	snap, err := CompilePolicySnapshot(s.db, c.connectorID, s.snapshotTTL, c.signingKey)
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	c.sendMu.Lock()
+	baseVersion, base, baseGrace := c.policyVersion, c.policyResources, c.policyGrace
+	c.sendMu.Unlock()
+	snap, ok := s.compileSnapshot(c)
+	if !ok {
+		return
+	}
+	if base == nil {
+		s.pushSnapshot(c, snap)
+		return
+	}
+	if !refresh && policyHash(snap.Resources) == policyHash(base) && snap.SnapshotMeta.SurvivabilityGraceSeconds == baseGrace {
+		return
+	}
+	delta, err := BuildPolicyDelta(baseVersion, base, snap, s.signer)
+	if err != nil || (delta.Size() > 0 && delta.Size() >= len(snap.Resources)) {
+		s.pushSnapshot(c, snap)
+		return
+	}
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return
+	}
+	validUntil, _ := time.Parse(time.RFC3339, snap.SnapshotMeta.ValidUntil)
+	c.sendMu.Lock()
+	if err := c.stream.Send(&controllerpb.ControlMessage{
+		Type:    "policy_delta",
+		Payload: payload,
+	}); err == nil {
+		c.validUntil = validUntil
+		c.policyVersion = snap.SnapshotMeta.PolicyVersion
+		c.policyResources = snap.Resources
+		c.policyGrace = snap.SnapshotMeta.SurvivabilityGraceSeconds
+	}
+	c.sendMu.Unlock()
+	if delta.Size() > 0 {
+		s.logConnectorEvent(c.connectorID, fmt.Sprintf("policy delta pushed: base=%d version=%d changes=%d", baseVersion, snap.SnapshotMeta.PolicyVersion, delta.Size()))
+	}
+}
+
+func (s *ControlPlaneServer) compileSnapshot(c *connectorClient) (PolicySnapshot, bool) {
+	if s.db == nil || c == nil || c.connectorID == "" {
+		return PolicySnapshot{}, false
+	}
+	snap, err := CompilePolicySnapshot(s.db, c.connectorID, s.snapshotTTL, s.signer)
 	if err != nil {
 		log.Printf("failed to compile snapshot for %s: %v", c.connectorID, err)
 		s.logConnectorEvent(c.connectorID, fmt.Sprintf("policy snapshot failed: %v", err))
-		return
+		return PolicySnapshot{}, false
 	}
+	return snap, true
+}
+
+// pushSnapshot sends snap as a full snapshot. The caller holds c.policyMu.
+func (s *ControlPlaneServer) pushSnapshot(c *connectorClient, snap PolicySnapshot) {
 	payload, err := json.Marshal(snap)
 	if err != nil {
 		return
 	}
+	validUntil, _ := time.Parse(time.RFC3339, snap.SnapshotMeta.ValidUntil)
 	c.sendMu.Lock()
-	_ = c.stream.Send(&controllerpb.ControlMessage{
+	if err := c.stream.Send(&controllerpb.ControlMessage{
 		Type:    "policy_snapshot",
 		Payload: payload,
-	})
+	}); err == nil {
+		c.validUntil = validUntil
+		c.policyVersion = snap.SnapshotMeta.PolicyVersion
+		c.policyResources = snap.Resources
+		c.policyGrace = snap.SnapshotMeta.SurvivabilityGraceSeconds
+	}
 	c.sendMu.Unlock()
 	s.logConnectorEvent(c.connectorID, fmt.Sprintf("policy snapshot pushed: version=%d resources=%d", snap.SnapshotMeta.PolicyVersion, len(snap.Resources)))
 }
@@ -313,25 +583,45 @@ func parseConnectorID(spiffeID string) string {
 	return parts[2]
 }
 
-const policyKeyLabel = "ztna-policy-signing-v1"
+// PolicyKeys returns the published policy verification keys.
+func (s *ControlPlaneServer) PolicyKeys() []PolicyPublicKey {
+	return s.signer.PublicKeys()
+}
 
-func derivePolicyKey(ctx context.Context, connectorID string) []byte {
-	if connectorID == "" {
-		return nil
+// RotatePolicyKey activates a new policy signing key, pushes the updated key
+// set to connected connectors and re-signs their policy with the new key.
+func (s *ControlPlaneServer) RotatePolicyKey() (PolicyPublicKey, error) {
+	if s.signer == nil {
+		return PolicyPublicKey{}, fmt.Errorf("policy signer not configured")
 	}
-	p, ok := peer.FromContext(ctx)
-	if !ok || p.AuthInfo == nil {
-		return nil
+	key, err := s.signer.Rotate()
+	if err != nil {
+		return PolicyPublicKey{}, err
 	}
-	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
-	if !ok {
-		return nil
+	log.Printf("policy signing key rotated: %s", key.KeyID)
+	for _, c := range s.listClients() {
+		s.sendPolicyKeys(c)
+		s.sendPolicyUpdate(c, true)
 	}
-	key, err := tlsInfo.State.ExportKeyingMaterial(policyKeyLabel, []byte(connectorID), 32)
+	return key, nil
+}
+
+func (s *ControlPlaneServer) sendPolicyKeys(c *connectorClient) {
+	if c == nil || s.signer == nil {
+		return
+	}
+	payload, err := json.Marshal(struct {
+		Keys []PolicyPublicKey `json:"keys"`
+	}{Keys: s.signer.PublicKeys()})
 	if err != nil {
-		return nil
+		return
 	}
-	return key
+	c.sendMu.Lock()
+	_ = c.stream.Send(&controllerpb.ControlMessage{
+		Type:    "policy_keys",
+		Payload: payload,
+	})
+	c.sendMu.Unlock()
 }
 
 func (s *ControlPlaneServer) logConnectorEvent(connectorID, msg string) {
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+)
+
+// recordingConnectStream records messages sent to a connector.
+type recordingConnectStream struct {
+	grpc.BidiStreamingServer[controllerpb.ControlMessage, controllerpb.ControlMessage]
+	mu   sync.Mutex
+	sent []*controllerpb.ControlMessage
+}
+
+func (r *recordingConnectStream) Send(msg *controllerpb.ControlMessage) error {
+	// Widen the window between reading the base and recording the new one.
+	time.Sleep(time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func (r *recordingConnectStream) Context() context.Context { return context.Background() }
+
+func TestPolicyDeltasChainUnderConcurrentSends(t *testing.T) {
+	db := openTestDB(t)
+	signer, err := NewPolicySigner(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO connectors (id, last_seen, remote_network_id) VALUES ('c1', 0, 'n1')`); err != nil {
+		t.Fatal(err)
+	}
+	addResource := func(i int) {
+		if _, err := db.Exec(`INSERT INTO resources (id, type, address, protocol, port_from, port_to, remote_network_id) VALUES (?, 'dns', ?, 'TCP', 443, 443, 'n1')`,
+			fmt.Sprintf("res_%02d", i), fmt.Sprintf("app%d.internal", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	addResource(0)
+
+	s := NewControlPlaneServer("example.internal", nil, nil, nil, nil, db, signer, nil, nil, time.Hour)
+	stream := &recordingConnectStream{}
+	c := &connectorClient{stream: stream, connectorID: "c1"}
+	s.sendPolicySnapshot(c)
+
+	// Each round changes the policy and races an update against a refresh
+	// tick.
+	for i := 1; i <= 10; i++ {
+		addResource(i)
+		var wg sync.WaitGroup
+		for _, refresh := range []bool{false, true} {
+			wg.Add(1)
+			go func(refresh bool) {
+				defer wg.Done()
+				s.sendPolicyUpdate(c, refresh)
+			}(refresh)
+		}
+		wg.Wait()
+	}
+
+	version := 0
+	for i, msg := range stream.sent {
+		switch msg.GetType() {
+		case "policy_snapshot":
+			var snap PolicySnapshot
+			if err := json.Unmarshal(msg.GetPayload(), &snap); err != nil {
+				t.Fatal(err)
+			}
+			version = snap.SnapshotMeta.PolicyVersion
+		case "policy_delta":
+			var delta PolicyDelta
+			if err := json.Unmarshal(msg.GetPayload(), &delta); err != nil {
+				t.Fatal(err)
+			}
+			if delta.DeltaMeta.BaseVersion != version {
+				t.Fatalf("message %d: delta base %d does not match last sent version %d", i, delta.DeltaMeta.BaseVersion, version)
+			}
+			version = delta.DeltaMeta.PolicyVersion
+		}
+	}
+	if version == 0 {
+		t.Fatal("expected policy to be sent")
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// minRSABits is the smallest RSA workload key accepted in a CSR.
+const minRSABits = 2048
+
+// workloadKey returns the public key to certify for req and how possession of
+// it was proven. A CSR must be signed by its key and may only request
+// spiffeID and allowedIPs as SANs; the certificate's SANs are still chosen by
+// the controller. A bare public_key is accepted, with a warning, unless
+// RequireCSR is set.
+func (s *EnrollmentServer) workloadKey(scope string, req *controllerpb.EnrollRequest, spiffeID string, allowedIPs []net.IP) (crypto.PublicKey, string, error) {
+	if csrPEM := req.GetCsr(); len(csrPEM) > 0 {
+		pub, err := verifyCSR(csrPEM, spiffeID, allowedIPs)
+		if err != nil {
+			return nil, "", status.Errorf(codes.InvalidArgument, "invalid csr: %v", err)
+		}
+		// Clients send both during the deprecation window; they must agree.
+		if len(req.GetPublicKey()) > 0 {
+			raw, err := parsePublicKey(req.GetPublicKey())
+			if err != nil {
+				return nil, "", status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+			}
+			if k, ok := raw.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(pub) {
+				return nil, "", status.Error(codes.InvalidArgument, "public key does not match csr")
+			}
+		}
+		der, _ := x509.MarshalPKIXPublicKey(pub)
+		logPublicKey(scope, pub, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		return pub, state.KeyProofCSR, nil
+	}
+
+	if s.RequireCSR {
+		return nil, "", status.Error(codes.InvalidArgument, "csr is required")
+	}
+	pub, err := parsePublicKey(req.GetPublicKey())
+	if err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+	}
+	logPublicKey(scope, pub, req.GetPublicKey())
+	log.Printf("%s: %s sent a bare public key without a csr; this is deprecated", scope, spiffeID)
+	return pub, state.KeyProofNone, nil
+}
+
+// verifyCSR checks the CSR's self-signature, key, common name and requested
+// SANs and returns its public key. A common name is optional but must be the
+// SPIFFE ID or the workload ID.
+func verifyCSR(csrPEM []byte, spiffeID string, allowedIPs []net.IP) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to decode CERTIFICATE REQUEST PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if k, ok := csr.PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+	}
+
+	if cn := csr.Subject.CommonName; cn != "" && cn != spiffeID && cn != path.Base(spiffeID) {
+		return nil, fmt.Errorf("common name %q does not match %s", cn, spiffeID)
+	}
+	if len(csr.DNSNames) > 0 || len(csr.EmailAddresses) > 0 {
+		return nil, errors.New("DNS and email SANs are not allowed")
+	}
+	for _, uri := range csr.URIs {
+		if uri.String() != spiffeID {
+			return nil, fmt.Errorf("URI SAN %q is not allowed", uri)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		allowed := false
+		for _, a := range allowedIPs {
+			if a.Equal(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("IP SAN %s is not allowed", ip)
+		}
+	}
+	return csr.PublicKey, nil
+}
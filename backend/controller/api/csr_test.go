@@ -0,0 +1,157 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"testing"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testSPIFFEID = "spiffe://example.internal/connector/c1"
+
+func createTestCSR(t *testing.T, key crypto.Signer, tmpl *x509.CertificateRequest) []byte {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		t.Fatalf("create csr: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func spiffeCSRTemplate(t *testing.T, id string) *x509.CertificateRequest {
+	t.Helper()
+	uri, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509.CertificateRequest{URIs: []*url.URL{uri}}
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func publicKeyPEM(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestVerifyCSRAcceptsValidRequests(t *testing.T) {
+	ecKey := newECKey(t)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	withIP := spiffeCSRTemplate(t, testSPIFFEID)
+	withIP.IPAddresses = []net.IP{net.ParseIP("10.0.0.5")}
+	withCN := spiffeCSRTemplate(t, testSPIFFEID)
+	withCN.Subject = pkix.Name{CommonName: "c1"}
+
+	tests := map[string][]byte{
+		"ecdsa":      createTestCSR(t, ecKey, spiffeCSRTemplate(t, testSPIFFEID)),
+		"ed25519":    createTestCSR(t, edKey, spiffeCSRTemplate(t, testSPIFFEID)),
+		"allowed ip": createTestCSR(t, ecKey, withIP),
+		"id as cn":   createTestCSR(t, ecKey, withCN),
+		"no sans":    createTestCSR(t, ecKey, &x509.CertificateRequest{}),
+	}
+	for name, csrPEM := range tests {
+		pub, err := verifyCSR(csrPEM, testSPIFFEID, []net.IP{net.ParseIP("10.0.0.5")})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if pub == nil {
+			t.Errorf("%s: expected public key", name)
+		}
+	}
+}
+
+func TestVerifyCSRRejects(t *testing.T) {
+	ecKey := newECKey(t)
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherURI := spiffeCSRTemplate(t, "spiffe://example.internal/connector/c2")
+	otherDomain := spiffeCSRTemplate(t, "spiffe://other.internal/connector/c1")
+	otherCN := spiffeCSRTemplate(t, testSPIFFEID)
+	otherCN.Subject = pkix.Name{CommonName: "c2"}
+	dnsSAN := spiffeCSRTemplate(t, testSPIFFEID)
+	dnsSAN.DNSNames = []string{"evil.example.com"}
+	otherIP := spiffeCSRTemplate(t, testSPIFFEID)
+	otherIP.IPAddresses = []net.IP{net.ParseIP("10.0.0.6")}
+
+	badSig := createTestCSR(t, ecKey, spiffeCSRTemplate(t, testSPIFFEID))
+	block, _ := pem.Decode(badSig)
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	badSig = pem.EncodeToMemory(block)
+
+	tests := map[string][]byte{
+		"bad signature":  badSig,
+		"other uri san":  createTestCSR(t, ecKey, otherURI),
+		"other domain":   createTestCSR(t, ecKey, otherDomain),
+		"cn mismatch":    createTestCSR(t, ecKey, otherCN),
+		"dns san":        createTestCSR(t, ecKey, dnsSAN),
+		"ip not allowed": createTestCSR(t, ecKey, otherIP),
+		"weak rsa key":   createTestCSR(t, weakRSA, spiffeCSRTemplate(t, testSPIFFEID)),
+		"not a csr":      publicKeyPEM(t, &ecKey.PublicKey),
+		"not pem":        []byte("garbage"),
+	}
+	for name, csrPEM := range tests {
+		if _, err := verifyCSR(csrPEM, testSPIFFEID, []net.IP{net.ParseIP("10.0.0.5")}); err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+	}
+}
+
+func TestWorkloadKey(t *testing.T) {
+	key := newECKey(t)
+	other := newECKey(t)
+	csrPEM := createTestCSR(t, key, spiffeCSRTemplate(t, testSPIFFEID))
+	s := &EnrollmentServer{}
+
+	pub, proof, err := s.workloadKey("test", &controllerpb.EnrollRequest{Csr: csrPEM}, testSPIFFEID, nil)
+	if err != nil || proof != state.KeyProofCSR || !key.PublicKey.Equal(pub) {
+		t.Fatalf("csr: expected csr key proof, got %v %q %v", pub, proof, err)
+	}
+	if _, _, err := s.workloadKey("test", &controllerpb.EnrollRequest{Csr: csrPEM, PublicKey: publicKeyPEM(t, &key.PublicKey)}, testSPIFFEID, nil); err != nil {
+		t.Fatalf("csr with matching public key: %v", err)
+	}
+	_, _, err = s.workloadKey("test", &controllerpb.EnrollRequest{Csr: csrPEM, PublicKey: publicKeyPEM(t, &other.PublicKey)}, testSPIFFEID, nil)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("csr with other public key: expected InvalidArgument, got %v", err)
+	}
+
+	bare := &controllerpb.EnrollRequest{PublicKey: publicKeyPEM(t, &key.PublicKey)}
+	if _, proof, err := s.workloadKey("test", bare, testSPIFFEID, nil); err != nil || proof != state.KeyProofNone {
+		t.Fatalf("bare key: expected key proof none, got %q %v", proof, err)
+	}
+	s.RequireCSR = true
+	if _, _, err := s.workloadKey("test", bare, testSPIFFEID, nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("bare key with RequireCSR: expected InvalidArgument, got %v", err)
+	}
+	if _, _, err := s.workloadKey("test", &controllerpb.EnrollRequest{Csr: csrPEM}, testSPIFFEID, nil); err != nil {
+		t.Fatalf("csr with RequireCSR: %v", err)
+	}
+}
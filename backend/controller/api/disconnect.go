@@ -0,0 +1,47 @@
+package api
+
+import (
+	"log"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons the controller ends or refuses a connector's streams, sent as the
+// ErrorInfo reason of a PermissionDenied status.
+const (
+	DisconnectDeleted  = "CONNECTOR_DELETED"
+	DisconnectDisabled = "CONNECTOR_DISABLED"
+)
+
+// disconnectErrorDomain is the ErrorInfo domain of disconnect reasons.
+const disconnectErrorDomain = "controller.ztna"
+
+func disconnectError(reason, msg string) error {
+	st := status.New(codes.PermissionDenied, msg)
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: disconnectErrorDomain}); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// DisconnectConnector ends every control-plane stream of connector id with
+// reason and returns how many were open. It does not stop the connector from
+// reconnecting; disable or revoke it for that.
+func (s *ControlPlaneServer) DisconnectConnector(id, reason string) int {
+	err := disconnectError(reason, "disconnected by controller: "+reason)
+	n := 0
+	for _, c := range s.listClients() {
+		if c.connectorID != id || c.cancel == nil {
+			continue
+		}
+		c.cancel(err)
+		n++
+	}
+	if n > 0 {
+		log.Printf("disconnected connector %s: %s (%d streams)", id, reason, n)
+		s.logConnectorEvent(id, "control-plane stream closed by controller: "+reason)
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"controller/state"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// disconnectReason returns the ErrorInfo reason of a PermissionDenied err.
+func disconnectReason(t *testing.T, err error) string {
+	t.Helper()
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == disconnectErrorDomain {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func TestDisconnectConnector(t *testing.T) {
+	s := NewControlPlaneServer("example.internal", state.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, 0)
+	streams := map[string]context.Context{}
+	for _, spiffeID := range []string{"spiffe://example.internal/connector/c1", "spiffe://example.internal/connector/c2"} {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		t.Cleanup(func() { cancel(nil) })
+		streams[spiffeID] = ctx
+		s.addClient(spiffeID, &connectorClient{connectorID: parseConnectorID(spiffeID), cancel: cancel})
+	}
+
+	if n := s.DisconnectConnector("c1", DisconnectDisabled); n != 1 {
+		t.Fatalf("expected one stream closed, got %d", n)
+	}
+	if reason := disconnectReason(t, context.Cause(streams["spiffe://example.internal/connector/c1"])); reason != DisconnectDisabled {
+		t.Fatalf("expected %s, got %q", DisconnectDisabled, reason)
+	}
+	if err := streams["spiffe://example.internal/connector/c2"].Err(); err != nil {
+		t.Fatalf("expected c2 to stay connected, got %v", err)
+	}
+	if n := s.DisconnectConnector("c3", DisconnectDeleted); n != 0 {
+		t.Fatalf("expected no streams for an unknown connector, got %d", n)
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	reg := state.NewRegistry()
+	reg.SetDisabled("c1", true)
+
+	if err := checkDisabled(reg, "connector", "spiffe://example.internal/connector/c1"); disconnectReason(t, err) != DisconnectDisabled {
+		t.Fatalf("expected disabled connector to be refused with %s, got %v", DisconnectDisabled, err)
+	}
+	if err := checkDisabled(reg, "connector", "spiffe://example.internal/connector/c2"); err != nil {
+		t.Fatalf("expected enabled connector to pass, got %v", err)
+	}
+	if err := checkDisabled(reg, "tunneler", "spiffe://example.internal/tunneler/c1"); err != nil {
+		t.Fatalf("expected tunnelers to be ignored, got %v", err)
+	}
+	if err := checkDisabled(nil, "connector", "spiffe://example.internal/connector/c1"); err != nil {
+		t.Fatalf("expected no checker to pass, got %v", err)
+	}
+
+	reg.SetDisabled("c1", false)
+	if err := checkDisabled(reg, "connector", "spiffe://example.internal/connector/c1"); err != nil {
+		t.Fatalf("expected re-enabled connector to pass, got %v", err)
+	}
+}
+
+func TestEnrollRefusesDisabledConnectorBeforeSpendingToken(t *testing.T) {
+	s := newTestEnrollmentServer(t)
+	s.Registry.SetDisabled("c1", true)
+	token, _, _ := s.Tokens.CreateToken(state.TokenOptions{Role: "connector"})
+
+	_, err := s.EnrollConnector(context.Background(), connectorRequest(t, token, "10.0.0.5", "1.0.0"))
+	if reason := disconnectReason(t, err); reason != DisconnectDisabled {
+		t.Fatalf("expected %s, got %q", DisconnectDisabled, reason)
+	}
+	rec, err := s.Tokens.GetToken(state.TokenID(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.State(time.Now()) != state.TokenActive {
+		t.Fatalf("expected token to stay active, got %s", rec.State(time.Now()))
+	}
+
+	s.Registry.SetDisabled("c1", false)
+	if _, err := s.EnrollConnector(context.Background(), connectorRequest(t, token, "10.0.0.5", "1.0.0")); err != nil {
+		t.Fatalf("expected enabled connector to enroll: %v", err)
+	}
+}
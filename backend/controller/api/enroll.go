@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"database/sql"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
@@ -28,12 +29,28 @@ import (
 type EnrollmentServer struct {
 	controllerpb.UnimplementedEnrollmentServiceServer
 
-	CA          *ca.CA
-	CAPEM       []byte
+	// CAs issues certificates and supplies the trust bundle returned with
+	// them.
+	CAs         *CAManager
 	TrustDomain string
 	Tokens      *state.TokenStore
 	Registry    *state.Registry
 	Notifier    TunnelerNotifier
+	// Policy verification keys are handed to connectors with their cert.
+	PolicySigner *PolicySigner
+	// Issued certificates are recorded in the inventory in DB.
+	DB          *sql.DB
+	Revocations *state.RevocationStore
+	// TTLs decides the lifetime of issued certificates.
+	TTLs *TTLPolicy
+	// RequireCSR rejects requests that send a bare public key instead of a
+	// CSR.
+	RequireCSR bool
+	// RemoteNetworks receives connectors whose attestation names a remote
+	// network.
+	RemoteNetworks *state.RemoteNetworkStore
+	// Attestors verify enrollment evidence, keyed by attestation type.
+	Attestors map[string]Attestor
 }
 
 type TunnelerNotifier interface {
@@ -41,14 +58,20 @@ type TunnelerNotifier interface {
 }
 
 // NewEnrollmentServer creates a new EnrollmentServer.
-func NewEnrollmentServer(caInst *ca.CA, caPEM []byte, trustDomain string, tokens *state.TokenStore, registry *state.Registry, notifier TunnelerNotifier) *EnrollmentServer {
+func NewEnrollmentServer(cas *CAManager, trustDomain string, tokens *state.TokenStore, registry *state.Registry, notifier TunnelerNotifier, policySigner *PolicySigner, db *sql.DB, revocations *state.RevocationStore, ttls *TTLPolicy) *EnrollmentServer {
 	return &EnrollmentServer{
-		CA:          caInst,
-		CAPEM:       caPEM,
-		TrustDomain: trustDomain,
-		Tokens:      tokens,
-		Registry:    registry,
-		Notifier:    notifier,
+		CAs:          cas,
+		TrustDomain:  trustDomain,
+		Tokens:       tokens,
+		Registry:     registry,
+		Notifier:     notifier,
+		PolicySigner: policySigner,
+		DB:           db,
+		Revocations:  revocations,
+		TTLs:         ttls,
+		Attestors: map[string]Attestor{
+			AttestJoinToken: &JoinTokenAttestor{Tokens: tokens},
+		},
 	}
 }
 
@@ -58,9 +81,6 @@ func (s *EnrollmentServer) EnrollConnector(
 	req *controllerpb.EnrollRequest,
 ) (*controllerpb.EnrollResponse, error) {
 
-	if !validID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "missing connector id")
-	}
 	if req.GetPrivateIp() == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing private ip")
 	}
@@ -68,31 +88,48 @@ func (s *EnrollmentServer) EnrollConnector(
 		return nil, status.Error(codes.InvalidArgument, "missing version")
 	}
 
-	pubKey, err := parsePublicKey(req.GetPublicKey())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+	// Refuse a disabled ID before attesting so it does not spend a join
+	// token. The attested ID is checked again, as x509pop may omit req.Id.
+	if s.Registry.IsDisabled(req.GetId()) {
+		return nil, disconnectError(DisconnectDisabled, "connector is disabled")
 	}
-	logPublicKey("enroll-connector", pubKey, req.GetPublicKey())
-
-	if err := s.authorizeConnectorToken(req.GetToken(), req.GetId()); err != nil {
+	att, err := s.attest(ctx, "connector", req)
+	if err != nil {
 		return nil, err
 	}
+	id := att.WorkloadID
+	if s.Registry.IsDisabled(id) {
+		return nil, disconnectError(DisconnectDisabled, "connector is disabled")
+	}
 
 	spiffeID := fmt.Sprintf(
 		"spiffe://%s/connector/%s",
 		s.TrustDomain,
-		req.GetId(),
+		id,
 	)
 	var ipAddrs []net.IP
 	if ip := net.ParseIP(req.GetPrivateIp()); ip != nil {
 		ipAddrs = []net.IP{ip}
 	}
 
+	if err := s.checkRevokedIdentity(att, spiffeID); err != nil {
+		return nil, err
+	}
+
+	pubKey, keyProof, err := s.workloadKey("enroll-connector", req, spiffeID, ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+	approved, err := s.awaitApproval(ctx, "connector", id, req, pubKey, keyProof, att)
+	if err != nil {
+		return nil, err
+	}
+
 	certPEM, err := ca.IssueWorkloadCert(
-		s.CA,
+		s.CAs.Issuer(),
 		spiffeID,
 		pubKey,
-		5*time.Minute,
+		s.TTLs.For("connector", spiffeID),
 		nil,
 		ipAddrs,
 	)
@@ -100,16 +137,29 @@ func (s *EnrollmentServer) EnrollConnector(
 		return nil, status.Errorf(codes.Internal, "certificate issuance failed: %v", err)
 	}
 	logIssuedCert("enroll-connector", spiffeID, certPEM)
+	s.recordIssued(spiffeID, certPEM, state.IssuedViaEnroll, keyProof)
+	s.completeApproval(approved)
 
-	// Registration side-effect: log enrollment details.
-	logEnrollment("connector", req.GetId(), req.GetPrivateIp(), req.GetVersion())
+	// Registration side-effect: log enrollment details. An approved request
+	// is registered as the admin saw it.
+	version := req.GetVersion()
+	if approved != nil {
+		version = approved.Version
+	}
+	logEnrollment("connector", id, req.GetPrivateIp(), version)
 	if s.Registry != nil {
-		s.Registry.Register(req.GetId(), req.GetPrivateIp(), req.GetVersion())
+		s.Registry.Register(id, req.GetPrivateIp(), version)
+	}
+	if att.RemoteNetworkID != "" && s.RemoteNetworks != nil {
+		if err := s.RemoteNetworks.AssignConnector(att.RemoteNetworkID, id); err != nil {
+			log.Printf("enroll-connector: failed to assign %s to remote network %s: %v", id, att.RemoteNetworkID, err)
+		}
 	}
 
 	return &controllerpb.EnrollResponse{
-		Certificate:   certPEM,
-		CaCertificate: s.CAPEM,
+		Certificate:       certPEM,
+		CaCertificate:     s.CAs.BundlePEM(),
+		PolicySigningKeys: s.PolicySigner.ProtoKeys(),
 	}, nil
 }
 
@@ -119,34 +169,36 @@ func (s *EnrollmentServer) EnrollTunneler(
 	req *controllerpb.EnrollRequest,
 ) (*controllerpb.EnrollResponse, error) {
 
-	if !validID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "missing tunneler id")
-	}
-	if req.GetToken() == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing enrollment token")
-	}
-
-	pubKey, err := parsePublicKey(req.GetPublicKey())
+	att, err := s.attest(ctx, "tunneler", req)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
-	}
-	logPublicKey("enroll-tunneler", pubKey, req.GetPublicKey())
-
-	if err := s.authorizeConnectorToken(req.GetToken(), req.GetId()); err != nil {
 		return nil, err
 	}
+	id := att.WorkloadID
 
 	spiffeID := fmt.Sprintf(
 		"spiffe://%s/tunneler/%s",
 		s.TrustDomain,
-		req.GetId(),
+		id,
 	)
 
+	if err := s.checkRevokedIdentity(att, spiffeID); err != nil {
+		return nil, err
+	}
+
+	pubKey, keyProof, err := s.workloadKey("enroll-tunneler", req, spiffeID, nil)
+	if err != nil {
+		return nil, err
+	}
+	approved, err := s.awaitApproval(ctx, "tunneler", id, req, pubKey, keyProof, att)
+	if err != nil {
+		return nil, err
+	}
+
 	certPEM, err := ca.IssueWorkloadCert(
-		s.CA,
+		s.CAs.Issuer(),
 		spiffeID,
 		pubKey,
-		30*time.Minute,
+		s.TTLs.For("tunneler", spiffeID),
 		nil,
 		nil,
 	)
@@ -154,13 +206,15 @@ func (s *EnrollmentServer) EnrollTunneler(
 		return nil, status.Errorf(codes.Internal, "certificate issuance failed: %v", err)
 	}
 	logIssuedCert("enroll-tunneler", spiffeID, certPEM)
+	s.recordIssued(spiffeID, certPEM, state.IssuedViaEnroll, keyProof)
+	s.completeApproval(approved)
 	if s.Notifier != nil {
-		s.Notifier.NotifyTunnelerAllowed(req.GetId(), spiffeID)
+		s.Notifier.NotifyTunnelerAllowed(id, spiffeID)
 	}
 
 	return &controllerpb.EnrollResponse{
 		Certificate:   certPEM,
-		CaCertificate: s.CAPEM,
+		CaCertificate: s.CAs.BundlePEM(),
 	}, nil
 }
 
@@ -174,12 +228,6 @@ func (s *EnrollmentServer) Renew(
 		return nil, status.Error(codes.InvalidArgument, "missing id")
 	}
 
-	pubKey, err := parsePublicKey(req.GetPublicKey())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
-	}
-	logPublicKey("renew", pubKey, req.GetPublicKey())
-
 	role, id, err := s.identityFromContext(ctx)
 	if err != nil {
 		return nil, err
@@ -190,10 +238,7 @@ func (s *EnrollmentServer) Renew(
 
 	spiffeID := fmt.Sprintf("spiffe://%s/%s/%s", s.TrustDomain, role, req.GetId())
 
-	ttl := 30 * time.Minute
-	if role == "connector" {
-		ttl = 5 * time.Minute
-	}
+	ttl := s.TTLs.For(role, spiffeID)
 	var ipAddrs []net.IP
 	if role == "connector" && s.Registry != nil {
 		if rec, ok := s.Registry.Get(req.GetId()); ok {
@@ -203,16 +248,26 @@ func (s *EnrollmentServer) Renew(
 		}
 	}
 
-	certPEM, err := ca.IssueWorkloadCert(s.CA, spiffeID, pubKey, ttl, nil, ipAddrs)
+	pubKey, keyProof, err := s.workloadKey("renew", req, spiffeID, ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := ca.IssueWorkloadCert(s.CAs.Issuer(), spiffeID, pubKey, ttl, nil, ipAddrs)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "certificate renewal failed: %v", err)
 	}
 	logIssuedCert("renew", spiffeID, certPEM)
+	s.recordIssued(spiffeID, certPEM, state.IssuedViaRenew, keyProof)
 
-	return &controllerpb.EnrollResponse{
+	resp := &controllerpb.EnrollResponse{
 		Certificate:   certPEM,
-		CaCertificate: s.CAPEM,
-	}, nil
+		CaCertificate: s.CAs.BundlePEM(),
+	}
+	if role == "connector" {
+		resp.PolicySigningKeys = s.PolicySigner.ProtoKeys()
+	}
+	return resp, nil
 }
 
 // parsePublicKey parses a PEM-encoded public key.
@@ -248,16 +303,6 @@ func (s *EnrollmentServer) authorize(ctx context.Context, expectedRole, expected
 	return nil
 }
 
-func (s *EnrollmentServer) authorizeConnectorToken(token, connectorID string) error {
-	if s.Tokens == nil {
-		return status.Error(codes.FailedPrecondition, "token service unavailable")
-	}
-	if err := s.Tokens.ConsumeToken(token, connectorID); err != nil {
-		return status.Error(codes.PermissionDenied, "invalid enrollment token")
-	}
-	return nil
-}
-
 func (s *EnrollmentServer) identityFromContext(ctx context.Context) (string, string, error) {
 	spiffeID, ok := SPIFFEIDFromContext(ctx)
 	if !ok {
@@ -323,6 +368,28 @@ func logIssuedCert(scope, spiffeID string, certPEM []byte) {
 	)
 }
 
+// recordIssued adds the certificate to the inventory. A fresh enrollment
+// lifts any earlier revocation of the identity; checkRevokedIdentity only
+// lets a join token get this far for a revoked one.
+func (s *EnrollmentServer) recordIssued(spiffeID string, certPEM []byte, issuedVia, keyProof string) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return
+	}
+	if err := state.RecordIssuedCertificate(s.DB, cert, issuedVia, keyProof); err != nil {
+		log.Printf("failed to record issued cert for %s: %v", spiffeID, err)
+	}
+	if issuedVia == state.IssuedViaEnroll {
+		if err := s.Revocations.ClearSPIFFEID(spiffeID); err != nil {
+			log.Printf("failed to clear revocation for %s: %v", spiffeID, err)
+		}
+	}
+}
+
 func validID(id string) bool {
 	if id == "" || len(id) > 128 {
 		return false
@@ -21,6 +21,16 @@ const (
 	roleContextKey     contextKey = "spiffe-role"
 )
 
+// RevocationChecker reports whether a peer certificate has been revoked.
+type RevocationChecker interface {
+	IsRevoked(cert *x509.Certificate) bool
+}
+
+// DisabledChecker reports whether an admin has disabled a connector.
+type DisabledChecker interface {
+	IsDisabled(connectorID string) bool
+}
+
 // UnarySPIFFEInterceptor enforces SPIFFE identity on unary RPCs.
 func UnarySPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.UnaryServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
@@ -31,7 +41,7 @@ func UnarySPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.Una
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
 
-		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles)
+		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -44,8 +54,9 @@ func UnarySPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.Una
 }
 
 // UnaryAuthInterceptor enforces SPIFFE identity on unary RPCs, with optional
-// method-level bypass for bootstrap enrollment.
-func UnaryAuthInterceptor(trustDomain string, unauthenticatedMethods map[string]struct{}, allowedRoles ...string) grpc.UnaryServerInterceptor {
+// method-level bypass for bootstrap enrollment. Certificates reported by
+// revoked and connectors reported by disabled are rejected.
+func UnaryAuthInterceptor(trustDomain string, unauthenticatedMethods map[string]struct{}, revoked RevocationChecker, disabled DisabledChecker, allowedRoles ...string) grpc.UnaryServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
 	return func(
 		ctx context.Context,
@@ -58,10 +69,13 @@ func UnaryAuthInterceptor(trustDomain string, unauthenticatedMethods map[string]
 			return handler(ctx, req)
 		}
 
-		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles)
+		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles, revoked)
 		if err != nil {
 			return nil, err
 		}
+		if err := checkDisabled(disabled, role, spiffeID); err != nil {
+			return nil, err
+		}
 
 		ctx = context.WithValue(ctx, spiffeIDContextKey, spiffeID)
 		ctx = context.WithValue(ctx, roleContextKey, role)
@@ -70,8 +84,10 @@ func UnaryAuthInterceptor(trustDomain string, unauthenticatedMethods map[string]
 	}
 }
 
-// StreamSPIFFEInterceptor enforces SPIFFE identity on streaming RPCs.
-func StreamSPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.StreamServerInterceptor {
+// StreamSPIFFEInterceptor enforces SPIFFE identity on streaming RPCs and
+// rejects certificates reported by revoked and connectors reported by
+// disabled.
+func StreamSPIFFEInterceptor(trustDomain string, revoked RevocationChecker, disabled DisabledChecker, allowedRoles ...string) grpc.StreamServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
 	return func(
 		srv interface{},
@@ -80,10 +96,13 @@ func StreamSPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.St
 		handler grpc.StreamHandler,
 	) error {
 
-		spiffeID, role, err := extractAndVerifySPIFFE(ss.Context(), trustDomain, roles)
+		spiffeID, role, err := extractAndVerifySPIFFE(ss.Context(), trustDomain, roles, revoked)
 		if err != nil {
 			return err
 		}
+		if err := checkDisabled(disabled, role, spiffeID); err != nil {
+			return err
+		}
 
 		wrapped := &wrappedStream{
 			ServerStream: ss,
@@ -129,11 +148,12 @@ func RoleFromContext(ctx context.Context) (string, bool) {
 }
 
 // extractAndVerifySPIFFE pulls the peer certificate from context and validates
-// the SPIFFE ID and role.
+// the SPIFFE ID, role and, when revoked is set, revocation status.
 func extractAndVerifySPIFFE(
 	ctx context.Context,
 	trustDomain string,
 	allowedRoles map[string]struct{},
+	revoked RevocationChecker,
 ) (string, string, error) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
@@ -179,9 +199,28 @@ func extractAndVerifySPIFFE(
 		}
 	}
 
+	if revoked != nil && revoked.IsRevoked(cert) {
+		log.Printf("rejecting revoked certificate: spiffe=%s serial=%s", uri.String(), cert.SerialNumber.String())
+		return "", "", errors.New("certificate revoked")
+	}
+
 	return uri.String(), role, nil
 }
 
+// checkDisabled refuses a connector that has been disabled, telling it why
+// with a DisconnectDisabled reason.
+func checkDisabled(disabled DisabledChecker, role, spiffeID string) error {
+	if disabled == nil || role != "connector" {
+		return nil
+	}
+	id := parseConnectorID(spiffeID)
+	if id == "" || !disabled.IsDisabled(id) {
+		return nil
+	}
+	log.Printf("rejecting disabled connector: spiffe=%s", spiffeID)
+	return disconnectError(DisconnectDisabled, "connector is disabled")
+}
+
 func makeRoleSet(roles []string) map[string]struct{} {
 	if len(roles) == 0 {
 		return nil
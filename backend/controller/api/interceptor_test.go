@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"controller/state"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// peerContext returns a context carrying a TLS peer that presented a
+// certificate with serial and spiffeID.
+func peerContext(serial int64, spiffeID string) context.Context {
+	uri, _ := url.Parse(spiffeID)
+	cert := &x509.Certificate{SerialNumber: big.NewInt(serial), URIs: []*url.URL{uri}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+}
+
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context { return s.ctx }
+
+func TestInterceptorsRejectRevokedCertificates(t *testing.T) {
+	revoked := state.NewRevocationStore(nil)
+	if err := revoked.RevokeSerial("10", "key compromise", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := revoked.RevokeSPIFFEID("spiffe://example.internal/tunneler/t2", "deprovisioned"); err != nil {
+		t.Fatal(err)
+	}
+	unary := UnaryAuthInterceptor("example.internal", nil, revoked, nil, "connector", "tunneler")
+	stream := StreamSPIFFEInterceptor("example.internal", revoked, nil, "connector", "tunneler")
+	info := &grpc.UnaryServerInfo{FullMethod: "/controller.v1.ControlPlane/Connect"}
+
+	tests := []struct {
+		name     string
+		serial   int64
+		spiffeID string
+		allowed  bool
+	}{
+		{"valid", 11, "spiffe://example.internal/tunneler/t1", true},
+		{"revoked serial", 10, "spiffe://example.internal/tunneler/t1", false},
+		{"revoked identity", 12, "spiffe://example.internal/tunneler/t2", false},
+	}
+	for _, tt := range tests {
+		ctx := peerContext(tt.serial, tt.spiffeID)
+
+		var unaryID string
+		_, err := unary(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			unaryID, _ = SPIFFEIDFromContext(ctx)
+			return nil, nil
+		})
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: unary allowed=%v, err=%v", tt.name, tt.allowed, err)
+		}
+		if tt.allowed && unaryID != tt.spiffeID {
+			t.Errorf("%s: handler saw SPIFFE ID %q", tt.name, unaryID)
+		}
+
+		called := false
+		err = stream(nil, &contextServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(interface{}, grpc.ServerStream) error {
+			called = true
+			return nil
+		})
+		if (err == nil) != tt.allowed || called != tt.allowed {
+			t.Errorf("%s: stream allowed=%v, called=%v, err=%v", tt.name, tt.allowed, called, err)
+		}
+	}
+}
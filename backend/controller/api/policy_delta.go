@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// PolicyDelta is an incremental policy update for a connector that holds
+// BaseVersion. Resources whose attributes changed are sent whole; changes
+// limited to allowed identities are sent as per-resource add/remove lists.
+// PolicyHash is the hash of the full resource list after the delta is
+// applied, so the connector can detect drift and ask for a full snapshot.
+type PolicyDelta struct {
+	DeltaMeta       DeltaMeta        `json:"delta_meta"`
+	Added           []PolicyResource `json:"added"`
+	Changed         []PolicyResource `json:"changed"`
+	Removed         []string         `json:"removed"`
+	IdentityChanges []IdentityChange `json:"identity_changes"`
+}
+
+type DeltaMeta struct {
+	ConnectorID   string `json:"connector_id"`
+	BaseVersion   int    `json:"base_version"`
+	PolicyVersion int    `json:"policy_version"`
+	PolicyHash    string `json:"policy_hash"`
+	CompiledAt    string `json:"compiled_at"`
+	ValidUntil    string `json:"valid_until"`
+	KeyID         string `json:"key_id"`
+	Signature     string `json:"signature"`
+
+	SurvivabilityGraceSeconds int `json:"survivability_grace_seconds,omitempty"`
+}
+
+type IdentityChange struct {
+	ResourceID string   `json:"resource_id"`
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+}
+
+// Size is the number of resource-level changes carried by the delta.
+func (d PolicyDelta) Size() int {
+	return len(d.Added) + len(d.Changed) + len(d.Removed) + len(d.IdentityChanges)
+}
+
+// BuildPolicyDelta diffs next (a freshly compiled, signed snapshot) against
+// the resources the connector holds at baseVersion and signs the result with
+// the active policy signing key.
+func BuildPolicyDelta(baseVersion int, base []PolicyResource, next PolicySnapshot, signer *PolicySigner) (PolicyDelta, error) {
+	key, err := signer.active()
+	if err != nil {
+		return PolicyDelta{}, err
+	}
+	delta := PolicyDelta{
+		DeltaMeta: DeltaMeta{
+			ConnectorID:   next.SnapshotMeta.ConnectorID,
+			BaseVersion:   baseVersion,
+			PolicyVersion: next.SnapshotMeta.PolicyVersion,
+			PolicyHash:    policyHash(next.Resources),
+			CompiledAt:    next.SnapshotMeta.CompiledAt,
+			ValidUntil:    next.SnapshotMeta.ValidUntil,
+			KeyID:         key.KeyID,
+
+			SurvivabilityGraceSeconds: next.SnapshotMeta.SurvivabilityGraceSeconds,
+		},
+		Added:           []PolicyResource{},
+		Changed:         []PolicyResource{},
+		Removed:         []string{},
+		IdentityChanges: []IdentityChange{},
+	}
+
+	baseByID := make(map[string]PolicyResource, len(base))
+	for _, res := range base {
+		baseByID[res.ResourceID] = res
+	}
+	seen := make(map[string]struct{}, len(next.Resources))
+	for _, res := range next.Resources {
+		seen[res.ResourceID] = struct{}{}
+		old, ok := baseByID[res.ResourceID]
+		if !ok {
+			delta.Added = append(delta.Added, res)
+			continue
+		}
+		if !sameResourceAttributes(old, res) {
+			delta.Changed = append(delta.Changed, res)
+			continue
+		}
+		added, removed := diffStrings(old.AllowedIdentities, res.AllowedIdentities)
+		if len(added) > 0 || len(removed) > 0 {
+			delta.IdentityChanges = append(delta.IdentityChanges, IdentityChange{ResourceID: res.ResourceID, Added: added, Removed: removed})
+		}
+	}
+	for _, res := range base {
+		if _, ok := seen[res.ResourceID]; !ok {
+			delta.Removed = append(delta.Removed, res.ResourceID)
+		}
+	}
+	sort.Strings(delta.Removed)
+
+	sig, err := signDelta(key.PrivateKey, delta)
+	if err != nil {
+		return PolicyDelta{}, err
+	}
+	delta.DeltaMeta.Signature = sig
+	return delta, nil
+}
+
+func sameResourceAttributes(a, b PolicyResource) bool {
+	a.AllowedIdentities, b.AllowedIdentities = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// diffStrings returns the entries only in next and only in prev. Both inputs
+// must be sorted.
+func diffStrings(prev, next []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(prev) || j < len(next) {
+		switch {
+		case i == len(prev):
+			added = append(added, next[j])
+			j++
+		case j == len(next):
+			removed = append(removed, prev[i])
+			i++
+		case prev[i] == next[j]:
+			i++
+			j++
+		case prev[i] < next[j]:
+			removed = append(removed, prev[i])
+			i++
+		default:
+			added = append(added, next[j])
+			j++
+		}
+	}
+	return added, removed
+}
+
+func signDelta(key ed25519.PrivateKey, delta PolicyDelta) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", errors.New("signing key not configured")
+	}
+	delta.DeltaMeta.Signature = ""
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ed25519.Sign(key, data)), nil
+}
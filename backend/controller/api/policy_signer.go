@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+)
+
+// PolicyPublicKey is the published half of a policy signing key.
+type PolicyPublicKey struct {
+	KeyID     string `json:"key_id"`
+	PublicKey []byte `json:"public_key"`
+	CreatedAt string `json:"created_at"`
+	Active    bool   `json:"active"`
+}
+
+// PolicySigner holds the controller's Ed25519 policy signing keys. The newest
+// key signs; the key it replaced stays published so connectors can still
+// verify snapshots signed before a rotation.
+type PolicySigner struct {
+	db     *sql.DB
+	sealer *ca.KeySealer // encrypts seeds stored in db; nil if unset
+	mu     sync.RWMutex
+	keys   []state.PolicySigningKey // unretired, newest first
+}
+
+// NewPolicySigner loads the signing keys from db, generating the first key
+// if none exist. sealer encrypts the stored seeds.
+func NewPolicySigner(db *sql.DB, sealer *ca.KeySealer) (*PolicySigner, error) {
+	stored, err := state.LoadPolicySigningKeys(db, sealer)
+	if err != nil {
+		return nil, err
+	}
+	s := &PolicySigner{db: db, sealer: sealer}
+	for _, key := range stored {
+		if key.RetiredAt.IsZero() {
+			s.keys = append(s.keys, key)
+		}
+	}
+	if len(s.keys) == 0 {
+		if _, err := s.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Rotate makes a new key active and retires every key older than the one it
+// replaces.
+func (s *PolicySigner) Rotate() (PolicyPublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return PolicyPublicKey{}, err
+	}
+	key := state.PolicySigningKey{
+		KeyID:      policyKeyID(pub),
+		PrivateKey: priv,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := state.SavePolicySigningKey(s.db, key, s.sealer); err != nil {
+		return PolicyPublicKey{}, err
+	}
+	if len(s.keys) > 1 {
+		for _, old := range s.keys[1:] {
+			if err := state.RetirePolicySigningKey(s.db, old.KeyID, key.CreatedAt); err != nil {
+				return PolicyPublicKey{}, err
+			}
+		}
+		s.keys = s.keys[:1]
+	}
+	s.keys = append([]state.PolicySigningKey{key}, s.keys...)
+	return publicKey(key, true), nil
+}
+
+// PublicKeys returns the published verification keys, active key first.
+func (s *PolicySigner) PublicKeys() []PolicyPublicKey {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]PolicyPublicKey, 0, len(s.keys))
+	for i, key := range s.keys {
+		out = append(out, publicKey(key, i == 0))
+	}
+	return out
+}
+
+// ProtoKeys returns the published keys for an EnrollResponse.
+func (s *PolicySigner) ProtoKeys() []*controllerpb.PolicySigningKey {
+	keys := s.PublicKeys()
+	out := make([]*controllerpb.PolicySigningKey, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, &controllerpb.PolicySigningKey{KeyId: key.KeyID, PublicKey: key.PublicKey})
+	}
+	return out
+}
+
+func (s *PolicySigner) active() (state.PolicySigningKey, error) {
+	if s == nil {
+		return state.PolicySigningKey{}, errors.New("policy signer not configured")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return state.PolicySigningKey{}, errors.New("no active policy signing key")
+	}
+	return s.keys[0], nil
+}
+
+func publicKey(key state.PolicySigningKey, active bool) PolicyPublicKey {
+	return PolicyPublicKey{
+		KeyID:     key.KeyID,
+		PublicKey: key.Public(),
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+		Active:    active,
+	}
+}
+
+func policyKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "pk_" + hex.EncodeToString(sum[:8])
+}
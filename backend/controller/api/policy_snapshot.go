@@ -1,7 +1,7 @@
 package api
 
 import (
-	"crypto/hmac"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
@@ -12,6 +12,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"controller/state"
 )
 
 type PolicySnapshot struct {
@@ -24,7 +26,10 @@ type SnapshotMeta struct {
 	PolicyVersion int    `json:"policy_version"`
 	CompiledAt    string `json:"compiled_at"`
 	ValidUntil    string `json:"valid_until"`
+	KeyID         string `json:"key_id"`
 	Signature     string `json:"signature"`
+
+	SurvivabilityGraceSeconds int `json:"survivability_grace_seconds,omitempty"`
 }
 
 type PolicyResource struct {
@@ -51,13 +56,17 @@ func PolicyVersionForUI(db *sql.DB, connectorID, policyHash, compiledAt string)
 	return policyVersion(db, connectorID, policyHash, compiledAt)
 }
 
-func CompilePolicySnapshot(db *sql.DB, connectorID string, ttl time.Duration, signingKey []byte) (PolicySnapshot, error) {
+func CompilePolicySnapshot(db *sql.DB, connectorID string, ttl time.Duration, signer *PolicySigner) (PolicySnapshot, error) {
 	if db == nil {
 		return PolicySnapshot{}, errors.New("db not configured")
 	}
 	if connectorID == "" {
 		return PolicySnapshot{}, errors.New("connector_id required")
 	}
+	key, err := signer.active()
+	if err != nil {
+		return PolicySnapshot{}, err
+	}
 	networkID, err := lookupConnectorNetwork(db, connectorID)
 	if err != nil {
 		return PolicySnapshot{}, err
@@ -78,13 +87,16 @@ func CompilePolicySnapshot(db *sql.DB, connectorID string, ttl time.Duration, si
 			PolicyVersion: version,
 			CompiledAt:    compiledAt,
 			ValidUntil:    validUntil,
+			KeyID:         key.KeyID,
 			Signature:     "",
+
+			SurvivabilityGraceSeconds: state.SurvivabilityGrace(db, networkID),
 		},
 		Resources: resources,
 	}
 
 	snap = normalizeSnapshot(snap)
-	sig, err := signSnapshot(signingKey, snap)
+	sig, err := signSnapshot(key.PrivateKey, snap)
 	if err != nil {
 		return PolicySnapshot{}, err
 	}
@@ -92,8 +104,10 @@ func CompilePolicySnapshot(db *sql.DB, connectorID string, ttl time.Duration, si
 	return snap, nil
 }
 
-func signSnapshot(key []byte, snap PolicySnapshot) (string, error) {
-	if len(key) == 0 {
+// signSnapshot returns the hex Ed25519 signature over the snapshot JSON with
+// an empty signature field. SnapshotMeta.KeyID must already name key.
+func signSnapshot(key ed25519.PrivateKey, snap PolicySnapshot) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
 		return "", errors.New("signing key not configured")
 	}
 	snap.SnapshotMeta.Signature = ""
@@ -101,9 +115,7 @@ func signSnapshot(key []byte, snap PolicySnapshot) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	mac := hmac.New(sha256.New, key)
-	_, _ = mac.Write(data)
-	return hex.EncodeToString(mac.Sum(nil)), nil
+	return hex.EncodeToString(ed25519.Sign(key, data)), nil
 }
 
 func normalizeSnapshot(snap PolicySnapshot) PolicySnapshot {
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"controller/state"
+)
+
+// minCertTTL is the shortest TTL accepted for a role or identity; renewal
+// starts with 30% of the lifetime left and needs some headroom.
+const minCertTTL = time.Minute
+
+// DefaultMaxCertTTL caps workload certificate lifetimes unless
+// CERT_MAX_TTL_SECONDS sets another maximum.
+const DefaultMaxCertTTL = 24 * time.Hour
+
+// DefaultCertTTLs are the workload certificate lifetimes used when a role has
+// no configured TTL.
+var DefaultCertTTLs = map[string]time.Duration{
+	"connector": 5 * time.Minute,
+	"tunneler":  30 * time.Minute,
+}
+
+// TTLPolicy decides the lifetime of workload certificates: a per-identity
+// override stored in the database wins over the role's TTL, and every TTL is
+// capped at the policy's maximum.
+type TTLPolicy struct {
+	db    *sql.DB
+	roles map[string]time.Duration
+	max   time.Duration
+}
+
+// NewTTLPolicy returns a policy using roles on top of DefaultCertTTLs, capped
+// at max (DefaultMaxCertTTL when max is not positive).
+func NewTTLPolicy(db *sql.DB, roles map[string]time.Duration, max time.Duration) *TTLPolicy {
+	if max <= 0 {
+		max = DefaultMaxCertTTL
+	}
+	p := &TTLPolicy{db: db, max: max}
+	merged := make(map[string]time.Duration, len(DefaultCertTTLs))
+	for role, ttl := range DefaultCertTTLs {
+		merged[role] = ttl
+	}
+	for role, ttl := range roles {
+		if ttl > 0 {
+			merged[role] = ttl
+		}
+	}
+	for role, ttl := range merged {
+		if capped := p.capTTL(ttl); capped != ttl {
+			log.Printf("%s certificate TTL %s exceeds maximum; using %s", role, ttl, capped)
+			merged[role] = capped
+		}
+	}
+	p.roles = merged
+	return p
+}
+
+// Max returns the longest TTL the policy allows.
+func (p *TTLPolicy) Max() time.Duration {
+	return p.max
+}
+
+// For returns the certificate TTL for spiffeID, which has the given role.
+func (p *TTLPolicy) For(role, spiffeID string) time.Duration {
+	if ttl, ok, err := state.GetCertTTLOverride(p.db, spiffeID); err != nil {
+		log.Printf("failed to load certificate TTL override for %s: %v", spiffeID, err)
+	} else if ok {
+		return p.capTTL(ttl)
+	}
+	if ttl, ok := p.roles[role]; ok {
+		return ttl
+	}
+	return p.capTTL(DefaultCertTTLs["tunneler"])
+}
+
+// Roles returns the TTL of each role.
+func (p *TTLPolicy) Roles() map[string]time.Duration {
+	out := make(map[string]time.Duration, len(p.roles))
+	for role, ttl := range p.roles {
+		out[role] = ttl
+	}
+	return out
+}
+
+// Longest returns the longest TTL any workload can currently be issued,
+// which is how long a certificate from a replaced CA may stay live.
+func (p *TTLPolicy) Longest() time.Duration {
+	var longest time.Duration
+	for _, ttl := range p.roles {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	overrides, err := state.ListCertTTLOverrides(p.db)
+	if err != nil {
+		return p.max
+	}
+	for _, o := range overrides {
+		if ttl := p.capTTL(o.TTL); ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// SetOverride sets the TTL for one identity.
+func (p *TTLPolicy) SetOverride(spiffeID string, ttl time.Duration) error {
+	if err := p.ValidateCertTTL(ttl); err != nil {
+		return err
+	}
+	return state.SetCertTTLOverride(p.db, spiffeID, ttl)
+}
+
+// DeleteOverride returns spiffeID to its role's TTL.
+func (p *TTLPolicy) DeleteOverride(spiffeID string) (bool, error) {
+	return state.DeleteCertTTLOverride(p.db, spiffeID)
+}
+
+// Overrides lists the per-identity TTLs.
+func (p *TTLPolicy) Overrides() ([]state.CertTTLOverride, error) {
+	return state.ListCertTTLOverrides(p.db)
+}
+
+// ValidateCertTTL checks ttl against the minimum and the policy's maximum.
+func (p *TTLPolicy) ValidateCertTTL(ttl time.Duration) error {
+	if ttl < minCertTTL {
+		return fmt.Errorf("certificate TTL must be at least %s", minCertTTL)
+	}
+	if ttl > p.max {
+		return fmt.Errorf("certificate TTL may not exceed %s", p.max)
+	}
+	return nil
+}
+
+func (p *TTLPolicy) capTTL(ttl time.Duration) time.Duration {
+	if ttl > p.max {
+		return p.max
+	}
+	return ttl
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"controller/state"
+)
+
+func TestTTLPolicyFor(t *testing.T) {
+	db := openTestDB(t)
+	p := NewTTLPolicy(db, map[string]time.Duration{"connector": 10 * time.Minute, "tunneler": 0}, 2*time.Hour)
+
+	const id = "spiffe://example.internal/connector/c1"
+	if got := p.For("connector", id); got != 10*time.Minute {
+		t.Fatalf("expected configured connector TTL, got %s", got)
+	}
+	if got := p.For("tunneler", "spiffe://example.internal/tunneler/t1"); got != DefaultCertTTLs["tunneler"] {
+		t.Fatalf("expected default tunneler TTL, got %s", got)
+	}
+
+	if err := p.SetOverride(id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.For("connector", id); got != time.Hour {
+		t.Fatalf("expected override to win over the role TTL, got %s", got)
+	}
+	if got := p.Longest(); got != time.Hour {
+		t.Fatalf("expected longest TTL to include overrides, got %s", got)
+	}
+
+	// An override stored under a larger maximum is clamped to the current one.
+	if err := state.SetCertTTLOverride(db, id, 5*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.For("connector", id); got != 2*time.Hour {
+		t.Fatalf("expected override clamped to max, got %s", got)
+	}
+	if got := p.Longest(); got != 2*time.Hour {
+		t.Fatalf("expected longest TTL clamped to max, got %s", got)
+	}
+}
+
+func TestTTLPolicyClampsRoleTTLs(t *testing.T) {
+	p := NewTTLPolicy(openTestDB(t), map[string]time.Duration{"tunneler": 3 * time.Hour}, time.Hour)
+	if got := p.Roles()["tunneler"]; got != time.Hour {
+		t.Fatalf("expected role TTL clamped to max, got %s", got)
+	}
+	if got := NewTTLPolicy(nil, nil, 0).Max(); got != DefaultMaxCertTTL {
+		t.Fatalf("expected default max %s, got %s", DefaultMaxCertTTL, got)
+	}
+}
+
+func TestValidateCertTTL(t *testing.T) {
+	p := NewTTLPolicy(nil, nil, time.Hour)
+	for ttl, ok := range map[time.Duration]bool{
+		30 * time.Second: false,
+		minCertTTL:       true,
+		time.Hour:        true,
+		61 * time.Minute: false,
+	} {
+		if err := p.ValidateCertTTL(ttl); (err == nil) != ok {
+			t.Errorf("%s: expected ok=%v, got %v", ttl, ok, err)
+		}
+	}
+	if err := p.SetOverride("spiffe://example.internal/connector/c1", 2*time.Hour); err == nil {
+		t.Fatal("expected override above max to be rejected")
+	}
+}
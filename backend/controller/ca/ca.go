@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -9,20 +10,57 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 )
 
 // CA represents the internal Certificate Authority used by the controller.
 // It holds the parsed CA certificate and a crypto.Signer for the CA private key.
+// When the signing certificate is an intermediate, Chain holds its issuers
+// up to (optionally including) the root.
 type CA struct {
-	Cert *x509.Certificate
-	Key  crypto.Signer
+	Cert  *x509.Certificate
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// Root returns the trust anchor workloads should trust for certificates
+// issued by c: the last certificate in the chain, or Cert itself.
+func (c *CA) Root() *x509.Certificate {
+	if len(c.Chain) > 0 {
+		return c.Chain[len(c.Chain)-1]
+	}
+	return c.Cert
+}
+
+// IntermediatesPEM returns the certificates workloads must present after
+// their leaf: Cert and every chain certificate except a self-signed root.
+// It is empty when Cert is itself the root.
+func (c *CA) IntermediatesPEM() []byte {
+	var out []byte
+	for _, cert := range append([]*x509.Certificate{c.Cert}, c.Chain...) {
+		if isSelfSigned(cert) {
+			break
+		}
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
 }
 
 // GenerateSelfSignedCA creates a standards-compliant CA certificate and key.
 // The CA certificate includes critical BasicConstraints and KeyUsage for cert signing.
 func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM []byte, err error) {
+	return generateCA(commonName, ttl, 0)
+}
+
+// GenerateRootCA creates a self-signed root that may sign one level of
+// intermediate CAs. The root key is meant to be kept offline.
+func GenerateRootCA(commonName string, ttl time.Duration) (certPEM, keyPEM []byte, err error) {
+	return generateCA(commonName, ttl, 1)
+}
+
+func generateCA(commonName string, ttl time.Duration, maxPathLen int) (certPEM, keyPEM []byte, err error) {
 	if ttl <= 0 {
 		return nil, nil, errors.New("invalid CA TTL")
 	}
@@ -46,7 +84,8 @@ func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM
 		IsCA:                  true,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		Subject:               pkix.Name{CommonName: commonName},
-		MaxPathLenZero:        true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
 	}
 
 	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &privKey.PublicKey, privKey)
@@ -65,45 +104,78 @@ func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM
 }
 
 // LoadCA loads and parses the internal CA certificate and private key.
-// certPEM and keyPEM must be PEM-encoded data.
-// The private key must implement crypto.Signer (RSA, ECDSA, TPM-backed, etc.).
+// certPEM and keyPEM must be PEM-encoded data. certPEM may be a chain: the
+// signing certificate first, followed by its issuers.
+// The private key must be PKCS#8; keys that should not be held in memory are
+// loaded with LoadCAWithSigner instead.
 func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
-	if len(certPEM) == 0 {
-		return nil, errors.New("CA certificate PEM is empty")
-	}
 	if len(keyPEM) == 0 {
 		return nil, errors.New("CA private key PEM is empty")
 	}
-
-	// Decode and parse CA certificate
-	certBlock, _ := pem.Decode(certPEM)
-	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
-		return nil, errors.New("failed to decode CA certificate PEM")
-	}
-
-	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	signer, err := LoadPKCS8Signer(keyPEM)
 	if err != nil {
 		return nil, err
 	}
+	return LoadCAWithSigner(certPEM, signer)
+}
 
-	// Decode and parse CA private key (PKCS#8)
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return nil, errors.New("failed to decode CA private key PEM")
+// LoadCAWithSigner loads the CA certificate chain and pairs it with signer,
+// which may be backed by an HSM, KMS or out-of-process signer. The signer's
+// public key must match the certificate.
+func LoadCAWithSigner(certPEM []byte, signer crypto.Signer) (*CA, error) {
+	if len(certPEM) == 0 {
+		return nil, errors.New("CA certificate PEM is empty")
 	}
 
-	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	// Decode and parse CA certificate and its chain
+	certs, err := ParseChain(certPEM)
 	if err != nil {
 		return nil, err
 	}
+	cert := certs[0]
 
-	signer, ok := key.(crypto.Signer)
-	if !ok {
-		return nil, errors.New("CA private key does not implement crypto.Signer")
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, errors.New("CA private key does not match certificate")
 	}
 
 	return &CA{
-		Cert: cert,
-		Key:  signer,
+		Cert:  cert,
+		Key:   signer,
+		Chain: certs[1:],
 	}, nil
 }
+
+// ParseChain decodes every certificate in certPEM and checks that each one
+// is signed by the next.
+func ParseChain(certPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("failed to decode CA certificate PEM")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("failed to decode CA certificate PEM")
+	}
+	for i := 0; i+1 < len(certs); i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return nil, fmt.Errorf("CA chain: %q is not signed by %q: %w", certs[i].Subject.CommonName, certs[i+1].Subject.CommonName, err)
+		}
+	}
+	return certs, nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
@@ -0,0 +1,27 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// CreateCRL signs a DER-encoded certificate revocation list for the given
+// serials. number must increase with every CRL issued.
+func CreateCRL(ca *CA, revoked []x509.RevocationListEntry, number *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	if ca == nil || ca.Cert == nil || ca.Key == nil {
+		return nil, errors.New("CA is not initialized")
+	}
+	if !nextUpdate.After(thisUpdate) {
+		return nil, errors.New("CRL next update must be after this update")
+	}
+	tmpl := &x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+	}
+	return x509.CreateRevocationList(rand.Reader, tmpl, ca.Cert, ca.Key)
+}
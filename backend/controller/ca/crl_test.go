@@ -0,0 +1,54 @@
+package ca
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCreateCRL(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSignedCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	revoked := []x509.RevocationListEntry{
+		{SerialNumber: big.NewInt(42), RevocationTime: now.Add(-time.Minute)},
+		{SerialNumber: big.NewInt(43), RevocationTime: now},
+	}
+	der, err := CreateCRL(issuer, revoked, big.NewInt(7), now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(issuer.Cert); err != nil {
+		t.Fatalf("CRL signature: %v", err)
+	}
+	if crl.Number.Cmp(big.NewInt(7)) != 0 || !crl.ThisUpdate.Equal(now) || !crl.NextUpdate.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected CRL fields: number=%v this=%v next=%v", crl.Number, crl.ThisUpdate, crl.NextUpdate)
+	}
+	if len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(crl.RevokedCertificateEntries))
+	}
+	for i, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(revoked[i].SerialNumber) != 0 || !entry.RevocationTime.Equal(revoked[i].RevocationTime) {
+			t.Fatalf("entry %d: got serial %v at %v", i, entry.SerialNumber, entry.RevocationTime)
+		}
+	}
+
+	if _, err := CreateCRL(issuer, nil, big.NewInt(8), now, now); err == nil {
+		t.Fatal("expected next update before this update to be refused")
+	}
+	if _, err := CreateCRL(nil, nil, big.NewInt(8), now, now.Add(time.Hour)); err == nil {
+		t.Fatal("expected a nil CA to be refused")
+	}
+}
@@ -0,0 +1,101 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// CreateIntermediateCSR generates the key for an online intermediate CA and a
+// certificate request to be signed by the offline root.
+func CreateIntermediateCSR(commonName string) (csrPEM, keyPEM []byte, err error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return csrPEM, keyPEM, nil
+}
+
+// SignIntermediate signs an intermediate CA request with root. The
+// intermediate may only issue end-entity certificates and never outlives
+// the root. The returned PEM is the intermediate followed by root's chain,
+// ready to use as INTERNAL_CA_CERT.
+func SignIntermediate(root *CA, csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	if root == nil || root.Cert == nil || root.Key == nil {
+		return nil, errors.New("CA is not initialized")
+	}
+	if !root.Cert.IsCA || root.Cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("root certificate is not a signing CA")
+	}
+	if root.Cert.MaxPathLenZero {
+		return nil, errors.New("root certificate does not allow intermediate CAs (path length 0)")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("invalid CA TTL")
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to decode certificate request PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(root.Cert.NotAfter) {
+		notAfter = root.Cert.NotAfter
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             now.Add(-1 * time.Minute),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		Subject:               csr.Subject,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, root.Cert, csr.PublicKey, root.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	for _, cert := range append([]*x509.Certificate{root.Cert}, root.Chain...) {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out, nil
+}
@@ -15,7 +15,10 @@ import (
 // IssueWorkloadCert issues a short-lived X.509 certificate for a workload.
 // - spiffeID must be a valid SPIFFE URI (spiffe://...)
 // - pubKey is the workload public key
-// - ttl controls certificate lifetime
+// - ttl controls certificate lifetime (never past the CA's expiry; capped by the caller)
+//
+// The returned PEM is the leaf followed by any intermediate CA certificates
+// between it and the root.
 //
 // This function does NOT perform authorization.
 // It assumes the caller has already validated the workload identity.
@@ -51,12 +54,16 @@ func IssueWorkloadCert(
 	}
 
 	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
 
 	tmpl := x509.Certificate{
 		SerialNumber: serial,
 
 		NotBefore: now.Add(-1 * time.Minute),
-		NotAfter:  now.Add(ttl),
+		NotAfter:  notAfter,
 
 		KeyUsage: x509.KeyUsageDigitalSignature,
 
@@ -90,5 +97,5 @@ func IssueWorkloadCert(
 		Bytes: der,
 	})
 
-	return certPEM, nil
+	return append(certPEM, ca.IntermediatesPEM()...), nil
 }
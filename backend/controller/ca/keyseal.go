@@ -0,0 +1,85 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedKeyPrefix marks a key encrypted by KeySealer, so keys stored before
+// encryption was configured can still be told apart.
+var sealedKeyPrefix = []byte("ztca-sealed-v1:")
+
+// KeySealer encrypts private keys that the controller stores at rest (CA keys
+// and policy signing seeds), using AES-256-GCM with an operator-supplied key.
+type KeySealer struct {
+	aead cipher.AEAD
+}
+
+// NewKeySealer returns a sealer for a 32-byte key.
+func NewKeySealer(key []byte) (*KeySealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("CA key encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySealer{aead: aead}, nil
+}
+
+// ParseKeySealer decodes a base64 (standard or raw) 32-byte key.
+func ParseKeySealer(encoded string) (*KeySealer, error) {
+	encoded = strings.TrimSpace(encoded)
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.RawStdEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CA key encryption key is not base64: %w", err)
+	}
+	return NewKeySealer(key)
+}
+
+// Seal encrypts keyPEM. id is bound as additional data so a sealed key cannot
+// be moved to another record.
+func (s *KeySealer) Seal(id string, keyPEM []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, sealedKeyPrefix...)
+	out = append(out, nonce...)
+	return s.aead.Seal(out, nonce, keyPEM, []byte(id)), nil
+}
+
+// Open decrypts a key produced by Seal for the same id.
+func (s *KeySealer) Open(id string, sealed []byte) ([]byte, error) {
+	if !IsSealedKey(sealed) {
+		return nil, errors.New("key is not sealed")
+	}
+	data := sealed[len(sealedKeyPrefix):]
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("sealed key is truncated")
+	}
+	nonce, ct := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	keyPEM, err := s.aead.Open(nil, nonce, ct, []byte(id))
+	if err != nil {
+		return nil, errors.New("failed to decrypt key; wrong encryption key?")
+	}
+	return keyPEM, nil
+}
+
+// IsSealedKey reports whether b was produced by KeySealer.Seal.
+func IsSealedKey(b []byte) bool {
+	return bytes.HasPrefix(b, sealedKeyPrefix)
+}
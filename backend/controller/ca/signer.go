@@ -0,0 +1,97 @@
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// signTimeout bounds a single remote signing operation.
+const signTimeout = 10 * time.Second
+
+// KeyService is a KMS-style signing backend that holds CA keys and never
+// releases them. Keys are addressed by ID; Sign receives the digest (or, for
+// Ed25519, the message) exactly as crypto.Signer would.
+type KeyService interface {
+	PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error)
+	Sign(ctx context.Context, keyID string, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// remoteSigner adapts a key held by a KeyService to crypto.Signer.
+type remoteSigner struct {
+	svc   KeyService
+	keyID string
+	pub   crypto.PublicKey
+}
+
+// NewRemoteSigner returns a crypto.Signer for keyID in svc. The public key is
+// fetched once; each signature is a call to svc.
+func NewRemoteSigner(ctx context.Context, svc KeyService, keyID string) (crypto.Signer, error) {
+	pub, err := svc.PublicKey(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	return &remoteSigner{svc: svc, keyID: keyID, pub: pub}, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	return s.svc.Sign(ctx, s.keyID, digest, opts)
+}
+
+// LocalKeyService is the software KeyService: it holds keys in process. It
+// is the reference implementation behind `controller ca serve-signer` and is
+// meant for tests and for running the signer as a separate, locked-down
+// process.
+type LocalKeyService struct {
+	keys map[string]crypto.Signer
+}
+
+// NewLocalKeyService returns a LocalKeyService serving keys by ID.
+func NewLocalKeyService(keys map[string]crypto.Signer) *LocalKeyService {
+	return &LocalKeyService{keys: keys}
+}
+
+// LoadPKCS8Signer parses a PEM-encoded PKCS#8 private key.
+func LoadPKCS8Signer(keyPEM []byte) (crypto.Signer, error) {
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("failed to decode CA private key PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA private key does not implement crypto.Signer")
+	}
+	return signer, nil
+}
+
+func (l *LocalKeyService) PublicKey(_ context.Context, keyID string) (crypto.PublicKey, error) {
+	key, ok := l.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return key.Public(), nil
+}
+
+func (l *LocalKeyService) Sign(_ context.Context, keyID string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, ok := l.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
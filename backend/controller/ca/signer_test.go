@@ -0,0 +1,72 @@
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSocketSignerIssuesWorkloadCert(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSignedCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("generate CA: %v", err)
+	}
+	key, err := LoadPKCS8Signer(keyPEM)
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "signer.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go ServeKeyService(ln, NewLocalKeyService(map[string]crypto.Signer{"ca": key}))
+
+	svc := NewSocketKeyService(socket)
+	if _, err := NewRemoteSigner(context.Background(), svc, "missing"); err == nil {
+		t.Fatalf("expected error for unknown key")
+	}
+	signer, err := NewRemoteSigner(context.Background(), svc, "ca")
+	if err != nil {
+		t.Fatalf("remote signer: %v", err)
+	}
+	remote, err := LoadCAWithSigner(certPEM, signer)
+	if err != nil {
+		t.Fatalf("load CA with remote signer: %v", err)
+	}
+
+	workloadKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate workload key: %v", err)
+	}
+	issued, err := IssueWorkloadCert(remote, "spiffe://mycorp.internal/connector/con_1", &workloadKey.PublicKey, time.Minute, nil, nil)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	block, _ := pem.Decode(issued)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse leaf: %v", err)
+	}
+	if err := leaf.CheckSignatureFrom(remote.Cert); err != nil {
+		t.Fatalf("leaf not signed by CA: %v", err)
+	}
+
+	otherPEM, _, err := GenerateSelfSignedCA("other-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("generate other CA: %v", err)
+	}
+	if _, err := LoadCAWithSigner(otherPEM, signer); err == nil {
+		t.Fatalf("expected mismatched signer to be rejected")
+	}
+}
@@ -0,0 +1,144 @@
+package ca
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// The socket signer protocol is one JSON request and one JSON response per
+// connection over a Unix socket. Keys never cross the socket; only public
+// keys, digests and signatures do.
+type signerRequest struct {
+	Op    string `json:"op"` // "public_key" or "sign"
+	KeyID string `json:"key_id"`
+	// Digest and Hash (a crypto.Hash value, 0 for Ed25519) for "sign".
+	Digest []byte `json:"digest,omitempty"`
+	Hash   uint   `json:"hash,omitempty"`
+	// PSSSaltLength is set for RSA-PSS signatures.
+	PSSSaltLength *int `json:"pss_salt_length,omitempty"`
+}
+
+type signerResponse struct {
+	PublicKey []byte `json:"public_key,omitempty"` // PKIX DER
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SocketKeyService is a KeyService reached over a Unix socket, such as one
+// served by ServeKeyService in a separate process.
+type SocketKeyService struct {
+	path string
+}
+
+// NewSocketKeyService returns a KeyService that dials path for every call.
+func NewSocketKeyService(path string) *SocketKeyService {
+	return &SocketKeyService{path: path}
+}
+
+func (s *SocketKeyService) PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	resp, err := s.call(ctx, signerRequest{Op: "public_key", KeyID: keyID})
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(resp.PublicKey)
+}
+
+func (s *SocketKeyService) Sign(ctx context.Context, keyID string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := signerRequest{Op: "sign", KeyID: keyID, Digest: digest, Hash: uint(opts.HashFunc())}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		salt := pss.SaltLength
+		req.PSSSaltLength = &salt
+	}
+	resp, err := s.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+func (s *SocketKeyService) call(ctx context.Context, req signerRequest) (signerResponse, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return signerResponse{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return signerResponse{}, err
+	}
+	var resp signerResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return signerResponse{}, err
+	}
+	if resp.Error != "" {
+		return signerResponse{}, fmt.Errorf("signer: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// ServeKeyService answers socket signer requests on ln with svc until ln is
+// closed.
+func ServeKeyService(ln net.Listener, svc KeyService) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go serveSignerConn(conn, svc)
+	}
+}
+
+func serveSignerConn(conn net.Conn, svc KeyService) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(signTimeout))
+
+	var req signerRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+
+	var resp signerResponse
+	switch req.Op {
+	case "public_key":
+		pub, err := svc.PublicKey(ctx, req.KeyID)
+		if err == nil {
+			resp.PublicKey, err = x509.MarshalPKIXPublicKey(pub)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	case "sign":
+		var opts crypto.SignerOpts = crypto.Hash(req.Hash)
+		if req.PSSSaltLength != nil {
+			opts = &rsa.PSSOptions{SaltLength: *req.PSSSaltLength, Hash: crypto.Hash(req.Hash)}
+		}
+		sig, err := svc.Sign(ctx, req.KeyID, req.Digest, opts)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Signature = sig
+		log.Printf("signer: signed with key %q", req.KeyID)
+	default:
+		resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
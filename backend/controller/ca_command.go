@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"controller/ca"
+)
+
+const caCommandUsage = "usage: controller ca init-root | intermediate-csr | sign-intermediate | serve-signer [flags]"
+
+// runCACommand implements `controller ca`, which manages a two-tier CA: a
+// root that stays offline and an intermediate the controller signs with.
+//
+//	controller ca init-root          (offline) root.crt + root.key
+//	controller ca intermediate-csr   (controller host) intermediate.csr + intermediate.key
+//	controller ca sign-intermediate  (offline) intermediate.crt, the chain for INTERNAL_CA_CERT
+//	controller ca serve-signer       holds the CA key for INTERNAL_CA_SIGNER_SOCKET
+func runCACommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New(caCommandUsage)
+	}
+
+	fs := flag.NewFlagSet("controller ca "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "init-root":
+		cn := fs.String("cn", "internal-root-ca", "root CA common name")
+		days := fs.Int("days", 3650, "root CA validity in days")
+		certOut := fs.String("cert", "root.crt", "root certificate output path")
+		keyOut := fs.String("key", "root.key", "root private key output path")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		certPEM, keyPEM, err := ca.GenerateRootCA(*cn, daysToDuration(*days))
+		if err != nil {
+			return err
+		}
+		if err := writeNewFile(*keyOut, keyPEM, 0o600); err != nil {
+			return err
+		}
+		if err := writeNewFile(*certOut, certPEM, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s and %s; keep the root key offline\n", *certOut, *keyOut)
+
+	case "intermediate-csr":
+		cn := fs.String("cn", "internal-ca", "intermediate CA common name")
+		csrOut := fs.String("csr", "intermediate.csr", "certificate request output path")
+		keyOut := fs.String("key", "intermediate.key", "intermediate private key output path (INTERNAL_CA_KEY)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		csrPEM, keyPEM, err := ca.CreateIntermediateCSR(*cn)
+		if err != nil {
+			return err
+		}
+		if err := writeNewFile(*keyOut, keyPEM, 0o600); err != nil {
+			return err
+		}
+		if err := writeNewFile(*csrOut, csrPEM, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s and %s; sign %s with the root\n", *csrOut, *keyOut, *csrOut)
+
+	case "sign-intermediate":
+		rootCert := fs.String("root-cert", "root.crt", "root certificate path")
+		rootKey := fs.String("root-key", "root.key", "root private key path")
+		csrIn := fs.String("csr", "intermediate.csr", "certificate request path")
+		days := fs.Int("days", 365, "intermediate CA validity in days")
+		certOut := fs.String("out", "intermediate.crt", "intermediate chain output path (INTERNAL_CA_CERT)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		rootCertPEM, err := os.ReadFile(*rootCert)
+		if err != nil {
+			return err
+		}
+		rootKeyPEM, err := os.ReadFile(*rootKey)
+		if err != nil {
+			return err
+		}
+		csrPEM, err := os.ReadFile(*csrIn)
+		if err != nil {
+			return err
+		}
+		root, err := ca.LoadCA(rootCertPEM, rootKeyPEM)
+		if err != nil {
+			return fmt.Errorf("load root: %w", err)
+		}
+		chainPEM, err := ca.SignIntermediate(root, csrPEM, daysToDuration(*days))
+		if err != nil {
+			return err
+		}
+		if err := writeNewFile(*certOut, chainPEM, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", *certOut)
+
+	case "serve-signer":
+		keyIn := fs.String("key", "intermediate.key", "CA private key path")
+		keyID := fs.String("key-id", "ca", "key ID clients request (INTERNAL_CA_KEY_ID)")
+		socket := fs.String("socket", "/run/ztca/signer.sock", "Unix socket to listen on (INTERNAL_CA_SIGNER_SOCKET)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		keyPEM, err := os.ReadFile(*keyIn)
+		if err != nil {
+			return err
+		}
+		signer, err := ca.LoadPKCS8Signer(keyPEM)
+		if err != nil {
+			return err
+		}
+		_ = os.Remove(*socket)
+		ln, err := net.Listen("unix", *socket)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		if err := os.Chmod(*socket, 0o600); err != nil {
+			return err
+		}
+		log.Printf("CA signer serving key %q on %s", *keyID, *socket)
+		return ca.ServeKeyService(ln, ca.NewLocalKeyService(map[string]crypto.Signer{*keyID: signer}))
+
+	default:
+		return fmt.Errorf("unknown ca command %q; %s", args[0], caCommandUsage)
+	}
+	return nil
+}
+
+func daysToDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// writeNewFile writes data to path, refusing to overwrite existing keys or
+// certificates.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
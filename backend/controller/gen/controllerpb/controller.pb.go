@@ -22,14 +22,18 @@ const (
 )
 
 type EnrollRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	PublicKey     []byte                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
-	Token         string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
-	PrivateIp     string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
-	Version       string                 `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PublicKey        []byte                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	Token            string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	PrivateIp        string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
+	Version          string                 `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	Csr              []byte                 `protobuf:"bytes,6,opt,name=csr,proto3" json:"csr,omitempty"`
+	AttestationType  string                 `protobuf:"bytes,7,opt,name=attestation_type,json=attestationType,proto3" json:"attestation_type,omitempty"`
+	MachineCertChain []byte                 `protobuf:"bytes,8,opt,name=machine_cert_chain,json=machineCertChain,proto3" json:"machine_cert_chain,omitempty"`
+	MachineSignature []byte                 `protobuf:"bytes,9,opt,name=machine_signature,json=machineSignature,proto3" json:"machine_signature,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *EnrollRequest) Reset() {
@@ -97,12 +101,41 @@ func (x *EnrollRequest) GetVersion() string {
 	return ""
 }
 
+func (x *EnrollRequest) GetCsr() []byte {
+	if x != nil {
+		return x.Csr
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetAttestationType() string {
+	if x != nil {
+		return x.AttestationType
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetMachineCertChain() []byte {
+	if x != nil {
+		return x.MachineCertChain
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetMachineSignature() []byte {
+	if x != nil {
+		return x.MachineSignature
+	}
+	return nil
+}
+
 type EnrollResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Certificate   []byte                 `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
-	CaCertificate []byte                 `protobuf:"bytes,2,opt,name=ca_certificate,json=caCertificate,proto3" json:"ca_certificate,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Certificate       []byte                 `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	CaCertificate     []byte                 `protobuf:"bytes,2,opt,name=ca_certificate,json=caCertificate,proto3" json:"ca_certificate,omitempty"`
+	PolicySigningKeys []*PolicySigningKey    `protobuf:"bytes,3,rep,name=policy_signing_keys,json=policySigningKeys,proto3" json:"policy_signing_keys,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *EnrollResponse) Reset() {
@@ -149,6 +182,65 @@ func (x *EnrollResponse) GetCaCertificate() []byte {
 	return nil
 }
 
+func (x *EnrollResponse) GetPolicySigningKeys() []*PolicySigningKey {
+	if x != nil {
+		return x.PolicySigningKeys
+	}
+	return nil
+}
+
+type PolicySigningKey struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	PublicKey     []byte                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicySigningKey) Reset() {
+	*x = PolicySigningKey{}
+	mi := &file_controller_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicySigningKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicySigningKey) ProtoMessage() {}
+
+func (x *PolicySigningKey) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicySigningKey.ProtoReflect.Descriptor instead.
+func (*PolicySigningKey) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PolicySigningKey) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *PolicySigningKey) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
 type ControlMessage struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
@@ -162,7 +254,7 @@ type ControlMessage struct {
 
 func (x *ControlMessage) Reset() {
 	*x = ControlMessage{}
-	mi := &file_controller_proto_msgTypes[2]
+	mi := &file_controller_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -174,7 +266,7 @@ func (x *ControlMessage) String() string {
 func (*ControlMessage) ProtoMessage() {}
 
 func (x *ControlMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_controller_proto_msgTypes[2]
+	mi := &file_controller_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -187,7 +279,7 @@ func (x *ControlMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ControlMessage.ProtoReflect.Descriptor instead.
 func (*ControlMessage) Descriptor() ([]byte, []int) {
-	return file_controller_proto_rawDescGZIP(), []int{2}
+	return file_controller_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *ControlMessage) GetType() string {
@@ -225,11 +317,111 @@ func (x *ControlMessage) GetStatus() string {
 	return ""
 }
 
+type TunnelFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Destination   string                 `protobuf:"bytes,3,opt,name=destination,proto3" json:"destination,omitempty"`
+	Protocol      string                 `protobuf:"bytes,4,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Port          uint32                 `protobuf:"varint,5,opt,name=port,proto3" json:"port,omitempty"`
+	Reason        string                 `protobuf:"bytes,6,opt,name=reason,proto3" json:"reason,omitempty"`
+	SessionId     string                 `protobuf:"bytes,7,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	FlowId        string                 `protobuf:"bytes,8,opt,name=flow_id,json=flowId,proto3" json:"flow_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TunnelFrame) Reset() {
+	*x = TunnelFrame{}
+	mi := &file_controller_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TunnelFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelFrame) ProtoMessage() {}
+
+func (x *TunnelFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelFrame.ProtoReflect.Descriptor instead.
+func (*TunnelFrame) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TunnelFrame) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *TunnelFrame) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetFlowId() string {
+	if x != nil {
+		return x.FlowId
+	}
+	return ""
+}
+
 var File_controller_proto protoreflect.FileDescriptor
 
 const file_controller_proto_rawDesc = "" +
 	"\n" +
-	"\x10controller.proto\x12\rcontroller.v1\"\x8d\x01\n" +
+	"\x10controller.proto\x12\rcontroller.v1\"\xa5\x02\n" +
 	"\rEnrollRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1d\n" +
 	"\n" +
@@ -237,23 +429,43 @@ const file_controller_proto_rawDesc = "" +
 	"\x05token\x18\x03 \x01(\tR\x05token\x12\x1d\n" +
 	"\n" +
 	"private_ip\x18\x04 \x01(\tR\tprivateIp\x12\x18\n" +
-	"\aversion\x18\x05 \x01(\tR\aversion\"Y\n" +
+	"\aversion\x18\x05 \x01(\tR\aversion\x12\x10\n" +
+	"\x03csr\x18\x06 \x01(\fR\x03csr\x12)\n" +
+	"\x10attestation_type\x18\a \x01(\tR\x0fattestationType\x12,\n" +
+	"\x12machine_cert_chain\x18\b \x01(\fR\x10machineCertChain\x12+\n" +
+	"\x11machine_signature\x18\t \x01(\fR\x10machineSignature\"\xaa\x01\n" +
 	"\x0eEnrollResponse\x12 \n" +
 	"\vcertificate\x18\x01 \x01(\fR\vcertificate\x12%\n" +
-	"\x0eca_certificate\x18\x02 \x01(\fR\rcaCertificate\"\x98\x01\n" +
+	"\x0eca_certificate\x18\x02 \x01(\fR\rcaCertificate\x12O\n" +
+	"\x13policy_signing_keys\x18\x03 \x03(\v2\x1f.controller.v1.PolicySigningKeyR\x11policySigningKeys\"H\n" +
+	"\x10PolicySigningKey\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x02 \x01(\fR\tpublicKey\"\x98\x01\n" +
 	"\x0eControlMessage\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
 	"\apayload\x18\x02 \x01(\fR\apayload\x12!\n" +
 	"\fconnector_id\x18\x03 \x01(\tR\vconnectorId\x12\x1d\n" +
 	"\n" +
 	"private_ip\x18\x04 \x01(\tR\tprivateIp\x12\x16\n" +
-	"\x06status\x18\x05 \x01(\tR\x06status2\xf8\x01\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\"\xd7\x01\n" +
+	"\vTunnelFrame\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12 \n" +
+	"\vdestination\x18\x03 \x01(\tR\vdestination\x12\x1a\n" +
+	"\bprotocol\x18\x04 \x01(\tR\bprotocol\x12\x12\n" +
+	"\x04port\x18\x05 \x01(\rR\x04port\x12\x16\n" +
+	"\x06reason\x18\x06 \x01(\tR\x06reason\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\a \x01(\tR\tsessionId\x12\x17\n" +
+	"\aflow_id\x18\b \x01(\tR\x06flowId2\xf8\x01\n" +
 	"\x11EnrollmentService\x12N\n" +
 	"\x0fEnrollConnector\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse\x12M\n" +
 	"\x0eEnrollTunneler\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse\x12D\n" +
-	"\x05Renew\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse2[\n" +
+	"\x05Renew\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse2\xa1\x01\n" +
 	"\fControlPlane\x12K\n" +
-	"\aConnect\x12\x1d.controller.v1.ControlMessage\x1a\x1d.controller.v1.ControlMessage(\x010\x01B*Z(controller/gen/controllerpb;controllerpbb\x06proto3"
+	"\aConnect\x12\x1d.controller.v1.ControlMessage\x1a\x1d.controller.v1.ControlMessage(\x010\x01\x12D\n" +
+	"\x06Tunnel\x12\x1a.controller.v1.TunnelFrame\x1a\x1a.controller.v1.TunnelFrame(\x010\x01B*Z(controller/gen/controllerpb;controllerpbb\x06proto3"
 
 var (
 	file_controller_proto_rawDescOnce sync.Once
@@ -267,26 +479,31 @@ func file_controller_proto_rawDescGZIP() []byte {
 	return file_controller_proto_rawDescData
 }
 
-var file_controller_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_controller_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_controller_proto_goTypes = []any{
-	(*EnrollRequest)(nil),  // 0: controller.v1.EnrollRequest
-	(*EnrollResponse)(nil), // 1: controller.v1.EnrollResponse
-	(*ControlMessage)(nil), // 2: controller.v1.ControlMessage
+	(*EnrollRequest)(nil),    // 0: controller.v1.EnrollRequest
+	(*EnrollResponse)(nil),   // 1: controller.v1.EnrollResponse
+	(*PolicySigningKey)(nil), // 2: controller.v1.PolicySigningKey
+	(*ControlMessage)(nil),   // 3: controller.v1.ControlMessage
+	(*TunnelFrame)(nil),      // 4: controller.v1.TunnelFrame
 }
 var file_controller_proto_depIdxs = []int32{
-	0, // 0: controller.v1.EnrollmentService.EnrollConnector:input_type -> controller.v1.EnrollRequest
-	0, // 1: controller.v1.EnrollmentService.EnrollTunneler:input_type -> controller.v1.EnrollRequest
-	0, // 2: controller.v1.EnrollmentService.Renew:input_type -> controller.v1.EnrollRequest
-	2, // 3: controller.v1.ControlPlane.Connect:input_type -> controller.v1.ControlMessage
-	1, // 4: controller.v1.EnrollmentService.EnrollConnector:output_type -> controller.v1.EnrollResponse
-	1, // 5: controller.v1.EnrollmentService.EnrollTunneler:output_type -> controller.v1.EnrollResponse
-	1, // 6: controller.v1.EnrollmentService.Renew:output_type -> controller.v1.EnrollResponse
-	2, // 7: controller.v1.ControlPlane.Connect:output_type -> controller.v1.ControlMessage
-	4, // [4:8] is the sub-list for method output_type
-	0, // [0:4] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2, // 0: controller.v1.EnrollResponse.policy_signing_keys:type_name -> controller.v1.PolicySigningKey
+	0, // 1: controller.v1.EnrollmentService.EnrollConnector:input_type -> controller.v1.EnrollRequest
+	0, // 2: controller.v1.EnrollmentService.EnrollTunneler:input_type -> controller.v1.EnrollRequest
+	0, // 3: controller.v1.EnrollmentService.Renew:input_type -> controller.v1.EnrollRequest
+	3, // 4: controller.v1.ControlPlane.Connect:input_type -> controller.v1.ControlMessage
+	4, // 5: controller.v1.ControlPlane.Tunnel:input_type -> controller.v1.TunnelFrame
+	1, // 6: controller.v1.EnrollmentService.EnrollConnector:output_type -> controller.v1.EnrollResponse
+	1, // 7: controller.v1.EnrollmentService.EnrollTunneler:output_type -> controller.v1.EnrollResponse
+	1, // 8: controller.v1.EnrollmentService.Renew:output_type -> controller.v1.EnrollResponse
+	3, // 9: controller.v1.ControlPlane.Connect:output_type -> controller.v1.ControlMessage
+	4, // 10: controller.v1.ControlPlane.Tunnel:output_type -> controller.v1.TunnelFrame
+	6, // [6:11] is the sub-list for method output_type
+	1, // [1:6] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_controller_proto_init() }
@@ -300,7 +517,7 @@ func file_controller_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_controller_proto_rawDesc), len(file_controller_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   2,
 		},
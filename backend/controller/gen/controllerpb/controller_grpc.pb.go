@@ -198,6 +198,7 @@ var EnrollmentService_ServiceDesc = grpc.ServiceDesc{
 
 const (
 	ControlPlane_Connect_FullMethodName = "/controller.v1.ControlPlane/Connect"
+	ControlPlane_Tunnel_FullMethodName  = "/controller.v1.ControlPlane/Tunnel"
 )
 
 // ControlPlaneClient is the client API for ControlPlane service.
@@ -205,6 +206,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ControlPlaneClient interface {
 	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlMessage, ControlMessage], error)
+	Tunnel(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TunnelFrame, TunnelFrame], error)
 }
 
 type controlPlaneClient struct {
@@ -228,11 +230,25 @@ func (c *controlPlaneClient) Connect(ctx context.Context, opts ...grpc.CallOptio
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ControlPlane_ConnectClient = grpc.BidiStreamingClient[ControlMessage, ControlMessage]
 
+func (c *controlPlaneClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TunnelFrame, TunnelFrame], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ControlPlane_ServiceDesc.Streams[1], ControlPlane_Tunnel_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TunnelFrame, TunnelFrame]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlPlane_TunnelClient = grpc.BidiStreamingClient[TunnelFrame, TunnelFrame]
+
 // ControlPlaneServer is the server API for ControlPlane service.
 // All implementations must embed UnimplementedControlPlaneServer
 // for forward compatibility.
 type ControlPlaneServer interface {
 	Connect(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error
+	Tunnel(grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]) error
 	mustEmbedUnimplementedControlPlaneServer()
 }
 
@@ -246,6 +262,9 @@ type UnimplementedControlPlaneServer struct{}
 func (UnimplementedControlPlaneServer) Connect(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error {
 	return status.Error(codes.Unimplemented, "method Connect not implemented")
 }
+func (UnimplementedControlPlaneServer) Tunnel(grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]) error {
+	return status.Error(codes.Unimplemented, "method Tunnel not implemented")
+}
 func (UnimplementedControlPlaneServer) mustEmbedUnimplementedControlPlaneServer() {}
 func (UnimplementedControlPlaneServer) testEmbeddedByValue()                      {}
 
@@ -274,6 +293,13 @@ func _ControlPlane_Connect_Handler(srv interface{}, stream grpc.ServerStream) er
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ControlPlane_ConnectServer = grpc.BidiStreamingServer[ControlMessage, ControlMessage]
 
+func _ControlPlane_Tunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ControlPlaneServer).Tunnel(&grpc.GenericServerStream[TunnelFrame, TunnelFrame]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlPlane_TunnelServer = grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]
+
 // ControlPlane_ServiceDesc is the grpc.ServiceDesc for ControlPlane service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -288,6 +314,12 @@ var ControlPlane_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Tunnel",
+			Handler:       _ControlPlane_Tunnel_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "controller.proto",
 }
@@ -0,0 +1,283 @@
+// Package identity stores the key, certificate chain and CA a connector or
+// tunneler enrolled with, shared so both keep their identity files the same
+// way.
+package identity
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	blockPrivateKey          = "PRIVATE KEY"
+	blockEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	blockCertificate         = "CERTIFICATE"
+
+	purposeHeader = "Purpose"
+	purposeCA     = "ca"
+
+	kdfName       = "pbkdf2-sha256"
+	kdfIterations = 600000
+	aeadLabel     = "ztna-identity-v1"
+)
+
+// ErrWrongPassphrase is returned when an encrypted key cannot be decrypted.
+var ErrWrongPassphrase = errors.New("identity: wrong passphrase or corrupted key")
+
+// Identity is a workload's key, issued certificate chain and trusted CA.
+type Identity struct {
+	Certificate tls.Certificate
+	CertPEM     []byte
+	CAPEM       []byte
+	SPIFFEID    string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// Store persists an Identity as a single PEM file readable only by its owner.
+// When a passphrase is set the private key is encrypted with AES-256-GCM
+// under a PBKDF2-derived key; certificates are stored in the clear.
+type Store struct {
+	path       string
+	passphrase []byte
+}
+
+// NewStore returns a Store backed by path.
+func NewStore(path string, passphrase []byte) *Store {
+	return &Store{path: path, passphrase: passphrase}
+}
+
+// Path returns the identity file location.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the stored identity. A missing file yields an error matching
+// os.ErrNotExist.
+func (s *Store) Load() (Identity, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var keyDER []byte
+	var certPEM, caPEM []byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case blockPrivateKey:
+			keyDER = block.Bytes
+		case blockEncryptedPrivateKey:
+			keyDER, err = s.decryptKey(block)
+			if err != nil {
+				return Identity{}, err
+			}
+		case blockCertificate:
+			if block.Headers[purposeHeader] == purposeCA {
+				caPEM = append(caPEM, pem.EncodeToMemory(&pem.Block{Type: blockCertificate, Bytes: block.Bytes})...)
+			} else {
+				// The leaf followed by any intermediates.
+				certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: blockCertificate, Bytes: block.Bytes})...)
+			}
+		}
+	}
+	if keyDER == nil || len(certPEM) == 0 || len(caPEM) == 0 {
+		return Identity{}, fmt.Errorf("identity file %s is incomplete", s.path)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: blockPrivateKey, Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return Identity{}, fmt.Errorf("identity file %s: %w", s.path, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(leaf.URIs) != 1 {
+		return Identity{}, fmt.Errorf("identity file %s: certificate must contain exactly one SPIFFE ID", s.path)
+	}
+	return Identity{
+		Certificate: cert,
+		CertPEM:     certPEM,
+		CAPEM:       caPEM,
+		SPIFFEID:    leaf.URIs[0].String(),
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
+
+// Save atomically replaces the identity file with cert, certPEM and caPEM.
+func (s *Store) Save(cert tls.Certificate, certPEM, caPEM []byte) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("marshal private key: %w", err)
+	}
+
+	var buf bytes.Buffer
+	keyBlock := &pem.Block{Type: blockPrivateKey, Bytes: keyDER}
+	if len(s.passphrase) > 0 {
+		keyBlock, err = s.encryptKey(keyDER)
+		if err != nil {
+			return err
+		}
+	}
+	if err := pem.Encode(&buf, keyBlock); err != nil {
+		return err
+	}
+	certCount := 0
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != blockCertificate {
+			return errors.New("invalid certificate PEM")
+		}
+		certCount++
+		if err := pem.Encode(&buf, &pem.Block{Type: blockCertificate, Bytes: block.Bytes}); err != nil {
+			return err
+		}
+	}
+	if certCount == 0 {
+		return errors.New("invalid certificate PEM")
+	}
+	caCount := 0
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != blockCertificate {
+			continue
+		}
+		caCount++
+		if err := pem.Encode(&buf, &pem.Block{Type: blockCertificate, Headers: map[string]string{purposeHeader: purposeCA}, Bytes: block.Bytes}); err != nil {
+			return err
+		}
+	}
+	if caCount == 0 {
+		return errors.New("invalid CA PEM")
+	}
+
+	return writeFileAtomic(s.path, buf.Bytes())
+}
+
+// writeFileAtomic replaces path with data, readable only by the owner.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".identity-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Store) encryptKey(keyDER []byte) (*pem.Block, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := s.aead(salt, kdfIterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &pem.Block{
+		Type: blockEncryptedPrivateKey,
+		Headers: map[string]string{
+			"KDF":        kdfName,
+			"Iterations": strconv.Itoa(kdfIterations),
+			"Salt":       hex.EncodeToString(salt),
+			"Nonce":      hex.EncodeToString(nonce),
+		},
+		Bytes: gcm.Seal(nil, nonce, keyDER, []byte(aeadLabel)),
+	}, nil
+}
+
+func (s *Store) decryptKey(block *pem.Block) ([]byte, error) {
+	if len(s.passphrase) == 0 {
+		return nil, fmt.Errorf("identity file %s is encrypted but no passphrase is configured", s.path)
+	}
+	if block.Headers["KDF"] != kdfName {
+		return nil, fmt.Errorf("identity file %s: unsupported KDF %q", s.path, block.Headers["KDF"])
+	}
+	iterations, err := strconv.Atoi(block.Headers["Iterations"])
+	if err != nil || iterations <= 0 {
+		return nil, fmt.Errorf("identity file %s: invalid KDF iterations", s.path)
+	}
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("identity file %s: invalid salt", s.path)
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("identity file %s: invalid nonce", s.path)
+	}
+	gcm, err := s.aead(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("identity file %s: invalid nonce", s.path)
+	}
+	keyDER, err := gcm.Open(nil, nonce, block.Bytes, []byte(aeadLabel))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return keyDER, nil
+}
+
+func (s *Store) aead(salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(s.passphrase), salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
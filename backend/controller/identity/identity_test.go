@@ -0,0 +1,179 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestIdentity(t *testing.T) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	uri, _ := url.Parse("spiffe://mycorp.internal/connector/con_1")
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(30 * time.Minute),
+		URIs:         []*url.URL{uri},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caTmpl, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{leafDER}, PrivateKey: key}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return cert, certPEM, caPEM
+}
+
+func TestStoreRoundTripEncrypted(t *testing.T) {
+	cert, certPEM, caPEM := newTestIdentity(t)
+	path := filepath.Join(t.TempDir(), "identity.pem")
+
+	if err := NewStore(path, []byte("s3cret")).Save(cert, certPEM, caPEM); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	id, err := NewStore(path, []byte("s3cret")).Load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if id.SPIFFEID != "spiffe://mycorp.internal/connector/con_1" {
+		t.Fatalf("unexpected SPIFFE ID %q", id.SPIFFEID)
+	}
+	if string(id.CAPEM) != string(caPEM) || string(id.CertPEM) != string(certPEM) {
+		t.Fatalf("certificates did not round-trip")
+	}
+
+	if _, err := NewStore(path, []byte("wrong")).Load(); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected wrong passphrase error, got %v", err)
+	}
+	if _, err := NewStore(path, nil).Load(); err == nil {
+		t.Fatalf("expected error loading encrypted identity without passphrase")
+	}
+}
+
+func TestStoreRoundTripChain(t *testing.T) {
+	cert, leafPEM, caPEM := newTestIdentity(t)
+	// Stand in an intermediate after the leaf; the store does not verify it.
+	certPEM := append(append([]byte{}, leafPEM...), caPEM...)
+	path := filepath.Join(t.TempDir(), "identity.pem")
+
+	if err := NewStore(path, nil).Save(cert, certPEM, caPEM); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	id, err := NewStore(path, nil).Load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if string(id.CertPEM) != string(certPEM) || string(id.CAPEM) != string(caPEM) {
+		t.Fatalf("certificate chain did not round-trip")
+	}
+	if len(id.Certificate.Certificate) != 2 {
+		t.Fatalf("expected leaf and intermediate in TLS chain, got %d certificates", len(id.Certificate.Certificate))
+	}
+	if id.SPIFFEID != "spiffe://mycorp.internal/connector/con_1" {
+		t.Fatalf("unexpected SPIFFE ID %q", id.SPIFFEID)
+	}
+}
+
+func TestStoreLoadMissing(t *testing.T) {
+	_, err := NewStore(filepath.Join(t.TempDir(), "missing.pem"), nil).Load()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+}
+
+func TestStorePolicyKeysRoundTrip(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "identity.pem"), nil)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := store.SavePolicyKeys(PolicyKeys{"pk_1": pub}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	keys, err := store.LoadPolicyKeys()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(keys) != 1 || !keys["pk_1"].Equal(pub) {
+		t.Fatalf("policy keys did not round-trip: %v", keys)
+	}
+}
+
+func TestStoreSaveReplacesFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	store := NewStore(filepath.Join(dir, "identity.pem"), nil)
+	cert, certPEM, caPEM := newTestIdentity(t)
+	for i := 0; i < 2; i++ {
+		if err := store.Save(cert, certPEM, caPEM); err != nil {
+			t.Fatalf("save %d failed: %v", i, err)
+		}
+	}
+	if err := store.SavePolicyKeys(PolicyKeys{}); err != nil {
+		t.Fatalf("save policy keys failed: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "identity.pem" && e.Name() != policyKeysFile {
+			t.Fatalf("unexpected file %q left behind", e.Name())
+		}
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("%s: expected mode 0600, got %v", e.Name(), info.Mode().Perm())
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected the directory to be created with mode 0700: %v", err)
+	}
+	if _, err := store.Load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const policyKeysFile = "policy_keys.json"
+
+// PolicyKeys maps key IDs to the controller's policy verification keys,
+// which connectors keep next to their identity.
+type PolicyKeys map[string]ed25519.PublicKey
+
+type policyKeyEntry struct {
+	KeyID     string `json:"key_id"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// PolicyKeysPath returns the file the policy keys are kept in, next to the
+// identity file.
+func (s *Store) PolicyKeysPath() string {
+	return filepath.Join(filepath.Dir(s.path), policyKeysFile)
+}
+
+// LoadPolicyKeys reads the stored policy verification keys. A missing file
+// yields an error matching os.ErrNotExist.
+func (s *Store) LoadPolicyKeys() (PolicyKeys, error) {
+	data, err := os.ReadFile(s.PolicyKeysPath())
+	if err != nil {
+		return nil, err
+	}
+	var stored struct {
+		Keys []policyKeyEntry `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("policy keys file %s: %w", s.PolicyKeysPath(), err)
+	}
+	keys := make(PolicyKeys, len(stored.Keys))
+	for _, k := range stored.Keys {
+		if k.KeyID == "" || len(k.PublicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("policy keys file %s: invalid key %q", s.PolicyKeysPath(), k.KeyID)
+		}
+		keys[k.KeyID] = ed25519.PublicKey(k.PublicKey)
+	}
+	return keys, nil
+}
+
+// SavePolicyKeys atomically replaces the stored policy verification keys.
+func (s *Store) SavePolicyKeys(keys PolicyKeys) error {
+	entries := make([]policyKeyEntry, 0, len(keys))
+	for id, pub := range keys {
+		entries = append(entries, policyKeyEntry{KeyID: id, PublicKey: pub})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].KeyID < entries[j].KeyID })
+	data, err := json.MarshalIndent(struct {
+		Keys []policyKeyEntry `json:"keys"`
+	}{Keys: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.PolicyKeysPath(), data)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"database/sql"
 	"encoding/pem"
 	"errors"
 	"log"
@@ -14,6 +16,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"controller/admin"
@@ -27,12 +30,24 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ca" {
+		if err := runCACommand(os.Args[2:]); err != nil {
+			log.Fatalf("ca: %v", err)
+		}
+		return
+	}
+
 	// ---- required environment variables ----
 	caCertPEM := []byte(os.Getenv("INTERNAL_CA_CERT"))
 	caKeyPEM := []byte(os.Getenv("INTERNAL_CA_KEY"))
-	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
+	// With an external signer the controller never holds the CA key.
+	caSignerSocket := strings.TrimSpace(os.Getenv("INTERNAL_CA_SIGNER_SOCKET"))
+	if len(caCertPEM) == 0 || (len(caKeyPEM) == 0 && caSignerSocket == "") {
 		caCertPEM, caKeyPEM = loadCAFromFiles(caCertPEM, caKeyPEM)
 	}
+	if caSignerSocket != "" {
+		caKeyPEM = nil
+	}
 	trustDomain := os.Getenv("TRUST_DOMAIN")
 	if trustDomain == "" {
 		trustDomain = "mycorp.internal"
@@ -43,23 +58,24 @@ func main() {
 		adminAddr = ":8081"
 	}
 	adminAuthToken := os.Getenv("ADMIN_AUTH_TOKEN")
+	adminTokens := parseAdminTokens(os.Getenv("ADMIN_AUTH_TOKENS"))
 	internalAuthToken := os.Getenv("INTERNAL_API_TOKEN")
-	policySigningKey := os.Getenv("POLICY_SIGNING_KEY")
-	if policySigningKey == "" {
-		policySigningKey = internalAuthToken
-	}
 	policyTTL := 10 * time.Minute
 	if v := strings.TrimSpace(os.Getenv("POLICY_SNAPSHOT_TTL_SECONDS")); v != "" {
 		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
 			policyTTL = time.Duration(secs) * time.Second
 		}
 	}
+	roleTTLs := map[string]time.Duration{
+		"connector": envSeconds("CERT_TTL_CONNECTOR_SECONDS"),
+		"tunneler":  envSeconds("CERT_TTL_TUNNELER_SECONDS"),
+	}
 	tokenStorePath := os.Getenv("TOKEN_STORE_PATH")
 	if tokenStorePath == "" {
 		tokenStorePath = "/var/lib/grpccontroller/tokens.json"
 	}
 
-	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
+	if len(caCertPEM) == 0 || (len(caKeyPEM) == 0 && caSignerSocket == "") {
 		log.Fatal("INTERNAL_CA_CERT or INTERNAL_CA_KEY is not set and ca/ca.crt+ca/ca.key not found")
 	}
 	if adminAuthToken == "" {
@@ -70,44 +86,78 @@ func main() {
 	}
 
 	// ---- load internal CA ----
-	caInst, err := ca.LoadCA(caCertPEM, caKeyPEM)
+	var caInst *ca.CA
+	var err error
+	if caSignerSocket != "" {
+		caInst, err = loadRemoteCA(caCertPEM, caSignerSocket)
+	} else {
+		caInst, err = ca.LoadCA(caCertPEM, caKeyPEM)
+	}
 	if err != nil {
 		log.Fatalf("failed to load internal CA: %v", err)
 	}
 
-	// ---- load or issue controller TLS certificate ----
-	controllerTLSCert, err := loadOrIssueControllerCert(caInst, trustDomain)
+	db, err := state.OpenSQLite(os.Getenv("DB_PATH"))
 	if err != nil {
-		log.Fatalf("failed to prepare controller TLS cert: %v", err)
+		log.Fatalf("failed to open sqlite db: %v", err)
 	}
+	defer db.Close()
+
+	// ---- internal CA rotation state ----
+	caKeySealer, err := loadCAKeySealer()
+	if err != nil {
+		log.Fatalf("failed to load CA key encryption key: %v", err)
+	}
+	caManager, err := api.NewCAManager(db, caInst, caCertPEM, caKeySealer)
+	if err != nil {
+		log.Fatalf("failed to load internal CA state: %v", err)
+	}
+	caManager.ExternalSigner = caSignerSocket != ""
+	certTTLs := api.NewTTLPolicy(db, roleTTLs, envSeconds("CERT_MAX_TTL_SECONDS"))
+	caManager.RetireDelay = certTTLs.Longest
 
-	// ---- build CA pool ----
-	caPool := x509.NewCertPool()
-	if !caPool.AppendCertsFromPEM(caCertPEM) {
-		log.Fatal("failed to append internal CA cert to pool")
+	// ---- load or issue controller TLS certificate ----
+	controllerCert := &certHolder{maxTTL: certTTLs.Max()}
+	if err := controllerCert.issue(db, caManager.Issuer(), trustDomain, false); err != nil {
+		log.Fatalf("failed to prepare controller TLS cert: %v", err)
 	}
 
 	// ---- TLS config (mTLS enforced) ----
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{controllerTLSCert},
-		ClientCAs:    caPool,
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		MinVersion:   tls.VersionTLS13,
+		GetCertificate: controllerCert.get,
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+		MinVersion:     tls.VersionTLS13,
+	}
+	// Resolve client CAs per handshake so the trust bundle follows CA
+	// rotation.
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := tlsConfig.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = caManager.Pool()
+		return cfg, nil
 	}
 
 	creds := credentials.NewTLS(tlsConfig)
 
-	db, err := state.OpenSQLite(os.Getenv("DB_PATH"))
+	policySigner, err := api.NewPolicySigner(db, caKeySealer)
 	if err != nil {
-		log.Fatalf("failed to open sqlite db: %v", err)
+		log.Fatalf("failed to load policy signing keys: %v", err)
+	}
+
+	revocations := state.NewRevocationStore(db)
+	if err := revocations.Load(); err != nil {
+		log.Fatalf("failed to load revocations: %v", err)
 	}
-	defer db.Close()
 
 	registry := state.NewRegistry()
 	tunnelerRegistry := state.NewTunnelerRegistry()
 	tunnelerStatus := state.NewTunnelerStatusRegistry()
 	aclStore := state.NewACLStoreWithDB(db)
-	tokenStore := state.NewTokenStoreWithDB(0, db)
+	tokenTTL := envSeconds("ENROLLMENT_TOKEN_TTL_SECONDS")
+	if tokenTTL == 0 {
+		tokenTTL = defaultTokenTTL
+	}
+	tokenStore := state.NewTokenStoreWithDB(tokenTTL, db)
 	userStore := state.NewUserStore(db)
 	remoteNetStore := state.NewRemoteNetworkStore(db)
 
@@ -117,15 +167,25 @@ func main() {
 		grpc.UnaryInterceptor(api.UnaryAuthInterceptor(trustDomain, map[string]struct{}{
 			controllerpb.EnrollmentService_EnrollConnector_FullMethodName: {},
 			controllerpb.EnrollmentService_EnrollTunneler_FullMethodName:  {},
-		}, "connector", "tunneler")),
-		grpc.StreamInterceptor(api.StreamSPIFFEInterceptor(trustDomain, "connector", "tunneler")),
+		}, revocations, registry, "connector", "tunneler")),
+		grpc.StreamInterceptor(api.StreamSPIFFEInterceptor(trustDomain, revocations, registry, "connector", "tunneler")),
 	)
 
-	controlPlaneServer := api.NewControlPlaneServer(trustDomain, registry, tunnelerRegistry, tunnelerStatus, aclStore, db, []byte(policySigningKey), policyTTL)
+	controlPlaneServer := api.NewControlPlaneServer(trustDomain, registry, tunnelerRegistry, tunnelerStatus, aclStore, db, policySigner, revocations, caManager, policyTTL)
 	_ = state.LoadConnectorsFromDB(db, registry)
 	_ = state.LoadTunnelersFromDB(db, tunnelerStatus)
 	_ = state.LoadACLsFromDB(db, aclStore)
 	controlPlaneServer.NotifyACLInit()
+	caManager.OnChange(func() {
+		// Re-issue the controller certificate once a new CA is activated so
+		// it stays valid after the old CA is retired.
+		if err := controllerCert.issue(db, caManager.Issuer(), trustDomain, false); err != nil {
+			log.Printf("failed to re-issue controller TLS cert: %v", err)
+		}
+		controlPlaneServer.NotifyCABundle()
+	})
+	go controlPlaneServer.RunSnapshotRefresh(context.Background())
+	go controllerCert.renewalLoop(context.Background(), db, caManager, trustDomain)
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
 		defer ticker.Stop()
@@ -136,14 +196,36 @@ func main() {
 
 	// ---- enrollment service ----
 	enrollServer := api.NewEnrollmentServer(
-		caInst,
-		caCertPEM,
+		caManager,
 		trustDomain, // SPIFFE trust domain (without scheme)
 		tokenStore,
 		registry,
 		controlPlaneServer,
+		policySigner,
+		db,
+		revocations,
+		certTTLs,
 	)
 
+	// Bare public keys without a CSR are deprecated; set ENROLL_REQUIRE_CSR
+	// once every workload sends one.
+	enrollServer.RequireCSR, _ = strconv.ParseBool(strings.TrimSpace(os.Getenv("ENROLL_REQUIRE_CSR")))
+	enrollServer.RemoteNetworks = remoteNetStore
+	// Connectors with a machine certificate from this bundle can enroll
+	// without a token.
+	if path := strings.TrimSpace(os.Getenv("ATTEST_X509POP_CA_BUNDLE")); path != "" {
+		bundle, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("failed to read ATTEST_X509POP_CA_BUNDLE: %v", err)
+		}
+		attestor, err := api.NewX509PoPAttestor(bundle, remoteNetStore)
+		if err != nil {
+			log.Fatalf("invalid ATTEST_X509POP_CA_BUNDLE: %v", err)
+		}
+		enrollServer.Attestors[api.AttestX509PoP] = attestor
+		log.Printf("x509pop attestation enabled with machine CA bundle %s", path)
+	}
+
 	controllerpb.RegisterEnrollmentServiceServer(grpcServer, enrollServer)
 	controllerpb.RegisterControlPlaneServer(grpcServer, controlPlaneServer)
 
@@ -158,9 +240,18 @@ func main() {
 		Users:             userStore,
 		RemoteNet:         remoteNetStore,
 		StreamChecker:     controlPlaneServer,
+		Disconnector:      controlPlaneServer,
+		PolicyKeys:        controlPlaneServer,
+		Revocations:       revocations,
+		RevocationNotify:  controlPlaneServer,
+		TunnelerNotify:    controlPlaneServer,
+		CAs:               caManager,
+		ControllerCert:    controllerCert,
+		CertTTLs:          certTTLs,
+		TrustDomain:       trustDomain,
 		AdminAuthToken:    adminAuthToken,
+		AdminTokens:       adminTokens,
 		InternalAuthToken: internalAuthToken,
-		CACertPEM:         caCertPEM,
 	}
 	adminServer.RegisterRoutes(adminMux)
 	go func() {
@@ -183,6 +274,148 @@ func main() {
 	}
 }
 
+// defaultTokenTTL is how long an enrollment token is valid unless the admin
+// API or ENROLLMENT_TOKEN_TTL_SECONDS says otherwise.
+const defaultTokenTTL = 24 * time.Hour
+
+// controllerCertTTL is the lifetime of an issued controller certificate.
+const controllerCertTTL = 12 * time.Hour
+
+// certHolder serves the controller's TLS certificate and replaces it before
+// it expires or when the issuing CA changes.
+type certHolder struct {
+	issueMu sync.Mutex // serializes issue
+	mu      sync.RWMutex
+	cert    *tls.Certificate // replaced, never modified, so handshakes keep a stable copy
+	leaf    *x509.Certificate
+	issuer  *x509.Certificate
+	maxTTL  time.Duration // caps controllerCertTTL; unset means no cap
+}
+
+func (h *certHolder) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cert, nil
+}
+
+// issue loads or issues the controller certificate from issuer unless the
+// current one already came from it and force is unset. A certificate
+// supplied through CONTROLLER_CERT is never replaced.
+func (h *certHolder) issue(db *sql.DB, issuer *ca.CA, trustDomain string, force bool) error {
+	h.issueMu.Lock()
+	defer h.issueMu.Unlock()
+	supplied := len(os.Getenv("CONTROLLER_CERT")) > 0
+	h.mu.RLock()
+	current := h.issuer
+	h.mu.RUnlock()
+	if current != nil && (supplied || (!force && current.Equal(issuer.Cert))) {
+		return nil
+	}
+	cert, err := loadOrIssueControllerCert(issuer, trustDomain, h.maxTTL)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !supplied {
+		_ = state.RecordIssuedCertificate(db, leaf, state.IssuedViaController, state.KeyProofLocal)
+	}
+	h.mu.Lock()
+	h.cert = &cert
+	h.leaf = leaf
+	h.issuer = issuer.Cert
+	h.mu.Unlock()
+	log.Printf("controller TLS certificate serial=%s expires %s (in %s)", leaf.SerialNumber, leaf.NotAfter.UTC().Format(time.RFC3339), time.Until(leaf.NotAfter).Round(time.Minute))
+	return nil
+}
+
+// renewalLoop re-issues the controller certificate once 70% of its lifetime
+// has elapsed, like the workload renewal loop, retrying every minute on
+// failure. A supplied certificate cannot be renewed; its expiry is only
+// logged.
+func (h *certHolder) renewalLoop(ctx context.Context, db *sql.DB, cas *api.CAManager, trustDomain string) {
+	supplied := len(os.Getenv("CONTROLLER_CERT")) > 0
+	for {
+		h.mu.RLock()
+		leaf := h.leaf
+		h.mu.RUnlock()
+		next := renewAt(leaf)
+		renewWindow := leaf.NotAfter.Sub(next)
+		if supplied {
+			next = time.Now().Add(time.Hour)
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		remaining := time.Until(leaf.NotAfter)
+		if supplied {
+			if remaining < renewWindow {
+				log.Printf("WARNING: CONTROLLER_CERT expires in %s and is not renewed automatically", remaining.Round(time.Minute))
+			}
+			continue
+		}
+		if err := h.issue(db, cas.Issuer(), trustDomain, true); err != nil {
+			log.Printf("controller TLS certificate renewal failed (expires in %s): %v", remaining.Round(time.Second), err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+}
+
+// renewAt returns when leaf is due for renewal: once 70% of its lifetime has
+// elapsed.
+func renewAt(leaf *x509.Certificate) time.Time {
+	return leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 70 / 100)
+}
+
+// ControllerCertStatus reports the current certificate for the admin API.
+func (h *certHolder) ControllerCertStatus() admin.ControllerCertStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.leaf == nil {
+		return admin.ControllerCertStatus{}
+	}
+	status := admin.ControllerCertStatus{
+		Serial:           h.leaf.SerialNumber.String(),
+		Issuer:           h.leaf.Issuer.String(),
+		NotBefore:        h.leaf.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:         h.leaf.NotAfter.UTC().Format(time.RFC3339),
+		RemainingSeconds: int64(time.Until(h.leaf.NotAfter).Seconds()),
+		DNSNames:         h.leaf.DNSNames,
+		Supplied:         len(os.Getenv("CONTROLLER_CERT")) > 0,
+	}
+	if len(h.leaf.URIs) == 1 {
+		status.SPIFFEID = h.leaf.URIs[0].String()
+	}
+	return status
+}
+
+// loadRemoteCA pairs the CA certificate with a key held by the signer
+// listening on socketPath. INTERNAL_CA_KEY_ID selects the key (default "ca").
+func loadRemoteCA(certPEM []byte, socketPath string) (*ca.CA, error) {
+	keyID := strings.TrimSpace(os.Getenv("INTERNAL_CA_KEY_ID"))
+	if keyID == "" {
+		keyID = "ca"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	signer, err := ca.NewRemoteSigner(ctx, ca.NewSocketKeyService(socketPath), keyID)
+	if err != nil {
+		return nil, err
+	}
+	return ca.LoadCAWithSigner(certPEM, signer)
+}
+
 func loadCAFromFiles(certPEM, keyPEM []byte) ([]byte, []byte) {
 	certPath := "ca/ca.crt"
 	keyPath := "ca/ca.pkcs8.key"
@@ -200,13 +433,67 @@ func loadCAFromFiles(certPEM, keyPEM []byte) ([]byte, []byte) {
 	return certPEM, keyPEM
 }
 
+// loadCAKeySealer returns the sealer for CA keys and policy signing seeds
+// stored in the database, from
+// INTERNAL_CA_KEY_ENCRYPTION_KEY or the file named by
+// INTERNAL_CA_KEY_ENCRYPTION_KEY_FILE (base64, 32 bytes). It returns nil when
+// neither is set.
+func loadCAKeySealer() (*ca.KeySealer, error) {
+	encoded := os.Getenv("INTERNAL_CA_KEY_ENCRYPTION_KEY")
+	if path := strings.TrimSpace(os.Getenv("INTERNAL_CA_KEY_ENCRYPTION_KEY_FILE")); encoded == "" && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	if strings.TrimSpace(encoded) == "" {
+		return nil, nil
+	}
+	return ca.ParseKeySealer(encoded)
+}
+
+// parseAdminTokens parses ADMIN_AUTH_TOKENS, a comma-separated list of
+// name=token pairs, into a map from token to admin name.
+func parseAdminTokens(v string) map[string]string {
+	out := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			log.Printf("ignoring invalid ADMIN_AUTH_TOKENS entry; expected name=token")
+			continue
+		}
+		out[token] = name
+	}
+	return out
+}
+
+// envSeconds parses a positive number of seconds from key, or returns 0.
+func envSeconds(key string) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return 0
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		log.Printf("ignoring invalid %s=%q", key, v)
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
 func normalizeTrustDomain(v string) string {
 	v = strings.TrimSpace(v)
 	v = strings.TrimSuffix(v, ".")
 	return v
 }
 
-func loadOrIssueControllerCert(caInst *ca.CA, trustDomain string) (tls.Certificate, error) {
+func loadOrIssueControllerCert(caInst *ca.CA, trustDomain string, maxTTL time.Duration) (tls.Certificate, error) {
 	controllerCertPEM := []byte(os.Getenv("CONTROLLER_CERT"))
 	controllerKeyPEM := []byte(os.Getenv("CONTROLLER_KEY"))
 	if len(controllerCertPEM) > 0 && len(controllerKeyPEM) > 0 {
@@ -267,18 +554,32 @@ func loadOrIssueControllerCert(caInst *ca.CA, trustDomain string) (tls.Certifica
 		}
 	}
 
-	certPEM, err := ca.IssueWorkloadCert(caInst, spiffeID, &privKey.PublicKey, 12*time.Hour, dnsNames, ipAddrs)
+	ttl := controllerCertTTL
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	certPEM, err := ca.IssueWorkloadCert(caInst, spiffeID, &privKey.PublicKey, ttl, dnsNames, ipAddrs)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
 
-	block, _ := pem.Decode(certPEM)
-	if block == nil || block.Type != "CERTIFICATE" {
+	// The leaf is followed by any intermediates so peers can verify it
+	// against the root alone.
+	var chain [][]byte
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(chain) == 0 {
 		return tls.Certificate{}, errors.New("failed to decode controller certificate")
 	}
 
 	return tls.Certificate{
-		Certificate: [][]byte{block.Bytes},
+		Certificate: chain,
 		PrivateKey:  privKey,
 	}, nil
 }
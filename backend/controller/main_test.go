@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/api"
+	"controller/ca"
+	"controller/state"
+)
+
+func TestRenewAtSeventyPercent(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	leaf := &x509.Certificate{NotBefore: start, NotAfter: start.Add(10 * time.Hour)}
+	if got, want := renewAt(leaf), start.Add(7*time.Hour); !got.Equal(want) {
+		t.Fatalf("expected renewal at %s, got %s", want, got)
+	}
+}
+
+// newTestCertHolder returns a holder serving a certificate from issuer valid
+// from notBefore to notAfter.
+func newTestCertHolder(t *testing.T, issuer *ca.CA, notBefore, notAfter time.Time) *certHolder {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, _ := x509.ParseCertificate(issuer.Cert.Raw)
+	tmpl.NotBefore, tmpl.NotAfter = notBefore, notAfter
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer.Cert, &key.PublicKey, issuer.Key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &certHolder{
+		cert:   &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		leaf:   leaf,
+		issuer: issuer.Cert,
+	}
+}
+
+func newTestCAManager(t *testing.T) (*api.CAManager, *ca.CA) {
+	t.Helper()
+	t.Setenv("CONTROLLER_CERT", "")
+	db, err := state.OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("test-ca", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cas, err := api.NewCAManager(db, issuer, certPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cas, issuer
+}
+
+func servedSerial(t *testing.T, h *certHolder) string {
+	t.Helper()
+	cert, err := h.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.String()
+}
+
+func TestCertHolderRenewsPastSeventyPercent(t *testing.T) {
+	cas, issuer := newTestCAManager(t)
+	now := time.Now()
+	// 75% of the lifetime has passed.
+	h := newTestCertHolder(t, issuer, now.Add(-75*time.Minute), now.Add(25*time.Minute))
+	old := servedSerial(t, h)
+	inUse, _ := h.get(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.renewalLoop(ctx, nil, cas, "example.internal")
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, h) == old {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaf, _ := x509.ParseCertificate(inUse.Certificate[0]); leaf.SerialNumber.String() != old {
+		t.Fatal("renewal modified a certificate already handed to a handshake")
+	}
+	status := h.ControllerCertStatus()
+	if status.SPIFFEID != "spiffe://example.internal/controller/default" {
+		t.Fatalf("unexpected renewed identity %q", status.SPIFFEID)
+	}
+	if status.RemainingSeconds < int64((controllerCertTTL - time.Minute).Seconds()) {
+		t.Fatalf("expected a fresh %s certificate, %ds remaining", controllerCertTTL, status.RemainingSeconds)
+	}
+}
+
+func TestCertHolderKeepsCertBeforeSeventyPercent(t *testing.T) {
+	cas, issuer := newTestCAManager(t)
+	now := time.Now()
+	// 50% of the lifetime has passed.
+	h := newTestCertHolder(t, issuer, now.Add(-50*time.Minute), now.Add(50*time.Minute))
+	old := servedSerial(t, h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	h.renewalLoop(ctx, nil, cas, "example.internal")
+	if servedSerial(t, h) != old {
+		t.Fatal("certificate renewed before 70% of its lifetime")
+	}
+}
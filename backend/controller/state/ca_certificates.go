@@ -0,0 +1,89 @@
+package state
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Internal CA rotation states. A staged CA is trusted but does not issue; the
+// active CA issues; the previous CA stays trusted until it is retired.
+const (
+	CAStaged   = "staged"
+	CAActive   = "active"
+	CAPrevious = "previous"
+	CARetired  = "retired"
+)
+
+// CACertificate is an internal CA known to the controller. KeyPEM is the key
+// sealed by ca.KeySealer, and empty for the CA loaded from INTERNAL_CA_KEY,
+// whose key is never stored.
+type CACertificate struct {
+	ID          string
+	CertPEM     []byte
+	KeyPEM      []byte
+	Status      string
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+}
+
+// LoadCACertificates returns all known CAs, oldest first.
+func LoadCACertificates(db *sql.DB) ([]CACertificate, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query(`SELECT id, cert_pem, key_pem, status, created_at, activated_at, retired_at FROM ca_certificates ORDER BY created_at ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []CACertificate
+	for rows.Next() {
+		var (
+			c                      CACertificate
+			certPEM                string
+			keyPEM                 []byte
+			createdAt              int64
+			activatedAt, retiredAt sql.NullInt64
+		)
+		if err := rows.Scan(&c.ID, &certPEM, &keyPEM, &c.Status, &createdAt, &activatedAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		c.CertPEM = []byte(certPEM)
+		c.KeyPEM = keyPEM
+		c.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if activatedAt.Valid {
+			c.ActivatedAt = time.Unix(activatedAt.Int64, 0).UTC()
+		}
+		if retiredAt.Valid {
+			c.RetiredAt = time.Unix(retiredAt.Int64, 0).UTC()
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SaveCACertificate inserts or updates a CA.
+func SaveCACertificate(db *sql.DB, c CACertificate) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`INSERT INTO ca_certificates (id, cert_pem, key_pem, status, created_at, activated_at, retired_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET key_pem=COALESCE(excluded.key_pem, key_pem), status=excluded.status, activated_at=excluded.activated_at, retired_at=excluded.retired_at`,
+		c.ID, string(c.CertPEM), nullBytes(c.KeyPEM), c.Status, c.CreatedAt.UTC().Unix(), nullUnix(c.ActivatedAt), nullUnix(c.RetiredAt))
+	return err
+}
+
+func nullBytes(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func nullUnix(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Unix()
+}
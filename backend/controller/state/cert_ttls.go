@@ -0,0 +1,82 @@
+package state
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CertTTLOverride is a certificate lifetime set for one workload identity,
+// replacing its role's default.
+type CertTTLOverride struct {
+	SPIFFEID  string
+	TTL       time.Duration
+	UpdatedAt time.Time
+}
+
+// ListCertTTLOverrides returns all per-identity TTL overrides.
+func ListCertTTLOverrides(db *sql.DB) ([]CertTTLOverride, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query(`SELECT spiffe_id, ttl_seconds, updated_at FROM cert_ttl_overrides ORDER BY spiffe_id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []CertTTLOverride
+	for rows.Next() {
+		var (
+			o          CertTTLOverride
+			ttlSeconds int64
+			updatedAt  int64
+		)
+		if err := rows.Scan(&o.SPIFFEID, &ttlSeconds, &updatedAt); err != nil {
+			return nil, err
+		}
+		o.TTL = time.Duration(ttlSeconds) * time.Second
+		o.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// GetCertTTLOverride returns the TTL override for spiffeID, if any.
+func GetCertTTLOverride(db *sql.DB, spiffeID string) (time.Duration, bool, error) {
+	if db == nil {
+		return 0, false, nil
+	}
+	var ttlSeconds int64
+	err := db.QueryRow(`SELECT ttl_seconds FROM cert_ttl_overrides WHERE spiffe_id = ?`, spiffeID).Scan(&ttlSeconds)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(ttlSeconds) * time.Second, true, nil
+}
+
+// SetCertTTLOverride sets the certificate TTL for spiffeID.
+func SetCertTTLOverride(db *sql.DB, spiffeID string, ttl time.Duration) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`INSERT INTO cert_ttl_overrides (spiffe_id, ttl_seconds, updated_at) VALUES (?, ?, ?)
+ON CONFLICT(spiffe_id) DO UPDATE SET ttl_seconds=excluded.ttl_seconds, updated_at=excluded.updated_at`,
+		spiffeID, int64(ttl/time.Second), time.Now().UTC().Unix())
+	return err
+}
+
+// DeleteCertTTLOverride removes the override for spiffeID. It reports whether
+// one existed.
+func DeleteCertTTLOverride(db *sql.DB, spiffeID string) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	res, err := db.Exec(`DELETE FROM cert_ttl_overrides WHERE spiffe_id = ?`, spiffeID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
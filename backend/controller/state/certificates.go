@@ -0,0 +1,170 @@
+package state
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How a certificate was issued.
+const (
+	IssuedViaEnroll     = "enroll"
+	IssuedViaRenew      = "renew"
+	IssuedViaController = "controller"
+)
+
+// How the requester proved possession of the certified key.
+const (
+	// KeyProofCSR: a PKCS#10 request signed by the key.
+	KeyProofCSR = "csr"
+	// KeyProofNone: a bare public key, accepted during the CSR deprecation
+	// window.
+	KeyProofNone = "none"
+	// KeyProofLocal: a key the controller generated itself.
+	KeyProofLocal = "local"
+)
+
+// IssuedCertificate is an inventory entry for a certificate signed by the
+// internal CA. Serials are decimal.
+type IssuedCertificate struct {
+	Serial          string    `json:"serial"`
+	SPIFFEID        string    `json:"spiffe_id"`
+	Role            string    `json:"role"`
+	PublicKeySHA256 string    `json:"public_key_sha256"`
+	NotBefore       time.Time `json:"not_before"`
+	NotAfter        time.Time `json:"not_after"`
+	IssuedAt        time.Time `json:"issued_at"`
+	IssuedVia       string    `json:"issued_via"`
+	KeyAlgorithm    string    `json:"key_algorithm"`
+	KeyProof        string    `json:"key_proof"`
+	Revoked         bool      `json:"revoked"`
+}
+
+// CertificateFilter narrows ListIssuedCertificates. Zero fields match all.
+type CertificateFilter struct {
+	// Identity is a full SPIFFE ID or a bare workload ID.
+	Identity      string
+	Role          string
+	KeyProof      string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	Limit         int
+}
+
+// RecordIssuedCertificate adds cert to the inventory. keyProof is one of the
+// KeyProof constants.
+func RecordIssuedCertificate(db *sql.DB, cert *x509.Certificate, issuedVia, keyProof string) error {
+	if db == nil || cert == nil || len(cert.URIs) != 1 {
+		return nil
+	}
+	spiffeID := cert.URIs[0].String()
+	role := ""
+	if parts := strings.Split(strings.TrimPrefix(cert.URIs[0].Path, "/"), "/"); len(parts) == 2 {
+		role = parts[0]
+	}
+	fp := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	_, err := db.Exec(`INSERT OR REPLACE INTO issued_certificates (serial, spiffe_id, role, public_key_sha256, not_before, not_after, issued_at, issued_via, key_algorithm, key_proof)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cert.SerialNumber.String(), spiffeID, role, hex.EncodeToString(fp[:]),
+		cert.NotBefore.UTC().Unix(), cert.NotAfter.UTC().Unix(), time.Now().UTC().Unix(), issuedVia,
+		KeyAlgorithm(cert.PublicKey), keyProof)
+	return err
+}
+
+// ListIssuedCertificates returns matching certificates, newest first.
+func ListIssuedCertificates(db *sql.DB, filter CertificateFilter) ([]IssuedCertificate, error) {
+	out := []IssuedCertificate{}
+	if db == nil {
+		return out, nil
+	}
+	query := `SELECT c.serial, c.spiffe_id, c.role, c.public_key_sha256, c.not_before, c.not_after, c.issued_at, c.issued_via, c.key_algorithm, c.key_proof, r.value IS NOT NULL
+FROM issued_certificates c
+LEFT JOIN revocations r ON r.kind = ? AND r.value = c.serial
+WHERE 1=1`
+	args := []interface{}{RevokeSerial}
+	if id := strings.TrimSpace(filter.Identity); id != "" {
+		if strings.HasPrefix(id, "spiffe://") {
+			query += ` AND c.spiffe_id = ?`
+			args = append(args, id)
+		} else {
+			escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(id)
+			query += ` AND c.spiffe_id LIKE ? ESCAPE '\'`
+			args = append(args, "%/"+escaped)
+		}
+	}
+	if filter.Role != "" {
+		query += ` AND c.role = ?`
+		args = append(args, filter.Role)
+	}
+	if filter.KeyProof != "" {
+		query += ` AND c.key_proof = ?`
+		args = append(args, filter.KeyProof)
+	}
+	if !filter.ExpiresAfter.IsZero() {
+		query += ` AND c.not_after > ?`
+		args = append(args, filter.ExpiresAfter.Unix())
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		query += ` AND c.not_after <= ?`
+		args = append(args, filter.ExpiresBefore.Unix())
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	query += ` ORDER BY c.issued_at DESC, c.serial ASC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c                   IssuedCertificate
+			role, fp, issuedVia sql.NullString
+			keyAlg, keyProof    sql.NullString
+			notBefore           sql.NullInt64
+			notAfter, issuedAt  int64
+		)
+		if err := rows.Scan(&c.Serial, &c.SPIFFEID, &role, &fp, &notBefore, &notAfter, &issuedAt, &issuedVia, &keyAlg, &keyProof, &c.Revoked); err != nil {
+			return nil, err
+		}
+		c.Role = role.String
+		c.PublicKeySHA256 = fp.String
+		c.IssuedVia = issuedVia.String
+		c.KeyAlgorithm = keyAlg.String
+		c.KeyProof = keyProof.String
+		if notBefore.Valid && notBefore.Int64 > 0 {
+			c.NotBefore = time.Unix(notBefore.Int64, 0).UTC()
+		}
+		c.NotAfter = time.Unix(notAfter, 0).UTC()
+		c.IssuedAt = time.Unix(issuedAt, 0).UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// KeyAlgorithm names a public key's algorithm and size, e.g. "ECDSA-P-256",
+// "RSA-2048" or "Ed25519".
+func KeyAlgorithm(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + k.Curve.Params().Name
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "controller.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func recordTestCert(t *testing.T, db *sql.DB, serial int64, spiffeID string, issuedAt time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(spiffeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		URIs:         []*url.URL{uri},
+		PublicKey:    &key.PublicKey,
+		NotBefore:    issuedAt,
+		NotAfter:     issuedAt.Add(time.Hour),
+	}
+	if err := RecordIssuedCertificate(db, cert, IssuedViaEnroll, KeyProofCSR); err != nil {
+		t.Fatalf("record cert: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE issued_certificates SET issued_at = ? WHERE serial = ?`, issuedAt.Unix(), cert.SerialNumber.String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serials(certs []IssuedCertificate) []string {
+	out := make([]string, 0, len(certs))
+	for _, c := range certs {
+		out = append(out, c.Serial)
+	}
+	return out
+}
+
+func TestListIssuedCertificatesIdentityFilterEscapesLike(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	recordTestCert(t, db, 1, "spiffe://example.internal/connector/a_b", now)
+	recordTestCert(t, db, 2, "spiffe://example.internal/connector/axb", now)
+	recordTestCert(t, db, 3, "spiffe://example.internal/connector/a-b-c", now)
+	recordTestCert(t, db, 4, "spiffe://example.internal/tunneler/xa_b", now)
+
+	tests := []struct {
+		identity string
+		want     []string
+	}{
+		// _ and % are literal, and a bare ID matches the whole last path segment.
+		{"a_b", []string{"1"}},
+		{"a%", nil},
+		{"%", nil},
+		{"a-b-c", []string{"3"}},
+		{"spiffe://example.internal/connector/axb", []string{"2"}},
+		{"spiffe://example.internal/connector/a%", nil},
+	}
+	for _, tt := range tests {
+		certs, err := ListIssuedCertificates(db, CertificateFilter{Identity: tt.identity})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.identity, err)
+		}
+		got := serials(certs)
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("%s: expected %v, got %v", tt.identity, tt.want, got)
+		}
+	}
+}
+
+func TestListIssuedCertificatesLimitAndOrder(t *testing.T) {
+	db := openTestDB(t)
+	base := time.Now().Add(-time.Hour)
+	for i := int64(1); i <= 5; i++ {
+		recordTestCert(t, db, i, "spiffe://example.internal/connector/c1", base.Add(time.Duration(i)*time.Minute))
+	}
+
+	certs, err := ListIssuedCertificates(db, CertificateFilter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serials(certs); len(got) != 2 || got[0] != "5" || got[1] != "4" {
+		t.Fatalf("expected newest two [5 4], got %v", got)
+	}
+
+	for _, limit := range []int{0, -1, 5000} {
+		certs, err := ListIssuedCertificates(db, CertificateFilter{Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 5 {
+			t.Errorf("limit %d: expected default limit to return all 5, got %d", limit, len(certs))
+		}
+	}
+}
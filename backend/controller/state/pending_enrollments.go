@@ -0,0 +1,196 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Pending enrollment statuses.
+const (
+	EnrollmentPending  = "pending"
+	EnrollmentApproved = "approved"
+	EnrollmentDenied   = "denied"
+	// EnrollmentCompleted marks an approved request whose certificate has
+	// been issued.
+	EnrollmentCompleted = "enrolled"
+)
+
+// ErrEnrollmentDecided is returned when approving or denying a request that
+// is no longer pending.
+var ErrEnrollmentDecided = errors.New("enrollment request already decided")
+
+// PendingEnrollment is an enrollment request held for admin approval. It is
+// bound to the public key it was made with; only that key is certified once
+// the request is approved.
+type PendingEnrollment struct {
+	ID              string     `json:"id"`
+	Role            string     `json:"role"`
+	WorkloadID      string     `json:"workload_id"`
+	PublicKeyPEM    string     `json:"public_key_pem"`
+	PublicKeySHA256 string     `json:"public_key_sha256"`
+	KeyProof        string     `json:"key_proof"`
+	PrivateIP       string     `json:"private_ip,omitempty"`
+	Version         string     `json:"version,omitempty"`
+	SourceAddr      string     `json:"source_addr,omitempty"`
+	AttestationType string     `json:"attestation_type"`
+	AttestedBy      string     `json:"attested_by,omitempty"`
+	RemoteNetworkID string     `json:"remote_network_id,omitempty"`
+	Status          string     `json:"status"`
+	Reason          string     `json:"reason,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastSeenAt      time.Time  `json:"last_seen_at"`
+	DecidedAt       *time.Time `json:"decided_at,omitempty"`
+}
+
+const pendingEnrollmentColumns = `id, role, workload_id, public_key_pem, public_key_sha256, key_proof, private_ip, version, source_addr, attestation_type, attested_by, remote_network_id, status, reason, created_at, last_seen_at, decided_at`
+
+// CreatePendingEnrollment stores p as a new pending request, filling in its
+// ID, status and timestamps.
+func CreatePendingEnrollment(db *sql.DB, p *PendingEnrollment) error {
+	if db == nil {
+		return errors.New("db not configured")
+	}
+	now := time.Now().UTC()
+	p.ID = "enr_" + randHex(8)
+	p.Status = EnrollmentPending
+	p.CreatedAt = now
+	p.LastSeenAt = now
+	_, err := db.Exec(`INSERT INTO pending_enrollments (`+pendingEnrollmentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Role, p.WorkloadID, p.PublicKeyPEM, p.PublicKeySHA256, p.KeyProof, p.PrivateIP, p.Version, p.SourceAddr,
+		p.AttestationType, p.AttestedBy, p.RemoteNetworkID, p.Status, p.Reason, now.Unix(), now.Unix(), nil,
+	)
+	return err
+}
+
+// FindOpenEnrollment returns the latest request from role/workloadID with the
+// given key that has not completed, or nil.
+func FindOpenEnrollment(db *sql.DB, role, workloadID, keySHA256 string) (*PendingEnrollment, error) {
+	if db == nil {
+		return nil, errors.New("db not configured")
+	}
+	row := db.QueryRow(`SELECT `+pendingEnrollmentColumns+` FROM pending_enrollments
+WHERE role = ? AND workload_id = ? AND public_key_sha256 = ? AND status != ?
+ORDER BY created_at DESC, rowid DESC LIMIT 1`, role, workloadID, keySHA256, EnrollmentCompleted)
+	p, err := scanPendingEnrollment(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPendingEnrollment returns the request with id.
+func GetPendingEnrollment(db *sql.DB, id string) (*PendingEnrollment, error) {
+	if db == nil {
+		return nil, errors.New("db not configured")
+	}
+	row := db.QueryRow(`SELECT `+pendingEnrollmentColumns+` FROM pending_enrollments WHERE id = ?`, id)
+	return scanPendingEnrollment(row)
+}
+
+// ListPendingEnrollments returns requests, newest first, optionally only
+// those with status.
+func ListPendingEnrollments(db *sql.DB, status string) ([]PendingEnrollment, error) {
+	if db == nil {
+		return nil, nil
+	}
+	query := `SELECT ` + pendingEnrollmentColumns + ` FROM pending_enrollments`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC, rowid DESC`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PendingEnrollment{}
+	for rows.Next() {
+		p, err := scanPendingEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	return out, rows.Err()
+}
+
+// TouchPendingEnrollment records another poll of a request.
+func TouchPendingEnrollment(db *sql.DB, id, sourceAddr string) error {
+	if db == nil {
+		return errors.New("db not configured")
+	}
+	_, err := db.Exec(`UPDATE pending_enrollments SET last_seen_at = ?, source_addr = ? WHERE id = ?`, time.Now().UTC().Unix(), sourceAddr, id)
+	return err
+}
+
+// DecidePendingEnrollment approves or denies a pending request. It returns
+// sql.ErrNoRows for an unknown id and ErrEnrollmentDecided if the request is
+// not pending.
+func DecidePendingEnrollment(db *sql.DB, id string, approve bool, reason string) (*PendingEnrollment, error) {
+	if db == nil {
+		return nil, errors.New("db not configured")
+	}
+	status := EnrollmentDenied
+	if approve {
+		status = EnrollmentApproved
+	}
+	res, err := db.Exec(`UPDATE pending_enrollments SET status = ?, reason = ?, decided_at = ? WHERE id = ? AND status = ?`,
+		status, reason, time.Now().UTC().Unix(), id, EnrollmentPending)
+	if err != nil {
+		return nil, err
+	}
+	p, err := GetPendingEnrollment(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return p, ErrEnrollmentDecided
+	}
+	return p, nil
+}
+
+// CompletePendingEnrollment marks an approved request as enrolled so the
+// approval cannot be used again.
+func CompletePendingEnrollment(db *sql.DB, id string) error {
+	if db == nil {
+		return errors.New("db not configured")
+	}
+	_, err := db.Exec(`UPDATE pending_enrollments SET status = ? WHERE id = ? AND status = ?`, EnrollmentCompleted, id, EnrollmentApproved)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPendingEnrollment(row rowScanner) (*PendingEnrollment, error) {
+	var (
+		p                                                             PendingEnrollment
+		keyProof, privateIP, version, source, attType, attBy, network sql.NullString
+		reason                                                        sql.NullString
+		createdAt, lastSeenAt                                         int64
+		decidedAt                                                     sql.NullInt64
+	)
+	if err := row.Scan(&p.ID, &p.Role, &p.WorkloadID, &p.PublicKeyPEM, &p.PublicKeySHA256, &keyProof, &privateIP, &version, &source,
+		&attType, &attBy, &network, &p.Status, &reason, &createdAt, &lastSeenAt, &decidedAt); err != nil {
+		return nil, err
+	}
+	p.KeyProof = keyProof.String
+	p.PrivateIP = privateIP.String
+	p.Version = version.String
+	p.SourceAddr = source.String
+	p.AttestationType = attType.String
+	p.AttestedBy = attBy.String
+	p.RemoteNetworkID = network.String
+	p.Reason = reason.String
+	p.CreatedAt = time.Unix(createdAt, 0).UTC()
+	p.LastSeenAt = time.Unix(lastSeenAt, 0).UTC()
+	if decidedAt.Valid {
+		t := time.Unix(decidedAt.Int64, 0).UTC()
+		p.DecidedAt = &t
+	}
+	return &p, nil
+}
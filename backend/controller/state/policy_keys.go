@@ -0,0 +1,140 @@
+package state
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"controller/ca"
+)
+
+// PolicySigningKey is an Ed25519 key used to sign connector policy
+// snapshots. Retired keys are kept so their public halves can still be
+// published while connectors hold snapshots they signed.
+type PolicySigningKey struct {
+	KeyID      string
+	PrivateKey ed25519.PrivateKey
+	CreatedAt  time.Time
+	RetiredAt  time.Time
+}
+
+// Public returns the verification key.
+func (k PolicySigningKey) Public() ed25519.PublicKey {
+	return k.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+// LoadPolicySigningKeys returns all stored keys, newest first. Seeds sealed
+// with sealer are decrypted; seeds stored before a sealer was configured are
+// sealed in place once one is.
+func LoadPolicySigningKeys(db *sql.DB, sealer *ca.KeySealer) ([]PolicySigningKey, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query(`SELECT key_id, seed, created_at, retired_at FROM policy_signing_keys ORDER BY created_at DESC, key_id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []PolicySigningKey
+	var unsealed []PolicySigningKey
+	for rows.Next() {
+		var (
+			id        string
+			stored    []byte
+			createdAt int64
+			retiredAt sql.NullInt64
+		)
+		if err := rows.Scan(&id, &stored, &createdAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		seed, err := openPolicySeed(sealer, id, stored)
+		if err != nil {
+			return nil, fmt.Errorf("policy signing key %s: %w", id, err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("policy signing key %s: invalid seed length", id)
+		}
+		key := PolicySigningKey{
+			KeyID:      id,
+			PrivateKey: ed25519.NewKeyFromSeed(seed),
+			CreatedAt:  time.Unix(createdAt, 0).UTC(),
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = time.Unix(retiredAt.Int64, 0).UTC()
+		}
+		keys = append(keys, key)
+		if !ca.IsSealedKey(stored) {
+			unsealed = append(unsealed, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, key := range unsealed {
+		if sealer == nil {
+			log.Printf("policy signing key %s is stored unencrypted; set INTERNAL_CA_KEY_ENCRYPTION_KEY to encrypt it", key.KeyID)
+			continue
+		}
+		sealed, err := sealPolicySeed(sealer, key)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec(`UPDATE policy_signing_keys SET seed = ? WHERE key_id = ?`, sealed, key.KeyID); err != nil {
+			return nil, err
+		}
+		log.Printf("policy signing key %s encrypted in the database", key.KeyID)
+	}
+	return keys, nil
+}
+
+// SavePolicySigningKey inserts a new signing key, sealing its seed when
+// sealer is set.
+func SavePolicySigningKey(db *sql.DB, key PolicySigningKey, sealer *ca.KeySealer) error {
+	if db == nil {
+		return nil
+	}
+	seed := key.PrivateKey.Seed()
+	if sealer != nil {
+		sealed, err := sealPolicySeed(sealer, key)
+		if err != nil {
+			return err
+		}
+		seed = sealed
+	}
+	_, err := db.Exec(`INSERT INTO policy_signing_keys (key_id, seed, created_at) VALUES (?, ?, ?)`,
+		key.KeyID, seed, key.CreatedAt.UTC().Unix())
+	return err
+}
+
+// policySeedAAD binds a sealed seed to its key ID and keeps it apart from
+// sealed CA keys.
+func policySeedAAD(keyID string) string {
+	return "policy-signing-key:" + keyID
+}
+
+func sealPolicySeed(sealer *ca.KeySealer, key PolicySigningKey) ([]byte, error) {
+	return sealer.Seal(policySeedAAD(key.KeyID), key.PrivateKey.Seed())
+}
+
+func openPolicySeed(sealer *ca.KeySealer, keyID string, stored []byte) ([]byte, error) {
+	if !ca.IsSealedKey(stored) {
+		return stored, nil
+	}
+	if sealer == nil {
+		return nil, errors.New("seed is encrypted and INTERNAL_CA_KEY_ENCRYPTION_KEY is not set")
+	}
+	return sealer.Open(policySeedAAD(keyID), stored)
+}
+
+// RetirePolicySigningKey marks a key as no longer published.
+func RetirePolicySigningKey(db *sql.DB, keyID string, at time.Time) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(`UPDATE policy_signing_keys SET retired_at = ? WHERE key_id = ? AND retired_at IS NULL`, at.UTC().Unix(), keyID)
+	return err
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"testing"
+	"time"
+
+	"controller/ca"
+)
+
+func newTestPolicyKey(t *testing.T, id string) PolicySigningKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PolicySigningKey{KeyID: id, PrivateKey: priv, CreatedAt: time.Now().UTC()}
+}
+
+func storedPolicySeed(t *testing.T, db *sql.DB, id string) []byte {
+	t.Helper()
+	var seed []byte
+	if err := db.QueryRow(`SELECT seed FROM policy_signing_keys WHERE key_id = ?`, id).Scan(&seed); err != nil {
+		t.Fatal(err)
+	}
+	return seed
+}
+
+func TestPolicySigningKeySealed(t *testing.T) {
+	db := openTestDB(t)
+	sealer, err := ca.NewKeySealer(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newTestPolicyKey(t, "pk_1")
+	if err := SavePolicySigningKey(db, key, sealer); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedPolicySeed(t, db, "pk_1")
+	if !ca.IsSealedKey(stored) || bytes.Contains(stored, key.PrivateKey.Seed()) {
+		t.Fatal("expected the seed to be stored sealed")
+	}
+
+	keys, err := LoadPolicySigningKeys(db, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].PrivateKey.Equal(key.PrivateKey) {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if _, err := LoadPolicySigningKeys(db, nil); err == nil {
+		t.Fatal("expected loading a sealed seed without a sealer to fail")
+	}
+	other, _ := ca.NewKeySealer(bytes.Repeat([]byte{8}, 32))
+	if _, err := LoadPolicySigningKeys(db, other); err == nil {
+		t.Fatal("expected loading with the wrong key to fail")
+	}
+}
+
+func TestPolicySigningKeyPlaintextIsSealedOnLoad(t *testing.T) {
+	db := openTestDB(t)
+	key := newTestPolicyKey(t, "pk_1")
+	if err := SavePolicySigningKey(db, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storedPolicySeed(t, db, "pk_1"), key.PrivateKey.Seed()) {
+		t.Fatal("expected a plaintext seed without a sealer")
+	}
+	if keys, err := LoadPolicySigningKeys(db, nil); err != nil || len(keys) != 1 {
+		t.Fatalf("load without sealer: %v %+v", err, keys)
+	}
+
+	sealer, _ := ca.NewKeySealer(bytes.Repeat([]byte{7}, 32))
+	keys, err := LoadPolicySigningKeys(db, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].PrivateKey.Equal(key.PrivateKey) {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if !ca.IsSealedKey(storedPolicySeed(t, db, "pk_1")) {
+		t.Fatal("expected the seed to be sealed in place")
+	}
+	if keys, err := LoadPolicySigningKeys(db, sealer); err != nil || len(keys) != 1 || !keys[0].PrivateKey.Equal(key.PrivateKey) {
+		t.Fatalf("reload after sealing: %v %+v", err, keys)
+	}
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Snapshot acknowledgement statuses reported by connectors.
+const (
+	SnapshotAckApplied  = "applied"
+	SnapshotAckRejected = "rejected"
+)
+
+// PolicyConvergence compares the policy version the controller last compiled
+// for a connector with the one the connector reports as enforced.
+type PolicyConvergence struct {
+	ConnectorID    string `json:"connector_id"`
+	DesiredVersion int    `json:"desired_version"`
+	DesiredHash    string `json:"desired_hash"`
+	CompiledAt     string `json:"compiled_at"`
+	AppliedVersion int    `json:"applied_version"`
+	AppliedHash    string `json:"applied_hash"`
+	AckStatus      string `json:"ack_status"`
+	AckReason      string `json:"ack_reason,omitempty"`
+	AckedAt        string `json:"acked_at,omitempty"`
+	Converged      bool   `json:"converged"`
+}
+
+// RecordSnapshotAck stores a connector's ack or nack for a policy snapshot.
+// A rejected snapshot leaves the connector without policy, so the applied
+// version is reset.
+func RecordSnapshotAck(db *sql.DB, connectorID string, version int, policyHash, status, reason string, at time.Time) error {
+	if db == nil {
+		return nil
+	}
+	appliedVersion, appliedHash := version, policyHash
+	if status != SnapshotAckApplied {
+		appliedVersion, appliedHash = 0, ""
+	}
+	_, err := db.Exec(`INSERT INTO connector_policy_versions (connector_id, version, compiled_at, applied_version, applied_hash, ack_status, ack_reason, acked_at)
+VALUES (?, 0, '', ?, ?, ?, ?, ?)
+ON CONFLICT(connector_id) DO UPDATE SET applied_version=excluded.applied_version, applied_hash=excluded.applied_hash,
+ack_status=excluded.ack_status, ack_reason=excluded.ack_reason, acked_at=excluded.acked_at`,
+		connectorID, appliedVersion, appliedHash, status, reason, at.UTC().Unix())
+	if err != nil {
+		return err
+	}
+	if status == SnapshotAckApplied {
+		_, err = db.Exec(`UPDATE connectors SET last_policy_version = ? WHERE id = ?`, version, connectorID)
+	}
+	return err
+}
+
+// ListPolicyConvergence returns the desired and applied policy state of every
+// connector that has been compiled for or has acknowledged a snapshot.
+func ListPolicyConvergence(db *sql.DB) ([]PolicyConvergence, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query(`SELECT connector_id, version, policy_hash, compiled_at, applied_version, applied_hash, ack_status, ack_reason, acked_at
+FROM connector_policy_versions ORDER BY connector_id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PolicyConvergence{}
+	for rows.Next() {
+		var c PolicyConvergence
+		var desiredHash, appliedHash, ackStatus, ackReason sql.NullString
+		var ackedAt sql.NullInt64
+		if err := rows.Scan(&c.ConnectorID, &c.DesiredVersion, &desiredHash, &c.CompiledAt, &c.AppliedVersion, &appliedHash, &ackStatus, &ackReason, &ackedAt); err != nil {
+			return nil, err
+		}
+		c.DesiredHash = desiredHash.String
+		c.AppliedHash = appliedHash.String
+		c.AckStatus = ackStatus.String
+		c.AckReason = ackReason.String
+		if ackedAt.Valid && ackedAt.Int64 > 0 {
+			c.AckedAt = time.Unix(ackedAt.Int64, 0).UTC().Format(time.RFC3339)
+		}
+		c.Converged = c.AckStatus == SnapshotAckApplied && c.DesiredVersion > 0 &&
+			c.AppliedVersion == c.DesiredVersion && c.AppliedHash == c.DesiredHash
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
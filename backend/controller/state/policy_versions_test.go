@@ -0,0 +1,118 @@
+package state
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func setDesiredPolicy(t *testing.T, db *sql.DB, connectorID string, version int, hash string) {
+	t.Helper()
+	if _, err := db.Exec(`INSERT INTO connector_policy_versions (connector_id, version, compiled_at, policy_hash) VALUES (?, ?, '2026-01-01T00:00:00Z', ?)
+ON CONFLICT(connector_id) DO UPDATE SET version=excluded.version, policy_hash=excluded.policy_hash`, connectorID, version, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func convergenceFor(t *testing.T, db *sql.DB, connectorID string) PolicyConvergence {
+	t.Helper()
+	rows, err := ListPolicyConvergence(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.ConnectorID == connectorID {
+			return row
+		}
+	}
+	t.Fatalf("no convergence row for %s", connectorID)
+	return PolicyConvergence{}
+}
+
+func TestRecordSnapshotAck(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO connectors (id, last_seen) VALUES ('c1', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1700000000, 0)
+	setDesiredPolicy(t, db, "c1", 3, "h3")
+
+	if err := RecordSnapshotAck(db, "c1", 3, "h3", SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+	c := convergenceFor(t, db, "c1")
+	if !c.Converged || c.AppliedVersion != 3 || c.AppliedHash != "h3" || c.AckStatus != SnapshotAckApplied || c.AckedAt != "2023-11-14T22:13:20Z" {
+		t.Fatalf("unexpected convergence after ack: %+v", c)
+	}
+	var lastVersion int
+	if err := db.QueryRow(`SELECT last_policy_version FROM connectors WHERE id = 'c1'`).Scan(&lastVersion); err != nil || lastVersion != 3 {
+		t.Fatalf("expected last_policy_version 3, got %d %v", lastVersion, err)
+	}
+
+	// A nack resets the applied version but keeps the connector's last
+	// applied version on record.
+	if err := RecordSnapshotAck(db, "c1", 4, "h4", SnapshotAckRejected, "bad_signature", at); err != nil {
+		t.Fatal(err)
+	}
+	c = convergenceFor(t, db, "c1")
+	if c.Converged || c.AppliedVersion != 0 || c.AppliedHash != "" || c.AckStatus != SnapshotAckRejected || c.AckReason != "bad_signature" {
+		t.Fatalf("unexpected convergence after nack: %+v", c)
+	}
+	if c.DesiredVersion != 3 || c.DesiredHash != "h3" {
+		t.Fatalf("a nack must not change the desired version: %+v", c)
+	}
+	if err := db.QueryRow(`SELECT last_policy_version FROM connectors WHERE id = 'c1'`).Scan(&lastVersion); err != nil || lastVersion != 3 {
+		t.Fatalf("expected last_policy_version to stay 3, got %d %v", lastVersion, err)
+	}
+}
+
+func TestListPolicyConvergence(t *testing.T) {
+	db := openTestDB(t)
+	at := time.Now()
+
+	// c1 runs the compiled version.
+	setDesiredPolicy(t, db, "c1", 2, "h2")
+	if err := RecordSnapshotAck(db, "c1", 2, "h2", SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+	// c2 acked a version that has since been superseded.
+	setDesiredPolicy(t, db, "c2", 1, "h1")
+	if err := RecordSnapshotAck(db, "c2", 1, "h1", SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+	setDesiredPolicy(t, db, "c2", 2, "h2")
+	// c3 acked the right version with a different hash.
+	setDesiredPolicy(t, db, "c3", 5, "h5")
+	if err := RecordSnapshotAck(db, "c3", 5, "other", SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+	// c4 has been compiled for but has not acked yet.
+	setDesiredPolicy(t, db, "c4", 1, "h1")
+	// c5 acked before anything was compiled for it.
+	if err := RecordSnapshotAck(db, "c5", 1, "h1", SnapshotAckApplied, "", at); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ListPolicyConvergence(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"c1": true, "c2": false, "c3": false, "c4": false, "c5": false}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %+v", len(want), rows)
+	}
+	for i, row := range rows {
+		if i > 0 && rows[i-1].ConnectorID >= row.ConnectorID {
+			t.Fatalf("rows not ordered by connector: %+v", rows)
+		}
+		if row.Converged != want[row.ConnectorID] {
+			t.Errorf("%s: converged=%v, want %v (%+v)", row.ConnectorID, row.Converged, want[row.ConnectorID], row)
+		}
+	}
+	if c2 := convergenceFor(t, db, "c2"); c2.DesiredVersion != 2 || c2.AppliedVersion != 1 {
+		t.Fatalf("unexpected lagging row %+v", c2)
+	}
+	if c4 := convergenceFor(t, db, "c4"); c4.AckStatus != "" || c4.AckedAt != "" {
+		t.Fatalf("expected no ack for c4: %+v", c4)
+	}
+}
@@ -11,16 +11,21 @@ type ConnectorRecord struct {
 	PrivateIP string
 	Version   string
 	LastSeen  time.Time
+	Disabled  bool
 }
 
 type Registry struct {
 	mu         sync.RWMutex
 	connectors map[string]*ConnectorRecord
+	// Disabled connector IDs, kept apart from connectors so a connector can
+	// be disabled before it has ever connected.
+	disabled map[string]struct{}
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
 		connectors: make(map[string]*ConnectorRecord),
+		disabled:   make(map[string]struct{}),
 	}
 }
 
@@ -67,7 +72,9 @@ func (r *Registry) List() []ConnectorRecord {
 	defer r.mu.RUnlock()
 	out := make([]ConnectorRecord, 0, len(r.connectors))
 	for _, rec := range r.connectors {
-		out = append(out, *rec)
+		c := *rec
+		_, c.Disabled = r.disabled[c.ID]
+		out = append(out, c)
 	}
 	sort.Slice(out, func(i, j int) bool {
 		return out[i].LastSeen.After(out[j].LastSeen)
@@ -82,11 +89,37 @@ func (r *Registry) Get(id string) (ConnectorRecord, bool) {
 	if !ok {
 		return ConnectorRecord{}, false
 	}
-	return *rec, true
+	c := *rec
+	_, c.Disabled = r.disabled[id]
+	return c, true
 }
 
 func (r *Registry) Delete(id string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.connectors, id)
+	delete(r.disabled, id)
+}
+
+// SetDisabled marks a connector as disabled or enables it again. A disabled
+// connector is refused by the controller's interceptors and enrollment.
+func (r *Registry) SetDisabled(id string, disabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if disabled {
+		r.disabled[id] = struct{}{}
+	} else {
+		delete(r.disabled, id)
+	}
+}
+
+// IsDisabled reports whether connector id has been disabled.
+func (r *Registry) IsDisabled(id string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.disabled[id]
+	return ok
 }
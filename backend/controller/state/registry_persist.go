@@ -9,7 +9,7 @@ func LoadConnectorsFromDB(db *sql.DB, reg *Registry) error {
 	if db == nil || reg == nil {
 		return nil
 	}
-	rows, err := db.Query(`SELECT id, private_ip, version, last_seen FROM connectors`)
+	rows, err := db.Query(`SELECT id, COALESCE(private_ip, ''), COALESCE(version, ''), last_seen FROM connectors`)
 	if err != nil {
 		return err
 	}
@@ -25,7 +25,26 @@ func LoadConnectorsFromDB(db *sql.DB, reg *Registry) error {
 			reg.setLastSeen(id, time.Unix(lastSeen, 0))
 		}
 	}
-	return nil
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return loadDisabledConnectors(db, reg)
+}
+
+func loadDisabledConnectors(db *sql.DB, reg *Registry) error {
+	rows, err := db.Query(`SELECT connector_id FROM disabled_connectors`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		reg.SetDisabled(id, true)
+	}
+	return rows.Err()
 }
 
 func SaveConnectorToDB(db *sql.DB, rec ConnectorRecord) error {
@@ -48,12 +67,33 @@ ON CONFLICT(id) DO UPDATE SET private_ip=excluded.private_ip, version=excluded.v
 	return err
 }
 
+// SetConnectorDisabledInDB persists a connector's disabled state. Disabled IDs
+// live in their own table so a connector that has never connected is not
+// listed as one.
+func SetConnectorDisabledInDB(db *sql.DB, connectorID string, disabled bool) error {
+	if db == nil {
+		return nil
+	}
+	if !disabled {
+		_, err := db.Exec(`DELETE FROM disabled_connectors WHERE connector_id = ?`, connectorID)
+		return err
+	}
+	_, err := db.Exec(
+		`INSERT INTO disabled_connectors (connector_id, disabled_at) VALUES (?, ?)
+ON CONFLICT(connector_id) DO NOTHING`,
+		connectorID,
+		time.Now().UTC().Unix(),
+	)
+	return err
+}
+
 func DeleteConnectorFromDB(db *sql.DB, connectorID string) error {
 	if db == nil {
 		return nil
 	}
 	_, err := db.Exec(`DELETE FROM connectors WHERE id = ?`, connectorID)
 	_, _ = db.Exec(`DELETE FROM connector_remote_networks WHERE connector_id = ?`, connectorID)
+	_, _ = db.Exec(`DELETE FROM disabled_connectors WHERE connector_id = ?`, connectorID)
 	return err
 }
 
@@ -95,3 +135,19 @@ ON CONFLICT(id) DO UPDATE SET spiffe_id=excluded.spiffe_id, connector_id=exclude
 	)
 	return err
 }
+
+// DeleteTunnelerFromDB removes a tunneler's row, returning sql.ErrNoRows if
+// there was none.
+func DeleteTunnelerFromDB(db *sql.DB, tunnelerID string) error {
+	if db == nil {
+		return nil
+	}
+	res, err := db.Exec(`DELETE FROM tunnelers WHERE id = ?`, tunnelerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
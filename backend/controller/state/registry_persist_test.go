@@ -0,0 +1,48 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisabledConnectorsPersist(t *testing.T) {
+	db := openTestDB(t)
+	if err := SaveConnectorToDB(db, ConnectorRecord{ID: "c1", PrivateIP: "10.0.0.5", Version: "1.0.0", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// c2 is disabled before it has ever connected.
+	for _, id := range []string{"c1", "c2"} {
+		if err := SetConnectorDisabledInDB(db, id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg := NewRegistry()
+	if err := LoadConnectorsFromDB(db, reg); err != nil {
+		t.Fatal(err)
+	}
+	if !reg.IsDisabled("c1") || !reg.IsDisabled("c2") {
+		t.Fatal("expected c1 and c2 to be disabled after reload")
+	}
+	if list := reg.List(); len(list) != 1 || list[0].ID != "c1" || !list[0].Disabled {
+		t.Fatalf("expected only c1 to be registered, got %+v", list)
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM connectors`).Scan(&rows); err != nil || rows != 1 {
+		t.Fatalf("expected disabling not to add connector rows, got %d (%v)", rows, err)
+	}
+
+	if err := SetConnectorDisabledInDB(db, "c1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteConnectorFromDB(db, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	reg = NewRegistry()
+	if err := LoadConnectorsFromDB(db, reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.IsDisabled("c1") || reg.IsDisabled("c2") {
+		t.Fatal("expected enable and delete to clear the disabled state")
+	}
+}
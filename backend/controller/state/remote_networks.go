@@ -15,6 +15,13 @@ type RemoteNetwork struct {
 	Connectors int               `json:"connectors"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
+	// SurvivabilityGraceSeconds lets connectors in this network keep
+	// enforcing their last policy this long past its expiry while the
+	// controller is unreachable.
+	SurvivabilityGraceSeconds int `json:"survivability_grace_seconds"`
+	// RequireEnrollmentApproval holds connectors enrolled into this network
+	// with a network-scoped token until an admin approves them.
+	RequireEnrollmentApproval bool `json:"require_enrollment_approval"`
 }
 
 type RemoteNetworkStore struct {
@@ -37,8 +44,8 @@ func (s *RemoteNetworkStore) CreateNetwork(n *RemoteNetwork) error {
 	n.UpdatedAt = time.Now().UTC()
 	tagsJSON, _ := json.Marshal(n.Tags)
 	_, err := s.db.Exec(
-		`INSERT INTO remote_networks (id, name, location, tags_json, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
-		n.ID, n.Name, n.Location, string(tagsJSON), n.CreatedAt.Unix(), n.UpdatedAt.Unix(),
+		`INSERT INTO remote_networks (id, name, location, tags_json, survivability_grace_seconds, require_enrollment_approval, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.ID, n.Name, n.Location, string(tagsJSON), n.SurvivabilityGraceSeconds, boolToInt(n.RequireEnrollmentApproval), n.CreatedAt.Unix(), n.UpdatedAt.Unix(),
 	)
 	return err
 }
@@ -48,7 +55,7 @@ func (s *RemoteNetworkStore) ListNetworks() ([]RemoteNetwork, error) {
 		return nil, errors.New("db not configured")
 	}
 	rows, err := s.db.Query(`
-		SELECT r.id, r.name, r.location, r.tags_json, r.created_at, r.updated_at,
+		SELECT r.id, r.name, r.location, r.tags_json, r.survivability_grace_seconds, r.require_enrollment_approval, r.created_at, r.updated_at,
 		       (SELECT COUNT(1) FROM connector_remote_networks c WHERE c.remote_network_id = r.id) AS connectors
 		FROM remote_networks r
 		ORDER BY r.updated_at DESC`)
@@ -61,9 +68,11 @@ func (s *RemoteNetworkStore) ListNetworks() ([]RemoteNetwork, error) {
 		var n RemoteNetwork
 		var tagsJSON string
 		var created, updated int64
-		if err := rows.Scan(&n.ID, &n.Name, &n.Location, &tagsJSON, &created, &updated, &n.Connectors); err != nil {
+		var approval int
+		if err := rows.Scan(&n.ID, &n.Name, &n.Location, &tagsJSON, &n.SurvivabilityGraceSeconds, &approval, &created, &updated, &n.Connectors); err != nil {
 			return nil, err
 		}
+		n.RequireEnrollmentApproval = approval != 0
 		n.CreatedAt = time.Unix(created, 0).UTC()
 		n.UpdatedAt = time.Unix(updated, 0).UTC()
 		if tagsJSON != "" {
@@ -76,6 +85,74 @@ func (s *RemoteNetworkStore) ListNetworks() ([]RemoteNetwork, error) {
 	return out, nil
 }
 
+// SetSurvivabilityGrace updates how long connectors in the network may keep
+// enforcing an expired policy while the controller is unreachable.
+func (s *RemoteNetworkStore) SetSurvivabilityGrace(networkID string, seconds int) error {
+	if s == nil || s.db == nil {
+		return errors.New("db not configured")
+	}
+	res, err := s.db.Exec(`UPDATE remote_networks SET survivability_grace_seconds = ?, updated_at = ? WHERE id = ?`, seconds, time.Now().UTC().Unix(), networkID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SurvivabilityGrace returns the network's survivability grace in seconds.
+func SurvivabilityGrace(db *sql.DB, networkID string) int {
+	if db == nil || networkID == "" {
+		return 0
+	}
+	var seconds int
+	_ = db.QueryRow(`SELECT survivability_grace_seconds FROM remote_networks WHERE id = ?`, networkID).Scan(&seconds)
+	return seconds
+}
+
+// SetRequireEnrollmentApproval turns admin approval of connectors enrolling
+// into the network on or off.
+func (s *RemoteNetworkStore) SetRequireEnrollmentApproval(networkID string, require bool) error {
+	if s == nil || s.db == nil {
+		return errors.New("db not configured")
+	}
+	res, err := s.db.Exec(`UPDATE remote_networks SET require_enrollment_approval = ?, updated_at = ? WHERE id = ?`, boolToInt(require), time.Now().UTC().Unix(), networkID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RequiresEnrollmentApproval reports whether connectors enrolling into the
+// network must be approved by an admin.
+func RequiresEnrollmentApproval(db *sql.DB, networkID string) (bool, error) {
+	if db == nil || networkID == "" {
+		return false, nil
+	}
+	var require int
+	err := db.QueryRow(`SELECT require_enrollment_approval FROM remote_networks WHERE id = ?`, networkID).Scan(&require)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return require != 0, err
+}
+
+// NetworkExists reports whether a remote network with networkID exists.
+func (s *RemoteNetworkStore) NetworkExists(networkID string) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, errors.New("db not configured")
+	}
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM remote_networks WHERE id = ?`, networkID).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 func (s *RemoteNetworkStore) AssignConnector(networkID, connectorID string) error {
 	if s == nil || s.db == nil {
 		return errors.New("db not configured")
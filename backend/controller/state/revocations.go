@@ -0,0 +1,246 @@
+package state
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Revocation kinds.
+const (
+	RevokeSerial   = "serial"
+	RevokeSPIFFEID = "spiffe_id"
+)
+
+// Revocation is a revoked certificate serial or workload identity. Serials
+// are decimal, matching how certificates are logged.
+type Revocation struct {
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	// NotAfter is the expiry of a revoked serial; zero for identities.
+	// Expired serials are dropped from the list.
+	NotAfter time.Time `json:"not_after"`
+}
+
+// RevocationStore keeps revoked serials and SPIFFE IDs in memory for the
+// interceptors and persists them in SQLite. Revoking an identity also revokes
+// its live certificates from the issued certificate inventory.
+type RevocationStore struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	serials  map[string]Revocation
+	identity map[string]Revocation
+}
+
+func NewRevocationStore(db *sql.DB) *RevocationStore {
+	return &RevocationStore{
+		db:       db,
+		serials:  make(map[string]Revocation),
+		identity: make(map[string]Revocation),
+	}
+}
+
+// Load reads persisted revocations, skipping serials that have expired.
+func (s *RevocationStore) Load() error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	rows, err := s.db.Query(`SELECT kind, value, reason, revoked_at, not_after FROM revocations`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rows.Next() {
+		var (
+			rev       Revocation
+			reason    sql.NullString
+			revokedAt int64
+			notAfter  sql.NullInt64
+		)
+		if err := rows.Scan(&rev.Kind, &rev.Value, &reason, &revokedAt, &notAfter); err != nil {
+			return err
+		}
+		rev.Reason = reason.String
+		rev.RevokedAt = time.Unix(revokedAt, 0).UTC()
+		if notAfter.Valid && notAfter.Int64 > 0 {
+			rev.NotAfter = time.Unix(notAfter.Int64, 0).UTC()
+		}
+		switch rev.Kind {
+		case RevokeSerial:
+			if !rev.NotAfter.IsZero() && now.After(rev.NotAfter) {
+				continue
+			}
+			s.serials[rev.Value] = rev
+		case RevokeSPIFFEID:
+			s.identity[rev.Value] = rev
+		}
+	}
+	return rows.Err()
+}
+
+// RevokeSerial revokes a single certificate. A zero notAfter is looked up
+// from the issued certificates when the controller issued the serial.
+func (s *RevocationStore) RevokeSerial(serial, reason string, notAfter time.Time) error {
+	if serial == "" {
+		return errors.New("serial required")
+	}
+	if notAfter.IsZero() && s.db != nil {
+		var unix int64
+		if err := s.db.QueryRow(`SELECT not_after FROM issued_certificates WHERE serial = ?`, serial).Scan(&unix); err == nil {
+			notAfter = time.Unix(unix, 0).UTC()
+		}
+	}
+	rev := Revocation{Kind: RevokeSerial, Value: serial, Reason: reason, RevokedAt: time.Now().UTC(), NotAfter: notAfter}
+	if err := s.save(rev); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.serials[serial] = rev
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeSPIFFEID revokes a workload identity and every unexpired certificate
+// the controller has issued to it. The identity stays revoked until it
+// enrolls again; the serials stay revoked until they expire.
+func (s *RevocationStore) RevokeSPIFFEID(spiffeID, reason string) error {
+	if spiffeID == "" {
+		return errors.New("spiffe_id required")
+	}
+	now := time.Now().UTC()
+	rev := Revocation{Kind: RevokeSPIFFEID, Value: spiffeID, Reason: reason, RevokedAt: now}
+	if err := s.save(rev); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.identity[spiffeID] = rev
+	s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	rows, err := s.db.Query(`SELECT serial, not_after FROM issued_certificates WHERE spiffe_id = ? AND not_after > ?`, spiffeID, now.Unix())
+	if err != nil {
+		return err
+	}
+	type issued struct {
+		serial   string
+		notAfter int64
+	}
+	var live []issued
+	for rows.Next() {
+		var c issued
+		if err := rows.Scan(&c.serial, &c.notAfter); err != nil {
+			rows.Close()
+			return err
+		}
+		live = append(live, c)
+	}
+	rows.Close()
+	for _, c := range live {
+		if err := s.RevokeSerial(c.serial, reason, time.Unix(c.notAfter, 0).UTC()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearSPIFFEID lifts an identity revocation, e.g. after the workload
+// re-enrolls with a new token. Revoked serials are left in place.
+func (s *RevocationStore) ClearSPIFFEID(spiffeID string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	_, ok := s.identity[spiffeID]
+	delete(s.identity, spiffeID)
+	s.mu.Unlock()
+	if !ok || s.db == nil {
+		return nil
+	}
+	_, err := s.db.Exec(`DELETE FROM revocations WHERE kind = ? AND value = ?`, RevokeSPIFFEID, spiffeID)
+	return err
+}
+
+// IsSPIFFEIDRevoked reports whether spiffeID has been revoked.
+func (s *RevocationStore) IsSPIFFEIDRevoked(spiffeID string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.identity[spiffeID]
+	return ok
+}
+
+// IsRevoked reports whether cert's serial or SPIFFE ID has been revoked.
+func (s *RevocationStore) IsRevoked(cert *x509.Certificate) bool {
+	if s == nil || cert == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cert.SerialNumber != nil {
+		if _, ok := s.serials[cert.SerialNumber.String()]; ok {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if _, ok := s.identity[uri.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all current revocations, serials of expired certificates
+// excluded, ordered by revocation time.
+func (s *RevocationStore) List() []Revocation {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	s.mu.RLock()
+	out := make([]Revocation, 0, len(s.serials)+len(s.identity))
+	for _, rev := range s.serials {
+		if rev.NotAfter.IsZero() || now.Before(rev.NotAfter) {
+			out = append(out, rev)
+		}
+	}
+	for _, rev := range s.identity {
+		out = append(out, rev)
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].RevokedAt.Equal(out[j].RevokedAt) {
+			return out[i].Value < out[j].Value
+		}
+		return out[i].RevokedAt.Before(out[j].RevokedAt)
+	})
+	return out
+}
+
+func (s *RevocationStore) save(rev Revocation) error {
+	if s == nil {
+		return errors.New("revocation store not configured")
+	}
+	if s.db == nil {
+		return nil
+	}
+	var notAfter interface{}
+	if !rev.NotAfter.IsZero() {
+		notAfter = rev.NotAfter.Unix()
+	}
+	_, err := s.db.Exec(`INSERT INTO revocations (kind, value, reason, revoked_at, not_after) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(kind, value) DO UPDATE SET reason=excluded.reason, revoked_at=excluded.revoked_at, not_after=excluded.not_after`,
+		rev.Kind, rev.Value, rev.Reason, rev.RevokedAt.Unix(), notAfter)
+	return err
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testCert(serial int64, spiffeID string) *x509.Certificate {
+	uri, _ := url.Parse(spiffeID)
+	return &x509.Certificate{SerialNumber: big.NewInt(serial), URIs: []*url.URL{uri}}
+}
+
+func TestRevocationsPersistAndReload(t *testing.T) {
+	db := openTestDB(t)
+	store := NewRevocationStore(db)
+	if err := store.RevokeSerial("100", "key compromise", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSPIFFEID("spiffe://example.internal/tunneler/t1", "deprovisioned"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSerial("", "", time.Time{}); err == nil {
+		t.Fatal("expected an empty serial to be refused")
+	}
+	if err := store.RevokeSPIFFEID("", ""); err == nil {
+		t.Fatal("expected an empty SPIFFE ID to be refused")
+	}
+
+	reloaded := NewRevocationStore(db)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsRevoked(testCert(100, "spiffe://example.internal/connector/c1")) {
+		t.Fatal("expected serial 100 to stay revoked after reload")
+	}
+	if !reloaded.IsRevoked(testCert(101, "spiffe://example.internal/tunneler/t1")) {
+		t.Fatal("expected t1 to stay revoked after reload")
+	}
+	if !reloaded.IsSPIFFEIDRevoked("spiffe://example.internal/tunneler/t1") {
+		t.Fatal("expected IsSPIFFEIDRevoked for t1")
+	}
+	if reloaded.IsRevoked(testCert(101, "spiffe://example.internal/tunneler/t2")) {
+		t.Fatal("unrevoked certificate reported as revoked")
+	}
+	list := reloaded.List()
+	if len(list) != 2 {
+		t.Fatalf("expected 2 revocations, got %+v", list)
+	}
+	for _, rev := range list {
+		if rev.Kind == RevokeSerial && (rev.Value != "100" || rev.Reason != "key compromise" || rev.NotAfter.IsZero()) {
+			t.Fatalf("unexpected serial revocation %+v", rev)
+		}
+	}
+
+	if err := reloaded.ClearSPIFFEID("spiffe://example.internal/tunneler/t1"); err != nil {
+		t.Fatal(err)
+	}
+	again := NewRevocationStore(db)
+	if err := again.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if again.IsSPIFFEIDRevoked("spiffe://example.internal/tunneler/t1") {
+		t.Fatal("expected the cleared identity to stay cleared after reload")
+	}
+}
+
+func TestRevokeSPIFFEIDRevokesLiveCertificates(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	recordTestCert(t, db, 1, "spiffe://example.internal/connector/c1", now)
+	recordTestCert(t, db, 2, "spiffe://example.internal/connector/c1", now.Add(-2*time.Hour)) // expired
+	recordTestCert(t, db, 3, "spiffe://example.internal/connector/c2", now)
+
+	store := NewRevocationStore(db)
+	if err := store.RevokeSPIFFEID("spiffe://example.internal/connector/c1", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	// The live serial is revoked on its own, so it stays revoked after the
+	// identity is cleared on re-enrollment.
+	if err := store.ClearSPIFFEID("spiffe://example.internal/connector/c1"); err != nil {
+		t.Fatal(err)
+	}
+	if !store.IsRevoked(testCert(1, "spiffe://example.internal/connector/c1")) {
+		t.Fatal("expected the live certificate's serial to be revoked")
+	}
+	if store.IsRevoked(testCert(2, "spiffe://example.internal/connector/c1")) {
+		t.Fatal("expected the expired certificate to be skipped")
+	}
+	if store.IsRevoked(testCert(3, "spiffe://example.internal/connector/c2")) {
+		t.Fatal("expected other identities' certificates to be untouched")
+	}
+}
+
+func TestRevokedSerialsExpire(t *testing.T) {
+	db := openTestDB(t)
+	store := NewRevocationStore(db)
+	if err := store.RevokeSerial("200", "", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSerial("201", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Without an expiry the serial's not_after comes from the inventory.
+	recordTestCert(t, db, 202, "spiffe://example.internal/connector/c1", time.Now().Add(-2*time.Hour))
+	if err := store.RevokeSerial("202", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := store.List()
+	if len(list) != 1 || list[0].Value != "201" {
+		t.Fatalf("expected only the unexpired serial to be listed, got %+v", list)
+	}
+
+	reloaded := NewRevocationStore(db)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.IsRevoked(testCert(200, "spiffe://example.internal/connector/c1")) || reloaded.IsRevoked(testCert(202, "spiffe://example.internal/connector/c1")) {
+		t.Fatal("expected expired serials to be dropped on load")
+	}
+	if !reloaded.IsRevoked(testCert(201, "spiffe://example.internal/connector/c1")) {
+		t.Fatal("expected the unexpired serial to be loaded")
+	}
+}
@@ -140,12 +140,75 @@ func migrateSQLite(db *sql.DB) error {
 			timestamp TEXT NOT NULL,
 			message TEXT NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS disabled_connectors (
+			connector_id TEXT PRIMARY KEY,
+			disabled_at INTEGER NOT NULL
+		);`,
 		`CREATE TABLE IF NOT EXISTS connector_policy_versions (
 			connector_id TEXT PRIMARY KEY,
 			version INTEGER NOT NULL DEFAULT 0,
 			compiled_at TEXT NOT NULL,
 			policy_hash TEXT
 		);`,
+		`CREATE TABLE IF NOT EXISTS policy_signing_keys (
+			key_id TEXT PRIMARY KEY,
+			seed BLOB NOT NULL,
+			created_at INTEGER NOT NULL,
+			retired_at INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS ca_certificates (
+			id TEXT PRIMARY KEY,
+			cert_pem TEXT NOT NULL,
+			key_pem BLOB,
+			status TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			activated_at INTEGER,
+			retired_at INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS cert_ttl_overrides (
+			spiffe_id TEXT PRIMARY KEY,
+			ttl_seconds INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS pending_enrollments (
+			id TEXT PRIMARY KEY,
+			role TEXT NOT NULL,
+			workload_id TEXT NOT NULL,
+			public_key_pem TEXT NOT NULL,
+			public_key_sha256 TEXT NOT NULL,
+			key_proof TEXT,
+			private_ip TEXT,
+			version TEXT,
+			source_addr TEXT,
+			attestation_type TEXT,
+			attested_by TEXT,
+			remote_network_id TEXT,
+			status TEXT NOT NULL,
+			reason TEXT,
+			created_at INTEGER NOT NULL,
+			last_seen_at INTEGER NOT NULL,
+			decided_at INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS issued_certificates (
+			serial TEXT PRIMARY KEY,
+			spiffe_id TEXT NOT NULL,
+			role TEXT,
+			public_key_sha256 TEXT,
+			not_before INTEGER,
+			not_after INTEGER NOT NULL,
+			issued_at INTEGER NOT NULL,
+			issued_via TEXT,
+			key_algorithm TEXT,
+			key_proof TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS revocations (
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			reason TEXT,
+			revoked_at INTEGER NOT NULL,
+			not_after INTEGER,
+			PRIMARY KEY (kind, value)
+		);`,
 		`CREATE TABLE IF NOT EXISTS authorizations (
 			principal_spiffe TEXT NOT NULL,
 			resource_id TEXT NOT NULL,
@@ -191,6 +254,39 @@ func migrateSQLite(db *sql.DB) error {
 	if err := ensureColumn(db, "remote_networks", "updated_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return err
 	}
+	if err := ensureColumn(db, "remote_networks", "survivability_grace_seconds", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "remote_networks", "require_enrollment_approval", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "role", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "workload_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "remote_network_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "max_uses", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "used_by", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "created_at", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "created_by", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "revoked_at", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tokens", "require_approval", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
 	if err := ensureColumn(db, "connectors", "name", "TEXT"); err != nil {
 		return err
 	}
@@ -251,6 +347,21 @@ func migrateSQLite(db *sql.DB) error {
 	if err := ensureColumn(db, "resources", "description", "TEXT"); err != nil {
 		return err
 	}
+	if err := ensureColumn(db, "connector_policy_versions", "applied_version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "connector_policy_versions", "applied_hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "connector_policy_versions", "ack_status", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "connector_policy_versions", "ack_reason", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "connector_policy_versions", "acked_at", "INTEGER"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -305,6 +416,13 @@ func columnExists(db *sql.DB, table, column string) (bool, error) {
 	return false, nil
 }
 
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func PruneAuditLogs(db *sql.DB, olderThan time.Time) error {
 	if db == nil {
 		return nil
@@ -7,17 +7,69 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// TokenRecord is a stored enrollment token. ConnectorID is the first workload
+// that used it.
 type TokenRecord struct {
 	Hash        string
 	ExpiresAt   time.Time
 	Used        bool
 	ConnectorID string
+
+	// Scope. An empty Role or WorkloadID accepts any.
+	Role            string
+	WorkloadID      string
+	RemoteNetworkID string
+	MaxUses         int
+	UsedBy          []string
+	CreatedAt       time.Time
+	CreatedBy       string
+	RevokedAt       time.Time
+	RequireApproval bool
+}
+
+// Token states reported by TokenRecord.State.
+const (
+	TokenActive  = "active"
+	TokenUsed    = "used"
+	TokenExpired = "expired"
+	TokenRevoked = "revoked"
+)
+
+// tokenIDLen is the length of the hash prefix that identifies a token in the
+// admin API.
+const tokenIDLen = 12
+
+var (
+	ErrTokenNotFound  = errors.New("token not found")
+	ErrTokenAmbiguous = errors.New("token id matches more than one token")
+)
+
+// TokenOptions scopes a new enrollment token.
+type TokenOptions struct {
+	// Role is "connector", "tunneler" or empty for either.
+	Role string
+	// WorkloadID, if set, is the only ID the token can enroll.
+	WorkloadID string
+	// RemoteNetworkID is assigned to connectors enrolled with the token.
+	RemoteNetworkID string
+	// MaxUses is how many distinct workloads may enroll (default 1).
+	MaxUses int
+	// TTL overrides the store's default token lifetime.
+	TTL time.Duration
+	// CreatedBy records who asked for the token.
+	CreatedBy string
+	// RequireApproval holds workloads enrolled with the token until an admin
+	// approves them.
+	RequireApproval bool
 }
 
 type TokenStore struct {
@@ -48,39 +100,58 @@ func NewTokenStoreWithDB(ttl time.Duration, db *sql.DB) *TokenStore {
 	return store
 }
 
-func (s *TokenStore) CreateToken() (string, time.Time, error) {
+// CreateToken mints a token scoped by opts.
+func (s *TokenStore) CreateToken(opts TokenOptions) (string, time.Time, error) {
+	if opts.MaxUses < 0 {
+		return "", time.Time{}, errors.New("max uses must not be negative")
+	}
+	if opts.MaxUses == 0 {
+		opts.MaxUses = 1
+	}
 	raw := make([]byte, 16)
 	if _, err := rand.Read(raw); err != nil {
 		return "", time.Time{}, err
 	}
 	token := hex.EncodeToString(raw)
 	hash := hashToken(token)
+	ttl := s.ttl
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
 	expires := time.Time{}
-	if s.ttl > 0 {
-		expires = time.Now().Add(s.ttl)
-	} else {
-		expires = time.Time{}
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.tokens[hash] = &TokenRecord{
-		Hash:      hash,
-		ExpiresAt: expires,
-		Used:      false,
+		Hash:            hash,
+		ExpiresAt:       expires,
+		Used:            false,
+		Role:            opts.Role,
+		WorkloadID:      opts.WorkloadID,
+		RemoteNetworkID: opts.RemoteNetworkID,
+		MaxUses:         opts.MaxUses,
+		CreatedAt:       time.Now().UTC(),
+		CreatedBy:       opts.CreatedBy,
+		RequireApproval: opts.RequireApproval,
 	}
-	if err := s.saveLocked(); err != nil {
+	if err := s.persistLocked(s.tokens[hash]); err != nil {
 		return "", time.Time{}, err
 	}
 	return token, expires, nil
 }
 
-func (s *TokenStore) ConsumeToken(token, connectorID string) error {
+// ConsumeToken records that the workload role/workloadID enrolled with token
+// and returns the token's scope. A workload that already used the token may
+// use it again, so a failed enrollment can be retried.
+func (s *TokenStore) ConsumeToken(token, role, workloadID string) (TokenRecord, error) {
 	if token == "" {
-		return errors.New("missing token")
+		return TokenRecord{}, errors.New("missing token")
 	}
-	if connectorID == "" {
-		return errors.New("missing connector id")
+	if workloadID == "" {
+		return TokenRecord{}, errors.New("missing workload id")
 	}
 	hash := hashToken(token)
 
@@ -88,34 +159,235 @@ func (s *TokenStore) ConsumeToken(token, connectorID string) error {
 	defer s.mu.Unlock()
 	rec, ok := s.tokens[hash]
 	if !ok {
-		return errors.New("invalid token")
+		return TokenRecord{}, errors.New("invalid token")
+	}
+	if !rec.RevokedAt.IsZero() {
+		return TokenRecord{}, errors.New("token revoked")
 	}
 	if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
-		return errors.New("token expired")
+		return TokenRecord{}, errors.New("token expired")
 	}
-	if rec.Used {
-		if rec.ConnectorID != connectorID {
-			return errors.New("token already used")
+	if rec.Role != "" && rec.Role != role {
+		return TokenRecord{}, fmt.Errorf("token is scoped to role %q", rec.Role)
+	}
+	if rec.WorkloadID != "" && rec.WorkloadID != workloadID {
+		return TokenRecord{}, errors.New("token is scoped to another workload id")
+	}
+	for _, id := range rec.UsedBy {
+		if id == workloadID {
+			return *rec, nil
+		}
+	}
+	if rec.Used || len(rec.UsedBy) >= rec.maxUses() {
+		return TokenRecord{}, errors.New("token already used")
+	}
+	rec.UsedBy = append(rec.UsedBy, workloadID)
+	if rec.ConnectorID == "" {
+		rec.ConnectorID = workloadID
+	}
+	rec.Used = len(rec.UsedBy) >= rec.maxUses()
+	if err := s.persistLocked(rec); err != nil {
+		return TokenRecord{}, err
+	}
+	return *rec, nil
+}
+
+// ResumeToken returns the scope of a token that role/workloadID already used,
+// ignoring its expiry, so an enrollment held for approval can complete after
+// the token expires. Revoked tokens are still refused.
+func (s *TokenStore) ResumeToken(token, role, workloadID string) (TokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.tokens[hashToken(token)]
+	if !ok {
+		return TokenRecord{}, errors.New("invalid token")
+	}
+	if !rec.RevokedAt.IsZero() {
+		return TokenRecord{}, errors.New("token revoked")
+	}
+	if rec.Role != "" && rec.Role != role {
+		return TokenRecord{}, fmt.Errorf("token is scoped to role %q", rec.Role)
+	}
+	for _, id := range rec.UsedBy {
+		if id == workloadID {
+			return copyToken(rec), nil
+		}
+	}
+	return TokenRecord{}, errors.New("token was not used by this workload")
+}
+
+func (r *TokenRecord) maxUses() int {
+	if r.MaxUses <= 0 {
+		return 1
+	}
+	return r.MaxUses
+}
+
+// ID is the short hash prefix the admin API uses to refer to the token.
+func (r TokenRecord) ID() string {
+	if len(r.Hash) < tokenIDLen {
+		return r.Hash
+	}
+	return r.Hash[:tokenIDLen]
+}
+
+// State reports whether the token can still enroll workloads at now.
+func (r TokenRecord) State(now time.Time) string {
+	switch {
+	case !r.RevokedAt.IsZero():
+		return TokenRevoked
+	case r.Used || len(r.UsedBy) >= r.maxUses():
+		return TokenUsed
+	case !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt):
+		return TokenExpired
+	default:
+		return TokenActive
+	}
+}
+
+// ListTokens returns every stored token, newest first.
+func (s *TokenStore) ListTokens() []TokenRecord {
+	s.mu.Lock()
+	out := make([]TokenRecord, 0, len(s.tokens))
+	for _, rec := range s.tokens {
+		out = append(out, copyToken(rec))
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].Hash < out[j].Hash
+	})
+	return out
+}
+
+// GetToken returns the token whose hash starts with id.
+func (s *TokenStore) GetToken(id string) (TokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.findLocked(id)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	return copyToken(rec), nil
+}
+
+// RevokeToken stops the token whose hash starts with id from enrolling any
+// more workloads. Workloads already enrolled with it are unaffected.
+func (s *TokenStore) RevokeToken(id string) (TokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.findLocked(id)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	if rec.RevokedAt.IsZero() {
+		rec.RevokedAt = time.Now().UTC()
+		if err := s.persistLocked(rec); err != nil {
+			return TokenRecord{}, err
 		}
-		return nil
 	}
-	rec.Used = true
-	rec.ConnectorID = connectorID
-	return s.saveLocked()
+	return copyToken(rec), nil
 }
 
-func (s *TokenStore) DeleteByConnectorID(connectorID string) error {
+// PurgeTokens deletes tokens in any of the given states and returns how many
+// were removed. Active tokens are never purged.
+func (s *TokenStore) PurgeTokens(states ...string) (int, error) {
+	want := make(map[string]bool, len(states))
+	for _, st := range states {
+		want[st] = st != TokenActive
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hashes []string
+	for hash, rec := range s.tokens {
+		if want[rec.State(now)] {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+	if err := s.removeLocked(hashes); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+func (s *TokenStore) findLocked(id string) (*TokenRecord, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if id == "" {
+		return nil, ErrTokenNotFound
+	}
+	var found *TokenRecord
+	for hash, rec := range s.tokens {
+		if !strings.HasPrefix(hash, id) {
+			continue
+		}
+		if found != nil {
+			return nil, ErrTokenAmbiguous
+		}
+		found = rec
+	}
+	if found == nil {
+		return nil, ErrTokenNotFound
+	}
+	return found, nil
+}
+
+func copyToken(rec *TokenRecord) TokenRecord {
+	out := *rec
+	out.UsedBy = append([]string(nil), rec.UsedBy...)
+	return out
+}
+
+// ForgetConnector removes a deleted connector from the tokens it enrolled
+// with. A token is deleted once no workload that used it is left or if it was
+// single-use; a shared token keeps its other workloads, and one that was spent
+// stays spent.
+func (s *TokenStore) ForgetConnector(connectorID string) error {
 	if connectorID == "" {
 		return nil
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	var hashes []string
 	for hash, rec := range s.tokens {
-		if rec.ConnectorID == connectorID {
-			delete(s.tokens, hash)
+		if rec.Role != "" && rec.Role != "connector" {
+			continue
 		}
+		usedBy := make([]string, 0, len(rec.UsedBy))
+		for _, id := range rec.UsedBy {
+			if id != connectorID {
+				usedBy = append(usedBy, id)
+			}
+		}
+		if len(usedBy) == len(rec.UsedBy) && rec.ConnectorID != connectorID {
+			continue
+		}
+		if rec.maxUses() == 1 || len(usedBy) == 0 {
+			hashes = append(hashes, hash)
+			continue
+		}
+		rec.Used = rec.Used || len(rec.UsedBy) >= rec.maxUses()
+		rec.UsedBy = usedBy
+		rec.ConnectorID = usedBy[0]
+		if err := s.persistLocked(rec); err != nil {
+			return err
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
 	}
-	return s.saveLocked()
+	return s.removeLocked(hashes)
+}
+
+// TokenID returns the admin API ID of token.
+func TokenID(token string) string {
+	return hashToken(token)[:tokenIDLen]
 }
 
 func hashToken(token string) string {
@@ -125,7 +397,7 @@ func hashToken(token string) string {
 
 func (s *TokenStore) load() error {
 	if s.db != nil {
-		rows, err := s.db.Query(`SELECT hash, expires_at, used, connector_id FROM tokens`)
+		rows, err := s.db.Query(`SELECT hash, expires_at, used, connector_id, role, workload_id, remote_network_id, max_uses, used_by, created_at, created_by, revoked_at, require_approval FROM tokens`)
 		if err != nil {
 			return err
 		}
@@ -135,15 +407,33 @@ func (s *TokenStore) load() error {
 			var hash string
 			var expiresAt int64
 			var used int
-			var connectorID sql.NullString
-			if err := rows.Scan(&hash, &expiresAt, &used, &connectorID); err != nil {
+			var connectorID, role, workloadID, networkID, usedBy, createdBy sql.NullString
+			var maxUses, createdAt, revokedAt, requireApproval sql.NullInt64
+			if err := rows.Scan(&hash, &expiresAt, &used, &connectorID, &role, &workloadID, &networkID, &maxUses, &usedBy, &createdAt, &createdBy, &revokedAt, &requireApproval); err != nil {
 				return err
 			}
 			rec := &TokenRecord{
-				Hash:        hash,
-				ExpiresAt:   time.Unix(expiresAt, 0),
-				Used:        used != 0,
-				ConnectorID: connectorID.String,
+				Hash:            hash,
+				ExpiresAt:       time.Unix(expiresAt, 0),
+				Used:            used != 0,
+				ConnectorID:     connectorID.String,
+				Role:            role.String,
+				WorkloadID:      workloadID.String,
+				RemoteNetworkID: networkID.String,
+				MaxUses:         int(maxUses.Int64),
+				CreatedBy:       createdBy.String,
+				RequireApproval: requireApproval.Int64 != 0,
+			}
+			if createdAt.Valid && createdAt.Int64 > 0 {
+				rec.CreatedAt = time.Unix(createdAt.Int64, 0).UTC()
+			}
+			if revokedAt.Valid && revokedAt.Int64 > 0 {
+				rec.RevokedAt = time.Unix(revokedAt.Int64, 0).UTC()
+			}
+			if usedBy.String != "" {
+				_ = json.Unmarshal([]byte(usedBy.String), &rec.UsedBy)
+			} else if rec.Used && rec.ConnectorID != "" {
+				rec.UsedBy = []string{rec.ConnectorID}
 			}
 			records[hash] = rec
 		}
@@ -172,35 +462,68 @@ func (s *TokenStore) load() error {
 	return nil
 }
 
-func (s *TokenStore) saveLocked() error {
-	if s.db != nil {
-		tx, err := s.db.Begin()
-		if err != nil {
-			return err
+// persistLocked writes rec. With a database only rec's row is written; the
+// JSON file is always rewritten whole.
+func (s *TokenStore) persistLocked(rec *TokenRecord) error {
+	if s.db == nil {
+		return s.writeFileLocked()
+	}
+	used := 0
+	if rec.Used {
+		used = 1
+	}
+	usedBy, _ := json.Marshal(rec.UsedBy)
+	_, err := s.db.Exec(
+		`INSERT INTO tokens (hash, expires_at, used, connector_id, role, workload_id, remote_network_id, max_uses, used_by, created_at, created_by, revoked_at, require_approval)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(hash) DO UPDATE SET expires_at=excluded.expires_at, used=excluded.used, connector_id=excluded.connector_id, used_by=excluded.used_by, revoked_at=excluded.revoked_at`,
+		rec.Hash,
+		rec.ExpiresAt.Unix(),
+		used,
+		rec.ConnectorID,
+		rec.Role,
+		rec.WorkloadID,
+		rec.RemoteNetworkID,
+		rec.maxUses(),
+		string(usedBy),
+		rec.CreatedAt.Unix(),
+		rec.CreatedBy,
+		nullUnix(rec.RevokedAt),
+		boolToInt(rec.RequireApproval),
+	)
+	return err
+}
+
+// removeLocked deletes the tokens with the given hashes.
+func (s *TokenStore) removeLocked(hashes []string) error {
+	if s.db == nil {
+		for _, hash := range hashes {
+			delete(s.tokens, hash)
 		}
-		defer func() {
-			_ = tx.Rollback()
-		}()
-		for _, rec := range s.tokens {
-			used := 0
-			if rec.Used {
-				used = 1
-			}
-			_, err := tx.Exec(
-				`INSERT INTO tokens (hash, expires_at, used, connector_id)
-VALUES (?, ?, ?, ?)
-ON CONFLICT(hash) DO UPDATE SET expires_at=excluded.expires_at, used=excluded.used, connector_id=excluded.connector_id`,
-				rec.Hash,
-				rec.ExpiresAt.Unix(),
-				used,
-				rec.ConnectorID,
-			)
-			if err != nil {
-				return err
-			}
+		return s.writeFileLocked()
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`DELETE FROM tokens WHERE hash = ?`, hash); err != nil {
+			return err
 		}
-		return tx.Commit()
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		delete(s.tokens, hash)
+	}
+	return nil
+}
+
+func (s *TokenStore) writeFileLocked() error {
 	if s.path == "" {
 		return nil
 	}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForgetConnectorKeepsSharedTokens(t *testing.T) {
+	db := openTestDB(t)
+	store := NewTokenStoreWithDB(time.Hour, db)
+
+	single, _, err := store.CreateToken(TokenOptions{Role: "connector"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, _, err := store.CreateToken(TokenOptions{MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunneler, _, err := store.CreateToken(TokenOptions{Role: "tunneler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, use := range []struct{ token, role, id string }{
+		{single, "connector", "c1"},
+		{shared, "connector", "c1"},
+		{shared, "connector", "c2"},
+		{tunneler, "tunneler", "c1"},
+	} {
+		if _, err := store.ConsumeToken(use.token, use.role, use.id); err != nil {
+			t.Fatalf("consume %s: %v", use.id, err)
+		}
+	}
+
+	if err := store.ForgetConnector("c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload so the database rows are checked, not just memory.
+	store = NewTokenStoreWithDB(time.Hour, db)
+	if _, err := store.GetToken(TokenID(single)); err != ErrTokenNotFound {
+		t.Fatalf("expected single-use token to be deleted, got %v", err)
+	}
+	rec, err := store.GetToken(TokenID(shared))
+	if err != nil {
+		t.Fatalf("expected shared token to be kept: %v", err)
+	}
+	if len(rec.UsedBy) != 1 || rec.UsedBy[0] != "c2" || rec.ConnectorID != "c2" {
+		t.Fatalf("expected only c2 to remain, got used_by=%v connector_id=%q", rec.UsedBy, rec.ConnectorID)
+	}
+	if rec.State(time.Now()) != TokenUsed {
+		t.Fatalf("expected spent token to stay used, got %s", rec.State(time.Now()))
+	}
+	if _, err := store.GetToken(TokenID(tunneler)); err != nil {
+		t.Fatalf("expected tunneler token to be untouched: %v", err)
+	}
+
+	if err := store.ForgetConnector("c2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetToken(TokenID(shared)); err != ErrTokenNotFound {
+		t.Fatalf("expected shared token without users to be deleted, got %v", err)
+	}
+}
@@ -32,6 +32,25 @@ func (r *TunnelerRegistry) Add(id, spiffeID string) {
 	r.byID[id] = TunnelerInfo{ID: id, SPIFFEID: spiffeID}
 }
 
+// Remove drops a tunneler from the registry and returns what was recorded
+// for it.
+func (r *TunnelerRegistry) Remove(id string) (TunnelerInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.byID[id]
+	if !ok {
+		return TunnelerInfo{}, false
+	}
+	delete(r.byID, id)
+	for i, v := range r.order {
+		if v == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	return info, true
+}
+
 func (r *TunnelerRegistry) List() []TunnelerInfo {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -65,6 +65,15 @@ func (r *TunnelerStatusRegistry) Get(id string) (TunnelerRecord, bool) {
 	return *rec, true
 }
 
+// Delete forgets a tunneler and reports whether it was known.
+func (r *TunnelerStatusRegistry) Delete(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.tunnelers[id]
+	delete(r.tunnelers, id)
+	return ok
+}
+
 func (r *TunnelerStatusRegistry) List() []TunnelerRecord {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
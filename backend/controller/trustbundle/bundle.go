@@ -0,0 +1,164 @@
+// Package trustbundle holds the internal CA roots connectors and tunnelers
+// trust, shared so both follow CA rotation the same way.
+package trustbundle
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+)
+
+// Bundle holds the internal CA roots a workload trusts. During a CA
+// rotation the controller distributes a bundle with both the old and the new
+// root, so it may hold more than one certificate.
+type Bundle struct {
+	mu      sync.RWMutex
+	pem     []byte
+	roots   []*x509.Certificate
+	pool    *x509.CertPool
+	changed chan struct{}
+}
+
+// New parses and validates a PEM bundle of CA certificates.
+func New(pemBytes []byte) (*Bundle, error) {
+	roots, err := Parse(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Bundle{
+		pem:     pemBytes,
+		roots:   roots,
+		pool:    poolOf(roots),
+		changed: make(chan struct{}),
+	}, nil
+}
+
+// PEM returns the current bundle.
+func (b *Bundle) PEM() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pem
+}
+
+// Pool returns a cert pool of the current roots. TLS configs should fetch it
+// per handshake so bundle updates apply to new connections.
+func (b *Bundle) Pool() *x509.CertPool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pool
+}
+
+// Changed returns a channel that is closed on the next bundle update.
+func (b *Bundle) Changed() <-chan struct{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.changed
+}
+
+// Update replaces the bundle. The new bundle must share at least one root
+// with the current one so trust is never replaced wholesale. It reports
+// whether the bundle changed.
+func (b *Bundle) Update(pemBytes []byte) (bool, error) {
+	roots, err := Parse(pemBytes)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sameRoots(b.roots, roots) {
+		return false, nil
+	}
+	if !overlaps(b.roots, roots) {
+		return false, errors.New("CA bundle shares no root with the trusted bundle")
+	}
+	b.pem = pemBytes
+	b.roots = roots
+	b.pool = poolOf(roots)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return true, nil
+}
+
+// Parse parses every certificate in a PEM bundle and ensures each is
+// a valid CA.
+func Parse(pemBytes []byte) ([]*x509.Certificate, error) {
+	var roots []*x509.Certificate
+	rest := pemBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := parseCA(pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, cert)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("invalid CA PEM")
+	}
+	return roots, nil
+}
+
+func poolOf(roots []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	return pool
+}
+
+func sameRoots(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, cert := range b {
+		if !contains(a, cert) {
+			return false
+		}
+	}
+	return true
+}
+
+func overlaps(a, b []*x509.Certificate) bool {
+	for _, cert := range b {
+		if contains(a, cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCA ensures the PEM contains a valid CA certificate.
+func parseCA(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid CA PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA || !cert.BasicConstraintsValid {
+		return nil, errors.New("certificate is not a valid CA")
+	}
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("CA certificate missing key usage")
+	}
+	return cert, nil
+}
@@ -10,16 +10,24 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	controllerpb "controller/gen/controllerpb"
+	"controller/identity"
+	"controller/trustbundle"
 	"tunneler/internal/tlsutil"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // Config controls enrollment behavior.
@@ -38,15 +46,26 @@ func Run() error {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// No overall deadline: an enrollment held for approval is polled until
+	// an admin decides or the command is interrupted.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	cert, _, _, spiffeID, err := Enroll(ctx, cfg)
+	cert, certPEM, caPEM, spiffeID, err := Enroll(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	_ = cert
+	ids, err := IdentityStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	if ids != nil {
+		if err := ids.Save(cert, certPEM, caPEM); err != nil {
+			return fmt.Errorf("persist identity: %w", err)
+		}
+		fmt.Printf("Saved identity to %s\n", ids.Path())
+	}
 	fmt.Printf("Enrolled tunneler with SPIFFE ID: %s\n", spiffeID)
 	return nil
 }
@@ -80,9 +99,6 @@ func ConfigFromEnv() (Config, error) {
 		}
 		token = cred
 	}
-	if token == "" {
-		return Config{}, fmt.Errorf("ENROLLMENT_TOKEN is not set")
-	}
 
 	return Config{
 		ControllerAddr: controllerAddr,
@@ -95,6 +111,10 @@ func ConfigFromEnv() (Config, error) {
 
 // Enroll performs enrollment and returns the issued workload certificate.
 func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, string, error) {
+	if cfg.Token == "" {
+		return tls.Certificate{}, nil, nil, "", fmt.Errorf("ENROLLMENT_TOKEN is not set")
+	}
+
 	// ---- generate key pair (in-memory only) ----
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -110,6 +130,10 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		Type:  "PUBLIC KEY",
 		Bytes: pubDER,
 	})
+	csrPEM, err := tlsutil.CreateCSR(privKey, fmt.Sprintf("spiffe://%s/tunneler/%s", cfg.TrustDomain, cfg.TunnelerID))
+	if err != nil {
+		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to create CSR: %w", err)
+	}
 
 	rootPool, err := tlsutil.RootPoolFromPEM(cfg.RootCAPEM)
 	if err != nil {
@@ -138,11 +162,29 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
 
-	resp, err := client.EnrollTunneler(ctx, &controllerpb.EnrollRequest{
+	req := &controllerpb.EnrollRequest{
 		Id:        cfg.TunnelerID,
 		PublicKey: pubPEM,
+		Csr:       csrPEM,
 		Token:     cfg.Token,
-	})
+	}
+	var resp *controllerpb.EnrollResponse
+	for {
+		rpcCtx, rpcCancel := context.WithTimeout(ctx, enrollRPCTimeout)
+		resp, err = client.EnrollTunneler(rpcCtx, req)
+		rpcCancel()
+		delay, pending := approvalRetryDelay(err)
+		if !pending {
+			break
+		}
+		// Keep the same key while waiting: the approval is bound to it.
+		log.Printf("%s; retrying in %s", status.Convert(err).Message(), delay)
+		select {
+		case <-ctx.Done():
+			return tls.Certificate{}, nil, nil, "", fmt.Errorf("enrollment not approved: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("enrollment RPC failed: %w", err)
 	}
@@ -154,19 +196,14 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("controller returned empty CA certificate")
 	}
 
-	if _, err := tlsutil.ParseAndValidateCA(resp.CaCertificate); err != nil {
+	if _, err := trustbundle.Parse(resp.CaCertificate); err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("invalid internal CA: %w", err)
 	}
 
 	// ---- basic validation of returned cert ----
-	block, _ := pem.Decode(resp.Certificate)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("invalid certificate PEM from controller")
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
+	chain, cert, err := tlsutil.ParseCertChain(resp.Certificate)
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to parse issued certificate: %w", err)
+		return tls.Certificate{}, nil, nil, "", fmt.Errorf("invalid certificate from controller: %w", err)
 	}
 
 	if len(cert.URIs) != 1 {
@@ -174,7 +211,7 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 	}
 
 	workloadCert := tls.Certificate{
-		Certificate: [][]byte{block.Bytes},
+		Certificate: chain,
 		PrivateKey:  privKey,
 	}
 
@@ -187,6 +224,31 @@ func normalizeTrustDomain(v string) string {
 	return v
 }
 
+// IdentityStoreFromEnv returns the on-disk identity store configured by
+// IDENTITY_FILE, defaulting to identity.pem in systemd's STATE_DIRECTORY.
+// It returns nil when neither is set. IDENTITY_PASSPHRASE (env or systemd
+// credential) enables encryption of the private key.
+func IdentityStoreFromEnv() (*identity.Store, error) {
+	path := strings.TrimSpace(os.Getenv("IDENTITY_FILE"))
+	if path == "" {
+		if dir := strings.TrimSpace(os.Getenv("STATE_DIRECTORY")); dir != "" {
+			path = filepath.Join(dir, "identity.pem")
+		}
+	}
+	if path == "" {
+		return nil, nil
+	}
+	passphrase := os.Getenv("IDENTITY_PASSPHRASE")
+	if passphrase == "" {
+		cred, err := ReadCredential("IDENTITY_PASSPHRASE")
+		if err != nil {
+			return nil, err
+		}
+		passphrase = cred
+	}
+	return identity.NewStore(path, []byte(passphrase)), nil
+}
+
 func loadExplicitCA() ([]byte, error) {
 	if cred, err := ReadCredential("CONTROLLER_CA"); err != nil {
 		return nil, err
@@ -222,3 +284,25 @@ func ReadCredential(name string) (string, error) {
 	}
 	return strings.TrimSpace(string(data)), nil
 }
+
+// enrollRPCTimeout bounds each enrollment attempt.
+const enrollRPCTimeout = 15 * time.Second
+
+// approvalRetryDelay reports whether err says the enrollment is waiting for
+// admin approval and how long to wait before asking again.
+func approvalRetryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			delay := info.GetRetryDelay().AsDuration()
+			if delay <= 0 {
+				delay = enrollRPCTimeout
+			}
+			return delay, true
+		}
+	}
+	return 0, false
+}
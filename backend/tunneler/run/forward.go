@@ -0,0 +1,140 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sessionWaitTimeout bounds how long an accepted forward connection waits for
+// the connector session to come up (e.g. during a reconnect backoff).
+const sessionWaitTimeout = 10 * time.Second
+
+// fileConfig is the optional tunneler config file (TUNNELER_CONFIG).
+type fileConfig struct {
+	Forwards []forwardConfig `json:"forwards"`
+}
+
+// forwardConfig maps a local listen address to a destination reached
+// through the connector. Protocol is "tcp" (the default) or "udp".
+type forwardConfig struct {
+	Listen   string `json:"listen"`
+	Target   string `json:"target"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// forwarder serves one configured forward until ctx is done.
+type forwarder interface {
+	serve(ctx context.Context) error
+}
+
+// newForwarder returns the TCP or UDP forward for cfg.
+func newForwarder(cfg forwardConfig, sessions *sessionHolder) (forwarder, error) {
+	if cfg.Protocol == "udp" {
+		return newUDPForward(cfg, sessions)
+	}
+	return newPortForward(cfg, sessions)
+}
+
+func loadFileConfig(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, err
+	}
+	// Unknown fields are refused so a misspelt key does not silently drop a
+	// forward.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg fileConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return fileConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := make(map[string]struct{})
+	for i, fwd := range cfg.Forwards {
+		if !validListenAddr(fwd.Listen) {
+			return fileConfig{}, fmt.Errorf("forwards[%d]: invalid listen address %q", i, fwd.Listen)
+		}
+		if _, _, err := splitProxyTarget(fwd.Target, ""); err != nil {
+			return fileConfig{}, fmt.Errorf("forwards[%d]: %w", i, err)
+		}
+		switch fwd.Protocol {
+		case "":
+			cfg.Forwards[i].Protocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return fileConfig{}, fmt.Errorf("forwards[%d]: protocol must be tcp or udp, got %q", i, fwd.Protocol)
+		}
+		// TCP and UDP may share a port.
+		key := cfg.Forwards[i].Protocol + " " + fwd.Listen
+		if _, ok := seen[key]; ok {
+			return fileConfig{}, fmt.Errorf("forwards[%d]: duplicate listen address %q", i, fwd.Listen)
+		}
+		seen[key] = struct{}{}
+	}
+	return cfg, nil
+}
+
+// validListenAddr reports whether addr is host:port with a non-zero port;
+// the host may be empty to listen on all interfaces.
+func validListenAddr(addr string) bool {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	return err == nil && port != 0
+}
+
+// portForward accepts local TCP connections and tunnels each one to a fixed
+// target through the current connector session.
+type portForward struct {
+	listen   string
+	target   string
+	host     string
+	port     uint16
+	sessions *sessionHolder
+}
+
+func newPortForward(cfg forwardConfig, sessions *sessionHolder) (*portForward, error) {
+	host, port, err := splitProxyTarget(cfg.Target, "")
+	if err != nil {
+		return nil, err
+	}
+	return &portForward{listen: cfg.Listen, target: cfg.Target, host: host, port: port, sessions: sessions}, nil
+}
+
+func (f *portForward) serve(ctx context.Context) error {
+	lis, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		return fmt.Errorf("forward listen on %s: %w", f.listen, err)
+	}
+	log.Printf("forwarding %s -> %s", lis.Addr(), f.target)
+	return serveListener(ctx, lis, "forward "+f.listen, func(conn net.Conn) { f.handle(ctx, conn) })
+}
+
+func (f *portForward) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	sess, err := f.sessions.wait(ctx, sessionWaitTimeout)
+	if err != nil {
+		log.Printf("forward %s -> %s failed: %v", f.listen, f.target, err)
+		return
+	}
+	tunnelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := sess.openTunnel(tunnelCtx, f.host, "TCP", f.port)
+	if err != nil {
+		log.Printf("forward %s -> %s failed: %v", f.listen, f.target, err)
+		return
+	}
+	if err := relayTunnelConn(conn, stream); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("forward %s -> %s ended: %v", f.listen, f.target, err)
+	}
+}
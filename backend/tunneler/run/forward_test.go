@@ -0,0 +1,113 @@
+package run
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeForwardConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tunneler.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileConfig(t *testing.T) {
+	cfg, err := loadFileConfig(writeForwardConfig(t, `{"forwards": [
+		{"listen": "127.0.0.1:15432", "target": "db.internal:5432"},
+		{"listen": ":8080", "target": "[fd00::5]:80"},
+		{"listen": ":8080", "target": "dns.internal:53", "protocol": "udp"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Forwards) != 3 || cfg.Forwards[1].Listen != ":8080" || cfg.Forwards[1].Target != "[fd00::5]:80" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.Forwards[0].Protocol != "tcp" || cfg.Forwards[2].Protocol != "udp" {
+		t.Fatalf("unexpected protocols %+v", cfg.Forwards)
+	}
+}
+
+func TestLoadFileConfigRejects(t *testing.T) {
+	tests := map[string]string{
+		"missing listen":   `{"forwards": [{"target": "db.internal:5432"}]}`,
+		"listen no port":   `{"forwards": [{"listen": "127.0.0.1", "target": "db.internal:5432"}]}`,
+		"listen bad port":  `{"forwards": [{"listen": "127.0.0.1:99999", "target": "db.internal:5432"}]}`,
+		"listen port zero": `{"forwards": [{"listen": "127.0.0.1:0", "target": "db.internal:5432"}]}`,
+		"duplicate listen": `{"forwards": [{"listen": ":8080", "target": "a.internal:80"}, {"listen": ":8080", "target": "b.internal:80"}]}`,
+		"missing target":   `{"forwards": [{"listen": ":8080"}]}`,
+		"target no port":   `{"forwards": [{"listen": ":8080", "target": "db.internal"}]}`,
+		"target bad port":  `{"forwards": [{"listen": ":8080", "target": "db.internal:70000"}]}`,
+		"target no host":   `{"forwards": [{"listen": ":8080", "target": ":5432"}]}`,
+		"unknown field":    `{"forwards": [{"listen": ":8080", "taget": "db.internal:5432"}]}`,
+		"bad protocol":     `{"forwards": [{"listen": ":8080", "target": "db.internal:5432", "protocol": "sctp"}]}`,
+		"duplicate udp":    `{"forwards": [{"listen": ":53", "target": "a.internal:53", "protocol": "udp"}, {"listen": ":53", "target": "b.internal:53", "protocol": "udp"}]}`,
+		"unknown top key":  `{"forward": []}`,
+		"not json":         `forwards: []`,
+	}
+	for name, body := range tests {
+		if _, err := loadFileConfig(writeForwardConfig(t, body)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := loadFileConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
+
+func TestPortForwardRelaysThroughTunnel(t *testing.T) {
+	sessions := newTestSessions(t, &fakeConnector{tunnel: echoTunnel(t, "db.internal", 5432)})
+
+	// Reserve a free port for the forward to listen on.
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := probe.Addr().String()
+	_ = probe.Close()
+
+	fwd, err := newPortForward(forwardConfig{Listen: listen, Target: "db.internal:5432"}, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- fwd.serve(ctx) }()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if conn, err = net.Dial("tcp", listen); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("select 1")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "select 1" {
+		t.Fatalf("expected echo, got %q", got)
+	}
+}
@@ -0,0 +1,299 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// UDP tunnels carry one datagram per frame, tagged with a flow ID the
+// tunneler picks. The connector keeps a socket per flow and reports flows it
+// refuses or drops with flow_closed.
+const (
+	tunnelFrameDatagram   = "datagram"
+	tunnelFrameFlowClosed = "flow_closed"
+)
+
+const (
+	udpMaxDatagramSize = 64 * 1024
+	// udpFlowIdleTimeout matches the connector's default flow expiry.
+	udpFlowIdleTimeout = 60 * time.Second
+	// udpPendingLimit bounds the datagrams queued while a tunnel is opened.
+	udpPendingLimit = 64
+)
+
+// udpFlow is a local client of a UDP forward. Its flow ID is the client's
+// address, so a client keeps its connector socket across datagrams.
+type udpFlow struct {
+	addr       net.Addr
+	lastActive time.Time
+}
+
+// udpTunnel is an open protocol=UDP tunnel shared by all of a forward's
+// flows.
+type udpTunnel struct {
+	stream controllerpb.ControlPlane_TunnelClient
+	cancel context.CancelFunc
+	sendMu sync.Mutex
+	// closed is set, under the forward's mu, once the tunnel has failed.
+	closed bool
+}
+
+func (t *udpTunnel) send(frame *controllerpb.TunnelFrame) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	return t.stream.Send(frame)
+}
+
+// udpForward relays datagrams between local UDP clients and a fixed target
+// through one UDP tunnel, opened on the first datagram and reopened after it
+// fails.
+type udpForward struct {
+	listen   string
+	target   string
+	host     string
+	port     uint16
+	sessions *sessionHolder
+	conn     net.PacketConn
+
+	mu      sync.Mutex
+	flows   map[string]*udpFlow
+	tunnel  *udpTunnel
+	opening bool
+	pending []*controllerpb.TunnelFrame
+}
+
+func newUDPForward(cfg forwardConfig, sessions *sessionHolder) (*udpForward, error) {
+	host, port, err := splitProxyTarget(cfg.Target, "")
+	if err != nil {
+		return nil, err
+	}
+	return &udpForward{
+		listen:   cfg.Listen,
+		target:   cfg.Target,
+		host:     host,
+		port:     port,
+		sessions: sessions,
+		flows:    make(map[string]*udpFlow),
+	}, nil
+}
+
+func (f *udpForward) serve(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", f.listen)
+	if err != nil {
+		return fmt.Errorf("forward listen on %s: %w", f.listen, err)
+	}
+	f.conn = conn
+	log.Printf("forwarding udp %s -> %s", conn.LocalAddr(), f.target)
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	go f.expireIdle(ctx)
+
+	buf := make([]byte, udpMaxDatagramSize)
+	var delay time.Duration
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = acceptRetryMin
+			} else if delay *= 2; delay > acceptRetryMax {
+				delay = acceptRetryMax
+			}
+			log.Printf("forward udp %s read failed: %v; retrying in %v", f.listen, err, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+		delay = 0
+		if n == 0 {
+			continue
+		}
+		flowID := addr.String()
+		f.mu.Lock()
+		flow, ok := f.flows[flowID]
+		if !ok {
+			flow = &udpFlow{addr: addr}
+			f.flows[flowID] = flow
+		}
+		flow.lastActive = time.Now()
+		f.mu.Unlock()
+		f.forward(ctx, &controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: flowID, Data: append([]byte(nil), buf[:n]...)})
+	}
+}
+
+// forward sends frame on the current tunnel, or queues it and opens one.
+func (f *udpForward) forward(ctx context.Context, frame *controllerpb.TunnelFrame) {
+	f.mu.Lock()
+	t := f.tunnel
+	if t == nil {
+		if len(f.pending) < udpPendingLimit {
+			f.pending = append(f.pending, frame)
+		}
+		if !f.opening {
+			f.opening = true
+			go f.openTunnel(ctx)
+		}
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	if err := t.send(frame); err != nil {
+		f.dropTunnel(t, err)
+	}
+}
+
+// openTunnel opens the UDP tunnel and sends the datagrams queued meanwhile,
+// in order, before making it current.
+func (f *udpForward) openTunnel(ctx context.Context) {
+	t, err := f.dial(ctx)
+	if err != nil {
+		f.mu.Lock()
+		f.opening = false
+		f.pending = nil
+		f.mu.Unlock()
+		if ctx.Err() == nil {
+			log.Printf("forward udp %s -> %s failed: %v", f.listen, f.target, err)
+		}
+		return
+	}
+	go f.receive(ctx, t)
+	for {
+		f.mu.Lock()
+		pending := f.pending
+		f.pending = nil
+		if len(pending) == 0 || t.closed {
+			if !t.closed {
+				f.tunnel = t
+			}
+			f.opening = false
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+		for _, frame := range pending {
+			if err := t.send(frame); err != nil {
+				f.mu.Lock()
+				f.opening = false
+				f.pending = nil
+				f.mu.Unlock()
+				f.dropTunnel(t, err)
+				return
+			}
+		}
+	}
+}
+
+func (f *udpForward) dial(ctx context.Context) (*udpTunnel, error) {
+	sess, err := f.sessions.wait(ctx, sessionWaitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	tunnelCtx, cancel := context.WithCancel(ctx)
+	stream, err := sess.openTunnel(tunnelCtx, f.host, "UDP", f.port)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &udpTunnel{stream: stream, cancel: cancel}, nil
+}
+
+// receive delivers the connector's datagrams to their flows until the tunnel
+// ends.
+func (f *udpForward) receive(ctx context.Context, t *udpTunnel) {
+	for {
+		frame, err := t.stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				f.dropTunnel(t, err)
+			}
+			return
+		}
+		switch frame.GetType() {
+		case tunnelFrameDatagram:
+			f.mu.Lock()
+			flow, ok := f.flows[frame.GetFlowId()]
+			if ok {
+				flow.lastActive = time.Now()
+			}
+			f.mu.Unlock()
+			if ok {
+				_, _ = f.conn.WriteTo(frame.GetData(), flow.addr)
+			}
+		case tunnelFrameFlowClosed:
+			f.mu.Lock()
+			delete(f.flows, frame.GetFlowId())
+			f.mu.Unlock()
+			if frame.GetReason() != "" {
+				log.Printf("forward udp %s -> %s: flow %s closed: %s", f.listen, f.target, frame.GetFlowId(), frame.GetReason())
+			}
+		case tunnelFrameError:
+			f.dropTunnel(t, &tunnelRejectedError{Reason: frame.GetReason()})
+			return
+		}
+	}
+}
+
+// dropTunnel closes t so the next datagram opens a new tunnel.
+func (f *udpForward) dropTunnel(t *udpTunnel, err error) {
+	f.mu.Lock()
+	first := !t.closed
+	t.closed = true
+	if f.tunnel == t {
+		f.tunnel = nil
+	}
+	f.mu.Unlock()
+	t.cancel()
+	if first {
+		log.Printf("forward udp %s -> %s: tunnel closed: %v", f.listen, f.target, err)
+	}
+}
+
+// expireIdle forgets flows that have been idle for udpFlowIdleTimeout and
+// tells the connector to release their sockets.
+func (f *udpForward) expireIdle(ctx context.Context) {
+	ticker := time.NewTicker(udpFlowIdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		var expired []string
+		for id, flow := range f.flows {
+			if time.Since(flow.lastActive) >= udpFlowIdleTimeout {
+				expired = append(expired, id)
+				delete(f.flows, id)
+			}
+		}
+		t := f.tunnel
+		f.mu.Unlock()
+		if t == nil {
+			continue
+		}
+		for _, id := range expired {
+			if err := t.send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: id}); err != nil {
+				f.dropTunnel(t, err)
+				break
+			}
+		}
+	}
+}
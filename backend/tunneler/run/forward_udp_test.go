@@ -0,0 +1,157 @@
+package run
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// udpEchoTunnel accepts UDP tunnels to wantDest and echoes each datagram to
+// its flow. A datagram "deny" closes its flow as a refused destination would,
+// and "drop" ends the tunnel.
+func udpEchoTunnel(t *testing.T, wantDest string, wantPort uint32, opens *atomic.Int32, flows *sync.Map) func(controllerpb.ControlPlane_TunnelServer) error {
+	return func(stream controllerpb.ControlPlane_TunnelServer) error {
+		open, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if open.GetType() != tunnelFrameOpen || open.GetProtocol() != "UDP" || open.GetDestination() != wantDest || open.GetPort() != wantPort {
+			t.Errorf("unexpected open frame %+v", open)
+			return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "invalid_request"})
+		}
+		opens.Add(1)
+		if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameOpened}); err != nil {
+			return err
+		}
+		for {
+			frame, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if frame.GetType() != tunnelFrameDatagram {
+				continue
+			}
+			flows.Store(frame.GetFlowId(), true)
+			switch string(frame.GetData()) {
+			case "drop":
+				return nil
+			case "deny":
+				err = stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameFlowClosed, FlowId: frame.GetFlowId(), Reason: "not_allowed"})
+			default:
+				err = stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameDatagram, FlowId: frame.GetFlowId(), Data: frame.GetData()})
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// udpExchange sends msg from conn until it gets a reply, allowing for the
+// forward to come up and the tunnel to reopen.
+func udpExchange(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	buf := make([]byte, 1500)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			return string(buf[:n])
+		}
+	}
+	t.Fatalf("no reply to %q", msg)
+	return ""
+}
+
+func TestUDPForwardRelaysFlowsThroughTunnel(t *testing.T) {
+	var opens atomic.Int32
+	var seen sync.Map
+	sessions := newTestSessions(t, &fakeConnector{tunnel: udpEchoTunnel(t, "dns.internal", 53, &opens, &seen)})
+
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := probe.LocalAddr().String()
+	_ = probe.Close()
+
+	fwd, err := newForwarder(forwardConfig{Listen: listen, Target: "dns.internal:53", Protocol: "udp"}, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- fwd.serve(ctx) }()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	clientA, err := net.Dial("udp", listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientA.Close()
+	clientB, err := net.Dial("udp", listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientB.Close()
+
+	if got := udpExchange(t, clientA, "query-a"); got != "query-a" {
+		t.Fatalf("client A got %q", got)
+	}
+	if got := udpExchange(t, clientB, "query-b"); got != "query-b" {
+		t.Fatalf("client B got %q", got)
+	}
+	// Each client is its own flow, named by its address.
+	for _, c := range []net.Conn{clientA, clientB} {
+		if _, ok := seen.Load(c.LocalAddr().String()); !ok {
+			t.Fatalf("expected a flow for %s", c.LocalAddr())
+		}
+	}
+	if n := opens.Load(); n != 1 {
+		t.Fatalf("expected the flows to share one tunnel, got %d", n)
+	}
+
+	// A flow the connector closes is forgotten.
+	u := fwd.(*udpForward)
+	if _, err := clientB.Write([]byte("deny")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		u.mu.Lock()
+		_, ok := u.flows[clientB.LocalAddr().String()]
+		u.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the closed flow to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A failed tunnel is reopened by the next datagram.
+	if _, err := clientA.Write([]byte("drop")); err != nil {
+		t.Fatal(err)
+	}
+	if got := udpExchange(t, clientA, "again"); got != "again" {
+		t.Fatalf("client A got %q after reopen", got)
+	}
+	if n := opens.Load(); n != 2 {
+		t.Fatalf("expected the tunnel to be reopened once, got %d opens", n)
+	}
+}
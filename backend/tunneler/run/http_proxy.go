@@ -0,0 +1,227 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+)
+
+// httpProxyServer is a forward HTTP proxy: CONNECT requests are tunneled
+// as raw TCP, absolute-URI requests are forwarded over a tunnel to the
+// origin. Every destination is authorized by the connector first.
+type httpProxyServer struct {
+	addr     string
+	sessions *sessionHolder
+	proxy    *httputil.ReverseProxy
+}
+
+func newHTTPProxyServer(addr string, sessions *sessionHolder) *httpProxyServer {
+	s := &httpProxyServer{addr: addr, sessions: sessions}
+	s.proxy = &httputil.ReverseProxy{
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			DialContext:         s.dialTunnel,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("http proxy %s %s failed: %v", r.Method, r.URL, err)
+			writeProxyError(w, err)
+		},
+	}
+	return s
+}
+
+func (s *httpProxyServer) serve(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	log.Printf("http proxy listening on %s", s.addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (s *httpProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		s.handleConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Host == "" {
+		http.Error(w, "absolute-form request URI required", http.StatusBadRequest)
+		return
+	}
+	host, port, err := splitProxyTarget(r.URL.Host, r.URL.Scheme)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.authorize(w, r, host, port); !ok {
+		return
+	}
+	s.proxy.ServeHTTP(w, r)
+}
+
+func (s *httpProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
+	host, port, err := splitProxyTarget(r.Host, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sess, ok := s.authorize(w, r, host, port)
+	if !ok {
+		return
+	}
+
+	// The request context ends when the client goes away before the
+	// hijack, or when this handler returns.
+	tunnelCtx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream, err := sess.openTunnel(tunnelCtx, host, "TCP", port)
+	if err != nil {
+		log.Printf("http proxy CONNECT %s failed: %v", r.Host, err)
+		writeProxyError(w, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("http proxy hijack failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	client := &bufferedConn{Conn: conn, r: rw.Reader}
+	if err := relayTunnelConn(client, stream); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("http proxy tunnel %s ended: %v", r.Host, err)
+	}
+}
+
+// authorize asks the connector about host:port and writes an error response
+// when the request cannot proceed.
+func (s *httpProxyServer) authorize(w http.ResponseWriter, r *http.Request, host string, port uint16) (*connectorSession, bool) {
+	sess, err := s.sessions.get()
+	if err != nil {
+		writeProxyError(w, err)
+		return nil, false
+	}
+	d, err := sess.requestDecision(r.Context(), host, "TCP", port)
+	if err != nil {
+		log.Printf("http proxy decision for %s:%d failed: %v", host, port, err)
+		writeProxyError(w, err)
+		return nil, false
+	}
+	if !d.allowed() {
+		log.Printf("http proxy %s:%d denied: reason=%s", host, port, d.Reason)
+		writeProxyError(w, &tunnelRejectedError{Reason: d.Reason})
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *httpProxyServer) dialTunnel(ctx context.Context, _, addr string) (net.Conn, error) {
+	sess, err := s.sessions.get()
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := splitProxyTarget(addr, "")
+	if err != nil {
+		return nil, err
+	}
+	// The connection may be pooled past the request that dialed it, so the
+	// stream gets its own context; ctx only bounds opening it.
+	tunnelCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	stream, err := sess.openTunnel(tunnelCtx, host, "TCP", port)
+	if !stop() {
+		cancel()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return newTunnelConn(stream, cancel, addr), nil
+}
+
+// writeProxyError maps connector deny reasons and session failures to HTTP
+// status codes.
+func writeProxyError(w http.ResponseWriter, err error) {
+	var rejected *tunnelRejectedError
+	switch {
+	case errors.Is(err, errNoConnector):
+		http.Error(w, "connector unavailable", http.StatusServiceUnavailable)
+	case errors.As(err, &rejected):
+		http.Error(w, rejected.Reason, httpStatusForReason(rejected.Reason))
+	default:
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}
+}
+
+func httpStatusForReason(reason string) int {
+	switch reason {
+	case "not_allowed", "resource_not_found":
+		return http.StatusForbidden
+	case "snapshot_expired", "no_snapshot":
+		return http.StatusServiceUnavailable
+	case "invalid_request":
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func splitProxyTarget(hostport, scheme string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		switch scheme {
+		case "http":
+			host, portStr = hostport, "80"
+		case "https":
+			host, portStr = hostport, "443"
+		default:
+			return "", 0, fmt.Errorf("invalid proxy target %q", hostport)
+		}
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 || host == "" {
+		return "", 0, fmt.Errorf("invalid proxy target %q", hostport)
+	}
+	return host, uint16(port), nil
+}
+
+// bufferedConn replays bytes the HTTP server already buffered after the
+// CONNECT request before reading from the raw connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
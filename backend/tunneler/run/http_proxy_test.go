@@ -0,0 +1,68 @@
+package run
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSplitProxyTarget(t *testing.T) {
+	tests := []struct {
+		hostport, scheme string
+		host             string
+		port             uint16
+		wantErr          bool
+	}{
+		{hostport: "db.internal:5432", host: "db.internal", port: 5432},
+		{hostport: "[fd00::5]:443", host: "fd00::5", port: 443},
+		{hostport: "wiki.internal", scheme: "http", host: "wiki.internal", port: 80},
+		{hostport: "wiki.internal", scheme: "https", host: "wiki.internal", port: 443},
+		{hostport: "wiki.internal", wantErr: true},
+		{hostport: "wiki.internal:0", wantErr: true},
+		{hostport: "wiki.internal:70000", wantErr: true},
+		{hostport: ":443", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := splitProxyTarget(tt.hostport, tt.scheme)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q (%s): expected error, got %s:%d", tt.hostport, tt.scheme, host, port)
+			}
+			continue
+		}
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("%q (%s): expected %s:%d, got %s:%d err=%v", tt.hostport, tt.scheme, tt.host, tt.port, host, port, err)
+		}
+	}
+}
+
+func TestHTTPStatusForReason(t *testing.T) {
+	tests := map[string]int{
+		"not_allowed":        http.StatusForbidden,
+		"resource_not_found": http.StatusForbidden,
+		"snapshot_expired":   http.StatusServiceUnavailable,
+		"no_snapshot":        http.StatusServiceUnavailable,
+		"invalid_request":    http.StatusBadRequest,
+		"dial_failed":        http.StatusBadGateway,
+	}
+	for reason, want := range tests {
+		if got := httpStatusForReason(reason); got != want {
+			t.Errorf("%s: expected %d, got %d", reason, want, got)
+		}
+	}
+}
+
+func TestHTTPProxyListenerIsOptIn(t *testing.T) {
+	t.Setenv("CONTROLLER_ADDR", "controller:8443")
+	t.Setenv("CONNECTOR_ADDR", "connector:9443")
+	t.Setenv("TUNNELER_ID", "t1")
+	for value, want := range map[string]string{"": "", "off": "", "127.0.0.1:3128": "127.0.0.1:3128"} {
+		t.Setenv("HTTP_PROXY_LISTEN_ADDR", value)
+		cfg, err := configFromEnv()
+		if err != nil {
+			t.Fatalf("config failed: %v", err)
+		}
+		if cfg.httpProxyAddr != want {
+			t.Errorf("HTTP_PROXY_LISTEN_ADDR=%q: expected %q, got %q", value, want, cfg.httpProxyAddr)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = time.Second
+)
+
+// serveListener accepts connections on lis until ctx is done and hands each
+// to handle on its own goroutine. Like net/http.Server, it backs off after a
+// failed Accept (5ms doubling to 1s) so errors such as EMFILE do not spin the
+// loop or flood the log.
+func serveListener(ctx context.Context, lis net.Listener, name string, handle func(net.Conn)) error {
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+	var delay time.Duration
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = acceptRetryMin
+			} else if delay *= 2; delay > acceptRetryMax {
+				delay = acceptRetryMax
+			}
+			log.Printf("%s accept failed: %v; retrying in %v", name, err, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+		delay = 0
+		go handle(conn)
+	}
+}
@@ -0,0 +1,52 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingListener fails every Accept as if the process were out of file
+// descriptors.
+type failingListener struct {
+	accepts atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return nil, syscall.EMFILE
+}
+
+func (l *failingListener) Close() error   { return nil }
+func (l *failingListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestServeListenerBacksOffOnAcceptErrors(t *testing.T) {
+	lis := &failingListener{}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := serveListener(ctx, lis, "test", func(net.Conn) { t.Error("unexpected connection") })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	// 5+10+20+40ms of backoff fit in 100ms; a hot loop would make thousands
+	// of calls.
+	if n := lis.accepts.Load(); n > 6 {
+		t.Fatalf("expected accept to back off, got %d calls", n)
+	}
+}
+
+func TestServeListenerStopsWhenClosed(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = lis.Close()
+	if err := serveListener(context.Background(), lis, "test", func(net.Conn) {}); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
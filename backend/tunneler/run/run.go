@@ -17,6 +17,8 @@ import (
 	"time"
 
 	controllerpb "controller/gen/controllerpb"
+	"controller/identity"
+	"controller/trustbundle"
 	"tunneler/enroll"
 	"tunneler/internal/tlsutil"
 
@@ -40,10 +42,37 @@ func Run() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	workloadCert, certPEM, caPEM, spiffeID, err := enroll.Enroll(ctx, enrollCfg)
+	ids, err := enroll.IdentityStoreFromEnv()
 	if err != nil {
 		return err
 	}
+	var (
+		workloadCert tls.Certificate
+		certPEM      []byte
+		caPEM        []byte
+		spiffeID     string
+		restored     bool
+	)
+	if ids != nil {
+		id, err := ids.Load()
+		switch {
+		case err == nil && id.SPIFFEID != fmt.Sprintf("spiffe://%s/tunneler/%s", cfg.trustDomain, cfg.tunnelerID):
+			log.Printf("stored identity %s does not match TRUST_DOMAIN and TUNNELER_ID; re-enrolling", id.SPIFFEID)
+		case err == nil && time.Now().Before(id.NotAfter):
+			workloadCert, certPEM, caPEM, spiffeID = id.Certificate, id.CertPEM, id.CAPEM, id.SPIFFEID
+			restored = true
+		case err == nil:
+			log.Printf("stored identity expired at %s; re-enrolling", id.NotAfter.Format(time.RFC3339))
+		case !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("load identity: %w", err)
+		}
+	}
+	if !restored {
+		workloadCert, certPEM, caPEM, spiffeID, err = enroll.Enroll(ctx, enrollCfg)
+		if err != nil {
+			return err
+		}
+	}
 
 	certInfo, err := parseLeafCert(certPEM)
 	if err != nil {
@@ -52,16 +81,63 @@ func Run() error {
 
 	store := tlsutil.NewCertStore(workloadCert, certPEM, certInfo.NotAfter)
 	totalTTL := certInfo.NotAfter.Sub(certInfo.NotBefore)
-	rootPool, err := tlsutil.RootPoolFromPEM(caPEM)
+	trust, err := trustbundle.New(caPEM)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("tunneler enrolled as %s", spiffeID)
+	if restored {
+		log.Printf("tunneler restored identity %s from %s", spiffeID, ids.Path())
+		cert, renewedPEM, notAfter, notBefore, err := renewOnce(ctx, cfg.controllerAddr, cfg.tunnelerID, cfg.trustDomain, store, trust)
+		if err != nil {
+			log.Printf("startup renewal failed, using stored certificate until %s: %v", certInfo.NotAfter.Format(time.RFC3339), err)
+		} else {
+			store.Update(cert, renewedPEM, notAfter)
+			workloadCert, certPEM = cert, renewedPEM
+			totalTTL = notAfter.Sub(notBefore)
+		}
+	} else {
+		log.Printf("tunneler enrolled as %s", spiffeID)
+	}
+	if ids != nil {
+		if err := ids.Save(workloadCert, certPEM, trust.PEM()); err != nil {
+			log.Printf("failed to persist identity to %s: %v", ids.Path(), err)
+		}
+	}
+
+	sessions := &sessionHolder{}
+	if cfg.socksAddr != "" {
+		socks := &socksServer{addr: cfg.socksAddr, sessions: sessions}
+		go func() {
+			if err := socks.serve(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("socks proxy stopped: %v", err)
+			}
+		}()
+	}
+	if cfg.httpProxyAddr != "" {
+		httpProxy := newHTTPProxyServer(cfg.httpProxyAddr, sessions)
+		go func() {
+			if err := httpProxy.serve(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("http proxy stopped: %v", err)
+			}
+		}()
+	}
+
+	for _, fwdCfg := range cfg.forwards {
+		fwd, err := newForwarder(fwdCfg, sessions)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := fwd.serve(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("forward %s/%s stopped: %v", fwdCfg.Listen, fwdCfg.Protocol, err)
+			}
+		}()
+	}
 
 	reloadCh := make(chan struct{}, 1)
-	go controlPlaneLoop(ctx, cfg.connectorAddr, cfg.trustDomain, store, rootPool, spiffeID, cfg.tunnelerID, reloadCh)
-	go renewalLoop(ctx, cfg.controllerAddr, cfg.tunnelerID, cfg.trustDomain, store, rootPool, caPEM, totalTTL, reloadCh)
+	go controlPlaneLoop(ctx, cfg.connectorAddr, cfg.trustDomain, store, trust, spiffeID, cfg.tunnelerID, sessions, reloadCh)
+	go renewalLoop(ctx, cfg.controllerAddr, cfg.tunnelerID, cfg.trustDomain, store, trust, totalTTL, ids, reloadCh)
 
 	<-ctx.Done()
 	return ctx.Err()
@@ -72,6 +148,9 @@ type runtimeConfig struct {
 	connectorAddr  string
 	tunnelerID     string
 	trustDomain    string
+	socksAddr      string
+	httpProxyAddr  string
+	forwards       []forwardConfig
 }
 
 func configFromEnv() (runtimeConfig, error) {
@@ -79,6 +158,8 @@ func configFromEnv() (runtimeConfig, error) {
 	connectorAddr := os.Getenv("CONNECTOR_ADDR")
 	tunnelerID := os.Getenv("TUNNELER_ID")
 	trustDomain := os.Getenv("TRUST_DOMAIN")
+	socksAddr := listenAddrFromEnv("SOCKS_LISTEN_ADDR")
+	httpProxyAddr := listenAddrFromEnv("HTTP_PROXY_LISTEN_ADDR")
 
 	if trustDomain == "" {
 		trustDomain = "mycorp.internal"
@@ -94,15 +175,38 @@ func configFromEnv() (runtimeConfig, error) {
 		return runtimeConfig{}, fmt.Errorf("TUNNELER_ID is not set")
 	}
 
+	var forwards []forwardConfig
+	if path := strings.TrimSpace(os.Getenv("TUNNELER_CONFIG")); path != "" {
+		fileCfg, err := loadFileConfig(path)
+		if err != nil {
+			return runtimeConfig{}, err
+		}
+		forwards = fileCfg.Forwards
+	}
+
 	return runtimeConfig{
 		controllerAddr: controllerAddr,
 		connectorAddr:  connectorAddr,
 		tunnelerID:     tunnelerID,
 		trustDomain:    trustDomain,
+		socksAddr:      socksAddr,
+		httpProxyAddr:  httpProxyAddr,
+		forwards:       forwards,
 	}, nil
 }
 
-func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string, reloadCh <-chan struct{}) {
+// listenAddrFromEnv returns the bind address for an optional local listener.
+// Listeners are opt-in: unset, "off" or "disabled" leaves it off.
+func listenAddrFromEnv(key string) string {
+	v := strings.TrimSpace(os.Getenv(key))
+	switch strings.ToLower(v) {
+	case "off", "disabled":
+		return ""
+	}
+	return v
+}
+
+func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, spiffeID, tunnelerID string, sessions *sessionHolder, reloadCh <-chan struct{}) {
 	backoff := 2 * time.Second
 	for {
 		select {
@@ -114,7 +218,7 @@ func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, st
 		sessionCtx, cancel := context.WithCancel(ctx)
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- connectToConnector(sessionCtx, connectorAddr, trustDomain, store, roots, spiffeID, tunnelerID)
+			errCh <- connectToConnector(sessionCtx, connectorAddr, trustDomain, store, trust, spiffeID, tunnelerID, sessions)
 		}()
 
 		select {
@@ -145,11 +249,11 @@ func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, st
 	}
 }
 
-func connectToConnector(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string) error {
+func connectToConnector(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, spiffeID, tunnelerID string, sessions *sessionHolder) error {
 	tlsConfig := &tls.Config{
 		MinVersion:           tls.VersionTLS13,
 		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
+		RootCAs:              trust.Pool(),
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "connector")
 		},
@@ -176,18 +280,23 @@ func connectToConnector(ctx context.Context, connectorAddr, trustDomain string,
 		return err
 	}
 
-	if err := stream.Send(&controllerpb.ControlMessage{Type: "tunneler_hello"}); err != nil {
+	sess := newConnectorSession(client, stream)
+	sess.trust = trust
+	if err := sess.send(&controllerpb.ControlMessage{Type: "tunneler_hello"}); err != nil {
 		return err
 	}
+	sessions.current.Store(sess)
+	defer sessions.current.CompareAndSwap(sess, nil)
 
 	recvErr := make(chan error, 1)
 	go func() {
 		for {
-			_, err := stream.Recv()
+			msg, err := stream.Recv()
 			if err != nil {
 				recvErr <- err
 				return
 			}
+			sess.handleMessage(msg)
 		}
 	}()
 
@@ -205,7 +314,7 @@ func connectToConnector(ctx context.Context, connectorAddr, trustDomain string,
 				"tunneler_id": tunnelerID,
 				"spiffe_id":   spiffeID,
 			})
-			if err := stream.Send(&controllerpb.ControlMessage{
+			if err := sess.send(&controllerpb.ControlMessage{
 				Type:    "tunneler_heartbeat",
 				Payload: payload,
 				Status:  "ONLINE",
@@ -216,7 +325,7 @@ func connectToConnector(ctx context.Context, connectorAddr, trustDomain string,
 	}
 }
 
-func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte, totalTTL time.Duration, reloadCh chan<- struct{}) {
+func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle, totalTTL time.Duration, ids *identity.Store, reloadCh chan<- struct{}) {
 	for {
 		next := nextRenewal(store.NotAfter(), totalTTL)
 		timer := time.NewTimer(time.Until(next))
@@ -224,10 +333,21 @@ func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain st
 		case <-ctx.Done():
 			timer.Stop()
 			return
+		case <-trust.Changed():
+			// A CA bundle relayed by the connector; persist it with the
+			// current certificate.
+			timer.Stop()
+			if ids != nil {
+				cert, certPEM := store.Certificate()
+				if err := ids.Save(cert, certPEM, trust.PEM()); err != nil {
+					log.Printf("failed to persist CA bundle to %s: %v", ids.Path(), err)
+				}
+			}
+			continue
 		case <-timer.C:
 		}
 
-		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, tunnelerID, trustDomain, store, roots, caPEM)
+		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, tunnelerID, trustDomain, store, trust)
 		if err != nil {
 			log.Printf("certificate renewal failed: %v", err)
 			continue
@@ -235,10 +355,15 @@ func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain st
 
 		store.Update(cert, certPEM, notAfter)
 		totalTTL = notAfter.Sub(notBefore)
+		if ids != nil {
+			if err := ids.Save(cert, certPEM, trust.PEM()); err != nil {
+				log.Printf("failed to persist renewed identity to %s: %v", ids.Path(), err)
+			}
+		}
 	}
 }
 
-func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte) (tls.Certificate, []byte, time.Time, time.Time, error) {
+func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, trust *trustbundle.Bundle) (tls.Certificate, []byte, time.Time, time.Time, error) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
@@ -250,11 +375,15 @@ func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain stri
 	}
 
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	csrPEM, err := tlsutil.CreateCSR(privKey, fmt.Sprintf("spiffe://%s/tunneler/%s", trustDomain, tunnelerID))
+	if err != nil {
+		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
+	}
 
 	tlsConfig := &tls.Config{
 		MinVersion:           tls.VersionTLS13,
 		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
+		RootCAs:              trust.Pool(),
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
 		},
@@ -271,31 +400,47 @@ func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain stri
 	defer conn.Close()
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
-	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: tunnelerID, PublicKey: pubPEM})
+	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: tunnelerID, PublicKey: pubPEM, Csr: csrPEM})
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
 	if len(resp.CaCertificate) == 0 {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("empty CA certificate in renewal response")
 	}
-	if !tlsutil.EqualCAPEM(caPEM, resp.CaCertificate) {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("internal CA mismatch during renewal")
-	}
-
-	block, _ := pem.Decode(resp.Certificate)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("invalid certificate PEM")
-	}
 
-	leaf, err := x509.ParseCertificate(block.Bytes)
+	chain, leaf, err := tlsutil.ParseCertChain(resp.Certificate)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
+	if err := acceptCABundle(trust, resp.CaCertificate, leaf); err != nil {
+		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
+	}
 
-	workloadCert := tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: privKey}
+	workloadCert := tls.Certificate{Certificate: chain, PrivateKey: privKey}
 	return workloadCert, resp.Certificate, leaf.NotAfter, leaf.NotBefore, nil
 }
 
+// acceptCABundle installs the CA bundle returned with a renewed certificate.
+// During a CA rotation it differs from the trusted bundle; it must still
+// share a root with it and must verify the new certificate.
+func acceptCABundle(trust *trustbundle.Bundle, bundlePEM []byte, leaf *x509.Certificate) error {
+	roots, err := tlsutil.RootPoolFromPEM(bundlePEM)
+	if err != nil {
+		return err
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return fmt.Errorf("renewed certificate does not chain to CA bundle: %w", err)
+	}
+	changed, err := trust.Update(bundlePEM)
+	if err != nil {
+		return fmt.Errorf("CA bundle rejected during renewal: %w", err)
+	}
+	if changed {
+		log.Printf("CA bundle updated during renewal")
+	}
+	return nil
+}
+
 func nextRenewal(notAfter time.Time, totalTTL time.Duration) time.Time {
 	remaining := time.Until(notAfter)
 	if remaining <= 0 {
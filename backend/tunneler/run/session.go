@@ -0,0 +1,166 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/trustbundle"
+)
+
+const decisionTimeout = 5 * time.Second
+
+var errNoConnector = errors.New("connector session not established")
+
+// decision is the connector's answer to a tunneler_request.
+type decision struct {
+	RequestID  string `json:"request_id"`
+	Decision   string `json:"decision"`
+	ResourceID string `json:"resource_id"`
+	Reason     string `json:"reason"`
+}
+
+func (d decision) allowed() bool {
+	return d.Decision == "allow"
+}
+
+// connectorSession is the live connection to the connector: the control
+// stream used for decisions and the client used to open Tunnel streams.
+type connectorSession struct {
+	client controllerpb.ControlPlaneClient
+	stream controllerpb.ControlPlane_ConnectClient
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan decision
+	seq     atomic.Uint64
+
+	// trust receives CA bundle updates relayed by the connector.
+	trust *trustbundle.Bundle
+}
+
+func newConnectorSession(client controllerpb.ControlPlaneClient, stream controllerpb.ControlPlane_ConnectClient) *connectorSession {
+	return &connectorSession{
+		client:  client,
+		stream:  stream,
+		pending: make(map[string]chan decision),
+	}
+}
+
+func (s *connectorSession) send(msg *controllerpb.ControlMessage) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.stream.Send(msg)
+}
+
+// requestDecision asks the connector whether dest/protocol/port is allowed
+// for this tunneler and waits for the matching tunneler_decision.
+func (s *connectorSession) requestDecision(ctx context.Context, dest, protocol string, port uint16) (decision, error) {
+	requestID := fmt.Sprintf("req-%d", s.seq.Add(1))
+	ch := make(chan decision, 1)
+	s.mu.Lock()
+	s.pending[requestID] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, requestID)
+		s.mu.Unlock()
+	}()
+
+	payload, err := json.Marshal(map[string]any{
+		"request_id":  requestID,
+		"destination": dest,
+		"protocol":    protocol,
+		"port":        port,
+	})
+	if err != nil {
+		return decision{}, err
+	}
+	if err := s.send(&controllerpb.ControlMessage{Type: "tunneler_request", Payload: payload}); err != nil {
+		return decision{}, err
+	}
+
+	timer := time.NewTimer(decisionTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return decision{}, ctx.Err()
+	case <-timer.C:
+		return decision{}, errors.New("timed out waiting for connector decision")
+	case d := <-ch:
+		return d, nil
+	}
+}
+
+// handleMessage dispatches messages received from the connector.
+func (s *connectorSession) handleMessage(msg *controllerpb.ControlMessage) {
+	switch msg.GetType() {
+	case "tunneler_decision":
+		var d decision
+		if err := json.Unmarshal(msg.GetPayload(), &d); err != nil {
+			return
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[d.RequestID]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case ch <- d:
+			default:
+			}
+		}
+	case "ca_bundle":
+		if s.trust == nil {
+			return
+		}
+		var bundle struct {
+			BundlePEM string `json:"bundle_pem"`
+		}
+		if err := json.Unmarshal(msg.GetPayload(), &bundle); err != nil {
+			return
+		}
+		changed, err := s.trust.Update([]byte(bundle.BundlePEM))
+		if err != nil {
+			log.Printf("CA bundle rejected: %v", err)
+		} else if changed {
+			log.Printf("CA bundle updated by connector")
+		}
+	}
+}
+
+// sessionHolder publishes the current connector session to local listeners.
+type sessionHolder struct {
+	current atomic.Pointer[connectorSession]
+}
+
+func (h *sessionHolder) get() (*connectorSession, error) {
+	sess := h.current.Load()
+	if sess == nil {
+		return nil, errNoConnector
+	}
+	return sess, nil
+}
+
+// wait is like get but gives a reconnecting session up to timeout to appear.
+func (h *sessionHolder) wait(ctx context.Context, timeout time.Duration) (*connectorSession, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if sess, err := h.get(); err == nil {
+			return sess, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errNoConnector
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
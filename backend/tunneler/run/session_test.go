@@ -0,0 +1,82 @@
+package run
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeConnector serves the connector's Tunnel RPC with tunnel.
+type fakeConnector struct {
+	controllerpb.UnimplementedControlPlaneServer
+	tunnel func(stream controllerpb.ControlPlane_TunnelServer) error
+}
+
+func (f *fakeConnector) Tunnel(stream controllerpb.ControlPlane_TunnelServer) error {
+	return f.tunnel(stream)
+}
+
+// newTestSessions starts srv on an in-memory listener and returns a holder
+// whose current session talks to it.
+func newTestSessions(t *testing.T, srv controllerpb.ControlPlaneServer) *sessionHolder {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	controllerpb.RegisterControlPlaneServer(gs, srv)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///connector",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	sessions := &sessionHolder{}
+	sessions.current.Store(newConnectorSession(controllerpb.NewControlPlaneClient(conn), nil))
+	return sessions
+}
+
+// echoTunnel accepts a TCP tunnel to wantDest and echoes its data back,
+// half-closing when the tunneler does.
+func echoTunnel(t *testing.T, wantDest string, wantPort uint32) func(controllerpb.ControlPlane_TunnelServer) error {
+	return func(stream controllerpb.ControlPlane_TunnelServer) error {
+		open, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if open.GetType() != tunnelFrameOpen || open.GetDestination() != wantDest || open.GetPort() != wantPort {
+			t.Errorf("unexpected open frame %+v", open)
+			return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameError, Reason: "not_allowed"})
+		}
+		if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameOpened}); err != nil {
+			return err
+		}
+		for {
+			frame, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch frame.GetType() {
+			case tunnelFrameData:
+				if err := stream.Send(frame); err != nil {
+					return err
+				}
+			case tunnelFrameCloseWrite:
+				return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameCloseWrite})
+			}
+		}
+	}
+}
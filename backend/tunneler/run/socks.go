@@ -0,0 +1,203 @@
+package run
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 (RFC 1928) constants. Only the CONNECT command with no
+// authentication is supported; the listener is meant for loopback use.
+const (
+	socksVersion5 = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect = 0x01
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksReplySucceeded          = 0x00
+	socksReplyGeneralFailure     = 0x01
+	socksReplyNotAllowed         = 0x02
+	socksReplyHostUnreachable    = 0x04
+	socksReplyCommandUnsupported = 0x07
+	socksReplyAddrUnsupported    = 0x08
+)
+
+const socksHandshakeTimeout = 10 * time.Second
+
+// socksServer accepts SOCKS5 CONNECT requests and forwards them through the
+// connector after it has authorized the destination.
+type socksServer struct {
+	addr     string
+	sessions *sessionHolder
+}
+
+func (s *socksServer) serve(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("socks listen on %s: %w", s.addr, err)
+	}
+	log.Printf("socks5 proxy listening on %s", lis.Addr())
+	return serveListener(ctx, lis, "socks", func(conn net.Conn) { s.handle(ctx, conn) })
+}
+
+func (s *socksServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	host, port, err := socksHandshake(conn)
+	if err != nil {
+		log.Printf("socks handshake from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	sess, err := s.sessions.get()
+	if err != nil {
+		_ = writeSocksReply(conn, socksReplyGeneralFailure)
+		log.Printf("socks connect %s:%d failed: %v", host, port, err)
+		return
+	}
+	d, err := sess.requestDecision(ctx, host, "TCP", port)
+	if err != nil {
+		_ = writeSocksReply(conn, socksReplyGeneralFailure)
+		log.Printf("socks connect %s:%d failed: %v", host, port, err)
+		return
+	}
+	if !d.allowed() {
+		_ = writeSocksReply(conn, socksReplyNotAllowed)
+		log.Printf("socks connect %s:%d denied: reason=%s", host, port, d.Reason)
+		return
+	}
+
+	tunnelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := sess.openTunnel(tunnelCtx, host, "TCP", port)
+	if err != nil {
+		_ = writeSocksReply(conn, socksReplyForError(err))
+		log.Printf("socks connect %s:%d failed: %v", host, port, err)
+		return
+	}
+	if err := writeSocksReply(conn, socksReplySucceeded); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if err := relayTunnelConn(conn, stream); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("socks tunnel %s:%d ended: %v", host, port, err)
+	}
+}
+
+// socksHandshake negotiates the auth method and reads a CONNECT request,
+// returning the requested host and port.
+func socksHandshake(conn net.Conn) (string, uint16, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != socksVersion5 {
+		return "", 0, fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", 0, err
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion5, method}); err != nil {
+		return "", 0, err
+	}
+	if method == socksMethodNoAcceptable {
+		return "", 0, errors.New("client offered no acceptable auth method")
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", 0, err
+	}
+	if req[0] != socksVersion5 {
+		return "", 0, fmt.Errorf("unsupported socks version %d", req[0])
+	}
+
+	var host string
+	switch req[3] {
+	case socksAtypIPv4:
+		addr := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr).String()
+	case socksAtypIPv6:
+		addr := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr).String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, int(length[0]))
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		_ = writeSocksReply(conn, socksReplyAddrUnsupported)
+		return "", 0, fmt.Errorf("unsupported address type %d", req[3])
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBuf); err != nil {
+		return "", 0, err
+	}
+	port := binary.BigEndian.Uint16(portBuf)
+
+	if req[1] != socksCmdConnect {
+		_ = writeSocksReply(conn, socksReplyCommandUnsupported)
+		return "", 0, fmt.Errorf("unsupported command %d for %s", req[1], net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}
+	if host == "" || port == 0 {
+		_ = writeSocksReply(conn, socksReplyGeneralFailure)
+		return "", 0, errors.New("empty destination")
+	}
+	return host, port, nil
+}
+
+// writeSocksReply sends a reply with an unspecified bind address; clients
+// do not need the connector-side address.
+func writeSocksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion5, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func socksReplyForError(err error) byte {
+	var rejected *tunnelRejectedError
+	if !errors.As(err, &rejected) {
+		return socksReplyGeneralFailure
+	}
+	switch rejected.Reason {
+	case "dial_failed":
+		return socksReplyHostUnreachable
+	case "not_allowed", "resource_not_found":
+		return socksReplyNotAllowed
+	default:
+		return socksReplyGeneralFailure
+	}
+}
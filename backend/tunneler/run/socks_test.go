@@ -0,0 +1,135 @@
+package run
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// runSocksHandshake runs socksHandshake against client, which writes the
+// given request bytes and collects everything the server sends back.
+func runSocksHandshake(t *testing.T, request []byte) (string, uint16, []byte, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+
+	type result struct {
+		host string
+		port uint16
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		host, port, err := socksHandshake(server)
+		_ = server.Close()
+		done <- result{host, port, err}
+	}()
+
+	go func() { _, _ = client.Write(request) }()
+	replies, _ := io.ReadAll(client)
+	r := <-done
+	return r.host, r.port, replies, r.err
+}
+
+func socksConnectRequest(atyp byte, addr []byte, port uint16) []byte {
+	req := []byte{socksVersion5, 1, socksMethodNoAuth, socksVersion5, socksCmdConnect, 0x00, atyp}
+	req = append(req, addr...)
+	return binary.BigEndian.AppendUint16(req, port)
+}
+
+func TestSocksHandshakeAddressTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		atyp byte
+		addr []byte
+		host string
+	}{
+		{"ipv4", socksAtypIPv4, net.ParseIP("10.0.0.5").To4(), "10.0.0.5"},
+		{"ipv6", socksAtypIPv6, net.ParseIP("fd00::5"), "fd00::5"},
+		{"domain", socksAtypDomain, append([]byte{byte(len("db.internal"))}, "db.internal"...), "db.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, replies, err := runSocksHandshake(t, socksConnectRequest(tt.atyp, tt.addr, 5432))
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if host != tt.host || port != 5432 {
+				t.Fatalf("expected %s:5432, got %s:%d", tt.host, host, port)
+			}
+			if !bytes.Equal(replies, []byte{socksVersion5, socksMethodNoAuth}) {
+				t.Fatalf("unexpected method reply %v", replies)
+			}
+		})
+	}
+}
+
+func TestSocksHandshakeRejectsAuthOnlyClients(t *testing.T) {
+	// Offers only username/password auth.
+	_, _, replies, err := runSocksHandshake(t, []byte{socksVersion5, 1, 0x02})
+	if err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+	if !bytes.Equal(replies, []byte{socksVersion5, socksMethodNoAcceptable}) {
+		t.Fatalf("expected no-acceptable-method reply, got %v", replies)
+	}
+}
+
+func TestSocksHandshakeRejectsUnsupportedCommand(t *testing.T) {
+	req := socksConnectRequest(socksAtypIPv4, net.ParseIP("10.0.0.5").To4(), 53)
+	req[4] = 0x03 // UDP ASSOCIATE
+	_, _, replies, err := runSocksHandshake(t, req)
+	if err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+	if len(replies) < 4 || replies[3] != socksReplyCommandUnsupported {
+		t.Fatalf("expected command-unsupported reply, got %v", replies)
+	}
+}
+
+func TestSocksHandshakeRejectsUnknownAddressType(t *testing.T) {
+	_, _, replies, err := runSocksHandshake(t, socksConnectRequest(0x05, nil, 80))
+	if err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+	if len(replies) < 4 || replies[3] != socksReplyAddrUnsupported {
+		t.Fatalf("expected address-unsupported reply, got %v", replies)
+	}
+}
+
+func TestSocksReplyForError(t *testing.T) {
+	tests := map[string]byte{
+		"dial_failed":        socksReplyHostUnreachable,
+		"not_allowed":        socksReplyNotAllowed,
+		"resource_not_found": socksReplyNotAllowed,
+		"no_snapshot":        socksReplyGeneralFailure,
+	}
+	for reason, want := range tests {
+		if got := socksReplyForError(&tunnelRejectedError{Reason: reason}); got != want {
+			t.Errorf("%s: expected reply %d, got %d", reason, want, got)
+		}
+	}
+	if got := socksReplyForError(io.ErrUnexpectedEOF); got != socksReplyGeneralFailure {
+		t.Errorf("expected general failure for transport errors, got %d", got)
+	}
+}
+
+func TestSocksListenerIsOptIn(t *testing.T) {
+	t.Setenv("CONTROLLER_ADDR", "controller:8443")
+	t.Setenv("CONNECTOR_ADDR", "connector:9443")
+	t.Setenv("TUNNELER_ID", "t1")
+	t.Setenv("SOCKS_LISTEN_ADDR", "")
+	cfg, err := configFromEnv()
+	if err != nil {
+		t.Fatalf("config failed: %v", err)
+	}
+	if cfg.socksAddr != "" {
+		t.Fatalf("expected socks proxy off by default, got %q", cfg.socksAddr)
+	}
+	t.Setenv("SOCKS_LISTEN_ADDR", "127.0.0.1:1080")
+	if cfg, _ = configFromEnv(); cfg.socksAddr != "127.0.0.1:1080" {
+		t.Fatalf("expected configured socks address, got %q", cfg.socksAddr)
+	}
+}
@@ -0,0 +1,127 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// Tunnel frame types exchanged with the connector's Tunnel RPC.
+const (
+	tunnelFrameOpen       = "open"
+	tunnelFrameOpened     = "opened"
+	tunnelFrameData       = "data"
+	tunnelFrameCloseWrite = "close_write"
+	tunnelFrameError      = "error"
+)
+
+const tunnelBufferSize = 32 * 1024
+
+// tunnelRejectedError carries the connector's reason for refusing a tunnel.
+type tunnelRejectedError struct {
+	Reason string
+}
+
+func (e *tunnelRejectedError) Error() string {
+	return fmt.Sprintf("tunnel rejected: %s", e.Reason)
+}
+
+// openTunnel opens a Tunnel stream to dest and waits for the connector to
+// confirm it. The caller must cancel ctx once the tunnel is finished.
+func (s *connectorSession) openTunnel(ctx context.Context, dest, protocol string, port uint16) (controllerpb.ControlPlane_TunnelClient, error) {
+	stream, err := s.client.Tunnel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&controllerpb.TunnelFrame{
+		Type:        tunnelFrameOpen,
+		Destination: dest,
+		Protocol:    protocol,
+		Port:        uint32(port),
+	}); err != nil {
+		return nil, err
+	}
+	frame, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	switch frame.GetType() {
+	case tunnelFrameOpened:
+		return stream, nil
+	case tunnelFrameError:
+		return nil, &tunnelRejectedError{Reason: frame.GetReason()}
+	default:
+		return nil, fmt.Errorf("unexpected tunnel frame %q", frame.GetType())
+	}
+}
+
+// relayTunnelConn copies bytes between a local connection and an open
+// Tunnel stream, propagating half-closes in both directions.
+func relayTunnelConn(conn net.Conn, stream controllerpb.ControlPlane_TunnelClient) error {
+	upErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, tunnelBufferSize)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				data := append([]byte(nil), buf[:n]...)
+				if sendErr := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameData, Data: data}); sendErr != nil {
+					upErr <- sendErr
+					return
+				}
+			}
+			if err == io.EOF {
+				_ = stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameCloseWrite})
+				upErr <- stream.CloseSend()
+				return
+			}
+			if err != nil {
+				upErr <- err
+				return
+			}
+		}
+	}()
+
+	downErr := func() error {
+		for {
+			frame, err := stream.Recv()
+			if err == io.EOF {
+				return closeWrite(conn)
+			}
+			if err != nil {
+				return err
+			}
+			switch frame.GetType() {
+			case tunnelFrameData:
+				if _, err := conn.Write(frame.GetData()); err != nil {
+					return err
+				}
+			case tunnelFrameCloseWrite:
+				return closeWrite(conn)
+			case tunnelFrameError:
+				return &tunnelRejectedError{Reason: frame.GetReason()}
+			}
+		}
+	}()
+	if downErr != nil {
+		_ = conn.Close()
+		<-upErr
+		return downErr
+	}
+	err := <-upErr
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package run
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// tunnelConn adapts an open Tunnel stream to net.Conn so standard library
+// clients (such as http.Transport) can dial through the connector.
+//
+// A read deadline only ends the blocked Read; the stream stays usable. A
+// write deadline that passes while a frame is being sent cannot interrupt
+// the send, so it closes the tunnel instead.
+type tunnelConn struct {
+	stream controllerpb.ControlPlane_TunnelClient
+	cancel context.CancelFunc
+	local  net.Addr
+	remote net.Addr
+
+	// recvCh carries frames from the receive goroutine, which is started by
+	// the first Read and stops once done is closed.
+	recvOnce sync.Once
+	recvCh   chan tunnelRecv
+	done     chan struct{}
+	readBuf  []byte
+	readErr  error
+
+	readDeadline  connDeadline
+	writeDeadline connDeadline
+	writeTimedOut atomic.Bool
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+type tunnelRecv struct {
+	frame *controllerpb.TunnelFrame
+	err   error
+}
+
+func newTunnelConn(stream controllerpb.ControlPlane_TunnelClient, cancel context.CancelFunc, target string) *tunnelConn {
+	return &tunnelConn{
+		stream:        stream,
+		cancel:        cancel,
+		local:         tunnelAddr("tunneler"),
+		remote:        tunnelAddr(target),
+		recvCh:        make(chan tunnelRecv),
+		done:          make(chan struct{}),
+		readDeadline:  makeConnDeadline(),
+		writeDeadline: makeConnDeadline(),
+	}
+}
+
+func (c *tunnelConn) receive() {
+	for {
+		frame, err := c.stream.Recv()
+		select {
+		case c.recvCh <- tunnelRecv{frame: frame, err: err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.recvOnce.Do(func() { go c.receive() })
+		var r tunnelRecv
+		select {
+		case r = <-c.recvCh:
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-c.done:
+			return 0, net.ErrClosed
+		}
+		if r.err != nil {
+			c.readErr = r.err
+			continue
+		}
+		switch r.frame.GetType() {
+		case tunnelFrameData:
+			c.readBuf = r.frame.GetData()
+		case tunnelFrameCloseWrite:
+			c.readErr = io.EOF
+		case tunnelFrameError:
+			c.readErr = &tunnelRejectedError{Reason: r.frame.GetReason()}
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	expired := c.writeDeadline.wait()
+	select {
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	if c.writeDeadline.isSet() {
+		sent := make(chan struct{})
+		defer close(sent)
+		go func() {
+			select {
+			case <-expired:
+				c.writeTimedOut.Store(true)
+				_ = c.Close()
+			case <-sent:
+			}
+		}()
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > tunnelBufferSize {
+			chunk = chunk[:tunnelBufferSize]
+		}
+		data := append([]byte(nil), chunk...)
+		if err := c.stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameData, Data: data}); err != nil {
+			if c.writeTimedOut.Load() {
+				return written, os.ErrDeadlineExceeded
+			}
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// CloseWrite half-closes the tunnel towards the destination.
+func (c *tunnelConn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameCloseWrite}); err != nil {
+		return err
+	}
+	return c.stream.CloseSend()
+}
+
+func (c *tunnelConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.cancel()
+	})
+	return nil
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return c.local }
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// connDeadline is a resettable deadline whose wait channel is closed once
+// it passes, as in net.Pipe.
+type connDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeConnDeadline() connDeadline {
+	return connDeadline{cancel: make(chan struct{})}
+}
+
+func (d *connDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // the timer fired; wait for it to close cancel
+	}
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline passes.
+func (d *connDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// isSet reports whether a deadline is pending or has passed.
+func (d *connDeadline) isSet() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.timer != nil || isClosedChan(d.cancel)
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "tunnel" }
+func (a tunnelAddr) String() string  { return string(a) }
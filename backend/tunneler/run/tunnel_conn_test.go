@@ -0,0 +1,112 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// dialTestTunnel opens a tunnel to db.internal:5432 on the fake connector.
+func dialTestTunnel(t *testing.T, sessions *sessionHolder) *tunnelConn {
+	t.Helper()
+	sess, err := sessions.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := sess.openTunnel(ctx, "db.internal", "TCP", 5432)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	conn := newTunnelConn(stream, cancel, "db.internal:5432")
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestTunnelConnReadDeadline(t *testing.T) {
+	release := make(chan struct{})
+	sessions := newTestSessions(t, &fakeConnector{tunnel: func(stream controllerpb.ControlPlane_TunnelServer) error {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		if err := stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameOpened}); err != nil {
+			return err
+		}
+		<-release
+		return stream.Send(&controllerpb.TunnelFrame{Type: tunnelFrameData, Data: []byte("late")})
+	}})
+	conn := dialTestTunnel(t, sessions)
+
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 16)
+	start := time.Now()
+	_, err := conn.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout net.Error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read took %v", elapsed)
+	}
+
+	// The stream survives a read timeout.
+	_ = conn.SetReadDeadline(time.Time{})
+	close(release)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("expected data after clearing the deadline, got %q %v", buf[:n], err)
+	}
+}
+
+func TestTunnelConnWriteDeadline(t *testing.T) {
+	sessions := newTestSessions(t, &fakeConnector{tunnel: echoTunnel(t, "db.internal", 5432)})
+	conn := dialTestTunnel(t, sessions)
+
+	_ = conn.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write with a future deadline: %v", err)
+	}
+	buf := make([]byte, 4)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("expected echo, got %q %v", buf[:n], err)
+	}
+}
+
+func TestDialTunnelHonoursContext(t *testing.T) {
+	streamDone := make(chan struct{})
+	sessions := newTestSessions(t, &fakeConnector{tunnel: func(stream controllerpb.ControlPlane_TunnelServer) error {
+		// Never answer the open frame.
+		<-stream.Context().Done()
+		close(streamDone)
+		return nil
+	}})
+	s := newHTTPProxyServer("", sessions)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.dialTunnel(ctx, "tcp", "db.internal:5432"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the dial context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial took %v", elapsed)
+	}
+	select {
+	case <-streamDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the abandoned tunnel stream to be cancelled")
+	}
+}